@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/creachadair/leaf"
+)
+
+// ageAccessKey returns the access key for the LEAF file at path, whose key
+// slots are slots, by using the age identity file to decrypt the access key of
+// one of its age key slots.
+func ageAccessKey(path string, slots []leaf.KeySlot, identity string) ([]byte, error) {
+	var lastErr error
+	for _, ks := range slots {
+		p := parseSlotParams(ks)
+		if p.Age == nil {
+			continue
+		}
+		key, err := runTool(bytes.NewReader(p.Age), "age", "--decrypt", "--identity", identity)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = fmt.Errorf("key slot %q: %w", ks.Name, err)
+	}
+	if lastErr == nil {
+		return nil, fmt.Errorf("no age key slots found in %q", path)
+	}
+	return nil, lastErr
+}
+
+// ageEncrypt encrypts data to the specified age recipient. If recipient names
+// an existing file, it is treated as a recipients file.
+func ageEncrypt(recipient string, data []byte) ([]byte, error) {
+	flag := "--recipient"
+	if _, err := os.Stat(recipient); err == nil {
+		flag = "--recipients-file"
+	}
+	return runTool(bytes.NewReader(data), "age", "--encrypt", flag, recipient)
+}
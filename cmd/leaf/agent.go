@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/creachadair/command"
+)
+
+// The agent holds access keys in memory and serves them to other invocations
+// of the tool over a Unix-domain socket. Each connection carries a single
+// JSON-encoded agentRequest and its agentResponse.
+
+type agentRequest struct {
+	Op   string `json:"op"` // get, put, forget, status, stop
+	Path string `json:"path,omitempty"`
+	Key  []byte `json:"key,omitempty"`
+}
+
+type agentResponse struct {
+	Key     []byte       `json:"key,omitempty"`
+	Entries []agentEntry `json:"entries,omitempty"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type agentEntry struct {
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires"`
+}
+
+// agentSocket returns the path of the agent socket. If $LEAF_AGENT_SOCK is
+// set, it is used; otherwise the socket is placed in a per-user directory,
+// under $XDG_RUNTIME_DIR if it is set.
+func agentSocket() string {
+	if s := os.Getenv("LEAF_AGENT_SOCK"); s != "" {
+		return s
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, fmt.Sprintf("leaf-agent-%d", os.Getuid()), "agent.sock")
+}
+
+// checkAgentSocket reports an error unless the agent socket and the directory
+// that contains it belong to the current user and are private to them.
+// Otherwise another user could listen on the socket to collect keys.
+func checkAgentSocket(sock string) error {
+	if err := checkPrivate(filepath.Dir(sock), true); err != nil {
+		return fmt.Errorf("agent socket: %w", err)
+	} else if err := checkPrivate(sock, false); err != nil {
+		return fmt.Errorf("agent socket: %w", err)
+	}
+	return nil
+}
+
+// callAgent sends req to the running agent and returns its response.
+func callAgent(req agentRequest) (*agentResponse, error) {
+	sock := agentSocket()
+	if err := checkAgentSocket(sock); err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, err
+	}
+	var rsp agentResponse
+	if err := json.NewDecoder(conn).Decode(&rsp); err != nil {
+		return nil, err
+	} else if rsp.Error != "" {
+		return nil, errors.New(rsp.Error)
+	}
+	return &rsp, nil
+}
+
+// agentAccessKey returns the access key held by the agent for the LEAF file
+// at path, if the agent is running and has one.
+func agentAccessKey(path string) ([]byte, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	rsp, err := callAgent(agentRequest{Op: "get", Path: abs})
+	if err != nil {
+		return nil, err
+	}
+	return rsp.Key, nil
+}
+
+// agentRemember sends the access key for the LEAF file at path to the agent,
+// if --agent is set and the agent is running. Errors are ignored.
+func agentRemember(path string, accessKey []byte) {
+	if !settings.Agent {
+		return
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		callAgent(agentRequest{Op: "put", Path: abs, Key: accessKey})
+	}
+}
+
+var agentFlags struct {
+	Timeout time.Duration `flag:"timeout,default=15m,How long to hold each access key"`
+	Idle    time.Duration `flag:"idle,default=$LEAF_AGENT_IDLE,Discard all keys after this long without use (0 means never)"`
+}
+
+func runAgentStart(env *command.Env) error {
+	if _, err := callAgent(agentRequest{Op: "status"}); err == nil {
+		return fmt.Errorf("agent is already running at %q", agentSocket())
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, "agent", "serve",
+		"--timeout", agentFlags.Timeout.String(), "--idle", agentFlags.Idle.String())
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("start agent: %w", err)
+	}
+	// Wait for the agent to begin serving, so that the caller can rely on it.
+	for i := 0; i < 50; i++ {
+		if _, err := callAgent(agentRequest{Op: "status"}); err == nil {
+			notify(env, object{"event": "started", "pid": cmd.Process.Pid, "socket": agentSocket()},
+				"agent started (pid %d) at %q", cmd.Process.Pid, agentSocket())
+			return cmd.Process.Release()
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	cmd.Process.Kill()
+	return errors.New("agent did not start")
+}
+
+func runAgentServe(env *command.Env) error {
+	if agentFlags.Idle == 0 {
+		agentFlags.Idle = configAgentIdle
+	}
+	if agentFlags.Timeout <= 0 {
+		return env.Usagef("invalid timeout %v", agentFlags.Timeout)
+	} else if agentFlags.Idle < 0 {
+		return env.Usagef("invalid idle timeout %v", agentFlags.Idle)
+	}
+	sock := agentSocket()
+	if err := os.MkdirAll(filepath.Dir(sock), 0700); err != nil {
+		return err
+	} else if err := checkPrivate(filepath.Dir(sock), true); err != nil {
+		return fmt.Errorf("agent socket: %w", err)
+	}
+	os.Remove(sock) // clean up a stale socket, if any
+	lst, err := net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sock)
+	if err := os.Chmod(sock, 0600); err != nil {
+		lst.Close()
+		return err
+	}
+	a := &keyAgent{
+		timeout: agentFlags.Timeout,
+		idle:    agentFlags.Idle,
+		keys:    make(map[string]*heldKey),
+		lastUse: time.Now(),
+	}
+	go a.expire()
+	for {
+		conn, err := lst.Accept()
+		if err != nil {
+			if a.stopped() {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			if a.serve(conn) {
+				lst.Close()
+			}
+		}()
+	}
+}
+
+func runAgentStop(env *command.Env) error {
+	if _, err := callAgent(agentRequest{Op: "stop"}); err != nil {
+		return fmt.Errorf("stop agent: %w", err)
+	}
+	notify(env, object{"event": "stopped"}, "agent stopped")
+	return nil
+}
+
+func runAgentStatus(env *command.Env) error {
+	rsp, err := callAgent(agentRequest{Op: "status"})
+	if err != nil {
+		return fmt.Errorf("agent is not running: %w", err)
+	}
+	return printResult(object{"socket": agentSocket(), "entries": rsp.Entries}, func() {
+		infof(env, "agent is running at %q\n", agentSocket())
+		for _, e := range rsp.Entries {
+			fmt.Printf("%s\texpires %s\n", e.Path, e.Expires.Format(time.RFC3339))
+		}
+	})
+}
+
+func runAgentForget(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	abs, err := filepath.Abs(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	if _, err := callAgent(agentRequest{Op: "forget", Path: abs}); err != nil {
+		return err
+	}
+	notify(env, object{"event": "forgotten", "file": abs}, "agent forgot the key for %q", abs)
+	return nil
+}
+
+// keyAgent is the state of a running agent.
+type keyAgent struct {
+	timeout time.Duration
+	idle    time.Duration // if positive, wipe all keys after this long unused
+
+	μ       sync.Mutex
+	keys    map[string]*heldKey
+	lastUse time.Time // when a key was last stored or retrieved
+	done    bool
+}
+
+type heldKey struct {
+	key     []byte
+	expires time.Time
+}
+
+func (a *keyAgent) stopped() bool {
+	a.μ.Lock()
+	defer a.μ.Unlock()
+	return a.done
+}
+
+// serve handles a single request on conn, and reports whether the agent
+// should stop.
+func (a *keyAgent) serve(conn net.Conn) bool {
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	var req agentRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return false
+	}
+	var rsp agentResponse
+
+	a.μ.Lock()
+	switch req.Op {
+	case "get":
+		if h, ok := a.keys[req.Path]; ok {
+			rsp.Key = h.key
+			a.lastUse = time.Now()
+		} else {
+			rsp.Error = "no key held for " + req.Path
+		}
+	case "put":
+		if h, ok := a.keys[req.Path]; !ok || string(h.key) != string(req.Key) {
+			if ok {
+				clear(h.key)
+			}
+			lockMemory(req.Key)
+			a.keys[req.Path] = &heldKey{key: req.Key, expires: time.Now().Add(a.timeout)}
+		}
+		a.lastUse = time.Now()
+	case "forget":
+		if h, ok := a.keys[req.Path]; ok {
+			clear(h.key)
+			delete(a.keys, req.Path)
+		}
+	case "status":
+		for path, h := range a.keys {
+			rsp.Entries = append(rsp.Entries, agentEntry{Path: path, Expires: h.expires})
+		}
+		sort.Slice(rsp.Entries, func(i, j int) bool { return rsp.Entries[i].Path < rsp.Entries[j].Path })
+	case "stop":
+		a.wipeLocked()
+		a.done = true
+	default:
+		rsp.Error = fmt.Sprintf("unknown operation %q", req.Op)
+	}
+	a.μ.Unlock()
+
+	json.NewEncoder(conn).Encode(rsp)
+	return req.Op == "stop"
+}
+
+// expire periodically discards keys whose lifetime has elapsed, and all keys
+// if the agent has been idle too long.
+func (a *keyAgent) expire() {
+	for range time.Tick(time.Second) {
+		a.μ.Lock()
+		now := time.Now()
+		if a.idle > 0 && now.Sub(a.lastUse) > a.idle {
+			a.wipeLocked()
+		}
+		for path, h := range a.keys {
+			if now.After(h.expires) {
+				clear(h.key)
+				delete(a.keys, path)
+			}
+		}
+		a.μ.Unlock()
+	}
+}
+
+func (a *keyAgent) wipeLocked() {
+	for path, h := range a.keys {
+		clear(h.key)
+		delete(a.keys, path)
+	}
+}
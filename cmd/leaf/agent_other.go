@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detach is a no-op on this platform.
+func detach(cmd *exec.Cmd) {}
+
+// checkPrivate is a no-op on this platform, where file modes do not control
+// access.
+func checkPrivate(path string, dir bool) error { return nil }
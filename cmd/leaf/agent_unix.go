@@ -0,0 +1,40 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// detach arranges for cmd to run in a new session, so that it is not
+// terminated along with the controlling terminal of its parent.
+func detach(cmd *exec.Cmd) { cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true} }
+
+// checkPrivate reports an error unless the file at path is owned by the
+// current user and is not accessible to others. If dir is true, the file
+// must be a directory with mode 0700; otherwise it must be a socket. A
+// symbolic link is not followed, and is rejected.
+func checkPrivate(path string, dir bool) error {
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.New("cannot check the owner of " + path)
+	} else if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("%s is not owned by the current user", path)
+	}
+	mode := fi.Mode()
+	if dir && (!mode.IsDir() || mode.Perm() != 0700) {
+		return fmt.Errorf("%s must be a directory with mode 0700 (has %v)", path, mode)
+	} else if !dir && (mode.Type() != fs.ModeSocket || mode.Perm()&0077 != 0) {
+		return fmt.Errorf("%s must be a socket private to its owner (has %v)", path, mode)
+	}
+	return nil
+}
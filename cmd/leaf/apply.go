@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// An applyOp is a single operation read by the apply command.
+type applyOp struct {
+	Op    string          `json:"op"`
+	Table string          `json:"table"`
+	Key   string          `json:"key,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+	To    string          `json:"to,omitempty"` // new name, for rename
+}
+
+func runApply(env *command.Env, args ...string) error {
+	var data []byte
+	var err error
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	} else if len(args) == 0 || args[0] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var ops []applyOp
+	if err := dec.Decode(&ops); err != nil {
+		return fmt.Errorf("decoding operations: %w", err)
+	}
+
+	f := env.Config.(*leaf.File)
+	db := fileDB(f)
+	for i, op := range ops {
+		if err := applyOne(db, op); err != nil {
+			return fmt.Errorf("operation %d (%s): %w", i+1, op.Op, err)
+		}
+	}
+	if !f.IsModified() {
+		notify(env, object{"event": "unchanged", "ops": len(ops)}, "no changes")
+		return nil
+	} else if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "applied", "ops": len(ops)},
+		"applied %d %s", len(ops), plural(len(ops), "operation", "operations"))
+	return nil
+}
+
+// applyOne applies a single operation to db.
+func applyOne(db *leaf.Database, op applyOp) error {
+	if op.Table == "" {
+		return errors.New("missing table name")
+	}
+	switch op.Op {
+	case "set":
+		if op.Key == "" {
+			return errors.New("missing key")
+		} else if op.Value == nil {
+			return errors.New("missing value")
+		}
+		db.Table(op.Table).Set(op.Key, op.Value)
+	case "delete":
+		if op.Key == "" {
+			return errors.New("missing key")
+		}
+		if tab, ok := db.GetTable(op.Table); ok {
+			tab.Delete(op.Key)
+		}
+	case "create-table":
+		db.Table(op.Table)
+	case "delete-table":
+		db.DeleteTable(op.Table)
+	case "rename":
+		tab, ok := db.GetTable(op.Table)
+		if !ok {
+			return notFoundf("table %q not found", op.Table)
+		} else if op.To == "" {
+			return errors.New("missing new table name")
+		} else if _, ok := db.GetTable(op.To); ok && op.To != op.Table {
+			return existsf("table %q already exists", op.To)
+		}
+		tab.Rename(op.To)
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var auditFlags struct {
+	Fields  string  `flag:"fields,Comma-separated names of password fields in objects (default password,passwd,pass,pw)"`
+	MinBits float64 `flag:"min-bits,default=50,Report passwords with less than this estimated entropy"`
+	HIBP    bool    `flag:"hibp,Check passwords against the Have I Been Pwned corpus"`
+}
+
+// A finding is the audit result for one password.
+type finding struct {
+	Key     string   `json:"key"`
+	Field   string   `json:"field,omitempty"` // empty if the value is a string
+	Bits    float64  `json:"bits"`            // estimated entropy
+	Weak    bool     `json:"weak,omitempty"`
+	Reused  []string `json:"reused,omitempty"` // other keys with the same password
+	Pwned   int      `json:"pwned,omitempty"`  // occurrences in HIBP
+	digest  string   // SHA-256 of the password, for reuse detection
+	sha1hex string   // for HIBP queries
+}
+
+func (f finding) label() string {
+	if f.Field == "" {
+		return f.Key
+	}
+	return f.Key + "." + f.Field
+}
+
+func runAudit(env *command.Env, table string) error {
+	f := env.Config.(*leaf.File)
+	tab, ok := fileDB(f).GetTable(table)
+	if !ok {
+		return notFoundf("table %q not found", table)
+	}
+	fields := []string{"password", "passwd", "pass", "pw"}
+	if auditFlags.Fields != "" {
+		fields = strings.Split(strings.ToLower(auditFlags.Fields), ",")
+	}
+
+	var found []*finding
+	add := func(key, field, pw string) {
+		if pw == "" {
+			return
+		}
+		d := sha256.Sum256([]byte(pw))
+		s := sha1.Sum([]byte(pw))
+		bits := entropyBits(pw)
+		found = append(found, &finding{
+			Key:     key,
+			Field:   field,
+			Bits:    math.Round(bits*10) / 10,
+			Weak:    bits < auditFlags.MinBits,
+			digest:  string(d[:]),
+			sha1hex: strings.ToUpper(hex.EncodeToString(s[:])),
+		})
+	}
+	for key, val := range leaf.AsMap[json.RawMessage](tab) {
+		var s string
+		var obj map[string]any
+		if json.Unmarshal(val, &s) == nil {
+			add(key, "", s)
+		} else if json.Unmarshal(val, &obj) == nil {
+			for name, v := range obj {
+				if s, ok := v.(string); ok && slices.Contains(fields, strings.ToLower(name)) {
+					add(key, name, s)
+				}
+			}
+		}
+	}
+	slices.SortFunc(found, func(a, b *finding) int { return strings.Compare(a.label(), b.label()) })
+
+	// Find reuse by comparing digests, so the passwords need not be retained.
+	byDigest := make(map[string][]*finding)
+	for _, fd := range found {
+		byDigest[fd.digest] = append(byDigest[fd.digest], fd)
+	}
+	for _, fd := range found {
+		for _, other := range byDigest[fd.digest] {
+			if other != fd {
+				fd.Reused = append(fd.Reused, other.label())
+			}
+		}
+	}
+
+	if auditFlags.HIBP {
+		cache := make(map[string]map[string]int)
+		for _, fd := range found {
+			prefix, suffix := fd.sha1hex[:5], fd.sha1hex[5:]
+			counts, ok := cache[prefix]
+			if !ok {
+				var err error
+				counts, err = hibpRange(prefix)
+				if err != nil {
+					return fmt.Errorf("checking HIBP: %w", err)
+				}
+				cache[prefix] = counts
+			}
+			fd.Pwned = counts[suffix]
+		}
+	}
+
+	var issues []*finding
+	for _, fd := range found {
+		if fd.Weak || len(fd.Reused) != 0 || fd.Pwned != 0 {
+			issues = append(issues, fd)
+		}
+	}
+	return printResult(object{"table": table, "checked": len(found), "issues": issues}, func() {
+		fmt.Printf("checked %d %s in table %q: %d with issues\n",
+			len(found), plural(len(found), "password", "passwords"), table, len(issues))
+		if len(issues) == 0 {
+			return
+		}
+		p := newPainter(os.Stdout)
+		rows := [][]cell{{{"KEY", bold}, {"BITS", bold}, {"ISSUES", bold}}}
+		for _, fd := range issues {
+			var msgs []string
+			if fd.Weak {
+				msgs = append(msgs, "weak")
+			}
+			if len(fd.Reused) != 0 {
+				msgs = append(msgs, "reused by "+strings.Join(fd.Reused, ", "))
+			}
+			if fd.Pwned != 0 {
+				msgs = append(msgs, fmt.Sprintf("pwned (seen %d times)", fd.Pwned))
+			}
+			rows = append(rows, []cell{{fd.label(), plain}, {fmt.Sprint(fd.Bits), plain}, {strings.Join(msgs, "; "), red}})
+		}
+		writeColumns(os.Stdout, p, []bool{false, true}, rows)
+	})
+}
+
+// entropyBits estimates the entropy of pw in bits from its length and the
+// classes of characters it uses. This overestimates the strength of words and
+// patterns, but reliably flags passwords that are short or use few classes.
+func entropyBits(pw string) float64 {
+	var lower, upper, digit, symbol, other bool
+	n := 0
+	for _, r := range pw {
+		n++
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	for _, c := range []struct {
+		ok   bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if c.ok {
+			pool += c.size
+		}
+	}
+	if pool == 0 {
+		return 0
+	}
+	return float64(n) * math.Log2(float64(pool))
+}
+
+// hibpRange queries the Have I Been Pwned range API for the given 5-digit
+// SHA-1 prefix, and returns the counts of the matching hash suffixes. Only the
+// prefix is sent, so the service does not learn which password is checked.
+func hibpRange(prefix string) (map[string]int, error) {
+	req, err := http.NewRequest("GET", "https://api.pwnedpasswords.com/range/"+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Add-Padding", "true")
+	req.Header.Set("User-Agent", "leaf-audit")
+	cli := &http.Client{Timeout: 30 * time.Second}
+	rsp, err := cli.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("range query: %s", rsp.Status)
+	}
+	out := make(map[string]int)
+	sc := bufio.NewScanner(rsp.Body)
+	for sc.Scan() {
+		suffix, count, ok := strings.Cut(strings.TrimSpace(sc.Text()), ":")
+		if !ok {
+			continue
+		}
+		if n, err := strconv.Atoi(count); err == nil && n > 0 {
+			out[suffix] = n // padding entries have count 0
+		}
+	}
+	return out, sc.Err()
+}
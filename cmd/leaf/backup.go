@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// backupDir is the name of the directory, next to the file, that holds the
+// copies saved when --backups is set.
+const backupDir = ".leaf-backups"
+
+// backupTimeFormat is the format of the timestamp in the name of a backup.
+// Names in this format sort in order of time.
+const backupTimeFormat = "20060102T150405.000000000Z"
+
+// backupSaved records whether a backup has been saved by this command, so
+// that a command that saves the file more than once saves only one backup.
+var backupSaved bool
+
+// saveBackup copies the file at path into the backup directory next to it,
+// before it is replaced, if --backups is set. It then removes the oldest
+// backups of the file, so that at most settings.Backups remain. If the file
+// does not exist yet, there is nothing to save.
+func saveBackup(path string) error {
+	if settings.Backups <= 0 || backupSaved {
+		return nil
+	} else if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	dir := filepath.Join(filepath.Dir(path), backupDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	base := filepath.Base(path)
+	name := filepath.Join(dir, base+"."+time.Now().UTC().Format(backupTimeFormat))
+	if err := copyFile(path, name); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	backupSaved = true
+
+	old, err := listBackups(dir, base)
+	if err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	for len(old) > settings.Backups {
+		if err := os.Remove(filepath.Join(dir, old[0])); err != nil {
+			return fmt.Errorf("backup: %w", err)
+		}
+		old = old[1:]
+	}
+	return nil
+}
+
+// listBackups returns the names of the backups of the file with the given
+// base name in dir, oldest first.
+func listBackups(dir, base string) ([]string, error) {
+	des, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, de := range des {
+		ts, ok := strings.CutPrefix(de.Name(), base+".")
+		if !ok || !de.Type().IsRegular() {
+			continue
+		} else if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			out = append(out, de.Name())
+		}
+	}
+	slices.Sort(out)
+	return out, nil
+}
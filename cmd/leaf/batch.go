@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// batchFile, if non-nil, is the file opened by the batch command. While a
+// batch is running, openFile returns it instead of reading the file again,
+// and saveFile records that it was modified instead of writing it.
+var batchFile *leaf.File
+
+// batchDirty reports whether a command in the current batch saved the file.
+var batchDirty bool
+
+func runBatch(env *command.Env, script string) error {
+	var data []byte
+	var err error
+	if script == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(script)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Find the root of the command tree, whose subcommands the script runs.
+	root := env
+	for root.Parent != nil {
+		root = root.Parent
+	}
+
+	batchFile = env.Config.(*leaf.File)
+	defer func() { batchFile = nil }()
+
+	var nrun int
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue // comment
+		}
+		args, err := splitWords(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", i+1, err)
+		} else if len(args) == 0 {
+			continue
+		}
+		sub := root.Command.FindSubcommand(args[0])
+		if sub == nil {
+			return fmt.Errorf("line %d: unknown command %q", i+1, args[0])
+		} else if sub == env.Command {
+			return fmt.Errorf("line %d: batch commands cannot be nested", i+1)
+		}
+		resetFlags(sub)
+		cenv := &command.Env{Parent: root, Command: sub, Config: batchFile, Log: env.Log}
+		if err := command.Run(cenv, args[1:]); err != nil {
+			return fmt.Errorf("line %d: %w (no changes were saved)", i+1, err)
+		}
+		nrun++
+	}
+	if !batchDirty {
+		return nil
+	}
+	if err := saveFileAs(settings.FilePath, batchFile); err != nil {
+		return err
+	}
+	notify(env, object{"event": "saved", "commands": nrun},
+		"saved after %d %s", nrun, plural(nrun, "command", "commands"))
+	return nil
+}
+
+// resetFlags restores the flags of c and its subcommands to their default
+// values, so that flags set by one command of a batch do not carry over to
+// the next.
+func resetFlags(c *command.C) {
+	c.Flags.VisitAll(func(f *flag.Flag) { f.Value.Set(f.DefValue) })
+	for _, sub := range c.Commands {
+		resetFlags(sub)
+	}
+}
+
+// splitWords splits line into words separated by unquoted whitespace, as a
+// shell would. Single quotes preserve their contents literally; within double
+// quotes, and outside quotes, a backslash escapes the next character.
+func splitWords(line string) ([]string, error) {
+	var words []string
+	var cur strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\r':
+			if inWord {
+				words = append(words, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	} else if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		words = append(words, cur.String())
+	}
+	return words, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+)
+
+// A style is an ANSI terminal text style (SGR parameter).
+type style string
+
+const (
+	plain  style = ""
+	bold   style = "1"
+	dim    style = "2"
+	red    style = "31"
+	green  style = "32"
+	yellow style = "33"
+	blue   style = "34"
+	cyan   style = "36"
+)
+
+// A painter applies styles to text if color output is enabled.
+type painter bool
+
+// newPainter returns a painter for output written to f. Color is enabled if f
+// is a terminal, unless it is disabled by --no-color, by the NO_COLOR
+// environment variable, or by JSON mode.
+func newPainter(f *os.File) painter {
+	return painter(!settings.NoColor && !settings.JSON && os.Getenv("NO_COLOR") == "" && isTerminal(f))
+}
+
+// paint returns s styled with st, or s unchanged if color is disabled.
+func (p painter) paint(s string, st style) string {
+	if !p || st == plain || s == "" {
+		return s
+	}
+	return "\x1b[" + string(st) + "m" + s + "\x1b[0m"
+}
+
+// isTerminal reports whether f is connected to a terminal.
+func isTerminal(f *os.File) bool { return term.IsTerminal(int(f.Fd())) }
+
+// termWidth returns the width of the terminal connected to f, or 80 if it
+// cannot be determined.
+func termWidth(f *os.File) int {
+	if w, _, err := term.GetSize(int(f.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// A cell is a single styled cell of columnar output.
+type cell struct {
+	Text  string
+	Style style
+}
+
+// writeColumns writes rows to w as aligned columns separated by two spaces.
+// Columns whose index has right[i] == true are right-aligned. Widths are
+// computed from the unstyled text, so styling does not disturb alignment. The
+// last cell of each row is not padded.
+func writeColumns(w io.Writer, p painter, right []bool, rows [][]cell) {
+	var widths []int
+	for _, row := range rows {
+		for i, c := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(c.Text))
+		}
+	}
+	var sb strings.Builder
+	for _, row := range rows {
+		sb.Reset()
+		for i, c := range row {
+			if i > 0 {
+				sb.WriteString("  ")
+			}
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(c.Text))
+			last := i == len(row)-1
+			if i < len(right) && right[i] {
+				sb.WriteString(pad)
+				sb.WriteString(p.paint(c.Text, c.Style))
+			} else {
+				sb.WriteString(p.paint(c.Text, c.Style))
+				if !last {
+					sb.WriteString(pad)
+				}
+			}
+		}
+		fmt.Fprintln(w, strings.TrimRight(sb.String(), " "))
+	}
+}
+
+// writeGrid writes words to w in as many columns as fit in width, filling
+// down each column in turn, in the manner of ls.
+func writeGrid(w io.Writer, p painter, width int, st style, words []string) {
+	if len(words) == 0 {
+		return
+	}
+	maxw := 0
+	for _, s := range words {
+		maxw = max(maxw, utf8.RuneCountInString(s))
+	}
+	ncol := max((width+2)/(maxw+2), 1)
+	nrow := (len(words) + ncol - 1) / ncol
+	ncol = (len(words) + nrow - 1) / nrow
+
+	rows := make([][]cell, nrow)
+	for i, s := range words {
+		rows[i%nrow] = append(rows[i%nrow], cell{Text: s, Style: st})
+	}
+	writeColumns(w, p, nil, rows)
+}
+
+// opStyle returns the style used to display a log or change operation.
+func opStyle(op string) style {
+	switch op {
+	case "update":
+		return yellow
+	case "create-table", changeAddTable, changeAddKey:
+		return green
+	case "delete", "delete-table", "clear-table":
+		return red
+	case "rename-table", "snapshot":
+		return cyan
+	case "tag":
+		return blue
+	}
+	return plain
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var combineFlags struct {
+	Namespace bool `flag:"namespace,Prefix each table with the base name of its input file"`
+}
+
+func runCombine(env *command.Env, out string, inputs ...string) error {
+	if len(inputs) == 0 {
+		return env.Usagef("missing input files")
+	} else if _, err := os.Lstat(out); err == nil {
+		return existsf("file %q already exists", out)
+	} else if err := checkDryRun(env); err != nil {
+		return err
+	}
+	if combineFlags.Namespace {
+		seen := make(map[string]string)
+		for _, in := range inputs {
+			ns := namespaceOf(in)
+			if prev, ok := seen[ns]; ok {
+				return fmt.Errorf("inputs %q and %q have the same namespace %q", prev, in, ns)
+			}
+			seen[ns] = in
+		}
+	}
+
+	// Open all the inputs before asking for a new access key, so that a
+	// mistake does not waste the user's effort.
+	srcs := make([]*leaf.File, len(inputs))
+	for i, in := range inputs {
+		src, err := openOtherFile(in, false)
+		if err != nil {
+			return fmt.Errorf("open %q: %w", in, err)
+		}
+		srcs[i] = src
+	}
+	var dst *leaf.File
+	var err error
+	if dryRunFlags.DryRun {
+		// The result of a dry run is not saved, so it does not need an access
+		// key from the user.
+		dst, err = leaf.New(make([]byte, leaf.AccessKeyLen))
+	} else {
+		dst, err = newFile(out)
+	}
+	if err != nil {
+		return err
+	}
+
+	db := fileDB(dst)
+	for i, src := range srcs {
+		var nkeys int
+		sdb := fileDB(src)
+		for name, stab := range sdb.All() {
+			tname := name
+			if combineFlags.Namespace {
+				tname = namespaceOf(inputs[i]) + "/" + name
+			}
+			dtab := db.Table(tname)
+			for key, val := range stab.All() {
+				var old json.RawMessage
+				if dtab.Get(key, &old) && !equalJSON(old, val) {
+					notify(env, object{"event": "conflict", "file": inputs[i], "table": tname, "key": key},
+						"%s: replaced %q in table %q", inputs[i], key, tname)
+				}
+				dtab.Set(key, val)
+				nkeys++
+			}
+		}
+		if !dryRunFlags.DryRun {
+			notify(env, object{"event": "combined", "file": inputs[i], "keys": nkeys},
+				"%s: copied %d %s", inputs[i], nkeys, plural(nkeys, "key", "keys"))
+		}
+	}
+	if dryRunFlags.DryRun {
+		return printDryRun(out, snapshot{}, db.Snapshot())
+	}
+	if err := saveFileAs(out, dst); err != nil {
+		return err
+	}
+	notify(env, object{"event": "created", "file": out}, "created %q", out)
+	return nil
+}
+
+// namespaceOf returns the table prefix used for the input file at path with
+// --namespace, which is its base name without extension.
+func namespaceOf(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
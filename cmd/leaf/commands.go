@@ -1,91 +1,491 @@
 package main
 
 import (
+	"bytes"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/command"
 	"github.com/creachadair/leaf"
+	"golang.org/x/term"
 )
 
+var createFlags struct {
+	Import string `flag:"import,Initialize the file from this JSON snapshot"`
+	Cipher string `flag:"cipher,Encrypt the payload with this cipher"`
+}
+
 func runCreate(env *command.Env) error {
 	if settings.FilePath == "" {
 		return env.Usagef("no file path is defined")
 	} else if _, err := os.Lstat(settings.FilePath); err == nil {
-		return fmt.Errorf("file %q already exists", settings.FilePath)
+		return existsf("file %q already exists", settings.FilePath)
+	} else if createFlags.Cipher != "" && !slices.Contains(ciphers, createFlags.Cipher) {
+		return fmt.Errorf("cipher %q is not supported (have %q)", createFlags.Cipher, ciphers)
+	}
+
+	// Read the import before prompting, so that a bad input does not waste
+	// the user's effort.
+	var snap map[string]map[string]any
+	if createFlags.Import != "" {
+		data, err := os.ReadFile(createFlags.Import)
+		if err != nil {
+			return err
+		}
+		snap, err = decodeSnapshot(data)
+		if err != nil {
+			return err
+		}
+	}
+	f, err := newFile(settings.FilePath)
+	if err != nil {
+		return err
 	}
-	_, err := openFile(true)
-	if err == nil {
-		fmt.Fprintf(env, "created %q\n", settings.FilePath)
+	if createFlags.Cipher != "" {
+		if err := f.SetCipher(createFlags.Cipher); err != nil {
+			return err
+		}
 	}
-	return err
+	importSnapshot(f, snap)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "created", "file": settings.FilePath}, "created %q", settings.FilePath)
+	return nil
+}
+
+var getFlags struct {
+	Path    string `flag:"path,Extract the value at this path (.a.b[0] or /a/b/0)"`
+	Raw     bool   `flag:"raw,Print string results without JSON quotation"`
+	Format  string `flag:"format,Format the output with this template"`
+	Default string `flag:"default,Print this JSON value if the key is not found"`
 }
 
 func runGet(env *command.Env, table, key string) error {
+	if getFlags.Default != "" && !json.Valid([]byte(getFlags.Default)) {
+		return env.Usagef("invalid JSON for --default: %q", getFlags.Default)
+	}
 	f := env.Config.(*leaf.File)
-	tab, ok := f.Database().GetTable(table)
+	var val json.RawMessage
+	tab, ok := fileDB(f).GetTable(table)
+	if ok {
+		ok = tab.Get(key, &val)
+	} else if getFlags.Default == "" {
+		return notFoundf("table %q not found", table)
+	}
 	if !ok {
-		return fmt.Errorf("table %q not found", table)
+		if getFlags.Default == "" {
+			return notFoundf("key %q not found", key)
+		}
+		val = json.RawMessage(getFlags.Default)
+	} else if getFlags.Path != "" {
+		sub, err := extractPath(val, getFlags.Path)
+		if err != nil {
+			return err
+		}
+		val = sub
 	}
-	var val json.RawMessage
-	if !tab.Get(key, &val) {
-		return fmt.Errorf("key %q not found", key)
+	if getFlags.Format != "" {
+		t, err := parseFormat(fileDB(f), getFlags.Format)
+		if err != nil {
+			return err
+		}
+		out, err := formatValue(t, table, key, val)
+		if err != nil {
+			return err
+		}
+		return printResult(out, func() { fmt.Print(out) })
 	}
-	fmt.Println(string(val))
-	return nil
+	return printResult(val, func() {
+		if getFlags.Raw {
+			fmt.Println(rawString(val))
+		} else {
+			fmt.Println(string(val))
+		}
+	})
+}
+
+var setFlags struct {
+	FromFile string `flag:"from-file,Read the value from this file"`
+	Stdin    bool   `flag:"stdin,Read the value from standard input"`
+
+	String bool `flag:"string,Store values as JSON strings"`
+	JSON   bool `flag:"json,Require values to be valid JSON"`
+	Int    bool `flag:"int,Require values to be integers"`
+	Bool   bool `flag:"bool,Require values to be Booleans"`
+
+	IfAbsent  bool   `flag:"if-absent,Set keys only if they do not exist"`
+	IfMatches string `flag:"if-matches,Set keys only if their current values equal this JSON value"`
 }
 
-func runSet(env *command.Env, table, key, value string, rest ...string) error {
-	if len(rest)%2 != 0 {
-		return env.Usagef("odd-length key-value list: %q", rest)
+func runSet(env *command.Env, table, key string, rest ...string) error {
+	var all []string
+	if setFlags.FromFile != "" || setFlags.Stdin {
+		if setFlags.FromFile != "" && setFlags.Stdin {
+			return env.Usagef("--from-file and --stdin are mutually exclusive")
+		} else if len(rest) != 0 {
+			return env.Usagef("extra arguments: %q", rest)
+		}
+		v, err := readValue(setFlags.FromFile)
+		if err != nil {
+			return err
+		}
+		all = []string{key, v}
+	} else if len(rest) == 0 {
+		return env.Usagef("missing value for key %q", key)
+	} else if len(rest)%2 != 1 {
+		return env.Usagef("odd-length key-value list: %q", rest[1:])
+	} else {
+		all = append([]string{key}, rest...)
 	}
+	var nt int
+	for _, ok := range []bool{setFlags.String, setFlags.JSON, setFlags.Int, setFlags.Bool} {
+		if ok {
+			nt++
+		}
+	}
+	if nt > 1 {
+		return env.Usagef("at most one of --string, --json, --int, --bool may be set")
+	} else if setFlags.IfAbsent && setFlags.IfMatches != "" {
+		return env.Usagef("--if-absent and --if-matches are mutually exclusive")
+	} else if setFlags.IfMatches != "" && !json.Valid([]byte(setFlags.IfMatches)) {
+		return env.Usagef("invalid JSON for --if-matches: %q", setFlags.IfMatches)
+	} else if err := checkDryRun(env); err != nil {
+		return err
+	}
+
+	// Encode all the values before modifying the table, so that an invalid
+	// value does not leave the update partly applied.
+	enc := make([]any, 0, len(all)/2)
+	for i := 1; i < len(all); i += 2 {
+		v, err := encodeValue(all[i])
+		if err != nil {
+			return fmt.Errorf("key %q: %w", all[i-1], err)
+		}
+		enc = append(enc, v)
+	}
+
 	f := env.Config.(*leaf.File)
-	tab := f.Database().Table(table)
-	all := append([]string{key, value}, rest...)
+	if err := checkSetConditions(fileDB(f), table, all); err != nil {
+		return err
+	}
+	before := fileDB(f).Snapshot()
+	tab := fileDB(f).Table(table)
+	for i, v := range enc {
+		tab.Set(all[2*i], v)
+	}
+	if dryRunFlags.DryRun {
+		return printDryRun(settings.FilePath, before, fileDB(f).Snapshot())
+	} else if f.IsModified() {
+		return saveFile(f)
+	}
+	return nil
+}
 
-	for i := 0; i+1 < len(all); i += 2 {
-		k, v := all[i], all[i+1]
+// checkSetConditions reports a conflict if any of the keys of the key-value
+// list kvs does not satisfy --if-absent or --if-matches in the named table.
+// All the keys are checked before any is set, so that a failed condition
+// leaves the table unchanged.
+func checkSetConditions(db *leaf.Database, table string, kvs []string) error {
+	if !setFlags.IfAbsent && setFlags.IfMatches == "" {
+		return nil
+	}
+	tab, ok := db.GetTable(table)
+	for i := 0; i < len(kvs); i += 2 {
+		var cur json.RawMessage
+		exists := ok && tab.Get(kvs[i], &cur)
+		if setFlags.IfAbsent && exists {
+			return conflictf("key %q already exists", kvs[i])
+		} else if setFlags.IfMatches == "" {
+			continue
+		} else if !exists {
+			return conflictf("key %q does not exist", kvs[i])
+		} else if !equalJSON(cur, json.RawMessage(setFlags.IfMatches)) {
+			return conflictf("key %q does not have the expected value", kvs[i])
+		}
+	}
+	return nil
+}
 
-		var enc any
-		if json.Valid([]byte(v)) {
-			enc = json.RawMessage(v)
-		} else {
-			enc = v // just the string
+// encodeValue converts v to a value for storage, according to the type flags
+// set for the set command. If no type flag is set, a valid JSON text is taken
+// verbatim, and anything else is stored as a string.
+func encodeValue(v string) (any, error) {
+	switch {
+	case setFlags.String:
+		return v, nil
+	case setFlags.JSON:
+		if !json.Valid([]byte(v)) {
+			return nil, fmt.Errorf("invalid JSON value %q", v)
 		}
-		tab.Set(k, enc)
+		return json.RawMessage(v), nil
+	case setFlags.Int:
+		z, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", v)
+		}
+		return z, nil
+	case setFlags.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Boolean %q", v)
+		}
+		return b, nil
+	case json.Valid([]byte(v)):
+		return json.RawMessage(v), nil
+	default:
+		return v, nil // just the string
 	}
-	if f.IsModified() {
-		return saveFile(f)
+}
+
+// readValue reads a value from the named file, or from stdin if path == "".
+// A single trailing line break is removed, so that a value written by a
+// program like echo round-trips as expected.
+func readValue(path string) (string, error) {
+	var data []byte
+	var err error
+	if path == "" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return "", err
+	}
+	s := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(s, "\r"), nil
+}
+
+func runCopy(env *command.Env, table, key, destTable string, rest ...string) error {
+	if len(rest) > 1 {
+		return env.Usagef("extra arguments: %q", rest[1:])
+	}
+	newKey := key
+	if len(rest) == 1 {
+		newKey = rest[0]
+	}
+	f := env.Config.(*leaf.File)
+	tab, ok := fileDB(f).GetTable(table)
+	if !ok {
+		return notFoundf("table %q not found", table)
+	}
+	var val json.RawMessage
+	if !tab.Get(key, &val) {
+		return notFoundf("key %q not found", key)
+	}
+	if table == destTable && key == newKey {
+		return nil // nothing to do
+	}
+	fileDB(f).Table(destTable).Set(newKey, val)
+	notify(env, object{"event": "copied", "table": table, "key": key, "dest_table": destTable, "dest_key": newKey},
+		"copied %q to %q in table %q", key, newKey, destTable)
+	return saveFile(f)
+}
+
+func runVerifyKey(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	ks, err := leaf.VerifyKey(accessKey, f)
+	if err != nil {
+		return openError(err)
 	}
+	notify(env, object{"event": "verified", "file": settings.FilePath, "slot": ks.Name},
+		"the access key is valid for %q (key slot %q)", settings.FilePath, ks.Name)
 	return nil
 }
 
+var listFlags struct {
+	Format string `flag:"format,Format each key with this template"`
+	Long   bool   `flag:"long,Show the modification time, type, and a preview of each value"`
+	Glob   string `flag:"glob,List only the keys matching this glob pattern"`
+	Regex  string `flag:"regex,List only the keys matching this regular expression"`
+}
+
 func runList(env *command.Env, table string) error {
+	var re *regexp.Regexp
+	if listFlags.Glob != "" && listFlags.Regex != "" {
+		return env.Usagef("--glob and --regex are mutually exclusive")
+	} else if _, err := path.Match(listFlags.Glob, ""); err != nil {
+		return env.Usagef("invalid --glob pattern %q", listFlags.Glob)
+	} else if listFlags.Regex != "" {
+		re, err = regexp.Compile(listFlags.Regex)
+		if err != nil {
+			return env.Usagef("invalid --regex: %v", err)
+		}
+	}
 	f := env.Config.(*leaf.File)
-	for _, key := range f.Database().Table(table).Keys() {
-		fmt.Println(key)
+	tab := fileDB(f).Table(table)
+	keys := tab.Keys()
+	if listFlags.Glob != "" {
+		keys = globKeys(tab, listFlags.Glob)
+	} else if re != nil {
+		keys = slices.DeleteFunc(keys, func(key string) bool { return !re.MatchString(key) })
 	}
-	return nil
+	if listFlags.Format != "" {
+		t, err := parseFormat(fileDB(f), listFlags.Format)
+		if err != nil {
+			return err
+		}
+		var out []string
+		for _, key := range keys {
+			var val json.RawMessage
+			tab.Get(key, &val)
+			s, err := formatValue(t, table, key, val)
+			if err != nil {
+				return err
+			}
+			out = append(out, s)
+		}
+		return printResult(out, func() { fmt.Print(strings.Join(out, "")) })
+	} else if listFlags.Long {
+		return listLong(fileDB(f), table, keys)
+	}
+	return printResult(keys, func() {
+		if isTerminal(os.Stdout) {
+			writeGrid(os.Stdout, newPainter(os.Stdout), termWidth(os.Stdout), bold, keys)
+			return
+		}
+		for _, key := range keys {
+			fmt.Println(key)
+		}
+	})
+}
+
+// listLong prints the keys of a table with their modification times, the
+// types of their values, and a preview of each value.
+func listLong(db *leaf.Database, table string, keys []string) error {
+	tab := db.Table(table)
+	mtimes := keyTimes(db, table)
+	var out []object
+	rows := [][]cell{{{"MODIFIED", bold}, {"TYPE", bold}, {"KEY", bold}, {"PREVIEW", bold}}}
+	for _, key := range keys {
+		var val json.RawMessage
+		tab.Get(key, &val)
+		mtime := mtimes[key]
+		out = append(out, object{
+			"key": key, "modified": mtime.Format(time.RFC3339), "type": jsonType(val), "preview": preview(val, 40),
+		})
+		rows = append(rows, []cell{
+			{mtime.Format(time.DateTime), dim}, {jsonType(val), cyan}, {key, bold}, {preview(val, 40), plain},
+		})
+	}
+	return printResult(out, func() {
+		writeColumns(os.Stdout, newPainter(os.Stdout), nil, rows)
+	})
+}
+
+// jsonType returns the name of the JSON type of val.
+func jsonType(val json.RawMessage) string {
+	switch v := bytes.TrimSpace(val); {
+	case len(v) == 0:
+		return "none"
+	case v[0] == '"':
+		return "string"
+	case v[0] == '{':
+		return "object"
+	case v[0] == '[':
+		return "array"
+	case v[0] == 't' || v[0] == 'f':
+		return "bool"
+	case v[0] == 'n':
+		return "null"
+	}
+	return "number"
+}
+
+// preview returns the compact JSON text of val, truncated to at most n
+// characters with an ellipsis.
+func preview(val json.RawMessage, n int) string {
+	var buf bytes.Buffer
+	if json.Compact(&buf, val) != nil {
+		buf.Reset()
+		buf.Write(val)
+	}
+	s := []rune(buf.String())
+	if len(s) > n {
+		return string(s[:n-1]) + "…"
+	}
+	return string(s)
+}
+
+var deleteFlags struct {
+	Glob string `flag:"glob,Delete the keys matching this glob pattern"`
 }
 
 func runDelete(env *command.Env, table string, keys ...string) error {
-	if len(keys) == 0 {
+	if deleteFlags.Glob != "" && len(keys) != 0 {
+		return env.Usagef("keys cannot be given with --glob")
+	} else if deleteFlags.Glob == "" && len(keys) == 0 {
 		return env.Usagef("missing required key")
+	} else if _, err := path.Match(deleteFlags.Glob, ""); err != nil {
+		return env.Usagef("invalid --glob pattern %q", deleteFlags.Glob)
+	} else if err := checkDryRun(env); err != nil {
+		return err
 	}
 	f := env.Config.(*leaf.File)
-	tab, ok := f.Database().GetTable(table)
+	tab, ok := fileDB(f).GetTable(table)
 	if !ok {
-		return fmt.Errorf("table %q not found", table)
+		return notFoundf("table %q not found", table)
+	}
+	if deleteFlags.Glob != "" {
+		keys = globKeys(tab, deleteFlags.Glob)
+		if len(keys) == 0 {
+			notify(env, object{"event": "unchanged", "table": table},
+				"no keys in table %q match %q", table, deleteFlags.Glob)
+			return nil
+		}
+		if !dryRunFlags.DryRun {
+			if err := previewGlobDelete(env, table, keys); err != nil {
+				return err
+			}
+		}
+	}
+	if dryRunFlags.DryRun {
+		before := fileDB(f).Snapshot()
+		for _, key := range keys {
+			tab.Delete(key)
+		}
+		return printDryRun(settings.FilePath, before, fileDB(f).Snapshot())
+	}
+	var n int
+	for _, key := range keys {
+		if tab.Get(key, nil) {
+			n++
+		}
+	}
+	if n == 0 {
+		return nil
+	} else if err := confirm(env, "Delete %d %s from table %q?", n, plural(n, "key", "keys"), table); err != nil {
+		return err
 	}
 	for _, key := range keys {
 		if tab.Delete(key) {
-			fmt.Fprintf(env, "deleted: %q\n", key)
+			notify(env, object{"event": "deleted", "table": table, "key": key}, "deleted: %q", key)
 		}
 	}
 	if f.IsModified() {
@@ -94,19 +494,98 @@ func runDelete(env *command.Env, table string, keys ...string) error {
 	return nil
 }
 
+// globKeys returns the keys of tab matching the glob pattern, in order. The
+// pattern has the syntax of path.Match, so "*" does not match "/".
+func globKeys(tab leaf.Table, pattern string) []string {
+	var out []string
+	for _, key := range tab.Keys() {
+		if ok, _ := path.Match(pattern, key); ok {
+			out = append(out, key)
+		}
+	}
+	return out
+}
+
+// previewGlobDelete lists the keys matched by delete --glob on stderr.
+// Unlike the confirmation for other deletions, which is skipped if stdin is
+// not a terminal, deleting by pattern requires --force in that case, since
+// a pattern can match more keys than intended.
+func previewGlobDelete(env *command.Env, table string, keys []string) error {
+	fmt.Fprintf(env, "%d %s in table %q %s %q:\n", len(keys), plural(len(keys), "key", "keys"),
+		table, plural(len(keys), "matches", "match"), deleteFlags.Glob)
+	for _, key := range keys {
+		fmt.Fprintf(env, "  %s\n", key)
+	}
+	if !settings.Force && !settings.ReadOnly && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("deleting keys by pattern requires confirmation; use --force to skip it")
+	}
+	return nil
+}
+
 func runTableList(env *command.Env) error {
 	f := env.Config.(*leaf.File)
-	for _, tab := range f.Database().TableNames() {
-		fmt.Println(tab)
+	db := fileDB(f)
+	names := db.TableNames()
+	return printResult(names, func() {
+		if isTerminal(os.Stdout) {
+			// On a terminal, show the number of keys in each table.
+			rows := [][]cell{{{"TABLE", bold}, {"KEYS", bold}}}
+			for _, name := range names {
+				tab, _ := db.GetTable(name)
+				rows = append(rows, []cell{{name, blue}, {fmt.Sprint(tab.Len()), plain}})
+			}
+			writeColumns(os.Stdout, newPainter(os.Stdout), []bool{false, true}, rows)
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	})
+}
+
+func runDBList(env *command.Env) error {
+	f := env.Config.(*leaf.File)
+	names := f.DatabaseNames()
+	return printResult(names, func() {
+		if isTerminal(os.Stdout) {
+			// On a terminal, show the number of tables in each database.
+			rows := [][]cell{{{"DATABASE", bold}, {"TABLES", bold}}}
+			for _, name := range names {
+				db, _ := f.GetDatabase(name)
+				rows = append(rows, []cell{{name, blue}, {fmt.Sprint(len(db.TableNames())), plain}})
+			}
+			writeColumns(os.Stdout, newPainter(os.Stdout), []bool{false, true}, rows)
+			return
+		}
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	})
+}
+
+func runDBDelete(env *command.Env, name string) error {
+	f := env.Config.(*leaf.File)
+	if name == "" {
+		return env.Usagef("the default database cannot be deleted")
 	}
-	return nil
+	db, ok := f.GetDatabase(name)
+	if !ok {
+		return notFoundf("database %q not found", name)
+	}
+	n := len(db.TableNames())
+	if err := confirm(env, "Delete database %q with %d %s?", name, n, plural(n, "table", "tables")); err != nil {
+		return err
+	}
+	f.DeleteDatabase(name)
+	notify(env, object{"event": "deleted", "database": name}, "deleted database %q", name)
+	return saveFile(f)
 }
 
 func runTableCreate(env *command.Env, name string) error {
 	f := env.Config.(*leaf.File)
-	f.Database().Table(name)
+	fileDB(f).Table(name)
 	if f.IsModified() {
-		fmt.Fprintf(env, "created %q\n", name)
+		notify(env, object{"event": "created", "table": name}, "created %q", name)
 		return saveFile(f)
 	}
 	return nil
@@ -114,18 +593,42 @@ func runTableCreate(env *command.Env, name string) error {
 
 func runTableDelete(env *command.Env, name string) error {
 	f := env.Config.(*leaf.File)
-	if !f.Database().DeleteTable(name) {
-		return fmt.Errorf("table %q not found", name)
+	tab, ok := fileDB(f).GetTable(name)
+	if !ok {
+		return notFoundf("table %q not found", name)
+	}
+	if err := confirm(env, "Delete table %q with %d %s?", name, tab.Len(), plural(tab.Len(), "key", "keys")); err != nil {
+		return err
+	}
+	fileDB(f).DeleteTable(name)
+	notify(env, object{"event": "deleted", "table": name}, "deleted %q", name)
+	return saveFile(f)
+}
+
+func runTableClear(env *command.Env, name string) error {
+	f := env.Config.(*leaf.File)
+	tab, ok := fileDB(f).GetTable(name)
+	if !ok {
+		return notFoundf("table %q not found", name)
+	}
+	n := tab.Len()
+	if n == 0 {
+		return nil
+	}
+	if err := confirm(env, "Remove %d %s from table %q?", n, plural(n, "key", "keys"), name); err != nil {
+		return err
 	}
-	fmt.Fprintf(env, "deleted %q\n", name)
+	tab.Clear()
+	notify(env, object{"event": "cleared", "table": name, "removed": n},
+		"removed %d %s from %q", n, plural(n, "key", "keys"), name)
 	return saveFile(f)
 }
 
 func runTableRename(env *command.Env, oldName, newName string) error {
 	f := env.Config.(*leaf.File)
-	tab, ok := f.Database().GetTable(oldName)
+	tab, ok := fileDB(f).GetTable(oldName)
 	if !ok {
-		return fmt.Errorf("table %q not found", oldName)
+		return notFoundf("table %q not found", oldName)
 	}
 	tab.Rename(newName)
 	if f.IsModified() {
@@ -134,26 +637,392 @@ func runTableRename(env *command.Env, oldName, newName string) error {
 	return nil
 }
 
+var tableCopyFlags struct {
+	KeyFile string `flag:"key-file,Access key file for the other LEAF file"`
+	Move    bool   `flag:"move,Delete the table from its original file after copying"`
+}
+
+func runTableExport(env *command.Env, name, destPath string) error {
+	f := env.Config.(*leaf.File)
+	if _, ok := fileDB(f).GetTable(name); !ok {
+		return notFoundf("table %q not found", name)
+	}
+	dest, err := openOtherFile(destPath, true)
+	if err != nil {
+		return err
+	}
+	n := copyTable(fileDB(f), fileDB(dest), name)
+	if err := saveFileAs(destPath, dest); err != nil {
+		return err
+	}
+	notify(env, object{"event": "exported", "table": name, "file": destPath, "keys": n},
+		"copied %d keys of table %q to %q", n, name, destPath)
+	if tableCopyFlags.Move {
+		if err := confirm(env, "Delete table %q from this file?", name); err != nil {
+			return err
+		}
+		fileDB(f).DeleteTable(name)
+		notify(env, object{"event": "deleted", "table": name}, "deleted %q", name)
+		return saveFile(f)
+	}
+	return nil
+}
+
+var copyTableFlags struct {
+	To string `flag:"to,Path of the LEAF file to copy the table into (required)"`
+}
+
+func runCopyTable(env *command.Env, name string) error {
+	if copyTableFlags.To == "" {
+		return env.Usagef("missing --to")
+	}
+	return runTableExport(env, name, copyTableFlags.To)
+}
+
+func runTableImport(env *command.Env, name, srcPath string) error {
+	if tableCopyFlags.Move {
+		// The source file is written too.
+		if err := lockFile(srcPath); err != nil {
+			return err
+		}
+	}
+	src, err := openOtherFile(srcPath, false)
+	if err != nil {
+		return err
+	}
+	if _, ok := fileDB(src).GetTable(name); !ok {
+		return notFoundf("table %q not found in %q", name, srcPath)
+	}
+	f := env.Config.(*leaf.File)
+	if err := checkDryRun(env); err != nil {
+		return err
+	} else if dryRunFlags.DryRun {
+		before := fileDB(f).Snapshot()
+		copyTable(fileDB(src), fileDB(f), name)
+		return printDryRun(settings.FilePath, before, fileDB(f).Snapshot())
+	}
+	n := copyTable(fileDB(src), fileDB(f), name)
+	if f.IsModified() {
+		if err := saveFile(f); err != nil {
+			return err
+		}
+	}
+	notify(env, object{"event": "imported", "table": name, "file": srcPath, "keys": n},
+		"copied %d keys of table %q from %q", n, name, srcPath)
+	if tableCopyFlags.Move {
+		if err := confirm(env, "Delete table %q from %q?", name, srcPath); err != nil {
+			return err
+		}
+		fileDB(src).DeleteTable(name)
+		notify(env, object{"event": "deleted", "table": name, "file": srcPath}, "deleted %q from %q", name, srcPath)
+		return saveFileAs(srcPath, src)
+	}
+	return nil
+}
+
+// copyTable copies the contents of the named table from src to dst, creating
+// the table in dst if necessary. It returns the number of keys copied.
+func copyTable(src, dst *leaf.Database, name string) int {
+	stab, _ := src.GetTable(name)
+	vals := leaf.AsMap[json.RawMessage](stab)
+	leaf.SetMap(dst.Table(name), vals)
+	return len(vals)
+}
+
+// openOtherFile opens a LEAF file other than the one selected by the global
+// flags, using the access key given by --key-file if set. If create is true
+// and the file does not exist, a new empty file is returned; it is not saved.
+// If create is true, the file is to be written, and is locked as lockFile
+// does.
+func openOtherFile(path string, create bool) (*leaf.File, error) {
+	if create {
+		if err := lockFile(path); err != nil {
+			return nil, err
+		}
+	}
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) && create {
+		accessKey, err := otherAccessKey(path, true)
+		if err != nil {
+			return nil, err
+		}
+		return leaf.New(accessKey)
+	} else if err != nil {
+		return nil, err
+	}
+	warnPermissions(path)
+	accessKey, err := otherAccessKey(path, false)
+	if err != nil {
+		return nil, err
+	}
+	return openWithKey(path, accessKey)
+}
+
+// otherAccessKey returns the access key for the LEAF file at path, which is not
+// the file selected by the global flags. If create is true, the key is for a
+// new file.
+func otherAccessKey(path string, create bool) ([]byte, error) {
+	if tableCopyFlags.KeyFile != "" {
+		warnPermissions(tableCopyFlags.KeyFile)
+		return os.ReadFile(tableCopyFlags.KeyFile)
+	}
+	if !create {
+		if key, err := agentAccessKey(path); err == nil {
+			return key, nil
+		}
+		if key, err := keyringAccessKey(path); err == nil {
+			return key, nil
+		}
+	}
+	return promptAccessKey(filepath.Base(path), create)
+}
+
+func runKeyList(env *command.Env) error {
+	f := env.Config.(*leaf.File)
+	slots := f.KeySlots()
+	var out []object
+	for _, ks := range slots {
+		ev := object{"name": ks.Name}
+		if ks.Params != nil {
+			ev["params"] = ks.Params
+		}
+		out = append(out, ev)
+	}
+	return printResult(out, func() {
+		for _, ks := range slots {
+			fmt.Println(ks.Name)
+		}
+	})
+}
+
+var keyAddFlags struct {
+	KeyFile      string `flag:"key-file,Read the new access key from this file"`
+	AgeRecipient string `flag:"age-recipient,Encrypt a random access key to this age recipient"`
+	SSHAgentKey  string `flag:"ssh-agent-key,Derive the access key using this ssh-agent key"`
+	TPM          bool   `flag:"tpm,Seal a random access key to the TPM of this machine"`
+	TPMPCRs      string `flag:"tpm-pcrs,Require these PCR values to unseal the key (with --tpm)"`
+	TouchID      bool   `flag:"touch-id,Wrap a random access key with a Secure Enclave key requiring Touch ID"`
+	FIDO2        bool   `flag:"fido2,Derive the access key using a FIDO2 security key"`
+	PKCS11Key    string `flag:"pkcs11-key,Wrap a random access key with the PKCS#11 key pair with this ID"`
+	PKCS11Module string `flag:"pkcs11-module,default=$LEAF_PKCS11_MODULE,PKCS#11 module path (with --pkcs11-key)"`
+}
+
+func runKeyAdd(env *command.Env, name string) error {
+	ks := leaf.KeySlot{Name: name}
+	var accessKey []byte
+	var nk int
+	for _, s := range []string{keyAddFlags.KeyFile, keyAddFlags.AgeRecipient, keyAddFlags.SSHAgentKey, keyAddFlags.PKCS11Key} {
+		if s != "" {
+			nk++
+		}
+	}
+	for _, b := range []bool{keyAddFlags.TPM, keyAddFlags.TouchID, keyAddFlags.FIDO2} {
+		if b {
+			nk++
+		}
+	}
+	if nk > 1 {
+		return env.Usagef("at most one of --key-file, --age-recipient, --ssh-agent-key, --tpm, --touch-id, --fido2, --pkcs11-key may be set")
+	} else if keyAddFlags.TPMPCRs != "" && !keyAddFlags.TPM {
+		return env.Usagef("--tpm-pcrs requires --tpm")
+	} else if keyAddFlags.KeyFile != "" {
+		ak, err := os.ReadFile(keyAddFlags.KeyFile)
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+	} else if keyAddFlags.AgeRecipient != "" {
+		accessKey = make([]byte, leaf.AccessKeyLen)
+		if _, err := cryptorand.Read(accessKey); err != nil {
+			return err
+		}
+		wrapped, err := ageEncrypt(keyAddFlags.AgeRecipient, accessKey)
+		if err != nil {
+			return err
+		}
+		ks.Params, err = json.Marshal(slotParams{Age: wrapped})
+		if err != nil {
+			return err
+		}
+	} else if keyAddFlags.SSHAgentKey != "" {
+		ak, p, err := sshNewKey(keyAddFlags.SSHAgentKey)
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+		ks.Params, err = json.Marshal(slotParams{SSH: p})
+		if err != nil {
+			return err
+		}
+	} else if keyAddFlags.TPM {
+		var pcrs string
+		if keyAddFlags.TPMPCRs != "" {
+			var err error
+			pcrs, err = parsePCRs(keyAddFlags.TPMPCRs)
+			if err != nil {
+				return env.Usagef("%v", err)
+			}
+		}
+		ak, p, err := tpmNewKey(pcrs)
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+		ks.Params, err = json.Marshal(slotParams{TPM: p})
+		if err != nil {
+			return err
+		}
+	} else if keyAddFlags.TouchID {
+		ak, p, err := seNewKey()
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+		ks.Params, err = json.Marshal(slotParams{SE: p})
+		if err != nil {
+			return err
+		}
+	} else if keyAddFlags.FIDO2 {
+		ak, p, err := fido2NewKey()
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+		ks.Params, err = json.Marshal(slotParams{FIDO2: p})
+		if err != nil {
+			return err
+		}
+	} else if keyAddFlags.PKCS11Key != "" {
+		ak, p, err := pkcs11NewKey(keyAddFlags.PKCS11Module, keyAddFlags.PKCS11Key)
+		if err != nil {
+			return err
+		}
+		accessKey = ak
+		ks.Params, err = json.Marshal(slotParams{PKCS11: p})
+		if err != nil {
+			return err
+		}
+	} else if ak, err := promptAccessKey(fmt.Sprintf("key slot %q", name), true); err != nil {
+		return err
+	} else {
+		accessKey = ak
+	}
+	f := env.Config.(*leaf.File)
+	if err := f.AddKeySlot(ks, accessKey); err != nil {
+		return err
+	}
+	notify(env, object{"event": "added", "slot": name}, "added key slot %q", name)
+	return saveFile(f)
+}
+
+func runKeyRemove(env *command.Env, name string) error {
+	f := env.Config.(*leaf.File)
+
+	// Warn before leaving only slots that need a particular device to open.
+	fallback, removing := 0, false
+	for _, ks := range f.KeySlots() {
+		if ks.Name == name {
+			removing = !hardwareSlot(ks)
+		} else if !hardwareSlot(ks) {
+			fallback++
+		}
+	}
+	if removing && fallback == 0 {
+		if err := confirm(env, "The remaining key slots all require a hardware device. Remove %q anyway?", name); err != nil {
+			return err
+		}
+	}
+	if err := f.RemoveKey(name); err != nil {
+		return err
+	}
+	notify(env, object{"event": "removed", "slot": name}, "removed key slot %q", name)
+	return saveFile(f)
+}
+
+var compactFlags struct {
+	Replace bool `flag:"replace,Back up the file and replace it with the compacted log"`
+}
+
+func runCompact(env *command.Env) error {
+	f := env.Config.(*leaf.File)
+	before := fileDB(f).LogLen()
+	fileDB(f).Compact()
+	after := fileDB(f).LogLen()
+	removed := before - after
+	if !compactFlags.Replace || dryRunFlags.DryRun {
+		notify(env, object{"event": "compact", "entries": before, "removed": removed, "replaced": false},
+			"compacting would remove %d of %d log entries (use --replace to apply)", removed, before)
+		return nil
+	} else if !f.IsModified() {
+		notify(env, object{"event": "compact", "entries": before, "removed": 0, "replaced": false},
+			"the log is already compact")
+		return nil
+	}
+	if err := confirm(env, "Remove %d of %d log entries?", removed, before); err != nil {
+		return err
+	}
+	backup := fmt.Sprintf("%s.%s.bak", settings.FilePath, time.Now().UTC().Format("20060102T150405Z"))
+	if err := copyFile(settings.FilePath, backup); err != nil {
+		return fmt.Errorf("back up file: %w", err)
+	}
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "compact", "entries": before, "removed": removed, "replaced": true, "backup": backup},
+		"removed %d of %d log entries; original saved as %q", removed, before, backup)
+	return nil
+}
+
+// copyFile copies the contents of the file at src to a new file at dst, which
+// must not already exist.
+func copyFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 func runDebugLog(env *command.Env) error {
 	f := env.Config.(*leaf.File)
-	return writePrettyJSON(f.Database())
+	return writePrettyJSON(fileDB(f))
+}
+
+var debugSnapshotFlags struct {
+	Redact bool `flag:"redact,Replace values with placeholders describing their type and length"`
 }
 
 func runDebugSnapshot(env *command.Env) error {
 	f := env.Config.(*leaf.File)
-	return writePrettyJSON(f.Database().Snapshot())
+	snap := fileDB(f).Snapshot()
+	if debugSnapshotFlags.Redact {
+		snap = redactSnapshot(snap)
+	}
+	return writePrettyJSON(snap)
 }
 
 func runDebugCompact(env *command.Env) error {
 	f := env.Config.(*leaf.File)
-	f.Database().Compact()
+	before := fileDB(f).LogLen()
+	fileDB(f).Compact()
 	if rewindFlags.Replace {
-		if f.IsModified() {
-			return saveFile(f)
+		if !f.IsModified() {
+			return nil
 		}
-		return nil
+		if err := confirm(env, "Replace %q, discarding the history of %d log entries?", settings.FilePath, before); err != nil {
+			return err
+		}
+		return saveFile(f)
 	}
-	return writePrettyJSON(f.Database())
+	return writePrettyJSON(fileDB(f))
 }
 
 func runDebugImport(env *command.Env) error {
@@ -169,16 +1038,29 @@ func runDebugImport(env *command.Env) error {
 	if err != nil {
 		return err
 	}
-	var db map[string]map[string]any
-	if err := json.Unmarshal(data, &db); err != nil {
-		return fmt.Errorf("decoding input: %w", err)
+	decode := decodeSnapshot
+	if importFlags.CSV {
+		decode = decodeCSV
+	} else if importFlags.Table != "" || importFlags.KeyColumn != "" || importFlags.ValueCols != "" {
+		return env.Usagef("--table, --key-column, and --value-columns require --csv")
+	}
+	db, err := decode(data)
+	if err != nil {
+		return err
+	} else if err := checkDryRun(env); err != nil {
+		return err
 	}
 	f := env.Config.(*leaf.File)
-	for tname, tab := range db {
-		dbTab := f.Database().Table(tname)
-		for key, val := range tab {
-			dbTab.Set(key, val)
-		}
+	if dryRunFlags.DryRun {
+		before := fileDB(f).Snapshot()
+		importSnapshot(f, db)
+		return printDryRun(settings.FilePath, before, fileDB(f).Snapshot())
+	}
+	importSnapshot(f, db)
+	if importFlags.CSV {
+		n := len(db[importFlags.Table])
+		notify(env, object{"event": "imported", "table": importFlags.Table, "keys": n},
+			"imported %d %s into table %q", n, plural(n, "row", "rows"), importFlags.Table)
 	}
 	if f.IsModified() {
 		return saveFile(f)
@@ -186,30 +1068,253 @@ func runDebugImport(env *command.Env) error {
 	return nil
 }
 
+// decodeSnapshot decodes a JSON snapshot mapping table names to objects of
+// keys and values, as written by the export command.
+func decodeSnapshot(data []byte) (map[string]map[string]any, error) {
+	var snap map[string]map[string]any
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return nil, fmt.Errorf("decoding input: %w", err)
+	}
+	return snap, nil
+}
+
+// importSnapshot sets the tables and values of snap into f.
+func importSnapshot(f *leaf.File, snap map[string]map[string]any) {
+	for tname, tab := range snap {
+		dbTab := fileDB(f).Table(tname)
+		for key, val := range tab {
+			dbTab.Set(key, val)
+		}
+	}
+}
+
 var rewindFlags struct {
 	Replace bool `flag:"replace,Replace the file with the rewound state (UNSAFE)"`
 }
 
 func runDebugRewind(env *command.Env, when string) error {
-	ts, err := time.Parse(time.RFC3339Nano, when)
+	ts, err := parseTimestamp(when)
 	if err != nil {
-		v, err := strconv.ParseInt(when, 10, 64)
-		if err != nil {
-			return env.Usagef("invalid timestamp format: %q", when)
+		return env.Usagef("invalid timestamp format: %q", when)
+	} else if err := checkDryRun(env); err != nil {
+		return err
+	}
+
+	f := env.Config.(*leaf.File)
+	before, old := fileDB(f).LogLen(), fileDB(f).Snapshot()
+	fileDB(f).Rewind(ts)
+	notify(env, object{"event": "rewound", "time": ts.Format(time.RFC3339Nano), "timestamp": ts.UnixMicro()},
+		"Rewound database to %s (%d)", ts.Format(time.RFC3339), ts.UnixMicro())
+	if dryRunFlags.DryRun {
+		return printDryRun(settings.FilePath, old, fileDB(f).Snapshot())
+	} else if rewindFlags.Replace {
+		if !f.IsModified() {
+			return nil
+		}
+		n := before - fileDB(f).LogLen()
+		if err := confirm(env, "Replace %q, discarding %d %s?", settings.FilePath, n, plural(n, "log entry", "log entries")); err != nil {
+			return err
 		}
-		ts = time.UnixMicro(v)
+		return saveFile(f)
 	}
+	return writePrettyJSON(fileDB(f).Snapshot())
+}
 
+// plural returns one if n == 1, otherwise many.
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// parseTimestamp parses s as an RFC 3339 time or as an integer count of
+// microseconds since the Unix epoch.
+func parseTimestamp(s string) (time.Time, error) {
+	if ts, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return ts, nil
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return time.UnixMicro(v), nil
+}
+
+func runTag(env *command.Env, args ...string) error {
 	f := env.Config.(*leaf.File)
-	f.Database().Rewind(ts)
-	fmt.Fprintf(env, "Rewound database to %s (%d)\n", ts.Format(time.RFC3339), ts.UnixMicro())
-	if rewindFlags.Replace {
-		if f.IsModified() {
-			return saveFile(f)
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	} else if len(args) == 0 {
+		tags := fileDB(f).Tags()
+		var out []object
+		for _, tag := range tags {
+			out = append(out, object{"name": tag.Name, "time": tag.Time.Format(time.RFC3339Nano)})
+		}
+		return printResult(out, func() {
+			for _, tag := range tags {
+				fmt.Printf("%s\t%s\n", tag.Name, tag.Time.Format(time.RFC3339))
+			}
+		})
+	}
+	if err := fileDB(f).AddTag(args[0]); err != nil {
+		return err
+	}
+	notify(env, object{"event": "tagged", "tag": args[0]}, "added tag %q", args[0])
+	return saveFile(f)
+}
+
+func runChainEnable(env *command.Env) error {
+	f := env.Config.(*leaf.File)
+	if fileDB(f).IsChained() {
+		notify(env, object{"event": "unchanged"}, "the log is already hash-chained")
+		return nil
+	}
+	fileDB(f).EnableChain()
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	n := fileDB(f).LogLen()
+	notify(env, object{"event": "chained", "head": hex.EncodeToString(fileDB(f).ChainHead())},
+		"enabled hash chaining for %d %s", n, plural(n, "log entry", "log entries"))
+	return nil
+}
+
+func runChainVerify(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	}
+	var head []byte
+	if len(args) == 1 {
+		var err error
+		head, err = hex.DecodeString(args[0])
+		if err != nil || len(head) != sha256.Size {
+			return env.Usagef("invalid chain head %q", args[0])
 		}
+	}
+	db := fileDB(env.Config.(*leaf.File))
+	if err := db.VerifyChain(head); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+	h := hex.EncodeToString(db.ChainHead())
+	return printResult(object{"entries": db.LogLen(), "head": h}, func() {
+		n := db.LogLen()
+		infof(env, "verified the hash chain of %d %s\n", n, plural(n, "log entry", "log entries"))
+		fmt.Println(h)
+	})
+}
+
+func runRollback(env *command.Env, target string) error {
+	if err := checkDryRun(env); err != nil {
+		return err
+	}
+	f := env.Config.(*leaf.File)
+	db := fileDB(f)
+	before := db.Snapshot()
+	var changed bool
+	if _, ok := db.FindTag(target); ok {
+		changed, _ = db.RewindTag(target)
+	} else if ts, err := parseTimestamp(target); err != nil {
+		return env.Usagef("%q is not a tag or a timestamp", target)
+	} else {
+		changed = db.Rewind(ts)
+	}
+	if !changed {
+		notify(env, object{"event": "unchanged"}, "nothing to roll back")
+		return nil
+	}
+
+	changes := diffSnapshots(before, db.Snapshot())
+	var out []object
+	for _, c := range changes {
+		out = append(out, object{"op": c.Op, "table": c.Table, "key": c.Key})
+	}
+	if err := printResult(out, func() {
+		fmt.Fprintf(env, "rolling back to %q will make %d changes:\n", target, len(changes))
+		p := newPainter(os.Stderr)
+		for _, c := range changes {
+			fmt.Fprintln(env, " ", c.format(p))
+		}
+	}); err != nil {
+		return err
+	} else if dryRunFlags.DryRun {
 		return nil
 	}
-	return writePrettyJSON(f.Database().Snapshot())
+	if err := confirm(env, "Roll back?"); err != nil {
+		return err
+	}
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "rolled-back", "target": target, "changes": len(changes)},
+		"rolled back to %q", target)
+	return nil
+}
+
+var watchFlags struct {
+	Interval time.Duration `flag:"interval,default=1s,How often to check the file for changes"`
+}
+
+func runWatch(env *command.Env, args ...string) error {
+	if len(args) > 2 {
+		return env.Usagef("extra arguments: %q", args[2:])
+	} else if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	} else if watchFlags.Interval <= 0 {
+		return env.Usagef("invalid interval %v", watchFlags.Interval)
+	}
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	load := func() (snapshot, fs.FileInfo, error) {
+		fi, err := os.Stat(settings.FilePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		f, err := openWithKey(settings.FilePath, accessKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return fileDB(f).Snapshot(), fi, nil
+	}
+	cur, fi, err := load()
+	if err != nil {
+		return err
+	}
+	notify(env, object{"event": "watching", "file": settings.FilePath}, "watching %q", settings.FilePath)
+
+	tick := time.NewTicker(watchFlags.Interval)
+	defer tick.Stop()
+	for range tick.C {
+		st, err := os.Stat(settings.FilePath)
+		if err != nil {
+			notify(env, object{"event": "error", "error": err.Error()}, "stat: %v", err)
+			continue
+		} else if st.ModTime().Equal(fi.ModTime()) && st.Size() == fi.Size() {
+			continue // no change
+		}
+		next, nfi, err := load()
+		if err != nil {
+			notify(env, object{"event": "error", "error": err.Error()}, "reload: %v", err)
+			fi = st // don't retry until it changes again
+			continue
+		}
+		now := time.Now()
+		p := newPainter(os.Stdout)
+		for _, c := range diffSnapshots(cur, next) {
+			if len(args) > 0 && c.Table != args[0] {
+				continue
+			} else if len(args) > 1 && c.Key != args[1] {
+				continue
+			}
+			printResult(object{"time": now.Format(time.RFC3339), "op": c.Op, "table": c.Table, "key": c.Key}, func() {
+				fmt.Println(p.paint(now.Format(time.DateTime), dim), c.format(p))
+			})
+		}
+		cur, fi = next, nfi
+	}
+	return nil
 }
 
 var keyFileFlags struct {
@@ -223,7 +1328,7 @@ func runDebugKeyFile(env *command.Env, keyFile string) error {
 		if _, err := cryptorand.Read(accessKey); err != nil {
 			return err
 		}
-		fmt.Fprintf(env, "Generated a random %d-byte key\n", len(accessKey))
+		notify(env, object{"event": "generated", "bytes": len(accessKey)}, "Generated a random %d-byte key", len(accessKey))
 	} else if ak, err := promptAccessKey("", true); err != nil {
 		return err
 	} else {
@@ -231,3 +1336,66 @@ func runDebugKeyFile(env *command.Env, keyFile string) error {
 	}
 	return atomicfile.WriteData(keyFile, accessKey, 0600)
 }
+
+func runDebugUnwrap(env *command.Env, output string) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	} else if output != "-" {
+		if _, err := os.Lstat(output); err == nil {
+			return existsf("output file %q already exists", output)
+		}
+	}
+	data, err := os.ReadFile(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	payload, err := leaf.Unwrap(accessKey, bytes.NewReader(data))
+	if err != nil {
+		return openError(err)
+	}
+	if output == "-" {
+		_, err := os.Stdout.Write(payload)
+		return err
+	}
+	if err := atomicfile.WriteData(output, payload, 0600); err != nil {
+		return err
+	}
+	notify(env, object{"event": "unwrapped", "file": output, "bytes": len(payload)},
+		"wrote %d bytes of plaintext to %q", len(payload), output)
+	return nil
+}
+
+func runDebugWrap(env *command.Env, input, output string) error {
+	if _, err := os.Lstat(output); err == nil {
+		return existsf("output file %q already exists", output)
+	}
+	var payload []byte
+	var err error
+	if input == "-" {
+		payload, err = io.ReadAll(os.Stdin)
+	} else {
+		payload, err = os.ReadFile(input)
+	}
+	if err != nil {
+		return err
+	}
+	accessKey, params, err := newAccessKey(output)
+	if err != nil {
+		return err
+	}
+	f, err := leaf.Wrap(accessKey, payload)
+	if err != nil {
+		return err
+	} else if err := setDefaultSlotParams(f, accessKey, params); err != nil {
+		return err
+	}
+	if err := saveFileAs(output, f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "wrapped", "file": output}, "wrote %q", output)
+	return nil
+}
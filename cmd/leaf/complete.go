@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+func runCompletion(env *command.Env, shell string) error {
+	name := rootEnv(env).Command.Name
+	var script string
+	switch shell {
+	case "bash":
+		script = bashCompletion
+	case "zsh":
+		script = zshCompletion
+	case "fish":
+		script = fishCompletion
+	default:
+		return env.Usagef("unsupported shell %q (want bash, zsh, or fish)", shell)
+	}
+	fmt.Print(strings.ReplaceAll(script, "@PROG@", name))
+	return nil
+}
+
+const bashCompletion = `# bash completion for @PROG@
+_@PROG@_complete() {
+  local IFS=$'\n'
+  COMPREPLY=($(@PROG@ complete -- "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _@PROG@_complete @PROG@
+`
+
+const zshCompletion = `# zsh completion for @PROG@
+_@PROG@_complete() {
+  local -a cands
+  cands=("${(@f)$(@PROG@ complete -- "${(@)words[2,$CURRENT]}" 2>/dev/null)}")
+  compadd -a cands
+}
+compdef _@PROG@_complete @PROG@
+`
+
+const fishCompletion = `# fish completion for @PROG@
+function __@PROG@_complete
+    set -l args (commandline -opc)[2..-1] (commandline -ct)
+    @PROG@ complete -- $args 2>/dev/null
+end
+complete -c @PROG@ -f -a '(__@PROG@_complete)'
+`
+
+// runComplete prints completion candidates for the last of the given words,
+// which are the command-line arguments following the program name. This is
+// the callback used by the scripts generated by the completion command.
+func runComplete(env *command.Env) error {
+	words := env.Args
+	if len(words) != 0 && words[0] == "--" {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	root := rootEnv(env)
+	rootFlags := &root.Command.Flags // already populated
+
+	cmd, fs := root.Command, rootFlags
+	var pos []string // positional arguments to cmd
+	var skip bool
+	for _, w := range words[:len(words)-1] {
+		if skip {
+			skip = false
+			continue
+		}
+		if name, ok := strings.CutPrefix(w, "-"); ok && name != "" {
+			name, _, hasValue := strings.Cut(strings.TrimPrefix(name, "-"), "=")
+			f := fs.Lookup(name)
+			if f == nil {
+				f = rootFlags.Lookup(name)
+			}
+			skip = f != nil && !hasValue && !isBoolFlag(f)
+			continue
+		}
+		if len(pos) == 0 {
+			if sub := cmd.FindSubcommand(w); sub != nil {
+				cmd, fs = sub, commandFlags(root, sub)
+				continue
+			}
+		}
+		pos = append(pos, w)
+	}
+
+	last := words[len(words)-1]
+	var cands []string
+	if strings.HasPrefix(last, "-") {
+		for _, s := range []*flag.FlagSet{fs, rootFlags} {
+			s.VisitAll(func(f *flag.Flag) {
+				if len(f.Name) == 1 {
+					cands = append(cands, "-"+f.Name)
+				} else {
+					cands = append(cands, "--"+f.Name)
+				}
+			})
+		}
+	} else {
+		if len(pos) == 0 {
+			for _, sub := range cmd.Commands {
+				if !sub.Unlisted {
+					cands = append(cands, sub.Name)
+				}
+			}
+		}
+		if cmd.Run != nil {
+			cands = append(cands, completeArg(cmd, pos)...)
+		}
+	}
+	sort.Strings(cands)
+	for i, c := range cands {
+		if strings.HasPrefix(c, last) && (i == 0 || c != cands[i-1]) {
+			fmt.Println(c)
+		}
+	}
+	return nil
+}
+
+// completeArg returns completion candidates for the positional argument of
+// cmd following pos. Candidates are inferred from the first usage line of the
+// command: Arguments named like tables complete to table names, and <key>
+// arguments complete to the keys of the most recent table argument.
+//
+// Table and key names are completed only if the file can be opened without
+// prompting for a passphrase.
+func completeArg(cmd *command.C, pos []string) []string {
+	usage, _, _ := strings.Cut(cmd.Usage, "\n")
+	var kinds []string
+	for _, tok := range strings.Fields(usage) {
+		tok = strings.Trim(tok, "[]")
+		if tok == "..." && len(kinds) != 0 {
+			tok = kinds[len(kinds)-1]
+		}
+		kinds = append(kinds, tok)
+	}
+	if len(pos) >= len(kinds) {
+		if len(kinds) == 0 || !strings.HasSuffix(usage, "...]") {
+			return nil
+		}
+		pos = pos[:len(kinds)-1] // repeat the final kind
+	}
+
+	f := completionFile()
+	if f == nil {
+		return nil
+	}
+	kind := kinds[len(pos)]
+	switch {
+	case strings.Contains(kind, "table"):
+		return fileDB(f).TableNames()
+	case kind == "<key>":
+		for i := len(pos) - 1; i >= 0; i-- {
+			if strings.Contains(kinds[i], "table") {
+				if tab, ok := fileDB(f).GetTable(pos[i]); ok {
+					return tab.Keys()
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// completionFile opens the LEAF file for completion, if that is possible
+// without user interaction. Otherwise it returns nil.
+func completionFile() *leaf.File {
+	if settings.FilePath == "" || settings.AccessKeyFile == "" {
+		return nil
+	}
+	f, err := openFile()
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// commandFlags returns a fresh flag set populated with the flags of cmd.
+// Since binding resets flag targets to their defaults, this must not be used
+// for a command whose flags have already been parsed.
+func commandFlags(env *command.Env, cmd *command.C) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	if cmd.SetFlags != nil {
+		cmd.SetFlags(env, fs)
+	}
+	return fs
+}
+
+func isBoolFlag(f *flag.Flag) bool {
+	v, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && v.IsBoolFlag()
+}
+
+// rootEnv returns the root of the environment chain containing env.
+func rootEnv(env *command.Env) *command.Env {
+	for env.Parent != nil {
+		env = env.Parent
+	}
+	return env
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/creachadair/command"
+)
+
+// config is the contents of the configuration file.
+type config struct {
+	// The name of the profile to use if none is selected with -p.
+	Default string `toml:"default"`
+
+	// The idle timeout of the key agent, if --idle is not set. This is not
+	// part of a profile, since one agent serves all files.
+	AgentIdle time.Duration `toml:"agent-idle"`
+
+	// Named profiles, selected with -p.
+	Profiles map[string]profile `toml:"profiles"`
+}
+
+// A profile holds default settings for a LEAF file.
+type profile struct {
+	File        string `toml:"file"`         // LEAF file path
+	KeyFile     string `toml:"key-file"`     // access key file path
+	AgeIdentity string `toml:"age-identity"` // age identity file path
+	Table       string `toml:"table"`        // default table name
+	Database    string `toml:"db"`           // named database
+	Backups     int    `toml:"backups"`      // number of backups to keep
+}
+
+// defaultTable is the default table name set by the selected profile, if any.
+var defaultTable string
+
+// configAgentIdle is the idle timeout of the key agent set by the
+// configuration file, if any.
+var configAgentIdle time.Duration
+
+// configPath returns the path of the configuration file. It is named by
+// LEAF_CONFIG if set, or is config.toml in the leaf subdirectory of
+// XDG_CONFIG_HOME, or of ~/.config if that is not set.
+func configPath() (string, error) {
+	if p := os.Getenv("LEAF_CONFIG"); p != "" {
+		return p, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "leaf", "config.toml"), nil
+}
+
+// loadConfig reads the configuration file at path.
+func loadConfig(path string) (*config, error) {
+	var cfg config
+	md, err := toml.DecodeFile(path, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	if keys := md.Undecoded(); len(keys) != 0 {
+		return nil, fmt.Errorf("%s: unknown setting %q", path, keys[0].String())
+	}
+	return &cfg, nil
+}
+
+// applyProfile is the Init function of the root command. It loads the
+// profile selected by -p, or the default profile of the configuration file,
+// and uses its settings where the corresponding flags are not set.
+func applyProfile(env *command.Env) error {
+	if settings.Porcelain {
+		settings.JSON = true
+	}
+
+	// A credential passed by systemd takes precedence over the profile, since
+	// it was set explicitly for the service, but not over a key source set
+	// by a flag or the environment.
+	if !keySourceSet() {
+		cred, err := systemdCredential()
+		if err != nil {
+			return err
+		}
+		settings.AccessKeyFile = cred
+	}
+
+	// The doctor command reports a problem with the configuration, rather
+	// than failing because of it.
+	if err := loadProfile(); err != nil {
+		if len(env.Args) != 0 && env.Args[0] == "doctor" {
+			profileErr = err
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// profileErr is the error reported by loadProfile, if any, when it is
+// deferred to the doctor command.
+var profileErr error
+
+// loadProfile loads the selected profile from the configuration file, if
+// any, and uses its settings where the corresponding flags are not set. It
+// also records the settings of the file that do not belong to a profile.
+func loadProfile() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		if settings.Profile != "" {
+			return fmt.Errorf("profile %q: no config file at %s", settings.Profile, path)
+		}
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("config: %w", err)
+	}
+	configAgentIdle = cfg.AgentIdle
+
+	name := settings.Profile
+	if name == "" {
+		name = cfg.Default
+	}
+	if name == "" {
+		return nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(cfg.Profiles))
+		for n := range cfg.Profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("profile %q not found in %s (have %s)", name, path, strings.Join(names, ", "))
+	}
+	dir := filepath.Dir(path)
+	setDefault(&settings.FilePath, configFilePath(dir, p.File))
+	// The access key sources of the profile are used only if no source was
+	// set by a flag or the environment, since findAccessKey prefers a key
+	// file to an explicit --key-stdin, --age-identity, or --ssh-key.
+	if !keySourceSet() {
+		settings.AccessKeyFile = configFilePath(dir, p.KeyFile)
+		settings.AgeIdentity = configFilePath(dir, p.AgeIdentity)
+	}
+	setDefault(&settings.Database, p.Database)
+	if settings.Backups == 0 {
+		settings.Backups = p.Backups
+	}
+	defaultTable = p.Table
+	return nil
+}
+
+// setDefault sets *s to value if *s is empty.
+func setDefault(s *string, value string) {
+	if *s == "" {
+		*s = value
+	}
+}
+
+// configFilePath expands a path from the configuration file. A leading "~/"
+// is replaced by the user's home directory, and a relative path is taken
+// relative to dir, the directory containing the configuration file.
+func configFilePath(dir, path string) string {
+	if path == "" {
+		return ""
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path)
+	}
+	return path
+}
+
+// withDefaultTable returns an Init function for a command that takes n
+// arguments, the first of which is a table name. If only n-1 arguments are
+// given and the selected profile has a default table, the table is inserted
+// as the first argument. The file is then opened as for requireFile.
+func withDefaultTable(n int) func(*command.Env) error {
+	return func(env *command.Env) error {
+		if len(env.Args) == n-1 && defaultTable != "" {
+			env.Args = append([]string{defaultTable}, env.Args...)
+		}
+		return requireFile(env)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var convertFlags struct {
+	Version     int    `flag:"to-version,Target file format version"`
+	Compression string `flag:"compression,Target payload compression"`
+	Codec       string `flag:"codec,Target payload encoding"`
+	Cipher      string `flag:"cipher,Target payload cipher"`
+	ShardSize   int    `flag:"shard-size,default=-1,Store the log in shards of this many entries (0 for none)"`
+}
+
+// Format parameters supported by the library. Version 2 is version 1 with
+// a sharded log, version 3 adds named databases, and version 4 adds ciphers
+// other than the default.
+var (
+	formatVersions = []int{1, 2, 3, 4}
+	compressions   = []string{"snappy"}
+	codecs         = []string{"json"}
+	ciphers        = []string{leaf.CipherXChaCha20Poly1305, leaf.CipherAESSIV}
+)
+
+func runConvert(env *command.Env) error {
+	switch {
+	case convertFlags.Version != 0 && !slices.Contains(formatVersions, convertFlags.Version):
+		return fmt.Errorf("format version %d is not supported (have %v)", convertFlags.Version, formatVersions)
+	case convertFlags.Compression != "" && !slices.Contains(compressions, convertFlags.Compression):
+		return fmt.Errorf("compression %q is not supported (have %q)", convertFlags.Compression, compressions)
+	case convertFlags.Codec != "" && !slices.Contains(codecs, convertFlags.Codec):
+		return fmt.Errorf("codec %q is not supported (have %q)", convertFlags.Codec, codecs)
+	case convertFlags.Cipher != "" && !slices.Contains(ciphers, convertFlags.Cipher):
+		return fmt.Errorf("cipher %q is not supported (have %q)", convertFlags.Cipher, ciphers)
+	case convertFlags.Version == 1 && convertFlags.ShardSize > 0:
+		return env.Usagef("format version 1 does not support --shard-size")
+	case convertFlags.Version == 2 && convertFlags.ShardSize == 0:
+		return env.Usagef("format version 2 requires a positive --shard-size")
+	case settings.FilePath == "":
+		return env.Usagef("no file path is defined")
+	case settings.ReadOnly:
+		return errReadOnly
+	}
+
+	// Open the file directly, since the access key is needed again to verify
+	// the converted output.
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(settings.FilePath); err != nil {
+		return err
+	}
+	f, err := openWithKey(settings.FilePath, accessKey)
+	if err != nil {
+		return err
+	}
+	if convertFlags.Cipher != "" {
+		if err := f.SetCipher(convertFlags.Cipher); err != nil {
+			return err
+		}
+	}
+	switch {
+	case convertFlags.ShardSize >= 0:
+		f.SetShardSize(convertFlags.ShardSize)
+	case convertFlags.Version == 1:
+		f.SetShardSize(0)
+	case convertFlags.Version == 2 && f.ShardSize() == 0:
+		f.SetShardSize(leaf.DefaultShardSize)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return err
+	}
+	cf, err := leaf.Open(accessKey, bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	} else if err := sameContents(f, cf); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	} else if cf.Cipher() != f.Cipher() {
+		return fmt.Errorf("verify: cipher is %q, want %q", cf.Cipher(), f.Cipher())
+	} else if cf.ShardSize() != f.ShardSize() {
+		return fmt.Errorf("verify: shard size is %d, want %d", cf.ShardSize(), f.ShardSize())
+	}
+	info, err := leaf.ReadInfo(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		return fmt.Errorf("verify: %w", err)
+	} else if convertFlags.Version != 0 && info.Version > convertFlags.Version {
+		return fmt.Errorf("the file needs format version %d, which is later than %d (see \"help convert\")",
+			info.Version, convertFlags.Version)
+	}
+	if err := saveFileAs(settings.FilePath, cf); err != nil {
+		return err
+	}
+	notify(env, object{"event": "converted", "file": settings.FilePath, "version": info.Version,
+		"cipher": info.Cipher, "compression": info.Compression, "codec": info.Codec},
+		"converted %q (version %d, %s, %s, %s)", settings.FilePath, info.Version, info.Cipher, info.Compression, info.Codec)
+	return nil
+}
+
+// sameContents reports an error if a and b differ in their key slots, or in
+// the logs or current contents of any of their databases.
+func sameContents(a, b *leaf.File) error {
+	if !slices.EqualFunc(a.KeySlots(), b.KeySlots(), func(x, y leaf.KeySlot) bool {
+		return x.Name == y.Name && bytes.Equal(x.Params, y.Params)
+	}) {
+		return errors.New("key slots differ")
+	}
+	names := a.DatabaseNames()
+	if !slices.Equal(names, b.DatabaseNames()) {
+		return errors.New("databases differ")
+	}
+	for _, name := range append([]string{""}, names...) {
+		adb, _ := a.GetDatabase(name)
+		bdb, _ := b.GetDatabase(name)
+		if err := sameDatabase(adb, bdb); err != nil {
+			if name != "" {
+				return fmt.Errorf("database %q: %w", name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// sameDatabase reports an error if a and b differ in their logs or current
+// contents.
+func sameDatabase(adb, bdb *leaf.Database) error {
+	if adb.LogLen() != bdb.LogLen() {
+		return fmt.Errorf("log length differs: %d != %d", adb.LogLen(), bdb.LogLen())
+	}
+	if len(diffSnapshots(adb.Snapshot(), bdb.Snapshot())) != 0 {
+		return errors.New("contents differ")
+	}
+	alog, _ := json.Marshal(adb.Log())
+	blog, _ := json.Marshal(bdb.Log())
+	if !bytes.Equal(alog, blog) {
+		return errors.New("logs differ")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var importFlags struct {
+	CSV       bool   `flag:"csv,Read the input as CSV with a header row"`
+	Table     string `flag:"table,Table to import CSV rows into"`
+	KeyColumn string `flag:"key-column,CSV column holding the key of each row"`
+	ValueCols string `flag:"value-columns,Comma-separated CSV columns to store (default: all others)"`
+}
+
+// A csvField maps a CSV column to a field of the value stored for a row.
+type csvField struct {
+	Name   string // field name in the stored object
+	Column int    // column index in the CSV
+}
+
+// decodeCSV decodes CSV data with a header row into a snapshot containing the
+// table named by --table. Each row becomes a key, named by the --key-column,
+// whose value is an object with a field for each of the --value-columns.
+func decodeCSV(data []byte) (map[string]map[string]any, error) {
+	if importFlags.Table == "" {
+		return nil, errors.New("--table is required with --csv")
+	} else if importFlags.KeyColumn == "" {
+		return nil, errors.New("--key-column is required with --csv")
+	}
+	// Spreadsheets often begin UTF-8 CSV output with a byte order mark.
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.FieldsPerRecord = -1 // allow ragged rows; missing cells are empty
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decoding CSV: %w", err)
+	} else if len(rows) == 0 {
+		return nil, errors.New("CSV input has no header row")
+	}
+	header := rows[0]
+	keyCol := slices.Index(header, importFlags.KeyColumn)
+	if keyCol < 0 {
+		return nil, fmt.Errorf("key column %q not found in CSV header", importFlags.KeyColumn)
+	}
+	fields, err := csvFields(header, keyCol, importFlags.ValueCols)
+	if err != nil {
+		return nil, err
+	}
+
+	tab := make(map[string]any)
+	for i, row := range rows[1:] {
+		line := i + 2
+		if keyCol >= len(row) || row[keyCol] == "" {
+			return nil, fmt.Errorf("CSV line %d: empty key", line)
+		}
+		key := row[keyCol]
+		if _, ok := tab[key]; ok {
+			return nil, fmt.Errorf("CSV line %d: duplicate key %q", line, key)
+		}
+		val := make(map[string]string, len(fields))
+		for _, fd := range fields {
+			if fd.Column < len(row) {
+				val[fd.Name] = row[fd.Column]
+			} else {
+				val[fd.Name] = ""
+			}
+		}
+		tab[key] = val
+	}
+	return map[string]map[string]any{importFlags.Table: tab}, nil
+}
+
+// csvFields parses a comma-separated list of value columns. Each entry is
+// either a column name, which is also used as the field name, or a mapping
+// "field=column". If spec is empty, all columns except the key are used.
+func csvFields(header []string, keyCol int, spec string) ([]csvField, error) {
+	if spec == "" {
+		var out []csvField
+		for i, name := range header {
+			if i != keyCol {
+				out = append(out, csvField{Name: name, Column: i})
+			}
+		}
+		return out, nil
+	}
+	var out []csvField
+	for _, s := range strings.Split(spec, ",") {
+		name, col, ok := strings.Cut(strings.TrimSpace(s), "=")
+		if !ok {
+			col = name
+		}
+		i := slices.Index(header, col)
+		if i < 0 {
+			return nil, fmt.Errorf("value column %q not found in CSV header", col)
+		} else if name == "" {
+			return nil, fmt.Errorf("empty field name for column %q", col)
+		}
+		out = append(out, csvField{Name: name, Column: i})
+	}
+	return out, nil
+}
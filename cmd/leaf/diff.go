@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/creachadair/command"
+)
+
+// A change describes a difference between two database snapshots.
+type change struct {
+	Op    string // one of the change* constants
+	Table string
+	Key   string // empty for table changes
+}
+
+const (
+	changeAddTable = "add-table"
+	changeDelTable = "delete-table"
+	changeAddKey   = "add"
+	changeSetKey   = "update"
+	changeDelKey   = "delete"
+)
+
+func (c change) String() string { return c.format(false) }
+
+// format renders c as a line of text, with the operation styled by p.
+func (c change) format(p painter) string {
+	op := p.paint(fmt.Sprintf("%-12s", c.Op), opStyle(c.Op))
+	if c.Key == "" && (c.Op == changeAddTable || c.Op == changeDelTable) {
+		return fmt.Sprintf("%s %q", op, c.Table)
+	}
+	return fmt.Sprintf("%s %q %q", op, c.Table, c.Key)
+}
+
+type snapshot = map[string]map[string]json.RawMessage
+
+// diffSnapshots returns the changes required to convert old into new, ordered
+// by table and key.
+func diffSnapshots(old, new snapshot) []change {
+	var out []change
+	for _, name := range unionKeys(old, new) {
+		otab, inOld := old[name]
+		ntab, inNew := new[name]
+		if !inOld {
+			out = append(out, change{Op: changeAddTable, Table: name})
+		}
+		for _, key := range unionKeys(otab, ntab) {
+			ov, okOld := otab[key]
+			nv, okNew := ntab[key]
+			switch {
+			case !okOld:
+				out = append(out, change{Op: changeAddKey, Table: name, Key: key})
+			case !okNew:
+				out = append(out, change{Op: changeDelKey, Table: name, Key: key})
+			case !equalJSON(ov, nv):
+				out = append(out, change{Op: changeSetKey, Table: name, Key: key})
+			}
+		}
+		if !inNew {
+			out = append(out, change{Op: changeDelTable, Table: name})
+		}
+	}
+	return out
+}
+
+// equalJSON reports whether a and b are equivalent JSON texts, ignoring
+// insignificant whitespace.
+func equalJSON(a, b json.RawMessage) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	var ca, cb bytes.Buffer
+	if json.Compact(&ca, a) != nil || json.Compact(&cb, b) != nil {
+		return false
+	}
+	return bytes.Equal(ca.Bytes(), cb.Bytes())
+}
+
+// unionKeys returns the sorted union of the keys of a and b.
+func unionKeys[V any](a, b map[string]V) []string {
+	var out []string
+	for k := range a {
+		out = append(out, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			out = append(out, k)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+var dryRunFlags struct {
+	DryRun bool `flag:"dry-run,Print the changes that would be made, without saving"`
+}
+
+// checkDryRun reports an error if --dry-run is set in a batch. A dry run
+// modifies the file in memory and discards it, which would also discard the
+// earlier changes of the batch.
+func checkDryRun(env *command.Env) error {
+	if dryRunFlags.DryRun && batchFile != nil {
+		return env.Usagef("--dry-run cannot be used in a batch")
+	}
+	return nil
+}
+
+// printDryRun prints the changes required to convert old into new, as the
+// result of a dry run on the named file.
+func printDryRun(name string, old, new snapshot) error {
+	changes := diffSnapshots(old, new)
+	out := []object{}
+	for _, c := range changes {
+		out = append(out, object{"op": c.Op, "table": c.Table, "key": c.Key})
+	}
+	return printResult(object{"event": "dry-run", "file": name, "changes": out}, func() {
+		if len(changes) == 0 {
+			fmt.Printf("dry run: no changes to %s\n", name)
+			return
+		}
+		fmt.Printf("dry run: %d %s to %s:\n", len(changes), plural(len(changes), "change", "changes"), name)
+		p := newPainter(os.Stdout)
+		for _, c := range changes {
+			fmt.Println(" ", c.format(p))
+		}
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var doctorFlags struct {
+	FixPerms bool `flag:"fix-perms,Make the file and access key file private to their owner"`
+}
+
+// A diagnosis is the result of one check made by the doctor command.
+type diagnosis struct {
+	Status string `json:"status"`        // one of the status* constants
+	Check  string `json:"check"`         // what was checked
+	Detail string `json:"detail"`        // what was found
+	Fix    string `json:"fix,omitempty"` // what to do about it, if anything
+}
+
+const (
+	statusOK      = "ok"
+	statusInfo    = "info"
+	statusWarning = "warning"
+	statusError   = "error"
+)
+
+// A checkup accumulates the findings of the doctor command.
+type checkup []diagnosis
+
+func (c *checkup) add(status, check, fix, msg string, args ...any) {
+	*c = append(*c, diagnosis{Status: status, Check: check, Detail: fmt.Sprintf(msg, args...), Fix: fix})
+}
+
+func runDoctor(env *command.Env) error {
+	var c checkup
+	c.checkConfig()
+	data := c.checkFile()
+	c.checkKey(data)
+	c.checkEnv()
+	c.checkAgent()
+
+	var nerr, nwarn int
+	for _, f := range c {
+		switch f.Status {
+		case statusError:
+			nerr++
+		case statusWarning:
+			nwarn++
+		}
+	}
+	if err := printResult(object{"findings": c, "errors": nerr, "warnings": nwarn}, func() {
+		p := newPainter(os.Stdout)
+		for _, f := range c {
+			fmt.Printf("%s %s: %s\n", p.paint(fmt.Sprintf("%-8s", f.Status), statusStyle(f.Status)), p.paint(f.Check, bold), f.Detail)
+			if f.Fix != "" {
+				fmt.Printf("%8s   %s\n", "", p.paint(f.Fix, dim))
+			}
+		}
+	}); err != nil {
+		return err
+	}
+	if nerr != 0 {
+		return fmt.Errorf("found %d %s", nerr, plural(nerr, "problem", "problems"))
+	}
+	return nil
+}
+
+// statusStyle returns the terminal style for a diagnosis status.
+func statusStyle(status string) style {
+	switch status {
+	case statusOK:
+		return green
+	case statusWarning:
+		return yellow
+	case statusError:
+		return red
+	}
+	return dim
+}
+
+// checkConfig reports on the configuration file and the selected profile.
+func (c *checkup) checkConfig() {
+	path, err := configPath()
+	if err != nil {
+		c.add(statusWarning, "config", "set LEAF_CONFIG to the path of the config file", "cannot locate the config file: %v", err)
+		return
+	}
+	if profileErr != nil {
+		c.add(statusError, "config", "correct the config file, or select another profile with -p", "%v", profileErr)
+	} else if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		c.add(statusInfo, "config", "", "no config file at %s", path)
+	} else {
+		c.add(statusOK, "config", "", "loaded %s", path)
+	}
+}
+
+// checkFile reports whether the selected file exists and is a LEAF file in a
+// supported format. It returns the contents of the file, or nil if they could
+// not be read.
+func (c *checkup) checkFile() []byte {
+	const check = "file"
+	path := settings.FilePath
+	if path == "" {
+		c.add(statusError, check, `set -f, LEAF_FILE, or the "file" of a profile`, "no file path is defined")
+		return nil
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.add(statusError, check, `check the path, or create the file with "leaf create"`, "%s does not exist", path)
+		return nil
+	} else if err != nil {
+		c.add(statusError, check, "", "%v", err)
+		return nil
+	} else if !fi.Mode().IsRegular() {
+		c.add(statusError, check, "check the path", "%s is not a regular file", path)
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.add(statusError, check, "check the permissions of the file", "%v", err)
+		return nil
+	}
+	info, err := leaf.ReadInfo(bytes.NewReader(data))
+	if err != nil {
+		var hdr struct {
+			V int64 `json:"leaf"`
+		}
+		if json.Unmarshal(data, &hdr) == nil && hdr.V != 0 {
+			c.add(statusError, check, "upgrade leaf to a version that supports this format",
+				"%s has format version %d, which this version of leaf does not support", path, hdr.V)
+		} else {
+			c.add(statusError, check, "check the path; the file may be damaged", "%s is not a LEAF file: %v", path, err)
+		}
+		return nil
+	}
+	c.add(statusOK, check, "", "%s is a LEAF file (format version %d, %d key %s)",
+		path, info.Version, len(info.KeySlots), plural(len(info.KeySlots), "slot", "slots"))
+	c.checkPerms(check, "file", path)
+	return data
+}
+
+// checkKey reports on the sources of the access key, and whether the key file,
+// if one is set, opens the file whose contents are data.
+func (c *checkup) checkKey(data []byte) {
+	const check = "access key"
+	var sources []string
+	if settings.AccessKeyFile != "" {
+		sources = append(sources, "--access-key")
+	}
+	if settings.KeyStdin {
+		sources = append(sources, "--key-stdin")
+	}
+	if settings.AgeIdentity != "" {
+		sources = append(sources, "--age-identity")
+	}
+	if settings.SSHKey != "" {
+		sources = append(sources, "--ssh-key")
+	}
+	if len(sources) > 1 {
+		c.add(statusWarning, check, "unset the flags or variables that are not needed",
+			"several key sources are set (%s); only %s is used", strings.Join(sources, ", "), sources[0])
+	}
+
+	path := settings.AccessKeyFile
+	if path == "" {
+		if len(sources) == 0 {
+			c.add(statusInfo, check, "", "no key file is set; the key agent, keyring, key slots, or a passphrase are used")
+		}
+		return
+	}
+	if _, err := os.Stat(path); err != nil {
+		c.add(statusError, check, "check --access-key, LEAF_ACCESS_KEY, or the \"key-file\" of the profile", "%v", err)
+		return
+	}
+	c.checkPerms(check, "key file", path)
+	key, err := os.ReadFile(path)
+	if err != nil {
+		c.add(statusError, check, "check the permissions of the key file", "%v", err)
+		return
+	}
+	defer clear(key)
+	if len(key) != leaf.AccessKeyLen {
+		c.add(statusError, check, "check that the key file holds a LEAF access key, not a passphrase",
+			"key file %s has %d bytes, but an access key has %d", path, len(key), leaf.AccessKeyLen)
+		return
+	} else if data == nil {
+		return // the file could not be read; already reported
+	}
+	slot, err := leaf.VerifyKey(key, bytes.NewReader(data))
+	if err != nil {
+		c.add(statusError, check, "check that the key file belongs to this LEAF file", "key file %s: %v", path, err)
+		return
+	}
+	c.add(statusOK, check, "", "key file %s opens key slot %q", path, slot.Name)
+}
+
+// checkPerms reports whether the file at path, described by what, is
+// accessible to users other than its owner. With --fix-perms, it makes the
+// file private instead.
+func (c *checkup) checkPerms(check, what, path string) {
+	mode, ok := insecureMode(path)
+	if !ok {
+		return
+	} else if !doctorFlags.FixPerms {
+		c.add(statusWarning, check, fmt.Sprintf(`chmod 600 %s, or run "leaf doctor --fix-perms"`, path),
+			"%s %s is accessible to other users (mode %04o)", what, path, mode)
+	} else if err := os.Chmod(path, 0600); err != nil {
+		c.add(statusError, check, "", "cannot make %s %s private: %v", what, path, err)
+	} else {
+		c.add(statusOK, check, "", "changed the mode of %s %s from %04o to 0600", what, path, mode)
+	}
+}
+
+// checkEnv reports settings that have no effect because others take
+// precedence over them.
+func (c *checkup) checkEnv() {
+	const check = "environment"
+	if os.Getenv("LEAF_PASSPHRASE") != "" {
+		switch {
+		case settings.AccessKeyFile != "" || settings.KeyStdin:
+			c.add(statusWarning, check, "unset LEAF_PASSPHRASE",
+				"LEAF_PASSPHRASE is set, but is not used because an access key is given")
+		case settings.PassphraseFD >= 0:
+			c.add(statusWarning, check, "unset LEAF_PASSPHRASE, or do not set --passphrase-fd",
+				"LEAF_PASSPHRASE is set, but is not used because --passphrase-fd is set")
+		case isTerminal(os.Stdin):
+			c.add(statusWarning, check, "unset LEAF_PASSPHRASE; it is meant for automated jobs",
+				"LEAF_PASSPHRASE is set in an interactive session")
+		}
+	}
+	if settings.Pinentry != "" && (settings.PassphraseFD >= 0 || os.Getenv("LEAF_PASSPHRASE") != "") {
+		c.add(statusWarning, check, "unset --pinentry and LEAF_PINENTRY, or do not supply passphrases otherwise",
+			"--pinentry is set, but is not used because passphrases are read from elsewhere")
+	}
+	if n := len(*c); n == 0 || (*c)[n-1].Check != check {
+		c.add(statusOK, check, "", "no conflicting settings")
+	}
+}
+
+// checkAgent reports whether the key agent is reachable, and whether it holds
+// the access key for the selected file.
+func (c *checkup) checkAgent() {
+	const check = "agent"
+	rsp, err := callAgent(agentRequest{Op: "status"})
+	if err != nil {
+		if os.Getenv("LEAF_AGENT_SOCK") != "" {
+			c.add(statusWarning, check, `start the agent with "leaf agent start", or unset LEAF_AGENT_SOCK`,
+				"no agent is reachable at LEAF_AGENT_SOCK=%s", agentSocket())
+		} else {
+			c.add(statusInfo, check, "", "not running")
+		}
+		return
+	}
+	held := ""
+	if abs, err := filepath.Abs(settings.FilePath); err == nil && settings.FilePath != "" {
+		for _, e := range rsp.Entries {
+			if e.Path == abs {
+				held = "; it holds the key for this file"
+			}
+		}
+	}
+	c.add(statusOK, check, "", "running at %s%s", agentSocket(), held)
+}
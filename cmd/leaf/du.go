@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// usage records the space used by a table or a key.
+type usage struct {
+	Name    string  `json:"name"`
+	Total   int     `json:"total"`   // bytes used by all log entries
+	Live    int     `json:"live"`    // bytes used by the current values
+	Entries int     `json:"entries"` // number of log entries
+	Keys    []usage `json:"keys,omitempty"`
+}
+
+func runDU(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	}
+	f := env.Config.(*leaf.File)
+	db := fileDB(f)
+	tabs := make(map[string]*usage)
+	keys := make(map[string]map[string]*usage)
+	get := func(table, key string) (*usage, *usage) {
+		tu, ok := tabs[table]
+		if !ok {
+			tu = &usage{Name: table}
+			tabs[table] = tu
+			keys[table] = make(map[string]*usage)
+		}
+		if key == "" {
+			return tu, nil
+		}
+		ku, ok := keys[table][key]
+		if !ok {
+			ku = &usage{Name: key}
+			keys[table][key] = ku
+		}
+		return tu, ku
+	}
+
+	for _, e := range db.Log() {
+		switch e.Op {
+		case "tag":
+			continue
+		case "snapshot":
+			// Attribute the parts of a snapshot to the tables and keys they hold.
+			var snap map[string]map[string]json.RawMessage
+			if err := json.Unmarshal(e.Value, &snap); err != nil {
+				return fmt.Errorf("invalid snapshot: %w", err)
+			}
+			for table, vals := range snap {
+				tu, _ := get(table, "")
+				tu.Total += len(table) + 4
+				tu.Entries++
+				for key, val := range vals {
+					_, ku := get(table, key)
+					n := len(key) + len(val) + 4
+					tu.Total += n
+					ku.Total += n
+					ku.Entries++
+				}
+			}
+			continue
+		}
+		n := entrySize(e)
+		tu, ku := get(e.Table, e.Key)
+		tu.Total += n
+		tu.Entries++
+		if ku != nil && (e.Op == "update" || e.Op == "delete") {
+			ku.Total += n
+			ku.Entries++
+		}
+	}
+	for _, table := range db.TableNames() {
+		tab, _ := db.GetTable(table)
+		tu, _ := get(table, "")
+		for key, val := range leaf.AsMap[json.RawMessage](tab) {
+			_, ku := get(table, key)
+			ku.Live = len(key) + len(val)
+			tu.Live += ku.Live
+		}
+	}
+
+	var out []usage
+	for name, tu := range tabs {
+		if len(args) == 1 && name != args[0] {
+			continue
+		} else if _, ok := db.GetTable(name); !ok {
+			tu.Name += " (deleted)"
+		}
+		for _, ku := range keys[name] {
+			tu.Keys = append(tu.Keys, *ku)
+		}
+		sortUsage(tu.Keys)
+		out = append(out, *tu)
+	}
+	if len(args) == 1 && len(out) == 0 {
+		return notFoundf("table %q not found", args[0])
+	}
+	sortUsage(out)
+
+	return printResult(out, func() {
+		rows := [][]cell{{{"TOTAL", bold}, {"LIVE", bold}, {"ENTRIES", bold}, {"NAME", bold}}}
+		num := func(n int) cell { return cell{fmt.Sprint(n), plain} }
+		for _, tu := range out {
+			st := blue
+			if strings.HasSuffix(tu.Name, " (deleted)") {
+				st = dim
+			}
+			rows = append(rows, []cell{num(tu.Total), num(tu.Live), num(tu.Entries), {tu.Name, st}})
+			for _, ku := range tu.Keys {
+				st := plain
+				if ku.Live == 0 {
+					st = dim // deleted
+				}
+				rows = append(rows, []cell{num(ku.Total), num(ku.Live), num(ku.Entries), {"  " + ku.Name, st}})
+			}
+		}
+		writeColumns(os.Stdout, newPainter(os.Stdout), []bool{true, true, true}, rows)
+	})
+}
+
+// entrySize returns the approximate encoded size of a log entry, as stored in
+// the (uncompressed) log.
+func entrySize(e leaf.LogEntry) int {
+	bits, _ := json.Marshal(struct {
+		Op  string          `json:"op"`
+		Tab string          `json:"tab,omitempty"`
+		Key string          `json:"key,omitempty"`
+		Val json.RawMessage `json:"val,omitempty"`
+		Clk string          `json:"clk"`
+	}{e.Op, e.Table, e.Key, e.Value, fmt.Sprint(e.Time.UnixMicro())})
+	return len(bits) + 1 // separator
+}
+
+// sortUsage sorts us in decreasing order of total size, then by name.
+func sortUsage(us []usage) {
+	sort.Slice(us, func(i, j int) bool {
+		if us[i].Total != us[j].Total {
+			return us[i].Total > us[j].Total
+		}
+		return us[i].Name < us[j].Name
+	})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// Exit codes reported by the leaf command. These are documented by the
+// "exit-codes" help topic, and must not change.
+const (
+	exitFailure  = 1 // any error not described below
+	exitUsage    = 2 // invalid arguments or flags, or help was requested
+	exitNotFound = 3 // a file, database, table, key, slot, tag, or path was not found
+	exitWrongKey = 4 // the access key does not open the file
+	exitCorrupt  = 5 // the file could not be decoded or decrypted
+	exitConflict = 6 // something to be created exists, or a condition failed
+)
+
+const exitCodesHelp = `Exit codes of the leaf command.
+
+Scripts can use the exit code of a command to tell what happened, without
+parsing its error message:
+
+  0   success
+  1   any error not listed below
+  2   invalid arguments or flags, or help was requested
+  3   a file, database, table, key, key slot, or tag was not found, or
+      a path selected by "get --path" does not exist in the value
+  4   the access key does not open the file
+  5   the file is damaged or in an unsupported format, and could not be
+      decoded or decrypted
+  6   something to be created (a file, table, key, slot, or tag) exists,
+      or the condition of a conditional change, such as "set --if-matches",
+      was not met
+
+These codes are stable across versions.`
+
+// initErr is the error reported by requireFile, if any. The command package
+// reports the errors of Init functions as text, so the original error is kept
+// here to determine the exit code.
+var initErr error
+
+// errConflict is matched by errors reporting that a conditional change was
+// not made.
+var errConflict = errors.New("conflict")
+
+// errCorrupt is matched by errors reporting that a file could not be decoded.
+var errCorrupt = errors.New("file is damaged")
+
+// notFoundf returns an error with the formatted message, reported with the
+// exit code for something not found.
+func notFoundf(msg string, args ...any) error {
+	return exitError{kind: leaf.ErrNotFound, msg: fmt.Sprintf(msg, args...)}
+}
+
+// existsf returns an error with the formatted message, reported with the exit
+// code for something that already exists.
+func existsf(msg string, args ...any) error {
+	return exitError{kind: leaf.ErrExists, msg: fmt.Sprintf(msg, args...)}
+}
+
+// conflictf returns an error with the formatted message, reported with the
+// exit code for a conflict.
+func conflictf(msg string, args ...any) error {
+	return exitError{kind: errConflict, msg: fmt.Sprintf(msg, args...)}
+}
+
+// An exitError is an error with a specific message that matches kind, which
+// determines its exit code.
+type exitError struct {
+	kind error
+	msg  string
+}
+
+func (e exitError) Error() string { return e.msg }
+func (e exitError) Unwrap() error { return e.kind }
+
+// openError classifies an error reported by opening a file with an access
+// key: It is either an I/O error, a wrong access key, or a damaged file.
+func openError(err error) error {
+	var perr *fs.PathError
+	if err == nil || errors.As(err, &perr) || errors.Is(err, leaf.ErrWrongKey) {
+		return err
+	}
+	return corruptError{err}
+}
+
+// A corruptError is an error reporting that a file could not be decoded.
+type corruptError struct{ error }
+
+func (e corruptError) Unwrap() []error { return []error{e.error, errCorrupt} }
+
+// exitCode returns the exit code for err. See exitCodesHelp.
+func exitCode(err error) int {
+	var uerr command.UsageError
+	switch {
+	case errors.Is(err, command.ErrRequestHelp), errors.As(err, &uerr):
+		return exitUsage
+	case errors.Is(err, leaf.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return exitNotFound
+	case errors.Is(err, leaf.ErrWrongKey):
+		return exitWrongKey
+	case errors.Is(err, errCorrupt):
+		return exitCorrupt
+	case errors.Is(err, leaf.ErrExists), errors.Is(err, fs.ErrExist), errors.Is(err, errConflict):
+		return exitConflict
+	}
+	return exitFailure
+}
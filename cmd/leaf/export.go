@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/creachadair/atomicfile"
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var exportFlags struct {
+	Output    string `flag:"o,Write the export to this file (default stdout)"`
+	Redact    bool   `flag:"redact,Replace values with placeholders describing their type and length"`
+	Canonical bool   `flag:"canonical,Write the export in canonical form"`
+	Tables    string `flag:"tables,Export only these tables (comma-separated)"`
+	KeysGlob  string `flag:"keys-glob,Export only keys matching this glob pattern"`
+}
+
+func runExport(env *command.Env) error {
+	f := env.Config.(*leaf.File)
+	if exportFlags.Canonical && exportFlags.Redact {
+		return env.Usagef("--canonical and --redact cannot be combined")
+	}
+	if _, err := path.Match(exportFlags.KeysGlob, ""); err != nil {
+		return env.Usagef("invalid --keys-glob pattern %q", exportFlags.KeysGlob)
+	}
+	snap, err := filterSnapshot(fileDB(f).Snapshot(), exportFlags.Tables, exportFlags.KeysGlob)
+	if err != nil {
+		return err
+	}
+	if exportFlags.Canonical {
+		var buf bytes.Buffer
+		if err := leaf.WriteCanonical(&buf, snap); err != nil {
+			return err
+		} else if exportFlags.Output == "" {
+			_, err := os.Stdout.Write(buf.Bytes())
+			return err
+		}
+		return atomicfile.WriteData(exportFlags.Output, buf.Bytes(), 0600)
+	}
+	if exportFlags.Redact {
+		snap = redactSnapshot(snap)
+	}
+	if exportFlags.Output == "" {
+		return writePrettyJSON(snap)
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(snap); err != nil {
+		return err
+	}
+	return atomicfile.WriteData(exportFlags.Output, buf.Bytes(), 0600)
+}
+
+// filterSnapshot returns the portion of snap selected by tables, a
+// comma-separated list of table names, and glob, a pattern for key names.
+// An empty tables or glob selects everything. A table named in tables is
+// included even if none of its keys match glob; otherwise, when glob is set,
+// tables with no matching keys are omitted.
+func filterSnapshot(snap snapshot, tables, glob string) (snapshot, error) {
+	if tables == "" && glob == "" {
+		return snap, nil
+	}
+	out := make(snapshot)
+	var names []string
+	if tables != "" {
+		for _, name := range strings.Split(tables, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			} else if _, ok := snap[name]; !ok {
+				return nil, notFoundf("table %q not found", name)
+			}
+			names = append(names, name)
+		}
+	} else {
+		names = slices.Collect(maps.Keys(snap))
+	}
+	for _, name := range names {
+		tab := snap[name]
+		if glob == "" {
+			out[name] = tab
+			continue
+		}
+		sel := make(map[string]json.RawMessage)
+		for key, val := range tab {
+			if ok, _ := path.Match(glob, key); ok {
+				sel[key] = val
+			}
+		}
+		if len(sel) != 0 || tables != "" {
+			out[name] = sel
+		}
+	}
+	return out, nil
+}
+
+// redactSnapshot returns a copy of snap in which each value is replaced by
+// a placeholder that preserves its structure but not its contents.
+func redactSnapshot(snap snapshot) snapshot {
+	out := make(snapshot, len(snap))
+	for name, tab := range snap {
+		rtab := make(map[string]json.RawMessage, len(tab))
+		for key, val := range tab {
+			var v any
+			if err := json.Unmarshal(val, &v); err != nil {
+				rtab[key] = json.RawMessage(`"[invalid]"`)
+				continue
+			}
+			bits, err := json.Marshal(redactValue(v))
+			if err != nil {
+				panic(err) // should not be possible for decoded JSON
+			}
+			rtab[key] = bits
+		}
+		out[name] = rtab
+	}
+	return out
+}
+
+// redactValue returns a redacted copy of the decoded JSON value v. Objects
+// keep their field names and arrays their length; scalars are replaced by a
+// string giving their type (and, for strings, their length in bytes).
+func redactValue(v any) any {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case map[string]any:
+		out := make(map[string]any, len(t))
+		for k, e := range t {
+			out[k] = redactValue(e)
+		}
+		return out
+	case []any:
+		out := make([]any, len(t))
+		for i, e := range t {
+			out[i] = redactValue(e)
+		}
+		return out
+	case string:
+		return fmt.Sprintf("[string:%d]", len(t))
+	case float64:
+		return "[number]"
+	case bool:
+		return "[bool]"
+	default:
+		return fmt.Sprintf("[%T]", v)
+	}
+}
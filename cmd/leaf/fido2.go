@@ -0,0 +1,136 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/creachadair/leaf"
+	"golang.org/x/crypto/hkdf"
+)
+
+// fido2Params are the key slot parameters for an access key derived from the
+// hmac-secret extension of a FIDO2 security key. The token computes an HMAC
+// of the salt with a secret bound to the credential, which never leaves it.
+type fido2Params struct {
+	Credential []byte `json:"credential"` // credential ID
+	Salt       []byte `json:"salt"`       // hmac-secret salt
+}
+
+const (
+	fido2RP    = "leaf" // relying party ID for enrolled credentials
+	fido2Label = "leaf fido2 access key\x00"
+)
+
+// fido2Device returns the path of the FIDO2 device to use. If LEAF_FIDO2_DEVICE
+// is set, it is used; otherwise the first device reported by fido2-token.
+func fido2Device() (string, error) {
+	if dev := os.Getenv("LEAF_FIDO2_DEVICE"); dev != "" {
+		return dev, nil
+	}
+	out, err := runTool(nil, "fido2-token", "-L")
+	if err != nil {
+		return "", err
+	}
+	// Each line has the form "path: description".
+	for _, line := range strings.Split(string(out), "\n") {
+		if dev, _, ok := strings.Cut(line, ": "); ok && dev != "" {
+			return dev, nil
+		}
+	}
+	return "", errors.New("no FIDO2 security key found")
+}
+
+// fido2NewKey enrolls a new credential with hmac-secret on the security key,
+// and derives a new access key from it. It returns the key with the slot
+// parameters needed to derive it again.
+func fido2NewKey() ([]byte, *fido2Params, error) {
+	dev, err := fido2Device()
+	if err != nil {
+		return nil, nil, err
+	}
+	cdh, userID := make([]byte, 32), make([]byte, 32)
+	p := &fido2Params{Salt: make([]byte, 32)}
+	for _, b := range [][]byte{cdh, userID, p.Salt} {
+		if _, err := cryptorand.Read(b); err != nil {
+			return nil, nil, err
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Touch your security key to enroll it...")
+	out, err := runTool(fido2Input(b64(cdh), fido2RP, "leaf", b64(userID)), "fido2-cred", "-M", "-h", dev)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The output has the client data hash, relying party, format, authenticator
+	// data, and credential ID, followed by the attestation.
+	lines := strings.Split(string(out), "\n")
+	if len(lines) < 5 {
+		return nil, nil, errors.New("fido2-cred: incomplete output")
+	}
+	p.Credential, err = base64.StdEncoding.DecodeString(strings.TrimSpace(lines[4]))
+	if err != nil {
+		return nil, nil, fmt.Errorf("fido2-cred: invalid credential ID: %w", err)
+	}
+	accessKey, err := fido2DeriveKey(dev, p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessKey, p, nil
+}
+
+// fido2DeriveKey derives the access key for the slot described by p, using
+// the security key at dev. The user must touch the key.
+func fido2DeriveKey(dev string, p *fido2Params) ([]byte, error) {
+	cdh := make([]byte, 32)
+	if _, err := cryptorand.Read(cdh); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(os.Stderr, "Touch your security key to unlock the file...")
+	out, err := runTool(fido2Input(b64(cdh), fido2RP, b64(p.Credential), b64(p.Salt)), "fido2-assert", "-G", "-h", dev)
+	if err != nil {
+		return nil, err
+	}
+	// With -h, the hmac-secret output is the last line.
+	lines := strings.Fields(string(out))
+	if len(lines) == 0 {
+		return nil, errors.New("fido2-assert: empty output")
+	}
+	secret, err := base64.StdEncoding.DecodeString(lines[len(lines)-1])
+	if err != nil || len(secret) != 32 {
+		return nil, errors.New("fido2-assert: missing hmac-secret output")
+	}
+	kg := hkdf.New(sha256.New, secret, p.Salt, []byte(fido2Label))
+	accessKey := make([]byte, leaf.AccessKeyLen)
+	if _, err := kg.Read(accessKey); err != nil {
+		return nil, fmt.Errorf("access key: %w", err)
+	}
+	return accessKey, nil
+}
+
+// fido2Provider is a keyProvider that derives the access keys of FIDO2 key
+// slots using the connected security key.
+type fido2Provider struct{}
+
+func (fido2Provider) device() string        { return "a security key" }
+func (fido2Provider) has(p slotParams) bool { return p.FIDO2 != nil }
+
+func (fido2Provider) unlock(p slotParams) ([]byte, error) {
+	dev, err := fido2Device()
+	if err != nil {
+		return nil, err
+	}
+	return fido2DeriveKey(dev, p.FIDO2)
+}
+
+func b64(data []byte) string { return base64.StdEncoding.EncodeToString(data) }
+
+// fido2Input formats the input for a libfido2 tool, one field per line. The
+// tools prompt for a PIN at the terminal if the security key requires one.
+func fido2Input(fields ...string) io.Reader {
+	return strings.NewReader(strings.Join(fields, "\n") + "\n")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+)
+
+// runGit runs git with the given arguments in dir, and returns its output.
+func runGit(dir string, args ...string) (string, error) {
+	out, err := runTool(nil, "git", append([]string{"-C", dir}, args...)...)
+	return string(out), err
+}
+
+// inGitRepo reports whether dir is inside a git working tree.
+func inGitRepo(dir string) bool {
+	out, err := runGit(dir, "rev-parse", "--is-inside-work-tree")
+	return err == nil && strings.TrimSpace(out) == "true"
+}
+
+// gitCommitFile commits the current contents of the file at path, if it lies
+// in a git working tree and differs from the committed version. Otherwise it
+// does nothing.
+func gitCommitFile(path string) error {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	if !inGitRepo(dir) {
+		return nil
+	}
+	if _, err := runGit(dir, "add", "--", base); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "diff", "--cached", "--quiet", "--", base); err == nil {
+		return nil // no changes to commit
+	}
+	msg := fmt.Sprintf("leaf: update %s", base)
+	_, err := runGit(dir, "commit", "--quiet", "-m", msg, "--", base)
+	return err
+}
+
+func runGitSync(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	dir := filepath.Dir(settings.FilePath)
+	if !inGitRepo(dir) {
+		return fmt.Errorf("%q is not in a git repository", settings.FilePath)
+	}
+	if err := gitCommitFile(settings.FilePath); err != nil {
+		return err
+	}
+	if _, err := runGit(dir, "fetch", "--quiet"); err != nil {
+		return err
+	}
+	// If the upstream branch does not exist yet, there is nothing to merge.
+	if _, err := runGit(dir, "rev-parse", "--verify", "--quiet", "@{upstream}"); err == nil {
+		if _, err := runGit(dir, "merge", "--quiet", "--ff-only", "@{upstream}"); err != nil {
+			return errors.Join(err, errors.New("the local and remote copies may have diverged"))
+		}
+	}
+	if _, err := runGit(dir, "push", "--quiet"); err != nil {
+		return err
+	}
+	notify(env, object{"event": "synchronized", "file": settings.FilePath}, "synchronized %q with the git remote", settings.FilePath)
+	return nil
+}
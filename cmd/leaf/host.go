@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// The host speaks the native messaging protocol used by browser extensions.
+// Each message, in either direction, is a JSON object preceded by its length
+// in bytes as a 32-bit unsigned integer in native byte order. The browser
+// starts the host when the extension connects, and closes its stdin when the
+// extension disconnects.
+
+type hostRequest struct {
+	ID    any    `json:"id,omitempty"` // echoed in the response
+	Op    string `json:"op"`           // lookup, fill, status
+	URL   string `json:"url,omitempty"`
+	Table string `json:"table,omitempty"`
+	Key   string `json:"key,omitempty"`
+}
+
+type hostResponse struct {
+	ID      any         `json:"id,omitempty"`
+	File    string      `json:"file,omitempty"`
+	Entries []hostEntry `json:"entries,omitempty"`
+	Fill    *hostFill   `json:"fill,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// A hostEntry describes an entry matching a lookup. It does not include the
+// password, which must be requested with a fill.
+type hostEntry struct {
+	Table string `json:"table"`
+	Key   string `json:"key"`
+	User  string `json:"user,omitempty"`
+	URL   string `json:"url"`
+}
+
+type hostFill struct {
+	User     string `json:"user,omitempty"`
+	Password string `json:"password,omitempty"`
+	OTP      string `json:"otp,omitempty"` // the current TOTP code, if any
+}
+
+// Browsers do not accept messages from the host larger than 1MiB, and there
+// is no reason for the extension to send anything that large either.
+const maxHostMessage = 1 << 20
+
+// A nativeHost serves requests from a browser extension. The file is read
+// again whenever it changes, so the host sees updates made while it runs.
+type nativeHost struct {
+	path      string
+	accessKey []byte
+	file      *leaf.File
+	modTime   time.Time
+}
+
+func runHost(env *command.Env, args ...string) error {
+	// Browsers pass the origin of the extension as arguments, which are
+	// ignored, since the manifest already restricts which extensions may
+	// start the host.
+	if settings.FilePath == "" {
+		return errors.New("no file path is defined")
+	} else if settings.KeyStdin {
+		return env.Usagef("--key-stdin cannot be used with host")
+	}
+	settings.ReadOnly = true // the host never modifies the file
+
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	h := &nativeHost{path: settings.FilePath, accessKey: accessKey}
+	if err := h.load(); err != nil {
+		return err
+	}
+	for {
+		msg, err := readHostMessage(os.Stdin)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		var req hostRequest
+		var rsp *hostResponse
+		if err := json.Unmarshal(msg, &req); err != nil {
+			rsp = &hostResponse{Error: fmt.Sprintf("invalid request: %v", err)}
+		} else {
+			rsp = h.handle(req)
+			rsp.ID = req.ID
+		}
+		if err := writeHostMessage(os.Stdout, rsp); err != nil {
+			return err
+		}
+	}
+}
+
+// load reads the file if it has changed since it was last read.
+func (h *nativeHost) load() error {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	} else if h.file != nil && fi.ModTime().Equal(h.modTime) {
+		return nil
+	}
+	f, err := openWithKey(h.path, h.accessKey)
+	if err != nil {
+		return err
+	}
+	if h.file != nil {
+		h.file.Close()
+	}
+	h.file, h.modTime = f, fi.ModTime()
+	return nil
+}
+
+func (h *nativeHost) handle(req hostRequest) *hostResponse {
+	if err := h.load(); err != nil {
+		return &hostResponse{Error: err.Error()}
+	}
+	switch req.Op {
+	case "status":
+		return &hostResponse{File: h.path}
+
+	case "lookup":
+		page, err := parseOrigin(req.URL)
+		if err != nil {
+			return &hostResponse{Error: err.Error()}
+		}
+		return &hostResponse{Entries: h.matching(page, req.Table)}
+
+	case "fill":
+		// A fill must name the page it is for, and the entry must match it,
+		// so that the extension cannot be used to read arbitrary entries.
+		page, err := parseOrigin(req.URL)
+		if err != nil {
+			return &hostResponse{Error: err.Error()}
+		}
+		tab, ok := fileDB(h.file).GetTable(req.Table)
+		if !ok {
+			return &hostResponse{Error: fmt.Sprintf("table %q not found", req.Table)}
+		}
+		if !tab.Get(req.Key, nil) {
+			return &hostResponse{Error: fmt.Sprintf("key %q not found", req.Key)}
+		}
+		val, ok := objectValue(tab, req.Key)
+		if !ok || !entryMatches(val, page) {
+			return &hostResponse{Error: fmt.Sprintf("key %q does not match %q", req.Key, page)}
+		}
+		fill := &hostFill{User: stringField(val, "user"), Password: stringField(val, "password")}
+		if s := stringField(val, "totp"); s != "" {
+			code, err := totpCode(s)
+			if err != nil {
+				return &hostResponse{Error: fmt.Sprintf("key %q: %v", req.Key, err)}
+			}
+			fill.OTP = code
+		}
+		return &hostResponse{Fill: fill}
+
+	default:
+		return &hostResponse{Error: fmt.Sprintf("unknown operation %q", req.Op)}
+	}
+}
+
+// matching returns the entries whose URL matches page, in the named table or
+// in all tables if table == "".
+func (h *nativeHost) matching(page origin, table string) []hostEntry {
+	db := fileDB(h.file)
+	var out []hostEntry
+	for _, tname := range db.TableNames() {
+		if table != "" && tname != table {
+			continue
+		}
+		tab, _ := db.GetTable(tname)
+		for _, key := range tab.Keys() {
+			if val, ok := objectValue(tab, key); ok && entryMatches(val, page) {
+				out = append(out, hostEntry{
+					Table: tname,
+					Key:   key,
+					User:  stringField(val, "user"),
+					URL:   stringField(val, "url"),
+				})
+			}
+		}
+	}
+	return out
+}
+
+// objectValue returns the value of key in tab, if it is a JSON object.
+func objectValue(tab leaf.Table, key string) (map[string]any, bool) {
+	var raw json.RawMessage
+	var val map[string]any
+	if !tab.Get(key, &raw) || json.Unmarshal(raw, &val) != nil || val == nil {
+		return nil, false
+	}
+	return val, true
+}
+
+// entryMatches reports whether the "url" field of val matches page. The
+// schemes must be the same, and the host of the page must be the same as the
+// entry's, or a subdomain of it.
+func entryMatches(val map[string]any, page origin) bool {
+	eo, err := parseOrigin(stringField(val, "url"))
+	if err != nil || eo.scheme != page.scheme {
+		return false
+	}
+	return page.host == eo.host || strings.HasSuffix(page.host, "."+eo.host)
+}
+
+// An origin is the scheme and host name of a URL, in lower case.
+type origin struct{ scheme, host string }
+
+func (o origin) String() string { return o.scheme + "://" + o.host }
+
+// parseOrigin returns the origin of the URL s. If s has no scheme, it is
+// taken to be https.
+func parseOrigin(s string) (origin, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return origin{}, errors.New("missing url")
+	} else if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return origin{}, err
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return origin{}, fmt.Errorf("url %q has no host", s)
+	}
+	return origin{scheme: strings.ToLower(u.Scheme), host: host}, nil
+}
+
+// stringField returns the named field of val if it is a string, or "".
+func stringField(val map[string]any, name string) string {
+	s, _ := val[name].(string)
+	return s
+}
+
+// totpCode returns the current code for the otpauth:// URI s.
+func totpCode(s string) (string, error) {
+	k, err := parseOTPAuth(s)
+	if err != nil {
+		return "", err
+	} else if k.Type != "totp" {
+		return "", fmt.Errorf("%s keys are not supported", k.Type)
+	} else if err := k.check(); err != nil {
+		return "", err
+	}
+	return k.code(uint64(time.Now().Unix() / int64(k.Period)))
+}
+
+// readHostMessage reads a length-prefixed message from r. It returns io.EOF
+// if r ends before the next message begins.
+func readHostMessage(r io.Reader) ([]byte, error) {
+	var hdr [4]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated message length")
+		}
+		return nil, err
+	}
+	n := binary.NativeEndian.Uint32(hdr[:])
+	if n > maxHostMessage {
+		return nil, fmt.Errorf("message too long (%d bytes)", n)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, fmt.Errorf("truncated message: %w", err)
+	}
+	return msg, nil
+}
+
+// writeHostMessage writes v to w as a length-prefixed JSON message.
+func writeHostMessage(w io.Writer, v any) error {
+	msg, err := json.Marshal(v)
+	if err != nil {
+		return err
+	} else if len(msg) > maxHostMessage {
+		return fmt.Errorf("response too long (%d bytes)", len(msg))
+	}
+	buf := binary.NativeEndian.AppendUint32(nil, uint32(len(msg)))
+	_, err = w.Write(append(buf, msg...))
+	return err
+}
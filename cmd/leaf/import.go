@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var importPassFlags struct {
+	GPG   string `flag:"gpg,default=gpg,The gpg program used to decrypt entries"`
+	Table string `flag:"table,default=pass,Table for entries at the top level of the store"`
+}
+
+// saveImport stores the entries of snap into f and saves it. If any entries
+// would replace existing values, the user is asked to confirm first. The
+// source describes where the entries came from, for messages. With --dry-run,
+// the changes are printed and f is not saved.
+func saveImport(env *command.Env, f *leaf.File, snap map[string]map[string]any, source string) error {
+	if err := checkDryRun(env); err != nil {
+		return err
+	} else if dryRunFlags.DryRun {
+		before := fileDB(f).Snapshot()
+		importSnapshot(f, snap)
+		return printDryRun(settings.FilePath, before, fileDB(f).Snapshot())
+	}
+	var nkeys, nreplace int
+	for tname, tab := range snap {
+		nkeys += len(tab)
+		if dbTab, ok := fileDB(f).GetTable(tname); ok {
+			for key := range tab {
+				if dbTab.Get(key, nil) {
+					nreplace++
+				}
+			}
+		}
+	}
+	if nreplace != 0 {
+		if err := confirm(env, "Replace %d existing %s?", nreplace, plural(nreplace, "value", "values")); err != nil {
+			return err
+		}
+	}
+	importSnapshot(f, snap)
+	if f.IsModified() {
+		if err := saveFile(f); err != nil {
+			return err
+		}
+	}
+	notify(env, object{"event": "imported", "source": source, "tables": len(snap), "keys": nkeys},
+		"imported %d %s into %d %s from %s", nkeys, plural(nkeys, "entry", "entries"),
+		len(snap), plural(len(snap), "table", "tables"), source)
+	return nil
+}
+
+func runImportPass(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	} else if importPassFlags.Table == "" {
+		return env.Usagef("--table must not be empty")
+	}
+	dir := os.Getenv("PASSWORD_STORE_DIR")
+	if len(args) == 1 {
+		dir = args[0]
+	} else if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		dir = filepath.Join(home, ".password-store")
+	}
+
+	// Each entry is a file "dir/.../name.gpg". The directory path relative to
+	// the store names the table, and the name of the file is the key.
+	type passEntry struct{ path, table, key string }
+	var entries []passEntry
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		} else if d.IsDir() && path != dir && strings.HasPrefix(d.Name(), ".") {
+			return filepath.SkipDir // e.g., .git
+		} else if d.IsDir() || !strings.HasSuffix(d.Name(), ".gpg") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		table := filepath.ToSlash(filepath.Dir(rel))
+		if table == "." {
+			table = importPassFlags.Table
+		}
+		entries = append(entries, passEntry{path, table, strings.TrimSuffix(d.Name(), ".gpg")})
+		return nil
+	})
+	if err != nil {
+		return err
+	} else if len(entries) == 0 {
+		return fmt.Errorf("no entries found in %s", dir)
+	}
+
+	snap := make(map[string]map[string]any)
+	for _, e := range entries {
+		text, err := gpgDecrypt(importPassFlags.GPG, e.path)
+		if err != nil {
+			return fmt.Errorf("decrypting %s: %w", e.path, err)
+		}
+		if snap[e.table] == nil {
+			snap[e.table] = make(map[string]any)
+		}
+		snap[e.table][e.key] = parsePassEntry(text)
+	}
+	return saveImport(env, env.Config.(*leaf.File), snap, dir)
+}
+
+// gpgDecrypt decrypts the file at path using the gpg program, which may ask
+// the user for a passphrase via gpg-agent.
+func gpgDecrypt(gpg, path string) (string, error) {
+	out, err := runTool(os.Stdin, gpg, "--quiet", "--yes", "--decrypt", path)
+	return string(out), err
+}
+
+// passFieldNames maps the field names commonly used in pass entries to the
+// names used for imported values.
+var passFieldNames = map[string]string{
+	"login": "user", "username": "user", "user": "user",
+	"url": "url", "website": "url", "site": "url",
+}
+
+// parsePassEntry converts the text of a pass entry to a value. By convention,
+// the first line of an entry is the password, and later lines may be fields
+// of the form "name: value". An otpauth:// URI is stored as "totp", and other
+// lines are stored as "notes".
+func parsePassEntry(text string) map[string]string {
+	lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+	val := map[string]string{"password": lines[0]}
+	var notes []string
+	for _, line := range lines[1:] {
+		if strings.HasPrefix(line, "otpauth://") && val["totp"] == "" {
+			val["totp"] = line
+			continue
+		}
+		name, rest, ok := strings.Cut(line, ":")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if fn, known := passFieldNames[name]; ok && known {
+			name = fn
+		}
+		if !ok || name == "" || strings.ContainsAny(name, " \t") || val[name] != "" {
+			notes = append(notes, line)
+			continue
+		}
+		val[name] = strings.TrimSpace(rest)
+	}
+	if len(notes) != 0 {
+		val["notes"] = strings.Join(notes, "\n")
+	}
+	return val
+}
+
+var importCSVFlags struct {
+	Table string `flag:"table,Table for all entries (default: the folder of each entry)"`
+}
+
+// A csvFormat describes the CSV export format of a password manager. Column
+// names are matched without regard to case, and each list of columns is in
+// order of preference.
+type csvFormat struct {
+	title  []string            // columns naming the entry, used as the key
+	folder []string            // columns naming the folder of the entry
+	fields map[string][]string // field name → columns
+	custom string              // column of extra "name: value" lines
+}
+
+var csvFormats = map[string]csvFormat{
+	"1password": {
+		title: []string{"title", "name"},
+		fields: map[string][]string{
+			"user":     {"username", "login"},
+			"password": {"password"},
+			"url":      {"url", "website", "login_uri"},
+			"notes":    {"notes", "notesplain"},
+			"totp":     {"otpauth", "one-time password", "totp"},
+		},
+	},
+	"bitwarden": {
+		title:  []string{"name"},
+		folder: []string{"folder"},
+		fields: map[string][]string{
+			"user":     {"login_username"},
+			"password": {"login_password"},
+			"url":      {"login_uri"},
+			"notes":    {"notes"},
+			"totp":     {"login_totp"},
+		},
+		custom: "fields",
+	},
+	"lastpass": {
+		title:  []string{"name"},
+		folder: []string{"grouping"},
+		fields: map[string][]string{
+			"user":     {"username"},
+			"password": {"password"},
+			"url":      {"url"},
+			"notes":    {"extra"},
+			"totp":     {"totp"},
+		},
+	},
+}
+
+// runImportCSV returns a Run function that imports a CSV export in the named
+// format.
+func runImportCSV(format string) func(*command.Env, string) error {
+	return func(env *command.Env, path string) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		snap, err := decodeExportCSV(csvFormats[format], data, format)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		return saveImport(env, env.Config.(*leaf.File), snap, path)
+	}
+}
+
+// decodeExportCSV decodes a CSV export in format cf to a snapshot. Entries
+// are stored in the table named by their folder, or in the table named by
+// --table if it is set, or in defTable if the entry has no folder. Entries
+// with the same title in a table are distinguished by a numeric suffix.
+func decodeExportCSV(cf csvFormat, data []byte, defTable string) (map[string]map[string]any, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	r.FieldsPerRecord = -1
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("decoding CSV: %w", err)
+	} else if len(rows) == 0 {
+		return nil, errors.New("CSV input has no header row")
+	}
+	cols := make(map[string]int)
+	for i, name := range rows[0] {
+		cols[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	column := func(names []string) int {
+		for _, name := range names {
+			if i, ok := cols[name]; ok {
+				return i
+			}
+		}
+		return -1
+	}
+	titleCol := column(cf.title)
+	if titleCol < 0 {
+		return nil, fmt.Errorf("missing %q column in CSV header", cf.title[0])
+	}
+	folderCol, customCol := column(cf.folder), column([]string{cf.custom})
+	fieldCols := make(map[string]int)
+	for name, cs := range cf.fields {
+		if i := column(cs); i >= 0 {
+			fieldCols[name] = i
+		}
+	}
+	if _, ok := fieldCols["password"]; !ok {
+		return nil, fmt.Errorf("missing %q column in CSV header", cf.fields["password"][0])
+	}
+
+	cell := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	snap := make(map[string]map[string]any)
+	for i, row := range rows[1:] {
+		table := importCSVFlags.Table
+		if table == "" {
+			table = strings.ReplaceAll(cell(row, folderCol), `\`, "/")
+		}
+		if table == "" {
+			table = defTable
+		}
+		val := make(map[string]string)
+		for name, c := range fieldCols {
+			if v := cell(row, c); v != "" {
+				val[name] = v
+			}
+		}
+		for _, line := range strings.Split(cell(row, customCol), "\n") {
+			name, v, ok := strings.Cut(line, ":")
+			name = strings.TrimSpace(name)
+			if ok && name != "" && val[name] == "" {
+				val[name] = strings.TrimSpace(v)
+			}
+		}
+		title := cell(row, titleCol)
+		if title == "" {
+			title = fmt.Sprintf("entry-%d", i+1)
+		}
+		if snap[table] == nil {
+			snap[table] = make(map[string]any)
+		}
+		key := title
+		for n := 2; snap[table][key] != nil; n++ {
+			key = fmt.Sprintf("%s (%d)", title, n)
+		}
+		snap[table][key] = val
+	}
+	return snap, nil
+}
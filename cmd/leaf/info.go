@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var infoFlags struct {
+	Full bool `flag:"full,Decrypt the file and include statistics about its contents"`
+}
+
+func runInfo(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	data, err := os.ReadFile(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	info, err := leaf.ReadInfo(bytes.NewReader(data))
+	if err != nil {
+		return openError(err)
+	}
+
+	var slots []object
+	for _, ks := range info.KeySlots {
+		slot := object{"name": ks.Name, "kind": slotKind(ks)}
+		if p := parseSlotParams(ks); p.KDF != nil {
+			slot["kdf"] = object{"alg": p.KDF.Alg, "time": p.KDF.Time, "memory": p.KDF.Memory, "threads": p.KDF.Threads}
+		}
+		slots = append(slots, slot)
+	}
+	out := object{
+		"file":        settings.FilePath,
+		"size":        len(data),
+		"version":     info.Version,
+		"cipher":      info.Cipher,
+		"compression": info.Compression,
+		"codec":       info.Codec,
+		"payload":     info.DataLen,
+		"shards":      info.Shards,
+		"slots":       slots,
+	}
+	rows := [][]cell{
+		{{"file", bold}, {settings.FilePath, plain}},
+		{{"size", bold}, {fmt.Sprintf("%d bytes (payload %d)", len(data), info.DataLen), plain}},
+		{{"format", bold}, {fmt.Sprintf("version %d, %s, %s, %s", info.Version, info.Cipher, info.Compression, info.Codec), plain}},
+	}
+	if info.Shards != 0 {
+		rows = append(rows, []cell{{"shards", bold}, {fmt.Sprint(info.Shards), plain}})
+	}
+	rows = append(rows, []cell{{"key slots", bold}, {fmt.Sprint(len(info.KeySlots)), plain}})
+	for _, ks := range info.KeySlots {
+		desc := slotKind(ks)
+		if p := parseSlotParams(ks); p.KDF != nil {
+			desc += fmt.Sprintf(" (time=%d, memory=%dMiB, threads=%d)", p.KDF.Time, p.KDF.Memory/1024, p.KDF.Threads)
+		}
+		rows = append(rows, []cell{{"", plain}, {ks.Name + ": " + desc, plain}})
+	}
+
+	if infoFlags.Full {
+		accessKey, err := getAccessKey(settings.FilePath, false)
+		if err != nil {
+			return err
+		}
+		f, err := leaf.Open(accessKey, bytes.NewReader(data))
+		if err != nil {
+			return openError(err)
+		}
+		db := fileDB(f)
+		var nkeys int
+		for _, name := range db.TableNames() {
+			tab, _ := db.GetTable(name)
+			nkeys += tab.Len()
+		}
+		stats := object{
+			"tables":  len(db.TableNames()),
+			"keys":    nkeys,
+			"entries": db.LogLen(),
+			"tags":    len(db.Tags()),
+		}
+		rows = append(rows,
+			[]cell{{"tables", bold}, {fmt.Sprint(len(db.TableNames())), plain}},
+			[]cell{{"keys", bold}, {fmt.Sprint(nkeys), plain}},
+			[]cell{{"log entries", bold}, {fmt.Sprint(db.LogLen()), plain}},
+			[]cell{{"tags", bold}, {fmt.Sprint(len(db.Tags())), plain}},
+		)
+		if log := db.Log(); len(log) != 0 {
+			first, last := log[0].Time, log[len(log)-1].Time
+			stats["first"] = first.Format(time.RFC3339)
+			stats["last"] = last.Format(time.RFC3339)
+			rows = append(rows,
+				[]cell{{"first change", bold}, {first.Format(time.DateTime), plain}},
+				[]cell{{"last change", bold}, {last.Format(time.DateTime), plain}},
+			)
+		}
+		out["stats"] = stats
+	}
+	return printResult(out, func() {
+		writeColumns(os.Stdout, newPainter(os.Stdout), nil, rows)
+	})
+}
+
+// slotKind describes how the access key of a key slot is obtained.
+func slotKind(ks leaf.KeySlot) string {
+	p := parseSlotParams(ks)
+	switch {
+	case p.Age != nil:
+		return "age"
+	case p.SSH != nil:
+		return "ssh-agent"
+	case p.KDF != nil:
+		return "passphrase, " + p.KDF.Alg
+	}
+	return "passphrase or key file, hkdf"
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/salsa20"
+	"golang.org/x/crypto/twofish"
+)
+
+var importKDBXFlags struct {
+	KeyFile string `flag:"key-file,KeePass key file for the database"`
+	Table   string `flag:"table,Table for all entries (default: the group of each entry)"`
+}
+
+func runImportKDBX(env *command.Env, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var keyData []byte
+	if importKDBXFlags.KeyFile != "" {
+		kf, err := os.ReadFile(importKDBXFlags.KeyFile)
+		if err != nil {
+			return err
+		}
+		keyData, err = kdbxKeyFileKey(kf)
+		if err != nil {
+			return fmt.Errorf("key file: %w", err)
+		}
+	}
+	pw, err := promptPassphrase(filepath.Base(path), false)
+	if err != nil {
+		return err
+	}
+	var pwData []byte
+	if pw != "" || keyData == nil {
+		pwData = []byte(pw)
+	}
+	doc, err := readKDBX(data, pwData, keyData)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	snap, err := kdbxEntries(doc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return saveImport(env, env.Config.(*leaf.File), snap, path)
+}
+
+// Identifiers used in KDBX headers.
+var (
+	kdbxCipherAES     = []byte{0x31, 0xc1, 0xf2, 0xe6, 0xbf, 0x71, 0x43, 0x50, 0xbe, 0x58, 0x05, 0x21, 0x6a, 0xfc, 0x5a, 0xff}
+	kdbxCipherChaCha  = []byte{0xd6, 0x03, 0x8a, 0x2b, 0x8b, 0x6f, 0x4c, 0xb5, 0xa5, 0x24, 0x33, 0x9a, 0x31, 0xdb, 0xb5, 0x9a}
+	kdbxCipherTwofish = []byte{0xad, 0x68, 0xf2, 0x9f, 0x57, 0x6f, 0x4b, 0xb9, 0xa3, 0x6a, 0xd4, 0x7a, 0xf9, 0x65, 0x34, 0x6c}
+	kdbxKDFAES3       = []byte{0xc9, 0xd9, 0xf3, 0x9a, 0x62, 0x8a, 0x44, 0x60, 0xbf, 0x74, 0x0d, 0x08, 0xc1, 0x8a, 0x4f, 0xea}
+	kdbxKDFAES4       = []byte{0x7c, 0x02, 0xbb, 0x82, 0x79, 0xa7, 0x4a, 0xc0, 0x92, 0x7d, 0x11, 0x4a, 0x00, 0x64, 0x82, 0x38}
+	kdbxKDFArgon2d    = []byte{0xef, 0x63, 0x6d, 0xdf, 0x8c, 0x29, 0x44, 0x4b, 0x91, 0xf7, 0xa9, 0xa4, 0x03, 0xe3, 0x0a, 0x0c}
+	kdbxKDFArgon2id   = []byte{0x9e, 0x29, 0x8b, 0x19, 0x56, 0xdb, 0x47, 0x73, 0xb2, 0x3d, 0xfc, 0x3e, 0xc6, 0xf0, 0xa1, 0xe6}
+)
+
+// Outer header field IDs.
+const (
+	kdbxEndOfHeader     = 0
+	kdbxCipherID        = 2
+	kdbxCompression     = 3
+	kdbxMasterSeed      = 4
+	kdbxTransformSeed   = 5 // KDBX 3
+	kdbxTransformRounds = 6 // KDBX 3
+	kdbxEncryptionIV    = 7
+	kdbxStreamKey       = 8  // KDBX 3
+	kdbxStreamStart     = 9  // KDBX 3
+	kdbxStreamID        = 10 // KDBX 3
+	kdbxKDFParams       = 11 // KDBX 4
+)
+
+var errKDBXKey = errors.New("wrong password or key file")
+
+// readKDBX decrypts a KeePass database in KDBX 3.1 or 4 format, and returns
+// its XML document with protected values decrypted. Either pw or keyData may
+// be nil if that component of the composite key is not used.
+func readKDBX(data, pw, keyData []byte) (*xmlNode, error) {
+	if len(data) < 12 || binary.LittleEndian.Uint32(data[0:]) != 0x9AA2D903 ||
+		binary.LittleEndian.Uint32(data[4:]) != 0xB54BFB67 {
+		return nil, errors.New("not a KeePass KDBX database")
+	}
+	major := binary.LittleEndian.Uint16(data[10:])
+	if major != 3 && major != 4 {
+		return nil, fmt.Errorf("KDBX version %d is not supported", major)
+	}
+
+	// Read the outer header. KDBX 4 uses 32-bit field lengths, KDBX 3 16-bit.
+	hdr := make(map[byte][]byte)
+	pos := 12
+	for {
+		sz := 3
+		if major == 4 {
+			sz = 5
+		}
+		if pos+sz > len(data) {
+			return nil, errors.New("truncated header")
+		}
+		id := data[pos]
+		var n int
+		if major == 4 {
+			n = int(binary.LittleEndian.Uint32(data[pos+1:]))
+		} else {
+			n = int(binary.LittleEndian.Uint16(data[pos+1:]))
+		}
+		pos += sz
+		if n < 0 || pos+n > len(data) {
+			return nil, errors.New("truncated header")
+		}
+		hdr[id] = data[pos : pos+n]
+		pos += n
+		if id == kdbxEndOfHeader {
+			break
+		}
+	}
+	header, body := data[:pos], data[pos:]
+
+	// Derive the master key from the composite key.
+	ck := sha256.New()
+	if pw != nil {
+		h := sha256.Sum256(pw)
+		ck.Write(h[:])
+	}
+	if keyData != nil {
+		ck.Write(keyData)
+	}
+	composite := ck.Sum(nil)
+	var transformed []byte
+	var err error
+	if major == 3 {
+		if len(hdr[kdbxTransformRounds]) != 8 {
+			return nil, errors.New("missing transform rounds")
+		}
+		transformed, err = kdbxAESKDF(composite, hdr[kdbxTransformSeed], binary.LittleEndian.Uint64(hdr[kdbxTransformRounds]))
+	} else {
+		transformed, err = kdbxKDF(composite, hdr[kdbxKDFParams])
+	}
+	if err != nil {
+		return nil, err
+	}
+	seed := hdr[kdbxMasterSeed]
+	if len(seed) != 32 {
+		return nil, errors.New("invalid master seed")
+	}
+	mk := sha256.Sum256(append(bytes.Clone(seed), transformed...))
+
+	var plain []byte
+	var streamID uint32
+	var streamKey []byte
+	if major == 3 {
+		dec, err := kdbxDecrypt(hdr[kdbxCipherID], mk[:], hdr[kdbxEncryptionIV], body)
+		if err != nil {
+			return nil, err
+		}
+		start := hdr[kdbxStreamStart]
+		if len(dec) < len(start) || !bytes.Equal(dec[:len(start)], start) {
+			return nil, errKDBXKey
+		}
+		plain, err = kdbxHashedBlocks(dec[len(start):])
+		if err != nil {
+			return nil, err
+		}
+		if len(hdr[kdbxStreamID]) == 4 {
+			streamID = binary.LittleEndian.Uint32(hdr[kdbxStreamID])
+		}
+		streamKey = hdr[kdbxStreamKey]
+	} else {
+		// The header is followed by its SHA-256 hash and HMAC. The HMAC is
+		// the first check of the key.
+		if len(body) < 64 {
+			return nil, errors.New("truncated header")
+		}
+		if h := sha256.Sum256(header); !bytes.Equal(h[:], body[:32]) {
+			return nil, errors.New("header checksum mismatch")
+		}
+		hk := sha512.New()
+		hk.Write(seed)
+		hk.Write(transformed)
+		hk.Write([]byte{1})
+		hmacKey := hk.Sum(nil)
+		m := hmac.New(sha256.New, kdbxHMACKey(hmacKey, ^uint64(0)))
+		m.Write(header)
+		if !hmac.Equal(m.Sum(nil), body[32:64]) {
+			return nil, errKDBXKey
+		}
+		enc, err := kdbxHMACBlocks(hmacKey, body[64:])
+		if err != nil {
+			return nil, err
+		}
+		plain, err = kdbxDecrypt(hdr[kdbxCipherID], mk[:], hdr[kdbxEncryptionIV], enc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c := hdr[kdbxCompression]; len(c) == 4 && binary.LittleEndian.Uint32(c) == 1 {
+		zr, err := gzip.NewReader(bytes.NewReader(plain))
+		if err != nil {
+			return nil, fmt.Errorf("decompress: %w", err)
+		}
+		plain, err = io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("decompress: %w", err)
+		}
+	}
+
+	// In KDBX 4, the XML is preceded by an inner header holding the key for
+	// protected values, and attachments.
+	if major == 4 {
+		for {
+			if len(plain) < 5 {
+				return nil, errors.New("truncated inner header")
+			}
+			id, n := plain[0], int(binary.LittleEndian.Uint32(plain[1:]))
+			if n < 0 || 5+n > len(plain) {
+				return nil, errors.New("truncated inner header")
+			}
+			val := plain[5 : 5+n]
+			plain = plain[5+n:]
+			if id == 0 {
+				break
+			} else if id == 1 && n == 4 {
+				streamID = binary.LittleEndian.Uint32(val)
+			} else if id == 2 {
+				streamKey = val
+			}
+		}
+	}
+	return parseKDBXML(plain, streamID, streamKey)
+}
+
+// kdbxKDF applies the key derivation function described by the KDBX 4
+// parameters in params to the composite key.
+func kdbxKDF(composite, params []byte) ([]byte, error) {
+	vd, err := parseVariantDict(params)
+	if err != nil {
+		return nil, fmt.Errorf("KDF parameters: %w", err)
+	}
+	u64 := func(name string) uint64 {
+		if v := vd[name]; len(v) == 8 {
+			return binary.LittleEndian.Uint64(v)
+		} else if len(v) == 4 {
+			return uint64(binary.LittleEndian.Uint32(v))
+		}
+		return 0
+	}
+	switch id := vd["$UUID"]; {
+	case bytes.Equal(id, kdbxKDFAES3), bytes.Equal(id, kdbxKDFAES4):
+		return kdbxAESKDF(composite, vd["S"], u64("R"))
+	case bytes.Equal(id, kdbxKDFArgon2id):
+		if v := u64("V"); v != 0x13 {
+			return nil, fmt.Errorf("argon2 version %#x is not supported", v)
+		}
+		iter, mem, par := u64("I"), u64("M")/1024, u64("P")
+		if iter == 0 || iter > 1<<32-1 || mem == 0 || mem > 1<<32-1 || par == 0 || par > 255 {
+			return nil, errors.New("invalid argon2 parameters")
+		}
+		return argon2.IDKey(composite, vd["S"], uint32(iter), uint32(mem), uint8(par), 32), nil
+	case bytes.Equal(id, kdbxKDFArgon2d):
+		return nil, errors.New("the Argon2d key derivation is not supported; " +
+			"change the database to use Argon2id or AES-KDF in KeePass and try again")
+	default:
+		return nil, fmt.Errorf("unknown key derivation %x", id)
+	}
+}
+
+// kdbxAESKDF derives a key by encrypting key with AES-256 under seed for the
+// given number of rounds.
+func kdbxAESKDF(key, seed []byte, rounds uint64) ([]byte, error) {
+	c, err := aes.NewCipher(seed)
+	if err != nil {
+		return nil, fmt.Errorf("transform seed: %w", err)
+	}
+	buf := bytes.Clone(key)
+	for range rounds {
+		c.Encrypt(buf[:16], buf[:16])
+		c.Encrypt(buf[16:], buf[16:])
+	}
+	h := sha256.Sum256(buf)
+	return h[:], nil
+}
+
+// parseVariantDict parses a KDBX 4 variant dictionary, returning the raw
+// bytes of each value by name.
+func parseVariantDict(data []byte) (map[string][]byte, error) {
+	if len(data) < 2 || data[1] != 1 {
+		return nil, errors.New("unsupported format")
+	}
+	out := make(map[string][]byte)
+	data = data[2:]
+	for len(data) != 0 {
+		if data[0] == 0 {
+			return out, nil
+		}
+		var fields [2][]byte
+		data = data[1:]
+		for i := range fields {
+			if len(data) < 4 {
+				return nil, errors.New("truncated")
+			}
+			n := int(binary.LittleEndian.Uint32(data))
+			if n < 0 || 4+n > len(data) {
+				return nil, errors.New("truncated")
+			}
+			fields[i] = data[4 : 4+n]
+			data = data[4+n:]
+		}
+		out[string(fields[0])] = fields[1]
+	}
+	return nil, errors.New("truncated")
+}
+
+// kdbxDecrypt decrypts data with the cipher identified by id.
+func kdbxDecrypt(id, key, iv, data []byte) ([]byte, error) {
+	var block cipher.Block
+	var err error
+	switch {
+	case bytes.Equal(id, kdbxCipherChaCha):
+		c, err := chacha20.NewUnauthenticatedCipher(key, iv)
+		if err != nil {
+			return nil, err
+		}
+		out := make([]byte, len(data))
+		c.XORKeyStream(out, data)
+		return out, nil
+	case bytes.Equal(id, kdbxCipherAES):
+		block, err = aes.NewCipher(key)
+	case bytes.Equal(id, kdbxCipherTwofish):
+		block, err = twofish.NewCipher(key)
+	default:
+		return nil, fmt.Errorf("unknown cipher %x", id)
+	}
+	if err != nil {
+		return nil, err
+	} else if len(iv) != block.BlockSize() || len(data) == 0 || len(data)%block.BlockSize() != 0 {
+		return nil, errors.New("invalid encrypted data")
+	}
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+
+	// Remove PKCS#7 padding. Invalid padding is usually due to a wrong key.
+	pad := int(out[len(out)-1])
+	if pad == 0 || pad > block.BlockSize() {
+		return nil, errKDBXKey
+	}
+	for _, b := range out[len(out)-pad:] {
+		if int(b) != pad {
+			return nil, errKDBXKey
+		}
+	}
+	return out[:len(out)-pad], nil
+}
+
+// kdbxHashedBlocks reads the hashed block stream of KDBX 3.
+func kdbxHashedBlocks(data []byte) ([]byte, error) {
+	var out []byte
+	for {
+		if len(data) < 40 {
+			return nil, errors.New("truncated block")
+		}
+		hash := data[4:36]
+		n := int(binary.LittleEndian.Uint32(data[36:]))
+		data = data[40:]
+		if n == 0 {
+			return out, nil
+		} else if n < 0 || n > len(data) {
+			return nil, errors.New("truncated block")
+		}
+		if h := sha256.Sum256(data[:n]); !bytes.Equal(h[:], hash) {
+			return nil, errors.New("block checksum mismatch")
+		}
+		out = append(out, data[:n]...)
+		data = data[n:]
+	}
+}
+
+// kdbxHMACBlocks reads the HMAC block stream of KDBX 4.
+func kdbxHMACBlocks(hmacKey, data []byte) ([]byte, error) {
+	var out []byte
+	for i := uint64(0); ; i++ {
+		if len(data) < 36 {
+			return nil, errors.New("truncated block")
+		}
+		mac := data[:32]
+		n := int(binary.LittleEndian.Uint32(data[32:]))
+		if n < 0 || 36+n > len(data) {
+			return nil, errors.New("truncated block")
+		}
+		if !hmac.Equal(kdbxBlockHMAC(hmacKey, i, data[32:36+n]), mac) {
+			return nil, errors.New("block authentication failed")
+		}
+		if n == 0 {
+			return out, nil
+		}
+		out = append(out, data[36:36+n]...)
+		data = data[36+n:]
+	}
+}
+
+// kdbxHMACKey returns the HMAC key for the block with the given index.
+func kdbxHMACKey(hmacKey []byte, index uint64) []byte {
+	var idx [8]byte
+	binary.LittleEndian.PutUint64(idx[:], index)
+	kh := sha512.New()
+	kh.Write(idx[:])
+	kh.Write(hmacKey)
+	return kh.Sum(nil)
+}
+
+// kdbxBlockHMAC computes the HMAC of the block with the given index, whose
+// contents (including its length prefix) are data.
+func kdbxBlockHMAC(hmacKey []byte, index uint64, data []byte) []byte {
+	var idx [8]byte
+	binary.LittleEndian.PutUint64(idx[:], index)
+	m := hmac.New(sha256.New, kdbxHMACKey(hmacKey, index))
+	m.Write(idx[:])
+	m.Write(data)
+	return m.Sum(nil)
+}
+
+// kdbxKeyFileKey returns the key data of a KeePass key file. A key file may
+// be an XML document (version 1.0 or 2.0), 32 bytes of raw key, 64 hex
+// digits, or any other file, whose SHA-256 hash is used.
+func kdbxKeyFileKey(data []byte) ([]byte, error) {
+	if t := bytes.TrimSpace(data); bytes.HasPrefix(t, []byte("<?xml")) || bytes.HasPrefix(t, []byte("<KeyFile")) {
+		var kf struct {
+			Version string `xml:"Meta>Version"`
+			Data    struct {
+				Hash string `xml:"Hash,attr"`
+				Text string `xml:",chardata"`
+			} `xml:"Key>Data"`
+		}
+		if err := xml.Unmarshal(data, &kf); err == nil {
+			if strings.HasPrefix(kf.Version, "2.") {
+				key, err := hex.DecodeString(strings.Join(strings.Fields(kf.Data.Text), ""))
+				if err != nil {
+					return nil, err
+				}
+				if h := sha256.Sum256(key); kf.Data.Hash != "" && !strings.EqualFold(hex.EncodeToString(h[:4]), kf.Data.Hash) {
+					return nil, errors.New("key file checksum mismatch")
+				}
+				return key, nil
+			}
+			return base64.StdEncoding.DecodeString(strings.TrimSpace(kf.Data.Text))
+		}
+	}
+	if len(data) == 32 {
+		return data, nil
+	} else if len(data) == 64 {
+		if key, err := hex.DecodeString(string(data)); err == nil {
+			return key, nil
+		}
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// An xmlNode is an element of a KDBX XML document.
+type xmlNode struct {
+	Name     string
+	Text     string
+	Children []*xmlNode
+}
+
+// child returns the first child of n with the given name, or nil.
+func (n *xmlNode) child(name string) *xmlNode {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// text returns the text of the named child of n, or "".
+func (n *xmlNode) text(name string) string {
+	if c := n.child(name); c != nil {
+		return c.Text
+	}
+	return ""
+}
+
+// parseKDBXML parses the XML document of a KDBX database. The values of
+// elements marked as protected are decrypted with the inner random stream,
+// in document order.
+func parseKDBXML(data []byte, streamID uint32, streamKey []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+	var protected []*xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("decoding XML: %w", err)
+		}
+		top := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{Name: t.Name.Local}
+			for _, a := range t.Attr {
+				if a.Name.Local == "Protected" && strings.EqualFold(a.Value, "true") {
+					protected = append(protected, n)
+				}
+			}
+			top.Children = append(top.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			top.Text += string(t)
+		}
+	}
+
+	var raw [][]byte
+	var total int
+	for _, n := range protected {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(n.Text))
+		if err != nil {
+			return nil, fmt.Errorf("protected value: %w", err)
+		}
+		raw = append(raw, b)
+		total += len(b)
+	}
+	ks := make([]byte, total)
+	switch streamID {
+	case 0: // no protection
+	case 2: // Salsa20
+		key := sha256.Sum256(streamKey)
+		salsa20.XORKeyStream(ks, ks, []byte{0xe8, 0x30, 0x09, 0x4b, 0x97, 0x20, 0x5d, 0x2a}, &key)
+	case 3: // ChaCha20
+		h := sha512.Sum512(streamKey)
+		c, err := chacha20.NewUnauthenticatedCipher(h[:32], h[32:44])
+		if err != nil {
+			return nil, err
+		}
+		c.XORKeyStream(ks, ks)
+	default:
+		return nil, fmt.Errorf("unsupported inner stream %d", streamID)
+	}
+	for i, n := range protected {
+		b := raw[i]
+		for j := range b {
+			b[j] ^= ks[j]
+		}
+		ks = ks[len(b):]
+		n.Text = string(b)
+	}
+	return root, nil
+}
+
+// kdbxFieldNames maps the standard KeePass entry fields to the names used for
+// imported values. Other fields are stored by their own names.
+var kdbxFieldNames = map[string]string{
+	"UserName": "user",
+	"Password": "password",
+	"URL":      "url",
+	"Notes":    "notes",
+	"otp":      "totp",
+}
+
+// kdbxEntries converts the entries of a KDBX document to a snapshot. Each
+// group is a table, named by the path of groups below the root group, and
+// each entry is a key named by its title. Entries in the root group go in
+// table "keepass", and entries in the recycle bin are skipped.
+func kdbxEntries(doc *xmlNode) (map[string]map[string]any, error) {
+	file := doc.child("KeePassFile")
+	root := file.child("Root").child("Group")
+	if root == nil {
+		return nil, errors.New("database has no root group")
+	}
+	recycle := file.child("Meta").text("RecycleBinUUID")
+	if strings.Trim(recycle, "A=") == "" {
+		recycle = "" // the zero UUID means there is no recycle bin
+	}
+	snap := make(map[string]map[string]any)
+	var walk func(g *xmlNode, path string)
+	walk = func(g *xmlNode, path string) {
+		table := importKDBXFlags.Table
+		if table == "" {
+			table = path
+		}
+		if table == "" {
+			table = "keepass"
+		}
+		for i, c := range g.Children {
+			switch c.Name {
+			case "Group":
+				if recycle != "" && c.text("UUID") == recycle {
+					continue
+				}
+				walk(c, strings.TrimPrefix(path+"/"+c.text("Name"), "/"))
+			case "Entry":
+				val := make(map[string]string)
+				var title string
+				for _, s := range c.Children {
+					if s.Name != "String" {
+						continue
+					}
+					name, v := s.text("Key"), s.text("Value")
+					if name == "Title" {
+						title = v
+						continue
+					} else if fn, ok := kdbxFieldNames[name]; ok {
+						name = fn
+					}
+					if v != "" {
+						val[name] = v
+					}
+				}
+				if title == "" {
+					title = fmt.Sprintf("entry-%d", i+1)
+				}
+				if snap[table] == nil {
+					snap[table] = make(map[string]any)
+				}
+				key := title
+				for n := 2; snap[table][key] != nil; n++ {
+					key = fmt.Sprintf("%s (%d)", title, n)
+				}
+				snap[table][key] = val
+			}
+		}
+	}
+	walk(root, "")
+	return snap, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+	"golang.org/x/crypto/argon2"
+)
+
+// kdfParams are the key slot parameters for an access key derived from a
+// passphrase with Argon2id.
+type kdfParams struct {
+	Alg     string `json:"alg"`     // "argon2id"
+	Salt    []byte `json:"salt"`    // random salt
+	Time    uint32 `json:"time"`    // number of passes
+	Memory  uint32 `json:"memory"`  // memory cost in KiB
+	Threads uint8  `json:"threads"` // degree of parallelism
+}
+
+// deriveKey derives an access key from passphrase using the parameters of p.
+func (p *kdfParams) deriveKey(passphrase string) ([]byte, error) {
+	if p.Alg != "argon2id" {
+		return nil, fmt.Errorf("unsupported KDF %q", p.Alg)
+	}
+	return argon2.IDKey([]byte(passphrase), p.Salt, p.Time, p.Memory, p.Threads, leaf.AccessKeyLen), nil
+}
+
+var kdfFlags struct {
+	KDF    string `flag:"kdf,default=hkdf,Passphrase KDF (hkdf or argon2id)"`
+	Memory uint   `flag:"kdf-memory,default=64,Argon2id memory cost in MiB"`
+	Time   uint   `flag:"kdf-time,default=3,Argon2id time cost (passes)"`
+}
+
+// newKDFParams returns key slot parameters for the KDF selected by the flags,
+// or nil for the default HKDF derivation, which needs no parameters.
+func newKDFParams() (*kdfParams, error) {
+	switch kdfFlags.KDF {
+	case "hkdf":
+		return nil, nil
+	case "argon2id":
+		if kdfFlags.Memory == 0 || kdfFlags.Memory > 1<<16 {
+			return nil, fmt.Errorf("invalid --kdf-memory %d", kdfFlags.Memory)
+		} else if kdfFlags.Time == 0 || kdfFlags.Time > 1<<16 {
+			return nil, fmt.Errorf("invalid --kdf-time %d", kdfFlags.Time)
+		}
+		p := &kdfParams{
+			Alg:     "argon2id",
+			Salt:    make([]byte, 16),
+			Time:    uint32(kdfFlags.Time),
+			Memory:  uint32(kdfFlags.Memory * 1024),
+			Threads: 4,
+		}
+		if _, err := cryptorand.Read(p.Salt); err != nil {
+			return nil, err
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown KDF %q (want hkdf or argon2id)", kdfFlags.KDF)
+	}
+}
+
+// newPassphraseKey prompts for a new passphrase, with confirmation, and
+// derives an access key from it using the KDF selected by the flags. It also
+// returns the key slot parameters that record the choice of KDF, which are nil
+// for the default.
+func newPassphraseKey(label string) ([]byte, json.RawMessage, error) {
+	p, err := newKDFParams()
+	if err != nil {
+		return nil, nil, err
+	}
+	if p == nil {
+		accessKey, err := promptAccessKey(label, true)
+		return accessKey, nil, err
+	}
+	pw, err := promptPassphrase(label, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	accessKey, err := p.deriveKey(pw)
+	if err != nil {
+		return nil, nil, err
+	}
+	params, err := json.Marshal(slotParams{KDF: p})
+	return accessKey, params, err
+}
+
+// passphraseSlot reports whether ks may be opened with a passphrase. A slot
+// without parameters for some other method is assumed to use one, since
+// older files did not record the parameters of passphrase slots.
+func passphraseSlot(ks leaf.KeySlot) bool {
+	p := parseSlotParams(ks)
+	return p.KDF != nil || (p.Age == nil && p.SSH == nil && !hardwareSlot(ks))
+}
+
+// promptFileKey prompts for the passphrase of the LEAF file at path, whose
+// contents are data and whose key slots are slots, and derives its access key.
+// If the file has key slots using different KDFs, the key for each is tried
+// in turn, and the first that opens the file is used.
+func promptFileKey(path string, data []byte, slots []leaf.KeySlot) ([]byte, error) {
+	pw, err := promptPassphrase(filepath.Base(path), false)
+	if err != nil {
+		return nil, err
+	}
+	var cands [][]byte
+	var legacy bool
+	for _, ks := range slots {
+		p := parseSlotParams(ks)
+		switch {
+		case p.KDF != nil:
+			key, err := p.KDF.deriveKey(pw)
+			if err != nil {
+				return nil, fmt.Errorf("key slot %q: %w", ks.Name, err)
+			}
+			cands = append(cands, key)
+		case passphraseSlot(ks):
+			legacy = true
+		}
+	}
+	if legacy || len(cands) == 0 {
+		key, err := hkdfAccessKey(pw)
+		if err != nil {
+			return nil, err
+		}
+		cands = append(cands, key)
+	}
+	if len(cands) == 1 {
+		return cands[0], nil
+	}
+	for _, key := range cands {
+		if _, err := leaf.VerifyKey(key, bytes.NewReader(data)); err == nil {
+			return key, nil
+		}
+	}
+	return nil, errors.New("incorrect passphrase")
+}
+
+func runRekey(env *command.Env, args ...string) error {
+	name := leaf.DefaultKeySlot
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	} else if len(args) == 1 {
+		name = args[0]
+	}
+	f := env.Config.(*leaf.File)
+	found := false
+	for _, ks := range f.KeySlots() {
+		found = found || ks.Name == name
+	}
+	if !found {
+		return notFoundf("key slot %q not found", name)
+	}
+	accessKey, params, err := newPassphraseKey(fmt.Sprintf("key slot %q", name))
+	if err != nil {
+		return err
+	}
+	if err := f.ReplaceKeySlot(leaf.KeySlot{Name: name, Params: params}, accessKey); err != nil {
+		return err
+	}
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	agentRemember(settings.FilePath, accessKey)
+	notify(env, object{"event": "rekeyed", "slot": name, "kdf": kdfFlags.KDF},
+		"changed the passphrase of key slot %q (KDF %s)", name, kdfFlags.KDF)
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+)
+
+// keyringService is the service name for access keys stored in the keyring.
+const keyringService = "leaf"
+
+// keyringAccount returns the keyring account name for the LEAF file at path.
+func keyringAccount(path string) (string, error) { return filepath.Abs(path) }
+
+// keyringAccessKey returns the access key stored in the platform keyring for
+// the LEAF file at path, if any.
+func keyringAccessKey(path string) ([]byte, error) {
+	acct, err := keyringAccount(path)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := keyringGet(acct)
+	if err != nil {
+		return nil, err
+	}
+	return hex.DecodeString(strings.TrimSpace(enc))
+}
+
+func runKeyringStore(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	// Verify that the key actually works before storing it.
+	if _, err := openWithKey(settings.FilePath, accessKey); err != nil {
+		return err
+	}
+	acct, err := keyringAccount(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	if err := keyringSet(acct, hex.EncodeToString(accessKey)); err != nil {
+		return fmt.Errorf("store key: %w", err)
+	}
+	notify(env, object{"event": "stored", "file": acct}, "stored access key for %q in the keyring", acct)
+	return nil
+}
+
+func runKeyringUse(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	accessKey, err := keyringAccessKey(settings.FilePath)
+	if err != nil {
+		return fmt.Errorf("no usable key in the keyring: %w", err)
+	}
+	if _, err := openWithKey(settings.FilePath, accessKey); err != nil {
+		return fmt.Errorf("keyring key does not open %q: %w", settings.FilePath, err)
+	}
+	notify(env, object{"event": "verified", "file": settings.FilePath}, "the keyring holds a valid access key for %q", settings.FilePath)
+	return nil
+}
+
+func runKeyringForget(env *command.Env) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	acct, err := keyringAccount(settings.FilePath)
+	if err != nil {
+		return err
+	}
+	if err := keyringDelete(acct); err != nil {
+		return fmt.Errorf("remove key: %w", err)
+	}
+	notify(env, object{"event": "removed", "file": acct}, "removed access key for %q from the keyring", acct)
+	return nil
+}
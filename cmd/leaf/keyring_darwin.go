@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// On macOS, keys are stored in the login keychain using the security tool.
+// Commands that include the secret are sent on stdin in interactive mode, so
+// that the secret does not appear in the process listing.
+
+func keyringGet(account string) (string, error) {
+	return runSecurity("", "find-generic-password", "-s", keyringService, "-a", account, "-w")
+}
+
+func keyringSet(account, secret string) error {
+	cmd := fmt.Sprintf("add-generic-password -U -s %s -a %s -w %s\n",
+		securityQuote(keyringService), securityQuote(account), securityQuote(secret))
+	_, err := runSecurity(cmd, "-i")
+	return err
+}
+
+func keyringDelete(account string) error {
+	_, err := runSecurity("", "delete-generic-password", "-s", keyringService, "-a", account)
+	return err
+}
+
+func runSecurity(input string, args ...string) (string, error) {
+	out, err := runTool(strings.NewReader(input), "/usr/bin/security", args...)
+	return string(out), err
+}
+
+// securityQuote quotes s for the interactive command parser of security.
+func securityQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+// On Linux, keys are stored via the Secret Service API using secret-tool.
+
+func keyringGet(account string) (string, error) {
+	out, err := runSecretTool("", "lookup", "service", keyringService, "account", account)
+	if err == nil && out == "" {
+		return "", errors.New("key not found")
+	}
+	return out, err
+}
+
+func keyringSet(account, secret string) error {
+	_, err := runSecretTool(secret, "store", "--label=leaf: "+account,
+		"service", keyringService, "account", account)
+	return err
+}
+
+func keyringDelete(account string) error {
+	_, err := runSecretTool("", "clear", "service", keyringService, "account", account)
+	return err
+}
+
+func runSecretTool(input string, args ...string) (string, error) {
+	out, err := runTool(strings.NewReader(input), "secret-tool", args...)
+	return string(out), err
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !linux && !windows
+
+package main
+
+import "errors"
+
+var errNoKeyring = errors.New("keyring is not supported on this platform")
+
+func keyringGet(account string) (string, error) { return "", errNoKeyring }
+
+func keyringSet(account, secret string) error { return errNoKeyring }
+
+func keyringDelete(account string) error { return errNoKeyring }
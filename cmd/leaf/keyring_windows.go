@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/base64"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// On Windows, keys are stored as generic credentials in the Credential Manager.
+// The secret is first encrypted with DPAPI (CryptProtectData) for the current
+// user, with the account name as additional entropy, so the stored credential
+// cannot be decrypted by another user, or used for another file. Credentials
+// stored without DPAPI are still accepted when read.
+
+var (
+	advapi32       = windows.NewLazySystemDLL("advapi32.dll")
+	procCredReadW  = advapi32.NewProc("CredReadW")
+	procCredWriteW = advapi32.NewProc("CredWriteW")
+	procCredDelete = advapi32.NewProc("CredDeleteW")
+	procCredFree   = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+)
+
+// dpapiPrefix marks a credential whose secret is encrypted with DPAPI.
+const dpapiPrefix = "dpapi:"
+
+// credential mirrors the Win32 CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        windows.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+func credTarget(account string) (*uint16, error) {
+	return windows.UTF16PtrFromString(keyringService + ":" + account)
+}
+
+func keyringGet(account string) (string, error) {
+	target, err := credTarget(account)
+	if err != nil {
+		return "", err
+	}
+	var pcred *credential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0,
+		uintptr(unsafe.Pointer(&pcred)))
+	if r == 0 {
+		return "", err
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(pcred)))
+	blob := string(unsafe.Slice(pcred.CredentialBlob, pcred.CredentialBlobSize))
+	enc, ok := strings.CutPrefix(blob, dpapiPrefix)
+	if !ok {
+		return blob, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(enc)
+	if err != nil {
+		return "", err
+	}
+	secret, err := dpapiUnprotect(data, account)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func keyringSet(account, secret string) error {
+	target, err := credTarget(account)
+	if err != nil {
+		return err
+	}
+	user, err := windows.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	data, err := dpapiProtect([]byte(secret), account)
+	if err != nil {
+		return err
+	}
+	blob := []byte(dpapiPrefix + base64.StdEncoding.EncodeToString(data))
+	cred := credential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func keyringDelete(account string) error {
+	target, err := credTarget(account)
+	if err != nil {
+		return err
+	}
+	r, _, err := procCredDelete.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// dpapiProtect encrypts data with DPAPI for the current user.
+func dpapiProtect(data []byte, entropy string) ([]byte, error) {
+	in, ent := dataBlob(data), dataBlob([]byte(entropy))
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(&in, nil, &ent, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return takeBlob(&out), nil
+}
+
+// dpapiUnprotect decrypts data encrypted by dpapiProtect.
+func dpapiUnprotect(data []byte, entropy string) ([]byte, error) {
+	in, ent := dataBlob(data), dataBlob([]byte(entropy))
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(&in, nil, &ent, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return takeBlob(&out), nil
+}
+
+func dataBlob(data []byte) windows.DataBlob {
+	if len(data) == 0 {
+		return windows.DataBlob{}
+	}
+	return windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeBlob copies the contents of a blob allocated by DPAPI, and frees it.
+func takeBlob(b *windows.DataBlob) []byte {
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(b.Data)))
+	return append([]byte(nil), unsafe.Slice(b.Data, b.Size)...)
+}
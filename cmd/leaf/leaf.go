@@ -2,13 +2,17 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/creachadair/atomicfile"
 	"github.com/creachadair/command"
@@ -16,11 +20,30 @@ import (
 	"github.com/creachadair/getpass"
 	"github.com/creachadair/leaf"
 	"golang.org/x/crypto/hkdf"
+	"golang.org/x/term"
 )
 
 var settings struct {
+	Profile       string `flag:"p,default=$LEAF_PROFILE,Use this profile from the config file"`
 	FilePath      string `flag:"f,default=$LEAF_FILE,LEAF file path (required)"`
 	AccessKeyFile string `flag:"access-key,default=$LEAF_ACCESS_KEY,Access key file path"`
+	KeyStdin      bool   `flag:"key-stdin,Read the access key from stdin"`
+	Credential    string `flag:"credential,default=$LEAF_CREDENTIAL,Name of the systemd credential holding the access key"`
+	PassphraseFD  int    `flag:"passphrase-fd,default=-1,Read passphrases from this file descriptor"`
+	Pinentry      string `flag:"pinentry,default=$LEAF_PINENTRY,Prompt for passphrases with this pinentry program"`
+	AgeIdentity   string `flag:"age-identity,default=$LEAF_AGE_IDENTITY,Age identity file path"`
+	SSHKey        string `flag:"ssh-key,default=$LEAF_SSH_KEY,Derive the access key using this ssh-agent key"`
+	Git           bool   `flag:"git,default=$LEAF_GIT,Commit the file to git after each save"`
+	Backups       int    `flag:"backups,default=$LEAF_BACKUPS,Keep this many copies of the file from before each save"`
+	Agent         bool   `flag:"agent,default=$LEAF_AGENT,Send the access key of each file opened to the key agent"`
+	JSON          bool   `flag:"json,Write machine-readable JSON output to stdout"`
+	Porcelain     bool   `flag:"porcelain,Write JSON output in the stable format for scripts (implies --json)"`
+	Quiet         bool   `flag:"quiet,Do not print informational messages"`
+	NoColor       bool   `flag:"no-color,Do not use color in terminal output"`
+	Force         bool   `flag:"force,Do not ask for confirmation before destructive changes"`
+	ReadOnly      bool   `flag:"read-only,Fail instead of saving changes to the file"`
+	Note          string `flag:"note,Record this note in the log with each change"`
+	Database      string `flag:"db,default=$LEAF_DB,Use this named database of the file"`
 }
 
 func main() {
@@ -32,12 +55,127 @@ func main() {
 Commands that operate on a file require a file path.
 If the -f flag is set, it is used as the path.
 Otherwise, the LEAF_FILE environment variable is used if set.
+Otherwise, the file named by the selected profile is used, if any.
+
+Profiles are defined in ~/.config/leaf/config.toml (or LEAF_CONFIG), and
+selected with -p (or LEAF_PROFILE). For example:
+
+  default = "work"   # used when -p is not set
+  agent-idle = "15m" # see "agent"
+
+  [profiles.work]
+  file = "~/work.leaf"
+  key-file = "~/.keys/work.key"
+  table = "web"
+
+A profile may set file, key-file, age-identity, db (see below), table
+(the default table for "get", "list", and "qr"), and backups (see
+below). Flags and environment variables take precedence over the
+settings of the profile; in particular, the key-file and age-identity of
+the profile are ignored if any source of the access key is set by a flag
+or environment variable. Relative paths are relative to the directory of
+the config file.
+
+A file may hold several independent databases, each with its own tables
+and history, sharing the same access keys. Commands use the default
+database unless --db (or LEAF_DB) names another. A named database is
+created when a command first changes it; use "db list" to list them.
 
 If --access-key is set, it is used as the access key file.
 Otherwise, if LEAF_ACCESS_KEY is set it is used.
-Otherwise the user is prompted at the terminal.`,
+Otherwise, if --key-stdin is set, the access key is read from the first
+32 bytes of stdin; any remaining input is left for the command.
+Otherwise, if --age-identity (or LEAF_AGE_IDENTITY) is set, the identity
+file is used to decrypt the access key of an age key slot, using the age
+command-line tool (see "key add").
+Otherwise, if --ssh-key (or LEAF_SSH_KEY) is set, the access key is derived
+from a signature by the matching ssh-agent key, identified by its SHA256
+fingerprint, its comment, or a public key file (see "key add").
+Otherwise, if the command runs as a systemd service with a credential named
+"leaf-access-key" (or the name set by --credential or LEAF_CREDENTIAL),
+for example from LoadCredential=leaf-access-key:/etc/leaf/access.key, the
+credential is used as the access key file.
+Otherwise, if a key agent is running and holds the access key for the
+file, it is used (see "agent start").
+Otherwise, if the platform keyring has an access key for the file, it is
+used (see "keyring store").
+Otherwise, if the file has a key slot sealed to the TPM of this machine,
+the access key is unsealed with tpm2-tools (see "key add").
+Otherwise, on macOS, if the file has a Touch ID key slot created on this
+machine, the user is asked for a fingerprint to unlock it (see "key add").
+Otherwise, if the file has a FIDO2 key slot, the access key is derived with
+the connected security key, which the user must touch (see "key add").
+Otherwise, if the file has a PKCS#11 key slot, the access key is unwrapped
+by the token, after prompting for its PIN (see "key add").
+If the hardware for these key slots is not available, or the file has no
+such slots, the user is prompted at the terminal for the passphrase, but
+only if the file has a key slot that a passphrase can open.
+
+If --passphrase-fd is set, passphrases are read from the given file
+descriptor instead of the terminal, one per line, without confirmation.
+Each prompt reads the next line. Otherwise, if LEAF_PASSPHRASE is set, its
+value is used for every passphrase instead of prompting. This is meant for
+automated jobs; a warning is printed if it is used at a terminal.
+Otherwise, if --pinentry (or LEAF_PINENTRY) is set, it names a pinentry
+program such as /usr/bin/pinentry, which is used to prompt the user.
+
+If --read-only is set, a command that would save changes to the file, or
+ask to confirm a change, fails without modifying it. This is useful when
+inspecting a file, to avoid changing it by accident.
+
+If --note is set, its text is recorded in the log with each change made by
+the command, to explain why it was made, for example:
+
+  leaf --note "rotated after incident #42" set api token ...
+
+Notes are shown by the "log" command.
+
+If --git is set (or LEAF_GIT=true) and the file lies in a git working
+tree, each change to the file is committed to git after it is saved.
+
+If --backups is set to a positive number N (or LEAF_BACKUPS, or "backups"
+in the profile), then before each change is saved, the file is copied into
+a .leaf-backups directory next to it, named <file>.<timestamp>, and only
+the N newest copies are kept. To undo a change, copy the newest backup
+over the file; it opens with the same access key as the file did then.
+
+While a command that changes a file is running, it holds a lock on a file
+of the same name with ".lock" appended, for example "secrets.leaf.lock".
+Another command that changes the same file waits until the lock is
+released, so that concurrent changes are not lost. Commands that only read
+the file do not take the lock, and do not wait for it. Locking is supported
+on Unix and Windows; on other platforms no lock is taken.
+
+If --json is set, each command writes its results, notices, and errors to
+stdout as JSON, one value per line. Notices are objects with an "event"
+field naming what happened and a "message" field with the human-readable
+text. Errors are objects with an "error" field, and a "code" field giving
+the exit code. Values read from the file are written as JSON regardless of
+options such as --raw.
+
+If --porcelain is set, output is written as for --json, in a format that
+is guaranteed to remain compatible in later versions; see "help porcelain".
+
+If --quiet is set, informational messages such as "created ..." and
+"deleted ..." are not written, in text or JSON mode. Results and errors
+are still written.
+
+The exit code of a command tells whether it succeeded, and if not, what
+kind of error occurred; see "help exit-codes".
+
+When stdout is a terminal, listings such as "list", "table list", "log",
+and "du" are aligned in columns and use color. Set --no-color, or set the
+NO_COLOR environment variable to a non-empty value, to disable color.
+
+Commands that destroy data, such as "delete", "table delete", and those
+with --replace, ask for confirmation if stdin is a terminal. Set --force
+to skip the confirmation.
+
+If a file cannot be opened and the reason is not clear, "leaf doctor"
+checks the file, access key, and environment for common problems.`,
 
 		SetFlags: command.Flags(flax.MustBind, &settings),
+		Init:     applyProfile,
 
 		Commands: []*command.C{
 			{
@@ -46,76 +184,1098 @@ Otherwise the user is prompted at the terminal.`,
 
 The specified file path must not exist; move or rename if necessary.
 If an --access-key file is specified, it is used to initialize the file.
-Otherwise the user is prompted for a passphrase.`,
+Otherwise the user is prompted for a passphrase.
+
+By default, the access key is derived from the passphrase with HKDF.
+With --kdf=argon2id, the key is derived with Argon2id instead, which makes
+guessing the passphrase much more costly. The cost can be adjusted with
+--kdf-memory (in MiB) and --kdf-time. The chosen parameters are recorded
+in the file, so no flags are needed to open it.
+
+With --import, the new file is initialized with the contents of a JSON
+snapshot, in the format written by "export" and read by "debug import".
+
+The contents of the file are encrypted with XChaCha20-Poly1305 by default.
+With --cipher=aes-siv, AES-SIV is used instead; see "convert".`,
+
+				SetFlags: command.Flags(flax.MustBind, &createFlags, &kdfFlags),
+				Run:      command.Adapt(runCreate),
+			},
+			{
+				Name:  "get",
+				Usage: "[<table-name>] <key>",
+				Help: `Get the value of a key.
+
+The table name may be omitted if the selected profile sets a default table.
+
+With --path, print only the portion of the value selected by the path.
+A path beginning with "/" is a JSON Pointer (RFC 6901), for example
+"/credentials/password". Otherwise it is a jq-style selector such as
+".credentials.password" or ".hosts[0]".
+
+With --raw, a string result is printed without JSON quotation.
+
+With --default, if the table or key does not exist, the given JSON value
+is printed instead of reporting an error, for example --default '"none"'.
+The --path is not applied to the default value.
+
+With --format, the output is rendered by a Go text template instead, for
+example --format '{{.Key}}: {{.Value.user}}'. The template is executed
+with fields .Table, .Key, and .Value, the decoded value (after --path, if
+set), and has the functions of the "template" command.`,
+
+				SetFlags: command.Flags(flax.MustBind, &getFlags),
+				Init:     withDefaultTable(2),
+				Run:      command.Adapt(runGet),
+			},
+			{
+				Name:  "set",
+				Usage: "<table-name> <key> <value> [<key> <value> ...]\n<table-name> <key> --from-file <path>\n<table-name> <key> --stdin",
+				Help: `Set the values of one or more keys.
+
+If a value is a valid JSON text, it is taken verbatim; otherwise the
+value is converted to a JSON string value. To override this guess, use
+one of the type flags:
+
+  --string   store each value as a JSON string, e.g., "true" or "123"
+  --json     require each value to be a valid JSON text
+  --int      require each value to be an integer
+  --bool     require each value to be a Boolean (true, false, 1, 0)
+
+With --from-file or --stdin, the value of a single key is read from the
+specified file or from standard input, rather than the command line.
+This avoids exposing secrets in the process listing. A single trailing
+line break is removed from the input.
+
+With --if-absent, the keys are set only if none of them exists. With
+--if-matches, the keys are set only if each exists and its value equals
+the given JSON value, ignoring whitespace. Otherwise no key is set, and
+the command fails with the conflict exit code (see "help exit-codes").
+This lets concurrent jobs update a key without overwriting each other's
+changes.
+
+With --dry-run, the keys that would be added or updated are printed, and
+the file is not saved.`,
+
+				SetFlags: command.Flags(flax.MustBind, &setFlags, &dryRunFlags),
+				Init:     requireFileIf(notDryRun),
+				Run:      command.Adapt(runSet),
+			},
+			{
+				Name:  "delete",
+				Usage: "<table-name> <key> [<key> ...]\n<table-name> --glob <pattern>",
+				Help: `Delete one or more keys from a table.
+
+With --glob, the keys matching the pattern are deleted instead, for example
+--glob 'tmp/*'. The pattern has the syntax of Go's path.Match, so "*" and
+"?" do not match "/". The matching keys are always listed before asking
+for confirmation. If stdin is not a terminal, --force is required.
+
+With --dry-run, the keys that would be deleted are printed, and the file
+is not saved.`,
+
+				SetFlags: command.Flags(flax.MustBind, &deleteFlags, &dryRunFlags),
+				Init:     requireFileIf(notDryRun),
+				Run:      command.Adapt(runDelete),
+			},
+			{
+				Name:  "cp",
+				Usage: "<table-name> <key> <dest-table> [<new-key>]",
+				Help: `Copy the value of a key to another table or key.
+
+The value is copied verbatim. If <new-key> is omitted, the copy has the
+same key as the original. The destination table is created if necessary.
+An existing value for the destination key is replaced.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runCopy),
+			},
+			{
+				Name:  "append",
+				Usage: "<table-name> <key> <value> [<value> ...]",
+				Help: `Append values to an array stored in a key.
+
+The stored value must be a JSON array; if the key does not exist, it is
+created with an empty array. Each value is appended in order. As with set,
+a value that is a valid JSON text is taken verbatim, and anything else is
+appended as a JSON string.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runAppend),
+			},
+			{
+				Name:  "incr",
+				Usage: "<table-name> <key> [<n>]",
+				Help: `Increment a number stored in a key, and print the new value.
+
+The key is incremented by n, which defaults to 1 and may be negative. If
+the key does not exist, it is created as if its value were 0. It is an
+error if the stored value is not a number. Integers are added exactly,
+and overflow is an error; otherwise the sum is computed in floating point.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runIncr),
+			},
+			{
+				Name:  "touch",
+				Usage: "<table-name> <key>",
+				Help: `Create a key if it does not exist.
+
+If the key does not exist, it is created with a null value, or with an
+empty object if --object is set. The table is created if necessary. If
+the key already exists, its value is not changed. This is useful to
+reserve a name, for example in a provisioning script.`,
+
+				SetFlags: command.Flags(flax.MustBind, &touchFlags),
+				Init:     requireFileRW,
+				Run:      command.Adapt(runTouch),
+			},
+			{
+				Name:  "random",
+				Usage: "<table-name> <key>",
+				Help: `Store cryptographically random bytes in a key.
+
+A value of --bytes random bytes is generated, encoded as a string with
+--encoding (hex, base64, or base64url without padding), and stored in the
+key, for example to provision an API token or a salt. By default nothing
+is printed, so the value does not appear on the terminal; use --print to
+print it. If the key already exists, its value is replaced after
+confirmation.`,
+
+				SetFlags: command.Flags(flax.MustBind, &randomFlags),
+				Init:     requireFileRW,
+				Run:      command.Adapt(runRandom),
+			},
+			{
+				Name:  "audit",
+				Usage: "<table-name>",
+				Help: `Check the passwords in a table for common weaknesses.
+
+A key whose value is a string is taken as a password. For a key whose
+value is an object, the string fields named by --fields are passwords.
+Each password is checked for:
+
+  reuse     the same password is stored under another key or field
+  weakness  its estimated entropy is below --min-bits, based on its length
+            and the classes of characters it contains
+  exposure  with --hibp, it appears in the Have I Been Pwned corpus
+
+The exposure check uses the k-anonymity range API of Have I Been Pwned:
+only the first 5 hex digits of the SHA-1 hash of each password are sent,
+and the match is made locally. The report names the keys and fields with
+issues, but never prints the passwords themselves.`,
+
+				SetFlags: command.Flags(flax.MustBind, &auditFlags),
+				Init:     requireFile,
+				Run:      command.Adapt(runAudit),
+			},
+			{
+				Name: "import",
+				Help: `Commands to import entries from other password managers.
+
+Imported entries replace existing values with the same table and key. If
+any values would be replaced, the user is asked to confirm first. With
+--dry-run, the keys that would be added or updated are printed, and the
+file is not saved.`,
+
+				Commands: []*command.C{
+					{
+						Name:  "pass",
+						Usage: "[<store-dir>]",
+						Help: `Import the entries of a pass password store.
+
+The store directory defaults to $PASSWORD_STORE_DIR, or ~/.password-store.
+Each entry is decrypted with gpg, which may prompt for a passphrase. An
+entry "work/db/admin.gpg" is stored in table "work/db" under key "admin";
+entries at the top level of the store are stored in the table named by
+--table. Directories whose names begin with "." are skipped.
+
+By the convention of pass, the first line of an entry is the password,
+and later lines may be fields such as "login: alice" or "url: ...". Each
+entry is stored as an object with fields "password", "user", "url", and
+"totp" (for an otpauth URI) where present, and other fields by their own
+names. Other lines are stored in "notes".`,
+
+						SetFlags: command.Flags(flax.MustBind, &importPassFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runImportPass),
+					},
+					{
+						Name:  "1password",
+						Usage: "<file.csv>",
+						Help: `Import a CSV export from 1Password.
+
+Each entry is stored under its title as an object with the fields "user",
+"password", "url", "notes", and "totp", where present. Entries are stored
+in the table named by --table, or in table "1password" if it is not set.
+Entries with the same title are distinguished by a suffix such as " (2)".`,
+
+						SetFlags: command.Flags(flax.MustBind, &importCSVFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runImportCSV("1password")),
+					},
+					{
+						Name:  "bitwarden",
+						Usage: "<file.csv>",
+						Help: `Import a CSV export from Bitwarden.
+
+Each entry is stored under its name as an object with the fields "user",
+"password", "url", "notes", and "totp", where present, and a field for
+each custom field of the entry. Entries are stored in the table named by
+their folder, or in table "bitwarden" if they have none. With --table, all
+entries are stored in that table instead. Entries with the same name are
+distinguished by a suffix such as " (2)".`,
+
+						SetFlags: command.Flags(flax.MustBind, &importCSVFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runImportCSV("bitwarden")),
+					},
+					{
+						Name:  "lastpass",
+						Usage: "<file.csv>",
+						Help: `Import a CSV export from LastPass.
+
+Each entry is stored under its name as an object with the fields "user",
+"password", "url", "notes" (from the "extra" column), and "totp", where
+present. Entries are stored in the table named by their group, with "\"
+replaced by "/", or in table "lastpass" if they have none. With --table,
+all entries are stored in that table instead. Entries with the same name
+are distinguished by a suffix such as " (2)".`,
+
+						SetFlags: command.Flags(flax.MustBind, &importCSVFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runImportCSV("lastpass")),
+					},
+					{
+						Name:  "kdbx",
+						Usage: "<file.kdbx>",
+						Help: `Import a KeePass database directly from its KDBX file.
+
+The database is decrypted with its password, which is prompted for as for
+other passphrases, and with the key file given by --key-file if it has one.
+Databases in the KDBX 3.1 and KDBX 4 formats are supported, with the AES,
+ChaCha20, or Twofish ciphers and the AES-KDF or Argon2id key derivations.
+The Argon2d key derivation is not supported.
+
+Each entry is stored under its title as an object with the fields "user",
+"password", "url", "notes", and "totp" (from the "otp" field), where
+present, and a field for each custom field of the entry. Entries are
+stored in the table named by the path of their group below the root, such
+as "Internet/Email", or in table "keepass" if they are in the root group.
+With --table, all entries are stored in that table instead. Entries with
+the same title are distinguished by a suffix such as " (2)". The history
+of entries and the recycle bin are not imported.`,
+
+						SetFlags: command.Flags(flax.MustBind, &importKDBXFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runImportKDBX),
+					},
+				},
+			},
+			{
+				Name: "otp",
+				Help: "Commands to manage one-time password (TOTP and HOTP) keys.",
+
+				Commands: []*command.C{
+					{
+						Name:  "import",
+						Usage: "<table-name> <key> [<otpauth-uri>]",
+						Help: `Import a one-time password key from an otpauth URI.
+
+The URI has the form used by authenticator apps, for example
+"otpauth://totp/Example:alice@example.com?secret=JBSWY3DPEHPK3PXP". If it
+is omitted, it is read from stdin, which avoids exposing the secret in the
+process listing. With --from-qr-image, it is read from a QR code in an
+image file instead, using the zbarimg tool.
+
+The key is stored as an object with fields type, issuer, account, secret,
+algorithm, digits, and period (for TOTP) or counter (for HOTP), for use by
+"otp code".`,
+
+						SetFlags: command.Flags(flax.MustBind, &otpImportFlags),
+						Init:     requireFileRW,
+						Run:      command.Adapt(runOTPImport),
+					},
+					{
+						Name:  "code",
+						Usage: "<table-name> <key>",
+						Help: `Print the current code for a one-time password key.
+
+The key must have been stored by "otp import". For a TOTP key, the code
+for the current time is printed. For an HOTP key, the code for the stored
+counter is printed, and the counter is advanced and saved.`,
+
+						Init: requireFileIf(func() bool { return !settings.ReadOnly }), // a HOTP code updates its counter
+						Run:  command.Adapt(runOTPCode),
+					},
+				},
+			},
+			{
+				Name:  "apply",
+				Usage: "[<ops-file>]",
+				Help: `Apply a list of operations to the file in a single save.
+
+The operations are read as a JSON array from the named file, or from stdin
+if the file is omitted or "-". Each operation is an object with an "op"
+field and a "table" field, and other fields according to the operation:
+
+  {"op": "set", "table": T, "key": K, "value": V}   set K to the JSON value V
+  {"op": "delete", "table": T, "key": K}            delete K if it exists
+  {"op": "create-table", "table": T}                create T if necessary
+  {"op": "delete-table", "table": T}                delete T if it exists
+  {"op": "rename", "table": T, "to": N}             rename table T to N
+
+Operations are applied in order. If any operation fails, none of them are
+saved, so the file is updated all at once or not at all.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runApply),
+			},
+			{
+				Name:  "batch",
+				Usage: "<script-file>|-",
+				Help: `Run a script of leaf commands with a single unlock and save.
+
+The script is read from the named file, or from stdin if the name is "-".
+Each line of the script is a command with its arguments, as it would be
+written after "leaf" on the command line. Words are separated by spaces,
+and may be quoted with single or double quotes as in the shell. Blank
+lines and lines beginning with "#" are ignored. For example:
+
+  # Rotate the staging credentials.
+  set web staging-user deploy
+  random web staging-token --bytes 24
+  delete web old-token
+
+The file is opened once before the first command, and saved once after
+the last, if any command changed it. If any command fails, the batch
+stops and none of the changes are saved. Global flags such as -f and
+--json apply to the whole batch and cannot be set within it.
+
+When the script is read from stdin, its commands cannot also read
+values from stdin.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runBatch),
+			},
+			{
+				Name:  "combine",
+				Usage: "<output-file> <input-file>...",
+				Help: `Combine several LEAF files into a new file.
+
+The output file must not exist. The user is prompted for the access key of
+each input file in turn, unless the key agent or platform keyring has it,
+and then for a passphrase for the output file (or set --access-key).
+
+By default, tables with the same name in different inputs are merged, and
+where inputs have the same key in a table, the value from the later input
+is kept and a notice is printed. With --namespace, each table is instead
+prefixed with the base name of its input file, so the tables of
+"work.leaf" are named "work/<table>" in the output.
+
+With --dry-run, the tables and keys the output would contain are printed,
+along with the keys replaced by later inputs, and the output file is not
+created. No passphrase is needed for the output.`,
+
+				SetFlags: command.Flags(flax.MustBind, &combineFlags, &kdfFlags, &dryRunFlags),
+				Run:      command.Adapt(runCombine),
+			},
+			{
+				Name: "convert",
+				Help: `Rewrite the file with the given format parameters.
+
+The file is re-encoded with the format version (--to-version), payload
+cipher (--cipher), payload compression (--compression), and payload
+encoding (--codec) selected by the flags. Parameters not set keep their
+defaults, except that the cipher and sharding are kept unchanged unless
+--cipher or --shard-size is set. Before the original is replaced, the new
+encoding is decrypted and decoded with the same access key, and its key
+slots, log, and contents are checked against the original; if they
+differ, the original is left unchanged.
+
+This version of the library supports only snappy compression and JSON
+encoding, so for now conversion re-encodes the file in that format, with a
+fresh encryption of its contents.
+
+Format version 2 is version 1 with the log stored in shards, each holding
+--shard-size entries and encrypted separately. Saving a large file with a
+sharded log is much faster, since only the shards that changed are
+re-encoded. Older versions of this tool cannot read version 2. Converting
+to version 2 without --shard-size uses a default size; --shard-size=0
+converts back to version 1. Format version 3 adds named databases (see
+"db"), and version 4 adds ciphers other than the default, each with or
+without the features of the earlier versions.
+
+The format version of the file is the lowest that has the features the
+file uses, so --to-version sets the oldest version that must be able to
+read the converted file. If the file uses a feature of a later version,
+such as named databases or a cipher other than the default, the
+conversion fails.
+
+The supported ciphers are xchacha20-poly1305 (the default) and aes-siv.
+AES-SIV resists nonce misuse: if the system's random source is poor when
+the file is saved, or a virtual machine is resumed from the same snapshot
+more than once, a repeated nonce does not expose the contents of the file.
+It is somewhat slower, and a file using it is saved in format version 4,
+which older versions of this tool cannot read.
+The cipher applies to the contents of the file; key slots are unchanged.`,
+
+				SetFlags: command.Flags(flax.MustBind, &convertFlags),
+				Run:      command.Adapt(runConvert),
+			},
+			{
+				Name:  "list",
+				Usage: "[<table-name>]",
+				Help: `List the keys in a table.
+
+When stdout is a terminal, the keys are printed in columns to fit the
+width of the terminal. Otherwise, they are printed one per line.
+The table name may be omitted if the selected profile sets a default table.
+
+With --long, each key is shown with the time it was last modified, taken
+from the log, the type of its value, and a preview of the value truncated
+to 40 characters. Note that the preview may reveal part of a secret.
+
+With --format, each key is rendered by a Go text template, as with
+"get --format", instead.
+
+With --glob or --regex, only the keys matching the pattern are listed, in
+any of the formats above. A --glob pattern has the syntax of Go's
+path.Match, so "*" does not match "/", and must match the whole key. A
+--regex matches any part of the key unless anchored with ^ and $.`,
+
+				SetFlags: command.Flags(flax.MustBind, &listFlags),
+				Init:     withDefaultTable(1),
+				Run:      command.Adapt(runList),
+			},
+			{
+				Name:  "tree",
+				Usage: "[<table-prefix>]",
+				Help: `Print the tables and keys of the file as a tree.
+
+Table names containing "/" are treated as paths, so tables such as
+"work/db" and "work/web" are shown together under a "work/" node. Each
+table and group is followed by the number of keys it contains.
+
+If a table prefix is given, only that table and the tables below it are
+shown. With --tables, keys are omitted and only tables are shown.`,
+
+				SetFlags: command.Flags(flax.MustBind, &treeFlags),
+				Init:     requireFile,
+				Run:      command.Adapt(runTree),
+			},
+			{
+				Name: "table",
+				Help: "Commands to manipulate tables.",
+
+				Commands: []*command.C{
+					{
+						Name: "list",
+						Help: `List the known tables.
+
+When stdout is a terminal, the number of keys in each table is also shown.`,
+
+						Init: requireFile,
+						Run:  command.Adapt(runTableList),
+					},
+					{
+						Name:  "create",
+						Usage: "<table-name>",
+						Help:  "Create a table.",
+						Init:  requireFileRW,
+						Run:   command.Adapt(runTableCreate),
+					},
+					{
+						Name:  "delete",
+						Usage: "<table-name>",
+						Help:  "Delete a table.",
+						Init:  requireFileRW,
+						Run:   command.Adapt(runTableDelete),
+					},
+					{
+						Name:  "clear",
+						Usage: "<table-name>",
+						Help:  "Remove all the keys from a table, but keep the table.",
+						Init:  requireFileRW,
+						Run:   command.Adapt(runTableClear),
+					},
+					{
+						Name:  "rename",
+						Usage: "<table-name> <new-name>",
+						Help:  "Rename a table.",
+						Init:  requireFileRW,
+						Run:   command.Adapt(runTableRename),
+					},
+					{
+						Name:  "export",
+						Usage: "<table-name> <dest-file>",
+						Help: `Copy a table to another LEAF file.
+
+The keys and values of the table are copied into the table of the same
+name in the destination file, replacing existing values for those keys.
+If the destination file does not exist, it is created with a new access
+key. With --move, the table is deleted from this file after the copy has
+been saved.
+
+The access key for the destination file is read from --key-file if it is
+set. Otherwise the key agent and platform keyring are consulted, and if
+they do not have a key the user is prompted for a passphrase.`,
+
+						SetFlags: command.Flags(flax.MustBind, &tableCopyFlags),
+						Init:     requireFileIf(func() bool { return tableCopyFlags.Move }),
+						Run:      command.Adapt(runTableExport),
+					},
+					{
+						Name:  "import",
+						Usage: "<table-name> <src-file>",
+						Help: `Copy a table from another LEAF file.
+
+The keys and values of the table in the source file are copied into the
+table of the same name in this file, replacing existing values for those
+keys. With --move, the table is deleted from the source file after the
+copy has been saved.
+
+The access key for the source file is found as for "table export". With
+--dry-run, the keys that would be added or updated are printed, and
+neither file is saved.`,
+
+						SetFlags: command.Flags(flax.MustBind, &tableCopyFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      command.Adapt(runTableImport),
+					},
+				},
+			},
+			{
+				Name: "key",
+				Help: `Commands to manage key slots.
+
+Each key slot holds a copy of the file's data key, encrypted with a
+different access key. Any of these access keys can open the file.`,
+
+				Commands: []*command.C{
+					{
+						Name: "list",
+						Help: "List the names of the key slots.",
+						Init: requireFile,
+						Run:  command.Adapt(runKeyList),
+					},
+					{
+						Name:  "add",
+						Usage: "<slot-name>",
+						Help: `Add a key slot granting access to a new key.
+
+If --key-file is set, the new access key is read from that file.
+
+If --age-recipient is set, a random access key is generated and stored
+in the slot encrypted to the specified age recipient (or recipients file)
+using the age command-line tool. The file can then be opened with the
+corresponding --age-identity.
+
+If --ssh-agent-key is set, the new access key is derived from a signature
+by the selected ssh-agent key, which may be given as a SHA256 fingerprint,
+the key comment, or a public key file. Only Ed25519 and RSA keys are
+supported. The file can then be opened with --ssh-key.
+
+If --tpm is set, a random access key is generated and sealed to the TPM
+of this machine using tpm2-tools, and the sealed key is stored in the
+slot. If --tpm-pcrs is also set, for example "7" or "sha256:0,2,7", the
+key can only be unsealed while those PCRs have their current values, so
+that it is not released if the firmware or boot configuration changes.
+The file is then opened with the TPM automatically, when no other access
+key is given. A copy of the file cannot be opened this way on another
+machine, so keep another key slot for recovery.
+
+If --touch-id is set (macOS only), a random access key is generated and
+wrapped with a new Secure Enclave key that can only be used after Touch ID
+authentication, using the age command-line tool and age-plugin-se. The
+file is then opened with a fingerprint instead of a passphrase, when no
+other access key is given. As with --tpm, the slot only works on the
+machine where it was created.
+
+If --fido2 is set, a new credential is enrolled on a FIDO2 security key
+using the libfido2 command-line tools, and the access key is derived from
+its hmac-secret extension. The key is the first reported by fido2-token,
+or the device named by LEAF_FIDO2_DEVICE. The file is then opened by
+touching the security key, when no other access key is given.
+
+If --pkcs11-key is set to the hex object ID of an RSA key pair on a PKCS#11
+token, such as an HSM or smart card, a random access key is generated and
+encrypted to its public key, and stored in the slot. The token must then
+decrypt the key, after the user enters its PIN, to open the file. The
+module is given by --pkcs11-module or LEAF_PKCS11_MODULE, for example
+/usr/lib/softhsm/libsofthsm2.so, and the OpenSC pkcs11-tool is used to
+access the token.
+
+Keys created with --tpm, --touch-id, --fido2, or --pkcs11-key are lost with the device
+that holds them, so the file should keep another key slot, such as a
+passphrase, for recovery. "key remove" asks for confirmation before
+removing the last such slot.
+
+Otherwise the user is prompted for a new passphrase.`,
+
+						SetFlags: command.Flags(flax.MustBind, &keyAddFlags),
+						Init:     requireFileRW,
+						Run:      command.Adapt(runKeyAdd),
+					},
+					{
+						Name:  "remove",
+						Usage: "<slot-name>",
+						Help: `Remove a key slot, revoking access by its key.
+
+The last remaining key slot cannot be removed.`,
+
+						Init: requireFileRW,
+						Run:  command.Adapt(runKeyRemove),
+					},
+				},
+			},
+			{
+				Name: "verify-key",
+				Help: `Check that the access key can open the file.
+
+The access key is obtained as for any other command, and is used to
+unlock the data key of the file. The data are not decrypted. The command
+succeeds if the key matches one of the key slots of the file, and fails
+otherwise.`,
+
+				Run: command.Adapt(runVerifyKey),
+			},
+			{
+				Name:  "rekey",
+				Usage: "[<slot-name>]",
+				Help: `Change the passphrase of a key slot.
+
+The user is prompted for a new passphrase, which replaces the access key
+of the named key slot (by default, the "default" slot). The KDF used to
+derive the new access key can be chosen with --kdf, --kdf-memory, and
+--kdf-time, as for "create".`,
+
+				SetFlags: command.Flags(flax.MustBind, &kdfFlags),
+				Init:     requireFileRW,
+				Run:      command.Adapt(runRekey),
+			},
+			{
+				Name: "keyring",
+				Help: `Commands to manage access keys in the platform keyring.
+
+The access key for a file can be stored in the platform keyring (the
+macOS Keychain, the Secret Service on Linux, or the Windows Credential
+Manager). When a file is opened and no other key source is specified, a
+key stored in the keyring for that file is used instead of prompting.
+
+On Windows, the key is also encrypted with DPAPI for the current user
+before it is stored, so that other users cannot read it. Run "keyring
+store" again to protect a key stored by an earlier version.`,
+
+				Commands: []*command.C{
+					{
+						Name: "store",
+						Help: "Store the access key for the file in the keyring.",
+						Run:  command.Adapt(runKeyringStore),
+					},
+					{
+						Name: "use",
+						Help: "Check that the keyring holds a valid access key for the file.",
+						Run:  command.Adapt(runKeyringUse),
+					},
+					{
+						Name: "forget",
+						Help: "Remove the access key for the file from the keyring.",
+						Run:  command.Adapt(runKeyringForget),
+					},
+				},
+			},
+			{
+				Name: "agent",
+				Help: `Commands to manage the key agent.
+
+The key agent holds access keys in memory, and serves them to other
+invocations of the tool over a Unix-domain socket, so that the passphrase
+need not be entered for each command. When the agent is running and
+--agent is set (or LEAF_AGENT=true), the access key for each file opened
+is sent to the agent, which holds it until its --timeout elapses. Keys are
+never sent to an agent unless --agent is set.
+
+If --idle is set (or LEAF_AGENT_IDLE, or agent-idle in the config file),
+the agent also discards all of its keys when it has not been used for that
+long, so that the passphrase must be entered again after a period of
+inactivity. For example, in ~/.config/leaf/config.toml:
+
+  agent-idle = "15m"
+
+The socket path is $LEAF_AGENT_SOCK if set, or a per-user default under
+$XDG_RUNTIME_DIR (or the temporary directory, if that is not set). The
+socket and the directory that contains it must belong to the user and be
+private to them (mode 0700); otherwise the tool refuses to use the agent,
+since another user could impersonate it.`,
+
+				Commands: []*command.C{
+					{
+						Name:     "start",
+						Help:     "Start a key agent in the background.",
+						SetFlags: command.Flags(flax.MustBind, &agentFlags),
+						Run:      command.Adapt(runAgentStart),
+					},
+					{
+						Name:     "serve",
+						Help:     "Run a key agent in the foreground.",
+						SetFlags: command.Flags(flax.MustBind, &agentFlags),
+						Run:      command.Adapt(runAgentServe),
+					},
+					{
+						Name: "stop",
+						Help: "Stop the running key agent, discarding all keys.",
+						Run:  command.Adapt(runAgentStop),
+					},
+					{
+						Name: "status",
+						Help: "Report the status of the key agent and the files it holds keys for.",
+						Run:  command.Adapt(runAgentStatus),
+					},
+					{
+						Name: "forget",
+						Help: "Discard the key held by the agent for the file.",
+						Run:  command.Adapt(runAgentForget),
+					},
+				},
+			},
+			{
+				Name:  "host",
+				Usage: "[<origin> ...]",
+				Help: `Serve a browser extension as a native messaging host.
+
+The browser starts the host when the extension connects to it, and sends
+requests on stdin, each a JSON object preceded by its length as a 32-bit
+integer in native byte order. Responses are written to stdout in the same
+form. The arguments passed by the browser are ignored.
+
+The host does not modify the file, and reads it again when it changes.
+Since the host has no terminal, the access key must be available without
+a prompt, for example from the key agent, the keyring, --access-key in
+the browser manifest, or a profile; or --pinentry must be set.
+
+Each request has an "op" and may have an "id", which is copied into the
+response. The operations are:
+
+  {"op": "status"}
+    Report the path of the file.
+
+  {"op": "lookup", "url": "https://example.com/login", "table": "web"}
+    Report the table, key, user, and url of each entry whose "url" field
+    has the same scheme as the page, and the same host or a parent domain
+    of it, in the "entries" field, which is omitted if none match. A "url"
+    without a scheme is taken to be https. If "table" is set, only that
+    table is searched. Passwords are not included.
+
+  {"op": "fill", "url": "https://example.com/login", "table": "web", "key": "example"}
+    Report the user, password, and current TOTP code (from an otpauth URI
+    in the "totp" field) of the entry. The entry must match the url.
+
+Errors are reported in the "error" field of the response.
+
+To install the host, write a manifest naming a script that runs this
+command, and register it with the browser, for example:
+
+  {
+    "name": "org.leaf.host",
+    "description": "LEAF password lookup",
+    "path": "/usr/local/bin/leaf-host",
+    "type": "stdio",
+    "allowed_origins": ["chrome-extension://<extension-id>/"]
+  }
+
+where leaf-host is a script that runs "leaf host" with the flags needed to
+select the file and its access key.`,
+
+				Run: command.Adapt(runHost),
+			},
+			{
+				Name:  "sync",
+				Usage: "<remote>",
+				Help: `Synchronize the file with a remote copy.
+
+The remote copy is downloaded and merged with the local file, and the
+result is saved locally and uploaded. If the remote copy does not exist,
+the local file is uploaded. Both copies must accept the same access key.
+
+Remote locations may have any of these forms:
+
+  ssh://[user@]host[:port]/path   copied using scp
+  s3://bucket/path                copied using the aws command-line tool
+  webdav://host/path              WebDAV over HTTPS (webdav+http for HTTP)
+  /local/path                     a local file (e.g., a mounted share)
+
+With --dry-run, the changes that merging would make to each copy are
+printed, and neither copy is written.`,
+
+				SetFlags: command.Flags(flax.MustBind, &dryRunFlags),
+				Run:      command.Adapt(runSync),
+			},
+			{
+				Name: "git",
+				Help: `Commands for files stored in git.
+
+If the file lies in a git working tree, the --git flag causes each change
+to be committed automatically after it is saved.`,
+
+				Commands: []*command.C{
+					{
+						Name: "sync",
+						Help: `Synchronize the file with the git remote.
+
+Any uncommitted changes to the file are committed, then the remote
+changes are fetched and merged (fast-forward only), and the local changes
+are pushed.`,
+
+						Run: command.Adapt(runGitSync),
+					},
+				},
+			},
+			{
+				Name: "log",
+				Help: `Print the log of changes to the file.
+
+Each entry is printed with its time, operation, table, and key, and with
+the note recorded for it (see --note), if any. Use the flags to select
+which entries are printed:
+
+  --table   entries affecting the named table
+  --key     entries updating or deleting the named key
+  --op      entries with the named operation (for example update, delete,
+            create-table, delete-table, rename-table, snapshot, tag)
+  --since   entries at or after a time
+  --until   entries before a time
+  -n        only the last n of the matching entries
 
-				Run: command.Adapt(runCreate),
+Times are given in RFC 3339 format, as microseconds since the Unix epoch,
+or as a duration before now, such as "24h". Stored values are not printed
+unless --values is set.`,
+
+				SetFlags: command.Flags(flax.MustBind, &logFlags),
+				Init:     requireFile,
+				Run:      command.Adapt(runLog),
 			},
 			{
-				Name:  "get",
-				Usage: "<table-name> <key>",
-				Help:  "Get the value of a key.",
-				Init:  requireFile,
-				Run:   command.Adapt(runGet),
+				Name: "info",
+				Help: `Print information about the file.
+
+The format version, cipher, compression, and encoding of the file, and the
+number and kinds of its key slots with their KDF parameters, are read from
+the unencrypted wrapper of the file, so no access key is needed. Files in
+the current format do not record a file identifier or other metadata.
+
+With --full, the file is also decrypted, prompting for the access key if
+necessary, and the numbers of tables, keys, log entries, and tags, and the
+times of the first and last changes, are printed.`,
+
+				SetFlags: command.Flags(flax.MustBind, &infoFlags),
+				Run:      command.Adapt(runInfo),
 			},
 			{
-				Name:  "set",
-				Usage: "<table-name> <key> <value> [<key> <value> ...]",
-				Help: `Set the values of one or more keys.
+				Name: "doctor",
+				Help: `Check the environment for common configuration problems.
 
-If a value is a valid JSON text, it is taken verbatim; otherwise the
-value is converted to a JSON string value.`,
+The checks include the config file and selected profile; whether the file
+exists and is a LEAF file in a format this version supports; whether the
+access key file has the right length, is private, and opens the file;
+settings that are ignored because others take precedence, such as several
+key sources or an unused LEAF_PASSPHRASE; and whether the key agent is
+reachable. The file is not decrypted, and no passphrase is requested.
 
-				Init: requireFile,
-				Run:  command.Adapt(runSet),
+Each finding is printed with its status (ok, info, warning, or error) and,
+for problems, a suggested fix. The command fails if any error is found.
+
+Commands that open a file warn if it, or the access key file, is accessible
+to users other than its owner. With --fix-perms, doctor changes the mode of
+such files to 0600 (read and write by the owner only).`,
+
+				SetFlags: command.Flags(flax.MustBind, &doctorFlags),
+				Run:      command.Adapt(runDoctor),
 			},
 			{
-				Name:  "delete",
-				Usage: "<table-name> <key> [<key> ...]",
-				Help:  "Delete one or more keys from a table.",
-				Init:  requireFile,
-				Run:   command.Adapt(runDelete),
+				Name:  "du",
+				Usage: "[<table-name>]",
+				Help: `Report the space used by each table and key.
+
+For each table, and each key within it, the following are printed in
+decreasing order of total size:
+
+  TOTAL     bytes used by all log entries, including past values
+  LIVE      bytes used by the current keys and values
+  ENTRIES   number of log entries
+
+Sizes are those of the log before compression and encryption. A large
+difference between the total and live sizes means the history is large,
+and compacting the file (see "compact") will make it smaller. Tables that
+no longer exist but still have log entries are marked as deleted.`,
+
+				Init: requireFile,
+				Run:  command.Adapt(runDU),
 			},
 			{
-				Name:  "list",
-				Usage: "<table-name>",
-				Help:  "List the keys in a table.",
-				Init:  requireFile,
-				Run:   command.Adapt(runList),
+				Name: "chain",
+				Help: `Commands to manage the hash chain of the log.
+
+When the log is hash-chained, each entry records a hash of the entry
+before it, so that history cannot be removed, reordered, or altered
+without the change being detected, even by someone who has the access key
+but does not relink the rest of the log.
+
+Removing entries from the end of the log, or rewriting and relinking it,
+can only be detected by comparing with a chain head recorded elsewhere.
+"chain verify" prints the current head; keep a copy of it outside the
+file, and pass it to a later "chain verify" to check that the state it
+names is still part of the history.`,
+
+				Commands: []*command.C{
+					{
+						Name: "enable",
+						Help: `Enable hash chaining for the log.
+
+The existing entries of the log are linked, and each new entry is linked
+to the one before it. Compacting the file keeps the link to the last entry
+it replaces, and sync links merged entries in their new order.`,
+
+						Init: requireFileRW,
+						Run:  command.Adapt(runChainEnable),
+					},
+					{
+						Name:  "verify",
+						Usage: "[<head>]",
+						Help: `Verify the hash chain of the log, and print its head.
+
+If a head printed by an earlier verify is given, it is an error if the
+state it names is no longer part of the log.`,
+
+						Init: requireFile,
+						Run:  command.Adapt(runChainVerify),
+					},
+				},
 			},
 			{
-				Name: "table",
-				Help: "Commands to manipulate tables.",
+				Name: "db",
+				Help: `Commands to manage the named databases of the file.
+
+In addition to its default database, a file may hold any number of named
+databases, each with its own tables and history. Select a database for
+other commands with --db (or LEAF_DB). A file with named databases is
+saved in format version 3, which older versions of this tool cannot read.`,
 
 				Commands: []*command.C{
 					{
 						Name: "list",
-						Help: "List the known tables.",
+						Help: "List the names of the named databases in the file.",
 						Init: requireFile,
-						Run:  command.Adapt(runTableList),
-					},
-					{
-						Name:  "create",
-						Usage: "<table-name>",
-						Help:  "Create a table.",
-						Init:  requireFile,
-						Run:   command.Adapt(runTableCreate),
+						Run:  command.Adapt(runDBList),
 					},
 					{
 						Name:  "delete",
-						Usage: "<table-name>",
-						Help:  "Delete a table.",
-						Init:  requireFile,
-						Run:   command.Adapt(runTableDelete),
-					},
-					{
-						Name:  "rename",
-						Usage: "<table-name> <new-name>",
-						Help:  "Rename a table.",
-						Init:  requireFile,
-						Run:   command.Adapt(runTableRename),
+						Usage: "<name>",
+						Help: `Delete the specified named database (destructive).
+
+The database and its history are removed from the file. If the file is
+synced, delete the database from each copy, since sync merges the named
+databases of both copies. The default database cannot be deleted.`,
+
+						Init: requireFileRW,
+						Run:  command.Adapt(runDBDelete),
 					},
 				},
 			},
+			{
+				Name:  "tag",
+				Usage: "[<tag-name>]",
+				Help: `Record a named tag marking the current state of the file.
+
+With no name, list the tags recorded in the file and when they were added.
+A tagged state can be restored with "rollback". Tags are discarded when
+the file is compacted.`,
+
+				Init: requireFileRW,
+				Run:  command.Adapt(runTag),
+			},
+			{
+				Name:  "rollback",
+				Usage: "<tag-name>|<timestamp>",
+				Help: `Roll the file back to a tag or a point in time.
+
+The target is the name of a tag (see "tag"), or a timestamp given as an
+RFC 3339 time or a count of microseconds since the Unix epoch. The changes
+that the rollback would make are printed, and the user is asked to confirm
+before the file is written.
+
+Changes made after the target are discarded from the file. With --dry-run,
+the changes are printed and the file is not written.`,
+
+				SetFlags: command.Flags(flax.MustBind, &dryRunFlags),
+				Init:     requireFileIf(notDryRun),
+				Run:      command.Adapt(runRollback),
+			},
+			{
+				Name: "compact",
+				Help: `Compact the log of the file to its current state.
+
+Compaction discards the history of changes to the file, leaving a single
+snapshot of the current contents. This makes the file smaller, but the
+discarded history can no longer be rewound or merged.
+
+By default, the number of log entries that would be removed is printed
+and the file is not changed. With --replace, a backup of the original is
+first written next to the file, named <file>.<timestamp>.bak, and then
+the compacted file is saved. With --dry-run, the file is not changed even
+if --replace is set.`,
+
+				SetFlags: command.Flags(flax.MustBind, &compactFlags, &dryRunFlags),
+				Init:     requireFileIf(func() bool { return compactFlags.Replace && !dryRunFlags.DryRun }),
+				Run:      command.Adapt(runCompact),
+			},
+			{
+				Name:  "copy-table",
+				Usage: "<table-name> --to <dest-file>",
+				Help: `Copy a table to another LEAF file.
+
+This is the same as "table export <table-name> <dest-file>": the table is
+copied directly between the files, without a plaintext intermediate. If
+the destination file does not exist, it is created with a new access key.
+With --move, the table is deleted from this file after the copy has been
+saved.
+
+The access key for the destination file is read from --key-file if it is
+set. Otherwise the key agent and platform keyring are consulted, and if
+they do not have a key the user is prompted for a passphrase.`,
+
+				SetFlags: command.Flags(flax.MustBind, &copyTableFlags, &tableCopyFlags),
+				Init:     requireFileIf(func() bool { return tableCopyFlags.Move }),
+				Run:      command.Adapt(runCopyTable),
+			},
+			{
+				Name: "export",
+				Help: `Export the contents of the file as plaintext JSON.
+
+The export is a JSON object mapping each table name to an object of its
+keys and values, in the format accepted by "debug import".
+
+With --redact, each value is replaced by a placeholder giving its type
+(and for strings, its length), so that the structure of a file can be
+shared without revealing its contents. Object field names are kept.
+
+With --canonical, tables, keys, and object fields are written in sorted
+order with uniform indentation, so that the export of the same contents is
+always the same, however the file was modified. This is useful to keep
+plaintext backups that are signed or deduplicated.
+
+To export only part of the file, use --tables to list the tables to include,
+separated by commas, and --keys-glob to include only keys matching a glob
+pattern, such as --keys-glob 'prod/*'. The pattern has the syntax of Go's
+path.Match. With --keys-glob alone, tables with no matching keys are left
+out; tables named by --tables are always included.
+
+WARNING: Without --redact, the export contains all values in plaintext.`,
+
+				SetFlags: command.Flags(flax.MustBind, &exportFlags),
+				Init:     requireFile,
+				Run:      command.Adapt(runExport),
+			},
 			{
 				Name: "debug",
 				Help: "Commands for debugging.",
@@ -130,8 +1290,10 @@ value is converted to a JSON string value.`,
 					{
 						Name: "snapshot",
 						Help: "Print a database snapshot.",
-						Init: requireFile,
-						Run:  command.Adapt(runDebugSnapshot),
+
+						SetFlags: command.Flags(flax.MustBind, &debugSnapshotFlags),
+						Init:     requireFile,
+						Run:      command.Adapt(runDebugSnapshot),
 					},
 					{
 						Name: "compact",
@@ -141,29 +1303,49 @@ By default, the compacted log is printed.
 
 WARNING: With --replace, the compacted database is written back to the file (destructive).
          Make a copy first if you want to keep the original.`,
-						Init:     requireFile,
+						Init:     requireFileIf(func() bool { return rewindFlags.Replace }),
 						SetFlags: command.Flags(flax.MustBind, &rewindFlags),
 						Run:      command.Adapt(runDebugCompact),
 					},
 					{
 						Name:  "import",
 						Usage: "[<input-file>]",
-						Help:  "Import a database snapshot.",
-						Init:  requireFile,
-						Run:   runDebugImport,
+						Help: `Import a database snapshot, or rows of a CSV file.
+
+By default the input is a JSON snapshot, in the format written by "export".
+
+With --csv, the input is CSV with a header row naming the columns, such as
+an export from a spreadsheet or another password manager. Each row is
+stored in the table named by --table, under the key in its --key-column.
+The value is a JSON object with a string field for each column listed in
+--value-columns, or for every other column if it is not set. An entry of
+the form "field=column" stores the column under a different field name:
+
+  --key-column name --value-columns user=username,url,password
+
+Rows replace existing values with the same key. It is an error for two
+rows to have the same key, or for a row to have an empty key.
+
+With --dry-run, the keys that would be added or updated are printed, and
+the file is not saved.`,
+
+						SetFlags: command.Flags(flax.MustBind, &importFlags, &dryRunFlags),
+						Init:     requireFileIf(notDryRun),
+						Run:      runDebugImport,
 					},
 					{
 						Name:  "rewind",
 						Usage: "<timestamp>|<rfc3339>",
 						Help: `Rewind the database to this timestamp.
 
-By default, a snapshot of the rewound database is printed.
+By default, a snapshot of the rewound database is printed. With --dry-run,
+the changes that rewinding would make are printed instead.
 
 WARNING: With --replace, the rewound database is written back to the file (destructive).
          Make a copy first if you want to keep the original.`,
 
-						SetFlags: command.Flags(flax.MustBind, &rewindFlags),
-						Init:     requireFile,
+						SetFlags: command.Flags(flax.MustBind, &rewindFlags, &dryRunFlags),
+						Init:     requireFileIf(func() bool { return rewindFlags.Replace && !dryRunFlags.DryRun }),
 						Run:      command.Adapt(runDebugRewind),
 					},
 					{
@@ -173,43 +1355,374 @@ WARNING: With --replace, the rewound database is written back to the file (destr
 						SetFlags: command.Flags(flax.MustBind, &keyFileFlags),
 						Run:      command.Adapt(runDebugKeyFile),
 					},
+					{
+						Name:  "unwrap",
+						Usage: "<output-file>|-",
+						Help: `Write the decrypted payload of the file.
+
+The payload is the plaintext JSON encoding of the database, as stored in
+the file after decryption and decompression. It is written to the output
+file, which must not exist, or to stdout if it is "-". Unlike other
+commands, the payload is not decoded, so this works even if it is damaged.
+
+WARNING: The output is not encrypted. Delete it when you are done.`,
+
+						Run: command.Adapt(runDebugUnwrap),
+					},
+					{
+						Name:  "wrap",
+						Usage: "<payload-file>|- <output-file>",
+						Help: `Encrypt a payload into a new file.
+
+The payload must be in the format written by "debug unwrap", and is read
+from the payload file, or from stdin if it is "-". It is encrypted into a
+new file at the output path, which must not exist, with a new data key.
+The access key is obtained as for "create": from --access-key if set, or
+else from a new passphrase, using the key derivation set by --kdf.`,
+
+						SetFlags: command.Flags(flax.MustBind, &kdfFlags),
+						Run:      command.Adapt(runDebugWrap),
+					},
 				},
 			},
-			command.HelpCommand(nil),
+			{
+				Name:  "completion",
+				Usage: "bash|zsh|fish",
+				Help: `Print a shell completion script.
+
+The script completes subcommands and flags. If the file and access key
+are available without prompting (for example, via LEAF_FILE and
+LEAF_ACCESS_KEY), table names and keys are also completed.
+
+To enable completion, add one of the following to your shell profile:
+
+  bash:  source <(leaf completion bash)
+  zsh:   source <(leaf completion zsh)
+  fish:  leaf completion fish | source`,
+
+				Run: command.Adapt(runCompletion),
+			},
+			{
+				Name:     "complete",
+				Usage:    "-- <word>...",
+				Help:     "Print completion candidates for the last of the given words.",
+				Unlisted: true,
+				Run:      runComplete,
+			},
+			{
+				Name:  "template",
+				Usage: "<template-file>",
+				Help: `Render a template with values from the file.
+
+The template is a Go text/template (see https://pkg.go.dev/text/template).
+The following functions are available:
+
+  get "table" "key"            the value of key (strings are unquoted)
+  field "table" "key" "path"   the portion of the value selected by path,
+                               as with get --path (strings are unquoted)
+  value "table" "key"          the decoded value of key, for use with
+                               actions such as range and index
+  json v                       the JSON encoding of v
+
+It is an error if a template refers to a table or key that does not exist.
+The output is written to stdout, or with -o to a file with mode 0600.`,
+
+				SetFlags: command.Flags(flax.MustBind, &templateFlags),
+				Init:     requireFile,
+				Run:      command.Adapt(runTemplate),
+			},
+			{
+				Name:  "watch",
+				Usage: "[<table-name> [<key>]]",
+				Help: `Watch the file for changes and print what changed.
+
+The file is checked periodically for changes. When it changes, it is
+re-opened with the same access key and the tables and keys that were
+added, updated, or deleted are printed. If a table or key is given,
+only changes affecting that table or key are printed.`,
+
+				SetFlags: command.Flags(flax.MustBind, &watchFlags),
+				Run:      command.Adapt(runWatch),
+			},
+			{
+				Name:  "qr",
+				Usage: "[<table-name>] <key>",
+				Help: `Display the value of a key as a QR code.
+
+The code is drawn on the terminal with block characters, so that the value
+can be scanned by a phone without writing it to an intermediate file.
+Strings are encoded without quotation; other values are encoded as JSON.
+Use --field to encode only part of a value, as with get --path.
+
+By default the code is drawn for a terminal with light text on a dark
+background; use --invert if your terminal has a light background.
+The table name may be omitted if the selected profile sets a default table.`,
+
+				SetFlags: command.Flags(flax.MustBind, &qrFlags),
+				Init:     withDefaultTable(2),
+				Run:      command.Adapt(runQR),
+			},
+			{
+				Name:  "pick",
+				Usage: "[<table-name>]",
+				Help: `Choose a key interactively and print, copy, or edit its value.
+
+The keys of all tables, or of the given table, are listed on the terminal.
+Type to narrow the list by fuzzy matching: a key matches if the characters
+typed occur in its name in order, not necessarily adjacent. Use the arrow
+keys (or Ctrl-P and Ctrl-N) to move the selection, Enter to choose, and
+Esc or Ctrl-C to cancel.
+
+By default the chosen value is printed, as with get. With --clip it is
+copied to the system clipboard instead (using pbcopy, wl-copy, xclip, xsel,
+or clip.exe). With --edit it is opened as JSON in $VISUAL or $EDITOR, and
+the edited value is saved if it changed.`,
+
+				SetFlags: command.Flags(flax.MustBind, &pickFlags),
+				Init:     requireFileIf(func() bool { return pickFlags.Edit }),
+				Run:      command.Adapt(runPick),
+			},
+			{
+				Name:  "tui",
+				Usage: "[<table-name>]",
+				Help: `Browse and edit the file in a full-screen terminal interface.
+
+The screen shows the tables of the file, the keys of the selected table,
+and the selected value. If a table name is given, it is selected first.
+Values are hidden until revealed. The following keys are understood:
+
+  ↑ ↓ (j k)    move the selection in the focused pane
+  Tab ← →      switch between the table and key panes
+  Enter        select a table, or show or hide the selected value
+  /            search the focused pane by fuzzy matching, as with pick
+  Esc          clear the searches
+  v            show or hide the selected value
+  h            show or hide the history of the selected key
+  c            copy the selected value to the clipboard
+  e            edit the selected value in $VISUAL or $EDITOR, and save
+  q (Ctrl-C)   quit
+
+The file is saved after each edit. The file is not locked while browsing,
+and if another process changes it, it is reloaded. An edit is not saved if
+the value was changed by another process while it was being edited.`,
+
+				Run: command.Adapt(runTUI),
+			},
+			command.HelpCommand([]command.HelpTopic{
+				{Name: "exit-codes", Help: exitCodesHelp},
+				{Name: "porcelain", Help: porcelainHelp},
+			}),
 			command.VersionCommand(),
 		},
 	}
-	command.RunOrFail(root.NewEnv(nil), os.Args[1:])
+	runMain(root.NewEnv(nil), os.Args[1:])
 }
 
+// getAccessKey returns the access key for the LEAF file at path, locked into
+// memory if possible.
 func getAccessKey(path string, confirm bool) ([]byte, error) {
+	accessKey, err := findAccessKey(path, confirm)
+	if err == nil {
+		lockMemory(accessKey)
+	}
+	return accessKey, err
+}
+
+// findAccessKey obtains the access key for path from the first source that
+// has it: a key file, stdin, an age identity, ssh-agent, the key agent, the
+// keyring, a hardware device, or else a passphrase prompt. If confirm is
+// true, only the key file and stdin are consulted before prompting.
+//
+// The key slots of the file are read once, and only the methods that can
+// unlock one of them are tried: a device is used only if the file has a slot
+// for it, and the passphrase is prompted for only if the file has a slot that
+// a passphrase can open.
+func findAccessKey(path string, confirm bool) ([]byte, error) {
 	if settings.AccessKeyFile != "" {
+		warnPermissions(settings.AccessKeyFile)
 		return os.ReadFile(settings.AccessKeyFile)
 	}
-	return promptAccessKey(path, confirm)
+	if settings.KeyStdin {
+		return readStdinKey()
+	}
+	if confirm {
+		return promptAccessKey(filepath.Base(path), true)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	slots, err := leaf.ReadKeySlots(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if settings.AgeIdentity != "" {
+		return ageAccessKey(path, slots, settings.AgeIdentity)
+	}
+	if settings.SSHKey != "" {
+		return sshAccessKey(path, slots, settings.SSHKey)
+	}
+	if key, err := agentAccessKey(path); err == nil {
+		return key, nil
+	}
+	if key, err := keyringAccessKey(path); err == nil {
+		return key, nil
+	}
+	for _, kp := range keyProviders {
+		if key, err := providerAccessKey(slots, kp); err == nil {
+			return key, nil
+		} else if !errors.Is(err, errNoProviderSlots) {
+			fmt.Fprintf(os.Stderr, "Could not unlock the access key with %s: %v\n", kp.device(), err)
+		}
+	}
+	if !slices.ContainsFunc(slots, passphraseSlot) {
+		return nil, fmt.Errorf("no key slot of %q can be opened with a passphrase", path)
+	}
+	return promptFileKey(path, data, slots)
+}
+
+// keySourceSet reports whether a source of the access key is selected by a
+// flag or environment variable, rather than being left to the profile or to
+// the search done by findAccessKey.
+func keySourceSet() bool {
+	return settings.AccessKeyFile != "" || settings.KeyStdin || settings.AgeIdentity != "" || settings.SSHKey != ""
+}
+
+// slotParams are the public parameters recorded in key slots by this tool.
+type slotParams struct {
+	// If set, the access key of the slot is encrypted to an age recipient.
+	Age []byte `json:"age,omitempty"`
+
+	// If set, the access key is derived using a key held in ssh-agent.
+	SSH *sshParams `json:"ssh,omitempty"`
+
+	// If set, the access key of the slot is sealed to the local TPM.
+	TPM *tpmParams `json:"tpm,omitempty"`
+
+	// If set, the access key of the slot is wrapped with a Secure Enclave key.
+	SE *seParams `json:"se,omitempty"`
+
+	// If set, the access key is derived using a FIDO2 security key.
+	FIDO2 *fido2Params `json:"fido2,omitempty"`
+
+	// If set, the access key of the slot is wrapped with a PKCS#11 token key.
+	PKCS11 *pkcs11Params `json:"pkcs11,omitempty"`
+
+	// If set, the access key is derived from a passphrase using this KDF.
+	// Otherwise, a passphrase is converted to a key with HKDF.
+	KDF *kdfParams `json:"kdf,omitempty"`
+}
+
+// parseSlotParams decodes the parameters of ks. Parameters not understood by
+// this tool are ignored.
+func parseSlotParams(ks leaf.KeySlot) slotParams {
+	var p slotParams
+	if ks.Params != nil {
+		json.Unmarshal(ks.Params, &p) // best effort
+	}
+	return p
+}
+
+// hardwareSlot reports whether ks can only be opened with a particular
+// device, such as a TPM or security key.
+func hardwareSlot(ks leaf.KeySlot) bool {
+	p := parseSlotParams(ks)
+	return p.TPM != nil || p.SE != nil || p.FIDO2 != nil || p.PKCS11 != nil
 }
 
-// prompt AccessKey prompts the user for a passphrase and uses it to generate
-// an access key. If confirm == true, the user is required to enter the same
-// passphrase twice to confirm, and an error is reported if they do not match.
-func promptAccessKey(path string, confirm bool) ([]byte, error) {
+// promptPassphrase prompts the user for a passphrase. If label != "", it is
+// included in the prompt to describe what the passphrase is for. If confirm is
+// true, the user must enter the same passphrase twice.
+func promptPassphrase(label string, confirm bool) (string, error) {
+	if confirm && settings.ReadOnly {
+		return "", errReadOnly // a new passphrase is only needed to save
+	}
+	if settings.PassphraseFD >= 0 {
+		return readPassphraseFD()
+	}
+	if pw := os.Getenv("LEAF_PASSPHRASE"); pw != "" {
+		if !warnedEnvPassphrase && term.IsTerminal(int(os.Stdin.Fd())) {
+			fmt.Fprintln(os.Stderr, "warning: using the passphrase from LEAF_PASSPHRASE")
+			warnedEnvPassphrase = true
+		}
+		return pw, nil
+	}
+	if settings.Pinentry != "" {
+		return pinentryPassphrase(settings.Pinentry, label, confirm)
+	}
 	prompt := "Passphrase: "
-	if path != "" {
-		prompt = fmt.Sprintf("Passphrase for %s: ", filepath.Base(path))
+	if label != "" {
+		prompt = fmt.Sprintf("Passphrase for %s: ", label)
 	}
 	pw, err := getpass.Prompt(prompt)
 	if err != nil {
-		return nil, fmt.Errorf("passphrase: %w", err)
+		return "", fmt.Errorf("passphrase: %w", err)
 	}
 	if confirm {
 		cf, err := getpass.Prompt("Confirm: ")
 		if err != nil {
-			return nil, fmt.Errorf("confirmation: %w", err)
+			return "", fmt.Errorf("confirmation: %w", err)
 		} else if cf != pw {
-			return nil, errors.New("passphrases do not match")
+			return "", errors.New("passphrases do not match")
 		}
 	}
+	return pw, nil
+}
+
+var stdinKey []byte // cached by readStdinKey
+
+// readStdinKey reads an access key from the first AccessKeyLen bytes of stdin.
+// The key is read only once; later calls return the same key.
+func readStdinKey() ([]byte, error) {
+	if stdinKey == nil {
+		key := make([]byte, leaf.AccessKeyLen)
+		if _, err := io.ReadFull(os.Stdin, key); err != nil {
+			return nil, fmt.Errorf("read access key from stdin: %w", err)
+		}
+		stdinKey = key
+	}
+	return stdinKey, nil
+}
+
+var passphraseFD *bufio.Reader // opened by readPassphraseFD
+
+var warnedEnvPassphrase bool // whether LEAF_PASSPHRASE has been reported
+
+// readPassphraseFD reads the next line from the file descriptor given by
+// --passphrase-fd, and returns it without its line ending.
+func readPassphraseFD() (string, error) {
+	if passphraseFD == nil {
+		f := os.NewFile(uintptr(settings.PassphraseFD), "passphrase-fd")
+		if f == nil {
+			return "", fmt.Errorf("invalid passphrase file descriptor %d", settings.PassphraseFD)
+		}
+		passphraseFD = bufio.NewReader(f)
+	}
+	line, err := passphraseFD.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	} else if err != nil {
+		return "", fmt.Errorf("read passphrase: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// promptAccessKey prompts the user for a passphrase and uses it to generate
+// an access key. If label != "", it is included in the prompt to describe what
+// the passphrase is for.  If confirm == true, the user is required to enter
+// the same passphrase twice to confirm, and an error is reported if they do
+// not match.
+func promptAccessKey(label string, confirm bool) ([]byte, error) {
+	pw, err := promptPassphrase(label, confirm)
+	if err != nil {
+		return nil, err
+	}
+	return hkdfAccessKey(pw)
+}
 
+// hkdfAccessKey generates an access key from a passphrase using HKDF.
+func hkdfAccessKey(pw string) ([]byte, error) {
 	const kdfSalt = "c2V0ZWMgYXN0cm9ub215"
 	kg := hkdf.New(sha256.New, []byte(pw), []byte(kdfSalt), nil)
 
@@ -220,56 +1733,209 @@ func promptAccessKey(path string, confirm bool) ([]byte, error) {
 	return accessKey, nil
 }
 
+// errCancelled is reported when the user declines to confirm a change.
+var errCancelled = errors.New("cancelled")
+
+// errReadOnly is reported when a command would save the file, or ask to
+// confirm a change, and --read-only is set.
+var errReadOnly = errors.New("the file is read-only (--read-only is set)")
+
+// confirm asks the user to confirm a destructive change, and reports
+// errCancelled if they do not answer "y" or "yes". The user is asked only if
+// stdin is a terminal and --force is not set; otherwise confirm returns nil.
+func confirm(env *command.Env, question string, args ...any) error {
+	if settings.ReadOnly {
+		return errReadOnly
+	} else if settings.Force || !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	fmt.Fprintf(env, question+" [y/N] ", args...)
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return nil
+	}
+	return errCancelled
+}
+
 func saveFile(f *leaf.File) error {
-	if settings.FilePath == "" {
+	if batchFile != nil && f == batchFile {
+		batchDirty = true // saved when the batch is complete
+		return nil
+	} else if settings.FilePath == "" {
 		return errors.New("no file path is defined")
 	}
-	return atomicfile.Tx(settings.FilePath, 0600, func(af *atomicfile.File) error {
+	return saveFileAs(settings.FilePath, f)
+}
+
+// saveFileAs writes f to the specified path.
+func saveFileAs(path string, f *leaf.File) error {
+	if samePath(path, settings.FilePath) {
+		if settings.ReadOnly {
+			return errReadOnly
+		} else if err := saveBackup(path); err != nil {
+			return err
+		}
+	}
+	err := atomicfile.Tx(path, 0600, func(af *atomicfile.File) error {
 		_, err := f.WriteTo(af)
 		return err
 	})
+	if err == nil && settings.Git {
+		err = gitCommitFile(path)
+	}
+	return err
 }
 
-func openFile(create bool) (*leaf.File, error) {
-	if settings.FilePath == "" {
-		return nil, errors.New("no file path is defined")
-	}
-	f, err := os.Open(settings.FilePath)
-	if errors.Is(err, fs.ErrNotExist) && create {
-		accessKey, err := getAccessKey(settings.FilePath, true)
-		if err != nil {
-			return nil, err
-		}
-		lf, err := leaf.New(accessKey)
-		if err != nil {
-			return nil, err
+// samePath reports whether paths a and b refer to the same file.
+func samePath(a, b string) bool {
+	if fa, err := os.Stat(a); err == nil {
+		if fb, err := os.Stat(b); err == nil {
+			return os.SameFile(fa, fb)
 		}
-		if err := saveFile(lf); err != nil {
-			return nil, err
+	}
+	aa, erra := filepath.Abs(a)
+	ab, errb := filepath.Abs(b)
+	return erra == nil && errb == nil && aa == ab
+}
+
+func openFile() (*leaf.File, error) {
+	lf, _, err := openFileKey()
+	return lf, err
+}
+
+// openFileKey is as openFile, but also returns the access key for the file.
+// The key is nil if the file is the file of an active batch.
+func openFileKey() (*leaf.File, []byte, error) {
+	if batchFile != nil {
+		return batchFile, nil, nil
+	} else if settings.FilePath == "" {
+		return nil, nil, errors.New("no file path is defined")
+	}
+	if _, err := os.Stat(settings.FilePath); err != nil {
+		return nil, nil, err
+	}
+	warnPermissions(settings.FilePath)
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if writeIntent {
+		if err := lockFile(settings.FilePath); err != nil {
+			return nil, nil, err
 		}
-		return lf, nil
-	} else if err != nil {
+	}
+	lf, err := openWithKey(settings.FilePath, accessKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	agentRemember(settings.FilePath, accessKey)
+	return lf, accessKey, nil
+}
+
+// newFile constructs a new empty LEAF file for the given path, with an access
+// key obtained from the user. The file is not saved.
+func newFile(path string) (*leaf.File, error) {
+	accessKey, params, err := newAccessKey(path)
+	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	accessKey, err := getAccessKey(settings.FilePath, false)
+	lf, err := leaf.New(accessKey)
+	if err != nil {
+		return nil, err
+	}
+	fileDB(lf).SetNote(settings.Note)
+	return lf, setDefaultSlotParams(lf, accessKey, params)
+}
+
+// newAccessKey obtains an access key for a new LEAF file at path, from the
+// access key file or stdin if one is given, or else from a new passphrase.
+// If params != nil, they must be recorded in the key slot for the key.
+func newAccessKey(path string) (accessKey []byte, params json.RawMessage, err error) {
+	if settings.AccessKeyFile != "" || settings.KeyStdin {
+		accessKey, err = getAccessKey(path, true)
+		return accessKey, nil, err
+	}
+	return newPassphraseKey(filepath.Base(path))
+}
+
+// setDefaultSlotParams records params in the default key slot of lf, whose
+// access key is accessKey. If params == nil, it does nothing.
+func setDefaultSlotParams(lf *leaf.File, accessKey []byte, params json.RawMessage) error {
+	if params == nil {
+		return nil
+	}
+	return lf.ReplaceKeySlot(leaf.KeySlot{Name: leaf.DefaultKeySlot, Params: params}, accessKey)
+}
+
+// openWithKey opens the LEAF file at path using the given access key.
+func openWithKey(path string, accessKey []byte) (*leaf.File, error) {
+	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	return leaf.Open(accessKey, f)
+	defer f.Close()
+	lf, err := leaf.Open(accessKey, f)
+	if err != nil {
+		return nil, openError(err)
+	}
+	fileDB(lf).SetNote(settings.Note)
+	return lf, nil
 }
 
 func writePrettyJSON(v any) error {
 	enc := json.NewEncoder(os.Stdout)
-	enc.SetIndent("", "  ")
+	if !settings.JSON {
+		enc.SetIndent("", "  ")
+	}
 	return enc.Encode(v)
 }
 
+// fileDB returns the database of f selected by --db, or its default database
+// if none is selected.
+func fileDB(f *leaf.File) *leaf.Database { return f.NamedDatabase(settings.Database) }
+
 func requireFile(env *command.Env) error {
-	f, err := openFile(false)
+	f, err := openFile()
 	if err != nil {
+		initErr = err
 		return err
 	}
 	env.Config = f
 	return nil
 }
+
+// writeIntent records whether the command may save changes to the file, in
+// which case openFile locks the file (see lockFile). A command that only
+// reads the file does not lock it, so that it does not wait for writers.
+var writeIntent bool
+
+// requireFileRW is as requireFile, for a command that changes the file. If
+// --read-only is set, it fails before asking for an access key or opening the
+// file. Otherwise the file is locked while the command runs.
+func requireFileRW(env *command.Env) error {
+	if settings.ReadOnly {
+		initErr = errReadOnly
+		return errReadOnly
+	}
+	writeIntent = true
+	return requireFile(env)
+}
+
+// requireFileIf returns an Init function for a command whose flags determine
+// whether it changes the file. It is requireFileRW if write reports true once
+// the flags are parsed, and requireFile otherwise.
+func requireFileIf(write func() bool) func(*command.Env) error {
+	return func(env *command.Env) error {
+		if write() {
+			return requireFileRW(env)
+		}
+		return requireFile(env)
+	}
+}
+
+// notDryRun reports whether --dry-run is not set, for requireFileIf.
+func notDryRun() bool { return !dryRunFlags.DryRun }
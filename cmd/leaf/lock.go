@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// heldLocks are the lock files held by this process, by path. The locks are
+// released when the process exits.
+var heldLocks = make(map[string]*os.File)
+
+// lockFile acquires an exclusive lock on the sidecar lock file for the LEAF
+// file at path, which is path with ".lock" appended, waiting if another
+// process holds it. The lock is held until the process exits, so that
+// concurrent invocations that read and then write the file do not overwrite
+// each other's changes. Locking a file that is already locked by this
+// process has no effect.
+//
+// In read-only mode no lock is taken, since the file will not be saved.
+// On platforms other than Unix and Windows, locking has no effect.
+func lockFile(path string) error {
+	if settings.ReadOnly {
+		return nil
+	}
+	lpath, err := filepath.Abs(path + ".lock")
+	if err != nil {
+		return err
+	} else if heldLocks[lpath] != nil {
+		return nil
+	}
+	f, err := os.OpenFile(lpath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("lock %s: %w", lpath, err)
+	}
+	ok, err := tryLock(f)
+	if err == nil && !ok {
+		infof(os.Stderr, "Waiting for another process to release %s...\n", lpath)
+		err = waitLock(f)
+	}
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("lock %s: %w", lpath, err)
+	}
+	heldLocks[lpath] = f
+	return nil
+}
+
+// unlockFile releases the lock on the LEAF file at path, if this process
+// holds it. It is used by long-running commands that should not keep other
+// processes from updating the file between their own writes.
+func unlockFile(path string) {
+	lpath, err := filepath.Abs(path + ".lock")
+	if err != nil {
+		return
+	} else if f := heldLocks[lpath]; f != nil {
+		f.Close()
+		delete(heldLocks, lpath)
+	}
+}
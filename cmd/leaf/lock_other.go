@@ -0,0 +1,11 @@
+//go:build !unix && !windows
+
+package main
+
+import "os"
+
+// tryLock is a no-op on this platform.
+func tryLock(f *os.File) (bool, error) { return true, nil }
+
+// waitLock is a no-op on this platform.
+func waitLock(f *os.File) error { return nil }
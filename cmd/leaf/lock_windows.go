@@ -0,0 +1,28 @@
+package main
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// tryLock attempts to acquire an exclusive lock on f without waiting, and
+// reports whether it succeeded.
+func tryLock(f *os.File) (bool, error) {
+	err := lockFileEx(f, windows.LOCKFILE_EXCLUSIVE_LOCK|windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// waitLock acquires an exclusive lock on f, waiting until it is available.
+func waitLock(f *os.File) error { return lockFileEx(f, windows.LOCKFILE_EXCLUSIVE_LOCK) }
+
+// lockFileEx locks the first byte of f with the given flags. The lock is
+// released when f is closed.
+func lockFileEx(f *os.File, flags uint32) error {
+	var ol windows.Overlapped
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, &ol)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var logFlags struct {
+	Table  string `flag:"table,Show only entries affecting this table"`
+	Key    string `flag:"key,Show only entries affecting this key"`
+	Op     string `flag:"op,Show only entries with this operation"`
+	Since  string `flag:"since,Show only entries at or after this time"`
+	Until  string `flag:"until,Show only entries before this time"`
+	Last   int    `flag:"n,Show only the last n matching entries"`
+	Values bool   `flag:"values,Include stored values in the output (UNSAFE)"`
+}
+
+func runLog(env *command.Env) error {
+	var since, until time.Time
+	if logFlags.Since != "" {
+		t, err := parseLogTime(logFlags.Since)
+		if err != nil {
+			return env.Usagef("invalid --since: %v", err)
+		}
+		since = t
+	}
+	if logFlags.Until != "" {
+		t, err := parseLogTime(logFlags.Until)
+		if err != nil {
+			return env.Usagef("invalid --until: %v", err)
+		}
+		until = t
+	}
+	if logFlags.Last < 0 {
+		return env.Usagef("invalid -n: %d", logFlags.Last)
+	}
+
+	f := env.Config.(*leaf.File)
+	var match []leaf.LogEntry
+	for _, e := range fileDB(f).Log() {
+		switch {
+		case logFlags.Table != "" && !entryHasTable(e, logFlags.Table),
+			logFlags.Key != "" && (!keyOp(e.Op) || e.Key != logFlags.Key),
+			logFlags.Op != "" && e.Op != logFlags.Op,
+			!since.IsZero() && e.Time.Before(since),
+			!until.IsZero() && !e.Time.Before(until):
+			continue
+		}
+		match = append(match, e)
+	}
+	if logFlags.Last > 0 && len(match) > logFlags.Last {
+		match = match[len(match)-logFlags.Last:]
+	}
+
+	out := make([]object, len(match))
+	for i, e := range match {
+		obj := object{"op": e.Op, "time": e.Time.Format(time.RFC3339Nano)}
+		if e.Table != "" {
+			obj["table"] = e.Table
+		}
+		if e.Key != "" {
+			obj["key"] = e.Key
+		}
+		if logFlags.Values && e.Value != nil {
+			obj["value"] = e.Value
+		}
+		if e.Note != "" {
+			obj["note"] = e.Note
+		}
+		out[i] = obj
+	}
+	return printResult(out, func() {
+		var rows [][]cell
+		for _, e := range match {
+			row := []cell{{e.Time.Format(time.DateTime), dim}, {e.Op, opStyle(e.Op)}, {"", blue}, {"", plain}}
+			if e.Table != "" {
+				row[2].Text = fmt.Sprintf("%q", e.Table)
+			}
+			if e.Key != "" {
+				row[3].Text = fmt.Sprintf("%q", e.Key)
+			}
+			if logFlags.Values && e.Value != nil {
+				row = append(row, cell{string(e.Value), plain})
+			}
+			if e.Note != "" {
+				row = append(row, cell{"# " + e.Note, dim})
+			}
+			rows = append(rows, row)
+		}
+		writeColumns(os.Stdout, newPainter(os.Stdout), nil, rows)
+	})
+}
+
+// keyOp reports whether op is an operation on a single key.
+func keyOp(op string) bool { return op == "update" || op == "delete" }
+
+// entryHasTable reports whether e affects the named table. A rename affects
+// both the old and the new table.
+func entryHasTable(e leaf.LogEntry, table string) bool {
+	switch e.Op {
+	case "tag":
+		return false
+	case "rename-table":
+		return e.Table == table || e.Key == table
+	case "snapshot":
+		return true // a snapshot replaces all tables
+	}
+	return e.Table == table
+}
+
+// keyTimes returns the time at which each key in the named table was last
+// modified, according to the log of db.
+func keyTimes(db *leaf.Database, table string) map[string]time.Time {
+	tabs := make(map[string]map[string]time.Time)
+	for _, e := range db.Log() {
+		switch e.Op {
+		case "update", "delete":
+			if tabs[e.Table] == nil {
+				tabs[e.Table] = make(map[string]time.Time)
+			}
+			tabs[e.Table][e.Key] = e.Time
+		case "delete-table", "clear-table":
+			delete(tabs, e.Table)
+		case "rename-table":
+			tabs[e.Key] = tabs[e.Table]
+			delete(tabs, e.Table)
+		case "snapshot":
+			var snap map[string]map[string]json.RawMessage
+			json.Unmarshal(e.Value, &snap) // best effort
+			clear(tabs)
+			for name, vals := range snap {
+				tabs[name] = make(map[string]time.Time)
+				for key := range vals {
+					tabs[name][key] = e.Time
+				}
+			}
+		}
+	}
+	return tabs[table]
+}
+
+// parseLogTime parses s as a timestamp (see parseTimestamp), or as a duration
+// before the current time, such as "36h".
+func parseLogTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return parseTimestamp(s)
+}
@@ -0,0 +1,6 @@
+//go:build !unix && !windows
+
+package main
+
+// lockMemory is a no-op on this platform.
+func lockMemory(b []byte) {}
@@ -0,0 +1,15 @@
+//go:build unix
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// lockMemory locks b into memory if possible, so that it is not written to
+// swap. Locking is best effort: failure, for example because RLIMIT_MEMLOCK
+// is exhausted, is ignored. The lock is not released, since the pages of b may
+// be shared with other locked buffers.
+func lockMemory(b []byte) {
+	if len(b) != 0 {
+		unix.Mlock(b)
+	}
+}
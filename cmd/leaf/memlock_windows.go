@@ -0,0 +1,16 @@
+package main
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockMemory locks b into memory if possible, so that it is not written to
+// the page file. Locking is best effort, and failure is ignored. The lock is
+// not released, since the pages of b may be shared with other locked buffers.
+func lockMemory(b []byte) {
+	if len(b) != 0 {
+		windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(len(b)))
+	}
+}
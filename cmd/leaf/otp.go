@@ -0,0 +1,246 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// otpKey is the structured value stored for a one-time password generator.
+type otpKey struct {
+	Type      string `json:"type"` // "totp" or "hotp"
+	Issuer    string `json:"issuer,omitempty"`
+	Account   string `json:"account,omitempty"`
+	Secret    string `json:"secret"`            // base32, as in the URI
+	Algorithm string `json:"algorithm"`         // SHA1, SHA256, or SHA512
+	Digits    int    `json:"digits"`            // code length
+	Period    int    `json:"period,omitempty"`  // seconds, for TOTP
+	Counter   uint64 `json:"counter,omitempty"` // next counter, for HOTP
+}
+
+// parseOTPAuth parses an otpauth:// URI as used by authenticator apps.
+func parseOTPAuth(s string) (*otpKey, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	} else if u.Scheme != "otpauth" {
+		return nil, fmt.Errorf("not an otpauth URI: %q", u.Scheme)
+	}
+	q := u.Query()
+	k := &otpKey{
+		Type:      strings.ToLower(u.Host),
+		Secret:    strings.ToUpper(strings.ReplaceAll(q.Get("secret"), " ", "")),
+		Algorithm: strings.ToUpper(q.Get("algorithm")),
+		Digits:    6,
+	}
+	label := strings.TrimPrefix(u.Path, "/")
+	if issuer, account, ok := strings.Cut(label, ":"); ok {
+		k.Issuer, k.Account = strings.TrimSpace(issuer), strings.TrimSpace(account)
+	} else {
+		k.Account = label
+	}
+	if v := q.Get("issuer"); v != "" {
+		k.Issuer = v
+	}
+	if k.Algorithm == "" {
+		k.Algorithm = "SHA1"
+	}
+	if v := q.Get("digits"); v != "" {
+		if k.Digits, err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("invalid digits: %q", v)
+		}
+	}
+	switch k.Type {
+	case "totp":
+		k.Period = 30
+		if v := q.Get("period"); v != "" {
+			if k.Period, err = strconv.Atoi(v); err != nil || k.Period <= 0 {
+				return nil, fmt.Errorf("invalid period: %q", v)
+			}
+		}
+	case "hotp":
+		v := q.Get("counter")
+		if v == "" {
+			return nil, errors.New("missing counter for hotp")
+		} else if k.Counter, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid counter: %q", v)
+		}
+	default:
+		return nil, fmt.Errorf("unknown OTP type %q", k.Type)
+	}
+	if err := k.check(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// check reports an error if the parameters of k are invalid.
+func (k *otpKey) check() error {
+	if _, err := k.key(); err != nil {
+		return err
+	} else if _, err := k.hash(); err != nil {
+		return err
+	} else if k.Digits < 6 || k.Digits > 10 {
+		return fmt.Errorf("invalid digits: %d", k.Digits)
+	} else if k.Type == "totp" && k.Period <= 0 {
+		return fmt.Errorf("invalid period: %d", k.Period)
+	}
+	return nil
+}
+
+func (k *otpKey) key() ([]byte, error) {
+	if k.Secret == "" {
+		return nil, errors.New("missing secret")
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(k.Secret, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %w", err)
+	}
+	return key, nil
+}
+
+func (k *otpKey) hash() (func() hash.Hash, error) {
+	switch k.Algorithm {
+	case "SHA1":
+		return sha1.New, nil
+	case "SHA256":
+		return sha256.New, nil
+	case "SHA512":
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unknown algorithm %q", k.Algorithm)
+}
+
+// code returns the code for the given counter value (RFC 4226).
+func (k *otpKey) code(counter uint64) (string, error) {
+	key, err := k.key()
+	if err != nil {
+		return "", err
+	}
+	h, err := k.hash()
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(h, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+	off := sum[len(sum)-1] & 0x0f
+	v := uint64(binary.BigEndian.Uint32(sum[off:]) & 0x7fffffff)
+	mod := uint64(1)
+	for range k.Digits {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", k.Digits, v%mod), nil
+}
+
+var otpImportFlags struct {
+	QRImage string `flag:"from-qr-image,Read the URI from a QR code in this image file"`
+}
+
+func runOTPImport(env *command.Env, table, key string, rest ...string) error {
+	var uri string
+	switch {
+	case len(rest) > 1:
+		return env.Usagef("extra arguments: %q", rest[1:])
+	case len(rest) == 1 && otpImportFlags.QRImage != "":
+		return env.Usagef("a URI and --from-qr-image are mutually exclusive")
+	case len(rest) == 1:
+		uri = rest[0]
+	case otpImportFlags.QRImage != "":
+		s, err := scanQRImage(otpImportFlags.QRImage)
+		if err != nil {
+			return err
+		}
+		uri = s
+	default:
+		v, err := readValue("")
+		if err != nil {
+			return err
+		}
+		uri = v
+	}
+	k, err := parseOTPAuth(uri)
+	if err != nil {
+		return err
+	}
+	f := env.Config.(*leaf.File)
+	tab := fileDB(f).Table(table)
+	if tab.Get(key, nil) {
+		if err := confirm(env, "Replace the value of %q in table %q?", key, table); err != nil {
+			return err
+		}
+	}
+	tab.Set(key, k)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "imported", "table": table, "key": key, "type": k.Type, "issuer": k.Issuer, "account": k.Account},
+		"imported %s key for %q", k.Type, strings.TrimPrefix(k.Issuer+":"+k.Account, ":"))
+	return nil
+}
+
+// scanQRImage decodes a QR code in the image file at path using the zbarimg
+// tool from the ZBar project.
+func scanQRImage(path string) (string, error) {
+	out, err := runTool(nil, "zbarimg", "--raw", "--quiet", "-Sdisable", "-Sqrcode.enable", path)
+	if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	return line, nil
+}
+
+func runOTPCode(env *command.Env, table, key string) error {
+	f := env.Config.(*leaf.File)
+	tab, ok := fileDB(f).GetTable(table)
+	if !ok {
+		return notFoundf("table %q not found", table)
+	}
+	var val json.RawMessage
+	if !tab.Get(key, &val) {
+		return notFoundf("key %q not found", key)
+	}
+	var k otpKey
+	if err := json.Unmarshal(val, &k); err != nil || k.Type == "" {
+		return fmt.Errorf("key %q is not an OTP key", key)
+	} else if err := k.check(); err != nil {
+		return fmt.Errorf("key %q: %w", key, err)
+	}
+
+	switch k.Type {
+	case "totp":
+		now := time.Now().Unix()
+		code, err := k.code(uint64(now / int64(k.Period)))
+		if err != nil {
+			return err
+		}
+		left := int64(k.Period) - now%int64(k.Period)
+		return printResult(object{"code": code, "remaining": left}, func() { fmt.Println(code) })
+	case "hotp":
+		code, err := k.code(k.Counter)
+		if err != nil {
+			return err
+		}
+		k.Counter++
+		tab.Set(key, k)
+		if err := saveFile(f); err != nil {
+			return err
+		}
+		return printResult(object{"code": code, "counter": k.Counter - 1}, func() { fmt.Println(code) })
+	}
+	return fmt.Errorf("unknown OTP type %q", k.Type)
+}
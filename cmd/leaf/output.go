@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/creachadair/command"
+)
+
+// An object is a JSON object written in JSON mode.
+type object map[string]any
+
+// notify reports a notice about the outcome of a command. In JSON mode, ev is
+// written to stdout with the formatted message added as its "message" field;
+// by convention, its "event" field names what happened. Otherwise, only the
+// message is written to env. Nothing is written if --quiet is set.
+func notify(env *command.Env, ev object, msg string, args ...any) {
+	if settings.Quiet {
+		return
+	}
+	text := fmt.Sprintf(msg, args...)
+	if settings.JSON {
+		ev["message"] = text
+		writeJSONLine(ev)
+		return
+	}
+	fmt.Fprintln(env, text)
+}
+
+// infof writes an informational message to w, unless --quiet is set.
+func infof(w io.Writer, msg string, args ...any) {
+	if !settings.Quiet {
+		fmt.Fprintf(w, msg, args...)
+	}
+}
+
+// printResult writes the result of a command to stdout. In JSON mode, v is
+// written as a single line of JSON. Otherwise, text is called to print the
+// result in human-readable form.
+func printResult(v any, text func()) error {
+	if settings.JSON {
+		return writeJSONLine(v)
+	}
+	text()
+	return nil
+}
+
+// writeJSONLine writes the JSON encoding of v to stdout as a single line.
+func writeJSONLine(v any) error { return json.NewEncoder(os.Stdout).Encode(v) }
+
+// runMain runs the command-line arguments with env, and exits if the command
+// fails. It behaves like command.RunOrFail, except that in JSON mode the error
+// is written to stdout as a JSON object.
+func runMain(env *command.Env, args []string) {
+	err := command.Run(env, args)
+	if err == nil {
+		return
+	} else if errors.Is(err, command.ErrRequestHelp) {
+		os.Exit(exitUsage)
+	}
+	var uerr command.UsageError
+	isUsage := errors.As(err, &uerr)
+	code := exitCode(err)
+	if initErr != nil {
+		code = exitCode(initErr)
+	}
+	if settings.JSON {
+		writeJSONLine(object{"error": err.Error(), "usage": isUsage, "code": code})
+	} else if isUsage {
+		log.Printf("Error: %s", uerr.Message)
+		uerr.Env.Command.HelpInfo(0).WriteUsage(uerr.Env)
+	} else {
+		log.Printf("Error: %v", err)
+	}
+	os.Exit(code)
+}
+
+const porcelainHelp = `Stable output for scripts and editor integrations.
+
+With --porcelain, commands write their output as with --json, and the
+format is guaranteed to remain compatible in later versions of leaf:
+
+  - Each value is written to stdout as a single line of JSON.
+
+  - The result of a command is a JSON value whose fields keep their names
+    and meanings. New fields may be added, so readers should ignore fields
+    they do not recognize.
+
+  - A notice is an object with an "event" field naming what happened and a
+    "message" field with human-readable text. Event names are not changed
+    or removed, but the text of a message may change. With --quiet, no
+    notices are written.
+
+  - An error is an object with an "error" field giving the message, which
+    may change, a "usage" field that is true for a usage error, and a
+    "code" field giving the exit code (see "help exit-codes").
+
+Prompts and warnings are written to stderr, never to stdout.
+
+The output of --json is currently the same, but only --porcelain carries
+this guarantee.`
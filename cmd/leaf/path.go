@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// extractPath returns the portion of the JSON value val selected by path.
+//
+// A path beginning with "/" is interpreted as a JSON Pointer (RFC 6901).
+// Otherwise, the path is a jq-style sequence of selectors, for example:
+//
+//	.name            -- the "name" field of an object
+//	.items[2]        -- element 2 of the "items" array
+//	."odd name".x    -- quoted field names may contain punctuation
+//
+// An empty path or "." selects the whole value.
+func extractPath(val json.RawMessage, path string) (json.RawMessage, error) {
+	var sel []string
+	var err error
+	if strings.HasPrefix(path, "/") {
+		sel, err = parsePointer(path)
+	} else {
+		sel, err = parseDotPath(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	cur := val
+	for i, s := range sel {
+		next, err := selectOne(cur, s)
+		if err != nil {
+			return nil, fmt.Errorf("at %q: %w", strings.Join(sel[:i+1], "/"), err)
+		}
+		cur = next
+	}
+	return cur, nil
+}
+
+// selectOne selects the element of an object or array denoted by s. If the
+// element does not exist, the error is reported with the exit code for
+// something not found.
+func selectOne(val json.RawMessage, s string) (json.RawMessage, error) {
+	switch firstByte(val) {
+	case '{':
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(val, &obj); err != nil {
+			return nil, err
+		}
+		v, ok := obj[s]
+		if !ok {
+			return nil, notFoundf("field not found")
+		}
+		return v, nil
+	case '[':
+		var arr []json.RawMessage
+		if err := json.Unmarshal(val, &arr); err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array index %q", s)
+		} else if n < 0 {
+			n += len(arr)
+		}
+		if n < 0 || n >= len(arr) {
+			return nil, notFoundf("index %q out of range (length %d)", s, len(arr))
+		}
+		return arr[n], nil
+	default:
+		return nil, errors.New("value is not an object or array")
+	}
+}
+
+// parsePointer parses a JSON Pointer into its unescaped reference tokens.
+func parsePointer(path string) ([]string, error) {
+	var out []string
+	for _, tok := range strings.Split(path[1:], "/") {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(tok, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("invalid escape in %q", tok)
+		}
+		out = append(out, strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~"))
+	}
+	return out, nil
+}
+
+// parseDotPath parses a jq-style path into a sequence of selectors.
+func parseDotPath(path string) ([]string, error) {
+	var out []string
+	rest := path
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			if rest == "" || rest[0] == '[' {
+				continue // "." alone, or ".[n]"
+			}
+			if rest[0] == '"' {
+				name, tail, err := cutQuoted(rest)
+				if err != nil {
+					return nil, err
+				}
+				out, rest = append(out, name), tail
+				continue
+			}
+			i := strings.IndexAny(rest, ".[")
+			if i < 0 {
+				i = len(rest)
+			}
+			if i == 0 {
+				return nil, errors.New("empty field name")
+			}
+			out, rest = append(out, rest[:i]), rest[i:]
+
+		case '[':
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, errors.New("missing ']'")
+			}
+			sel := rest[1:end]
+			if strings.HasPrefix(sel, `"`) {
+				name, tail, err := cutQuoted(sel)
+				if err != nil || tail != "" {
+					return nil, fmt.Errorf("invalid selector %q", sel)
+				}
+				sel = name
+			} else if _, err := strconv.Atoi(sel); err != nil {
+				return nil, fmt.Errorf("invalid array index %q", sel)
+			}
+			out, rest = append(out, sel), rest[end+1:]
+
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest[0])
+		}
+	}
+	return out, nil
+}
+
+// cutQuoted parses a JSON string from the front of s, returning the decoded
+// string and the remainder of s following the closing quote.
+func cutQuoted(s string) (string, string, error) {
+	for i := 1; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+		} else if s[i] == '"' {
+			var name string
+			if err := json.Unmarshal([]byte(s[:i+1]), &name); err != nil {
+				return "", "", err
+			}
+			return name, s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// firstByte returns the first non-space byte of data, or 0 if there is none.
+func firstByte(data []byte) byte {
+	for _, b := range data {
+		switch b {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b
+	}
+	return 0
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDotPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+		err  string // if non-empty, a substring of the expected error
+	}{
+		{"", nil, ""},
+		{".", nil, ""},
+		{".name", []string{"name"}, ""},
+		{".a.b.c", []string{"a", "b", "c"}, ""},
+		{".items[2]", []string{"items", "2"}, ""},
+		{".items[-1]", []string{"items", "-1"}, ""},
+		{".[0]", []string{"0"}, ""},
+		{"[0][1]", []string{"0", "1"}, ""},
+		{".a[0].b", []string{"a", "0", "b"}, ""},
+		{`."odd name".x`, []string{"odd name", "x"}, ""},
+		{`."a.b[c]"`, []string{"a.b[c]"}, ""},
+		{`."say \"hi\""`, []string{`say "hi"`}, ""},
+		{`.a["b c"]`, []string{"a", "b c"}, ""},
+		{`.a["0"]`, []string{"a", "0"}, ""},
+
+		{"name", nil, `unexpected 'n'`},
+		{".a..b", nil, "empty field name"},
+		{".a[0", nil, "missing ']'"},
+		{".a[x]", nil, `invalid array index "x"`},
+		{".a[]", nil, `invalid array index ""`},
+		{`.a["b"c]`, nil, "invalid selector"},
+		{`.a["b]`, nil, "invalid selector"},
+		{`."abc`, nil, "unterminated string"},
+		{`."a\qb"`, nil, "escape"},
+		{"]", nil, `unexpected ']'`},
+	}
+	for _, tc := range tests {
+		got, err := parseDotPath(tc.path)
+		if tc.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("parseDotPath(%q): got (%q, %v), want error %q", tc.path, got, err, tc.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseDotPath(%q): unexpected error: %v", tc.path, err)
+		} else if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("parseDotPath(%q) (-got, +want):\n%s", tc.path, diff)
+		}
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		path string
+		want []string
+		err  bool
+	}{
+		{"/", []string{""}, false},
+		{"/a", []string{"a"}, false},
+		{"/a/0/b", []string{"a", "0", "b"}, false},
+		{"/a//b", []string{"a", "", "b"}, false},
+		{"/a~1b", []string{"a/b"}, false},
+		{"/a~0b", []string{"a~b"}, false},
+		{"/~01", []string{"~1"}, false}, // ~0 is unescaped after ~1
+		{"/~10", []string{"/0"}, false},
+		{"/a.b[0]", []string{"a.b[0]"}, false},
+
+		{"/a~", nil, true},
+		{"/a~2", nil, true},
+		{"/ok/~x", nil, true},
+	}
+	for _, tc := range tests {
+		got, err := parsePointer(tc.path)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parsePointer(%q): got %q, want error", tc.path, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parsePointer(%q): unexpected error: %v", tc.path, err)
+		} else if diff := cmp.Diff(got, tc.want); diff != "" {
+			t.Errorf("parsePointer(%q) (-got, +want):\n%s", tc.path, diff)
+		}
+	}
+}
+
+func TestExtractPath(t *testing.T) {
+	const input = `{"a": {"b c": [10, 20, {"d/e": true}]}, "x~y": "z", "": 0}`
+	tests := []struct {
+		path, want string
+		code       int // if non-zero, the expected exit code for an error
+	}{
+		{"", input, 0},
+		{".", input, 0},
+		{`.a["b c"][1]`, "20", 0},
+		{`.a."b c"[-1]`, `{"d/e": true}`, 0},
+		{`.a["b c"][2]."d/e"`, "true", 0},
+		{"/a/b c/0", "10", 0},
+		{"/a/b c/2/d~1e", "true", 0},
+		{"/x~0y", `"z"`, 0},
+		{"/", "0", 0},
+
+		{".nonesuch", "", exitNotFound},
+		{`.a["b c"][3]`, "", exitNotFound},
+		{`.a["b c"][-4]`, "", exitNotFound},
+		{"/a/q", "", exitNotFound},
+		{`.a["0"]`, "", exitNotFound},
+		{`.a["b c"].q`, "", exitFailure}, // not an array index
+		{"/a/b c/x", "", exitFailure},    // not an array index
+		{".x~y.z", "", exitFailure},      // not an object or array
+		{".a[", "", exitFailure},         // invalid path
+	}
+	for _, tc := range tests {
+		got, err := extractPath(json.RawMessage(input), tc.path)
+		if tc.code != 0 {
+			if err == nil {
+				t.Errorf("extractPath(%q): got %s, want error", tc.path, got)
+			} else if c := exitCode(err); c != tc.code {
+				t.Errorf("extractPath(%q): got exit code %d (%v), want %d", tc.path, c, err, tc.code)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("extractPath(%q): unexpected error: %v", tc.path, err)
+		} else if string(got) != tc.want {
+			t.Errorf("extractPath(%q): got %s, want %s", tc.path, got, tc.want)
+		}
+	}
+}
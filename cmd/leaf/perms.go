@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"runtime"
+)
+
+// warnedPerms records the paths for which warnPermissions has printed a
+// warning, so that each is reported only once.
+var warnedPerms = make(map[string]bool)
+
+// warnPermissions prints a warning to stderr if the file at path is
+// accessible to users other than its owner. Like ssh with its key files, the
+// command does not refuse to use the file, but the user should fix it.
+func warnPermissions(path string) {
+	if warnedPerms[path] {
+		return
+	}
+	if mode, ok := insecureMode(path); ok {
+		warnedPerms[path] = true
+		fmt.Fprintf(os.Stderr, "warning: %s is accessible to other users (mode %04o); run \"leaf doctor --fix-perms\" to fix it\n", path, mode)
+	}
+}
+
+// insecureMode returns the permission bits of the file at path, and reports
+// whether they allow users other than its owner to access the file. It
+// reports false if the file cannot be found, or on Windows, where the
+// permission bits do not control access.
+func insecureMode(path string) (fs.FileMode, bool) {
+	if runtime.GOOS == "windows" {
+		return 0, false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return 0, false
+	}
+	mode := fi.Mode().Perm()
+	return mode, mode&0077 != 0
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+	"golang.org/x/term"
+)
+
+var pickFlags struct {
+	Clip bool `flag:"clip,Copy the selected value to the clipboard"`
+	Edit bool `flag:"edit,Edit the selected value with $EDITOR"`
+}
+
+// A pickItem is a candidate for selection.
+type pickItem struct {
+	Table, Key string
+	label      string // as displayed and matched
+}
+
+func runPick(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	} else if pickFlags.Clip && pickFlags.Edit {
+		return env.Usagef("--clip and --edit are mutually exclusive")
+	} else if pickFlags.Edit && settings.ReadOnly {
+		return errReadOnly
+	}
+	f := env.Config.(*leaf.File)
+	db := fileDB(f)
+	tables := db.TableNames()
+	if len(args) == 1 {
+		if _, ok := db.GetTable(args[0]); !ok {
+			return notFoundf("table %q not found", args[0])
+		}
+		tables = args[:1]
+	}
+	var items []pickItem
+	for _, name := range tables {
+		tab, _ := db.GetTable(name)
+		for _, key := range tab.Keys() {
+			label := name + "/" + key
+			if len(args) == 1 {
+				label = key
+			}
+			items = append(items, pickItem{Table: name, Key: key, label: label})
+		}
+	}
+	if len(items) == 0 {
+		return errors.New("no keys to choose from")
+	}
+
+	sel, err := fuzzyPick(items)
+	if err != nil {
+		return err
+	}
+	tab, _ := db.GetTable(sel.Table)
+	var val json.RawMessage
+	tab.Get(sel.Key, &val)
+
+	switch {
+	case pickFlags.Clip:
+		if err := copyToClipboard(rawString(val)); err != nil {
+			return err
+		}
+		notify(env, object{"event": "copied", "table": sel.Table, "key": sel.Key},
+			"copied the value of %q in table %q to the clipboard", sel.Key, sel.Table)
+		return nil
+	case pickFlags.Edit:
+		nval, err := editValue(val)
+		if err != nil {
+			return err
+		} else if equalJSON(val, nval) {
+			notify(env, object{"event": "unchanged", "table": sel.Table, "key": sel.Key}, "no changes")
+			return nil
+		}
+		tab.Set(sel.Key, nval)
+		if err := saveFile(f); err != nil {
+			return err
+		}
+		notify(env, object{"event": "updated", "table": sel.Table, "key": sel.Key},
+			"updated %q in table %q", sel.Key, sel.Table)
+		return nil
+	default:
+		return printResult(object{"table": sel.Table, "key": sel.Key, "value": val}, func() {
+			fmt.Println(rawString(val))
+		})
+	}
+}
+
+// fuzzyPick presents items for interactive selection at the terminal, and
+// returns the item chosen by the user.
+func fuzzyPick(items []pickItem) (pickItem, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return pickItem{}, fmt.Errorf("open terminal: %w", err)
+	}
+	defer tty.Close()
+	fd := int(tty.Fd())
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return pickItem{}, fmt.Errorf("set terminal mode: %w", err)
+	}
+	defer term.Restore(fd, old)
+
+	height := 10
+	if _, h, err := term.GetSize(fd); err == nil && h > 0 {
+		height = min(height, max(h-2, 1))
+	}
+
+	var query []rune
+	var cur int // index of the selected match
+	buf := make([]byte, 64)
+	for {
+		matches := fuzzyFilter(items, string(query))
+		cur = min(cur, max(len(matches)-1, 0))
+		drawPick(tty, string(query), matches, cur, height)
+
+		n, err := tty.Read(buf)
+		if err != nil {
+			clearPick(tty)
+			return pickItem{}, err
+		}
+		for in := buf[:n]; len(in) != 0; {
+			switch {
+			case bytes.HasPrefix(in, []byte("\x1b[A")), bytes.HasPrefix(in, []byte("\x1bOA")):
+				cur = max(cur-1, 0)
+				in = in[3:]
+				continue
+			case bytes.HasPrefix(in, []byte("\x1b[B")), bytes.HasPrefix(in, []byte("\x1bOB")):
+				cur++
+				in = in[3:]
+				continue
+			}
+			c := in[0]
+			in = in[1:]
+			switch c {
+			case '\r', '\n':
+				clearPick(tty)
+				if len(matches) == 0 {
+					return pickItem{}, errors.New("no matching keys")
+				}
+				return matches[cur], nil
+			case 0x1b, 0x03, 0x07: // Esc, Ctrl-C, Ctrl-G
+				clearPick(tty)
+				return pickItem{}, errCancelled
+			case 0x10, 0x0b: // Ctrl-P, Ctrl-K
+				cur = max(cur-1, 0)
+			case 0x0e: // Ctrl-N
+				cur++
+			case 0x7f, 0x08: // Backspace
+				if len(query) != 0 {
+					query = query[:len(query)-1]
+				}
+			case 0x15: // Ctrl-U
+				query = query[:0]
+			default:
+				if c >= 0x20 {
+					// Decode a complete UTF-8 sequence if one is present.
+					r, size := []rune(string(append([]byte{c}, in...)))[0], 1
+					if r != unicode.ReplacementChar {
+						size = len(string(r))
+					}
+					query = append(query, r)
+					in = in[size-1:]
+				}
+			}
+		}
+	}
+}
+
+// drawPick draws the prompt and the visible matches, leaving the cursor at
+// the end of the prompt.
+func drawPick(w io.Writer, query string, matches []pickItem, cur, height int) {
+	var sb strings.Builder
+	sb.WriteString("\r\x1b[J") // clear to the end of the screen
+	start := 0
+	if cur >= height {
+		start = cur - height + 1
+	}
+	end := min(start+height, len(matches))
+	for i := start; i < end; i++ {
+		sb.WriteString("\r\n")
+		if i == cur {
+			fmt.Fprintf(&sb, "\x1b[7m> %s\x1b[0m", matches[i].label)
+		} else {
+			fmt.Fprintf(&sb, "  %s", matches[i].label)
+		}
+	}
+	fmt.Fprintf(&sb, "\r\n  \x1b[2m%d/%d\x1b[0m", len(matches), cap(matches))
+	fmt.Fprintf(&sb, "\x1b[%dA\r> %s", end-start+1, query)
+	io.WriteString(w, sb.String())
+}
+
+// clearPick erases the picker from the terminal.
+func clearPick(w io.Writer) { io.WriteString(w, "\r\x1b[J") }
+
+// fuzzyFilter returns the items whose labels match query, best first. The
+// capacity of the result is the total number of items.
+func fuzzyFilter(items []pickItem, query string) []pickItem {
+	type scored struct {
+		item  pickItem
+		score int
+	}
+	var ms []scored
+	for _, it := range items {
+		if s, ok := fuzzyScore(query, it.label); ok {
+			ms = append(ms, scored{it, s})
+		}
+	}
+	sort.SliceStable(ms, func(i, j int) bool { return ms[i].score > ms[j].score })
+	out := make([]pickItem, len(ms), len(items))
+	for i, m := range ms {
+		out[i] = m.item
+	}
+	return out
+}
+
+// fuzzyScore reports whether the characters of query occur in order in s,
+// ignoring case, and if so returns a score for the match. Higher scores are
+// better: consecutive matches and matches at the start of a word score more,
+// and skipped characters score less.
+func fuzzyScore(query, s string) (int, bool) {
+	q := []rune(strings.ToLower(query))
+	r := []rune(strings.ToLower(s))
+	score, qi, prev := 0, 0, -2
+	for i := 0; i < len(r) && qi < len(q); i++ {
+		if r[i] != q[qi] {
+			continue
+		}
+		switch {
+		case i == prev+1:
+			score += 5
+		case i == 0 || !unicode.IsLetter(r[i-1]) && !unicode.IsDigit(r[i-1]):
+			score += 3
+		default:
+			score -= min(i-prev-1, 3)
+		}
+		prev = i
+		qi++
+	}
+	if qi < len(q) {
+		return 0, false
+	}
+	return score, true
+}
+
+// copyToClipboard copies text to the system clipboard, using the first
+// available clipboard tool for the platform.
+func copyToClipboard(text string) error {
+	var tools [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		tools = [][]string{{"pbcopy"}}
+	case "windows":
+		tools = [][]string{{"clip.exe"}}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			tools = append(tools, []string{"wl-copy"})
+		}
+		tools = append(tools, []string{"xclip", "-selection", "clipboard"}, []string{"xsel", "--clipboard", "--input"})
+	}
+	for _, t := range tools {
+		if _, err := exec.LookPath(t[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(t[0], t[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", t[0], err)
+		}
+		return nil
+	}
+	return errors.New("no clipboard tool found")
+}
+
+// editValue writes val to a private temporary file, runs the user's editor on
+// it, and returns the edited value, which must be valid JSON. The temporary
+// file is removed before returning.
+func editValue(val json.RawMessage) (json.RawMessage, error) {
+	dir, err := os.MkdirTemp("", "leaf-edit-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "value.json")
+
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, val, "", "  "); err != nil {
+		buf.Reset()
+		buf.Write(val)
+	}
+	buf.WriteByte('\n')
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return nil, err
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	// The editor setting may include arguments, so run it via the shell.
+	cmd := exec.Command("/bin/sh", "-c", editor+` "$1"`, "sh", path)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("editor: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimSpace(data)
+	if !json.Valid(data) {
+		return nil, errors.New("edited value is not valid JSON")
+	}
+	return data, nil
+}
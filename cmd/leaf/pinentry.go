@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// pinentryPassphrase prompts for a passphrase using the pinentry program at
+// path, which speaks the Assuan protocol used by GnuPG. If label != "", it is
+// included in the description of the prompt. If confirm is true, the user
+// must enter the same passphrase twice.
+func pinentryPassphrase(path, label string, confirm bool) (string, error) {
+	cmd := exec.Command(path)
+	cmd.Stderr = os.Stderr
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("start pinentry: %w", err)
+	}
+	defer cmd.Wait()
+	defer in.Close()
+
+	pe := &pinentry{w: in, r: bufio.NewReader(out)}
+	if _, err := pe.result(); err != nil { // greeting
+		return "", fmt.Errorf("pinentry: %w", err)
+	}
+	desc := "Enter the passphrase for the LEAF file."
+	if label != "" {
+		desc = fmt.Sprintf("Enter the passphrase for %s.", label)
+	}
+	setup := []string{"SETTITLE leaf", "SETDESC " + pinentryEscape(desc), "SETPROMPT Passphrase:"}
+	if tty := os.Getenv("GPG_TTY"); tty != "" {
+		setup = append(setup, "OPTION ttyname="+tty)
+	}
+	for _, c := range setup {
+		if _, err := pe.call(c); err != nil {
+			return "", fmt.Errorf("pinentry: %w", err)
+		}
+	}
+	pw, err := pe.call("GETPIN")
+	if err != nil {
+		return "", fmt.Errorf("passphrase: %w", err)
+	}
+	if confirm {
+		if _, err := pe.call("SETPROMPT Confirm:"); err != nil {
+			return "", fmt.Errorf("pinentry: %w", err)
+		}
+		cf, err := pe.call("GETPIN")
+		if err != nil {
+			return "", fmt.Errorf("confirmation: %w", err)
+		} else if cf != pw {
+			return "", errors.New("passphrases do not match")
+		}
+	}
+	pe.call("BYE")
+	return pw, nil
+}
+
+// pinentry is a client connection to a pinentry program.
+type pinentry struct {
+	w io.Writer
+	r *bufio.Reader
+}
+
+// call sends a command and returns the data of its response.
+func (p *pinentry) call(cmd string) (string, error) {
+	if _, err := fmt.Fprintf(p.w, "%s\n", cmd); err != nil {
+		return "", err
+	}
+	return p.result()
+}
+
+// result reads a response, up to and including its final OK or ERR line, and
+// returns the data lines of the response.
+func (p *pinentry) result() (string, error) {
+	var data strings.Builder
+	for {
+		line, err := p.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			_, msg, _ := strings.Cut(line[4:], " ")
+			return "", errors.New(msg)
+		case strings.HasPrefix(line, "D "):
+			s, err := url.PathUnescape(line[2:])
+			if err != nil {
+				return "", fmt.Errorf("invalid data from pinentry: %w", err)
+			}
+			data.WriteString(s)
+		}
+		// Ignore comments and status lines.
+	}
+}
+
+// pinentryEscape escapes s for use as an argument to a pinentry command.
+func pinentryEscape(s string) string {
+	return strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D").Replace(s)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/leaf"
+)
+
+// pkcs11Params are the key slot parameters for an access key wrapped with an
+// RSA key held in a PKCS#11 token, such as an HSM or smart card. The access
+// key is encrypted with RSA-OAEP to the public key, and decrypted by the
+// token, so the private key never leaves it.
+type pkcs11Params struct {
+	Module string `json:"module"` // path of the PKCS#11 module
+	ID     string `json:"id"`     // object ID of the key pair, in hex
+	Key    []byte `json:"key"`    // access key encrypted to the public key
+}
+
+// pkcs11Module returns the module path to use for a key slot. The module
+// named by LEAF_PKCS11_MODULE takes precedence, since the module recorded
+// in the slot may be installed elsewhere on this machine.
+func pkcs11Module(recorded string) (string, error) {
+	if m := os.Getenv("LEAF_PKCS11_MODULE"); m != "" {
+		return m, nil
+	} else if recorded == "" {
+		return "", errors.New("no PKCS#11 module is specified (set LEAF_PKCS11_MODULE)")
+	}
+	return recorded, nil
+}
+
+// pkcs11NewKey generates a random access key and wraps it with the RSA key
+// pair with the given ID on the token, using the PKCS#11 module at the given
+// path. The key is unwrapped once to check that the token can decrypt it.
+func pkcs11NewKey(module, id string) ([]byte, *pkcs11Params, error) {
+	if module == "" {
+		return nil, nil, errors.New("no PKCS#11 module is specified (set --pkcs11-module)")
+	}
+	der, err := runTool(nil, "pkcs11-tool", "--module", module, "--read-object", "--type", "pubkey", "--id", id)
+	if err != nil {
+		return nil, nil, err
+	}
+	pub, err := parseRSAPublicKey(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("public key %s: %w", id, err)
+	}
+
+	accessKey := make([]byte, leaf.AccessKeyLen)
+	if _, err := cryptorand.Read(accessKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), cryptorand.Reader, pub, accessKey, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	p := &pkcs11Params{Module: module, ID: id, Key: wrapped}
+	if key, err := pkcs11Unwrap(module, p); err != nil {
+		return nil, nil, fmt.Errorf("checking key: %w", err)
+	} else if !bytes.Equal(key, accessKey) {
+		return nil, nil, errors.New("checking key: the token did not decrypt the access key correctly")
+	}
+	return accessKey, p, nil
+}
+
+// parseRSAPublicKey parses a DER-encoded RSA public key, in either PKIX or
+// PKCS#1 form.
+func parseRSAPublicKey(der []byte) (*rsa.PublicKey, error) {
+	if pub, err := x509.ParsePKIXPublicKey(der); err == nil {
+		if rp, ok := pub.(*rsa.PublicKey); ok {
+			return rp, nil
+		}
+		return nil, fmt.Errorf("unsupported key type %T (want RSA)", pub)
+	}
+	return x509.ParsePKCS1PublicKey(der)
+}
+
+// pkcs11Unwrap decrypts the access key in p with the token, using the PKCS#11
+// module at the given path. The user is prompted for the token PIN.
+//
+// The decrypted key is written by pkcs11-tool to a pipe, so that it is never
+// written to disk, and cannot be confused with the messages that some
+// versions print to stdout. Only the wrapped key, which is not secret, is
+// passed in a temporary file, since stdin is used for the PIN.
+func pkcs11Unwrap(module string, p *pkcs11Params) ([]byte, error) {
+	pin, err := promptPassphrase(fmt.Sprintf("PIN for PKCS#11 key %s", p.ID), false)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "leaf-pkcs11")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in := filepath.Join(dir, "in")
+	if err := os.WriteFile(in, p.Key, 0600); err != nil {
+		return nil, err
+	}
+	// The PIN is sent on stdin rather than with --pin, so that it does not
+	// appear in the process listing.
+	out, err := runToolPipe(strings.NewReader(pin+"\n"), "pkcs11-tool", "--module", module, "--login", "--decrypt",
+		"--mechanism", "RSA-PKCS-OAEP", "--hash-algorithm", "SHA256", "--mgf", "MGF1-SHA256",
+		"--id", p.ID, "--input-file", in, "--output-file", pipeFile)
+	if err != nil {
+		return nil, err
+	} else if len(out) != leaf.AccessKeyLen {
+		clear(out)
+		return nil, fmt.Errorf("decrypted key has length %d, want %d", len(out), leaf.AccessKeyLen)
+	}
+	return out, nil
+}
+
+// pkcs11Provider is a keyProvider that unwraps the access keys of PKCS#11 key
+// slots with the token.
+type pkcs11Provider struct{}
+
+func (pkcs11Provider) device() string        { return "the PKCS#11 token" }
+func (pkcs11Provider) has(p slotParams) bool { return p.PKCS11 != nil }
+
+func (pkcs11Provider) unlock(p slotParams) ([]byte, error) {
+	module, err := pkcs11Module(p.PKCS11.Module)
+	if err != nil {
+		return nil, err
+	}
+	return pkcs11Unwrap(module, p.PKCS11)
+}
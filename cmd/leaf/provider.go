@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/creachadair/leaf"
+)
+
+// A keyProvider unlocks the key slots of one kind whose access keys are held
+// by a device, such as a TPM, a security key, or a PKCS#11 token, rather than
+// derived from a passphrase or read from a key file.
+type keyProvider interface {
+	// device describes the device in messages, for example "the TPM".
+	device() string
+
+	// has reports whether a slot with parameters p is of the kind the
+	// provider unlocks, and the provider is supported on this platform.
+	has(p slotParams) bool
+
+	// unlock returns the access key of a slot with parameters p, for which
+	// has reported true.
+	unlock(p slotParams) ([]byte, error)
+}
+
+// keyProviders are the providers consulted for an access key, in order.
+var keyProviders = []keyProvider{tpmProvider{}, seProvider{}, fido2Provider{}, pkcs11Provider{}}
+
+var errNoProviderSlots = errors.New("no key slots for the device")
+
+// providerAccessKey returns the access key of a file with the given key
+// slots, by using kp to unlock the first of them that it can. It reports
+// errNoProviderSlots if none of the slots are of the kind kp unlocks.
+func providerAccessKey(slots []leaf.KeySlot, kp keyProvider) ([]byte, error) {
+	lastErr := errNoProviderSlots
+	for _, ks := range slots {
+		p := parseSlotParams(ks)
+		if !kp.has(p) {
+			continue
+		}
+		key, err := kp.unlock(p)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = fmt.Errorf("key slot %q: %w", ks.Name, err)
+	}
+	return nil, lastErr
+}
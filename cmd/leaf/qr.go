@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+	"github.com/skip2/go-qrcode"
+)
+
+var qrFlags struct {
+	Field  string `flag:"field,Encode only the portion of the value selected by this path"`
+	Invert bool   `flag:"invert,Invert the colours (for dark text on a light background)"`
+}
+
+func runQR(env *command.Env, table, key string) error {
+	f := env.Config.(*leaf.File)
+	tab, ok := fileDB(f).GetTable(table)
+	if !ok {
+		return notFoundf("table %q not found", table)
+	}
+	var val json.RawMessage
+	if !tab.Get(key, &val) {
+		return notFoundf("key %q not found in table %q", key, table)
+	}
+	if qrFlags.Field != "" {
+		sub, err := extractPath(val, qrFlags.Field)
+		if err != nil {
+			return err
+		}
+		val = sub
+	}
+	code, err := qrcode.New(rawString(val), qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("encode QR code: %w", err)
+	}
+	text := renderQR(code.Bitmap(), qrFlags.Invert)
+	return printResult(text, func() { fmt.Print(text) })
+}
+
+// renderQR renders a QR code bitmap as text, using half-block characters so
+// that each line of output covers two rows of modules. The bitmap includes
+// the quiet zone around the code.
+//
+// By default, dark modules are drawn as blank space and light modules as
+// blocks, which suits light text on a dark background. If invert is true,
+// dark modules are drawn as blocks instead.
+func renderQR(bits [][]bool, invert bool) string {
+	// Index by [top][bottom], where true means "draw a block".
+	glyphs := [2][2]string{{" ", "▄"}, {"▀", "█"}}
+	block := func(y, x int) bool {
+		if y >= len(bits) {
+			return !invert // pad an odd final row with the quiet zone colour
+		}
+		return bits[y][x] == invert
+	}
+	var sb strings.Builder
+	for y := 0; y < len(bits); y += 2 {
+		for x := range bits[y] {
+			sb.WriteString(glyphs[b2i(block(y, x))][b2i(block(y+1, x))])
+		}
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+func b2i(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/creachadair/leaf"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// sshParams are the key slot parameters for an access key derived from a
+// signature by a key held in ssh-agent.
+type sshParams struct {
+	Key  string `json:"key"`  // SHA256 fingerprint of the public key
+	Salt []byte `json:"salt"` // random challenge salt
+}
+
+const sshChallengeLabel = "leaf ssh access key\x00"
+
+// sshAgent connects to the ssh-agent named by $SSH_AUTH_SOCK.
+func sshAgent() (agent.ExtendedAgent, func(), error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, errors.New("SSH_AUTH_SOCK is not set")
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), func() { conn.Close() }, nil
+}
+
+// findAgentKeys returns the keys held by ag that match sel. A key matches if
+// sel is its SHA256 fingerprint, its comment, or the path of a file containing
+// its public key in authorized_keys format.
+func findAgentKeys(ag agent.Agent, sel string) ([]*agent.Key, error) {
+	keys, err := ag.List()
+	if err != nil {
+		return nil, fmt.Errorf("list ssh-agent keys: %w", err)
+	}
+	var filePub []byte
+	if data, err := os.ReadFile(sel); err == nil {
+		pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse public key: %w", err)
+		}
+		filePub = pub.Marshal()
+	}
+	var out []*agent.Key
+	for _, k := range keys {
+		if sel == ssh.FingerprintSHA256(k) || sel == k.Comment || bytes.Equal(filePub, k.Marshal()) {
+			out = append(out, k)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("no ssh-agent key matches %q", sel)
+	}
+	return out, nil
+}
+
+// sshDeriveKey derives an access key from the signature of the challenge
+// described by p, by the agent key k.
+//
+// Only key types with deterministic signatures (Ed25519 and RSA) are
+// supported, since the same signature must be produced each time.
+func sshDeriveKey(ag agent.ExtendedAgent, k *agent.Key, p *sshParams) ([]byte, error) {
+	var flags agent.SignatureFlags
+	switch k.Type() {
+	case ssh.KeyAlgoED25519:
+	case ssh.KeyAlgoRSA:
+		flags = agent.SignatureFlagRsaSha256
+	default:
+		return nil, fmt.Errorf("unsupported ssh key type %q (want Ed25519 or RSA)", k.Type())
+	}
+	sig, err := ag.SignWithFlags(k, append([]byte(sshChallengeLabel), p.Salt...), flags)
+	if err != nil {
+		return nil, fmt.Errorf("sign challenge: %w", err)
+	}
+	kg := hkdf.New(sha256.New, sig.Blob, p.Salt, []byte(sshChallengeLabel))
+	accessKey := make([]byte, leaf.AccessKeyLen)
+	if _, err := kg.Read(accessKey); err != nil {
+		return nil, fmt.Errorf("access key: %w", err)
+	}
+	return accessKey, nil
+}
+
+// sshNewKey derives a new access key using the ssh-agent key selected by sel,
+// and returns the key with the slot parameters needed to derive it again.
+func sshNewKey(sel string) ([]byte, *sshParams, error) {
+	ag, done, err := sshAgent()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer done()
+	keys, err := findAgentKeys(ag, sel)
+	if err != nil {
+		return nil, nil, err
+	} else if len(keys) > 1 {
+		return nil, nil, fmt.Errorf("%d ssh-agent keys match %q", len(keys), sel)
+	}
+	p := &sshParams{Key: ssh.FingerprintSHA256(keys[0]), Salt: make([]byte, 32)}
+	if _, err := cryptorand.Read(p.Salt); err != nil {
+		return nil, nil, err
+	}
+	accessKey, err := sshDeriveKey(ag, keys[0], p)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessKey, p, nil
+}
+
+// sshAccessKey returns the access key for the LEAF file at path, whose key
+// slots are slots, derived from one of its ssh key slots using an ssh-agent
+// key selected by sel.
+func sshAccessKey(path string, slots []leaf.KeySlot, sel string) ([]byte, error) {
+	ag, done, err := sshAgent()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	keys, err := findAgentKeys(ag, sel)
+	if err != nil {
+		return nil, err
+	}
+	for _, ks := range slots {
+		p := parseSlotParams(ks)
+		if p.SSH == nil {
+			continue
+		}
+		for _, k := range keys {
+			if ssh.FingerprintSHA256(k) == p.SSH.Key {
+				return sshDeriveKey(ag, k, p.SSH)
+			}
+		}
+	}
+	return nil, fmt.Errorf("no ssh key slot in %q matches %q", path, sel)
+}
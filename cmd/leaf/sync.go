@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+// errRemoteNotFound is reported by a remote that does not (yet) have a copy.
+var errRemoteNotFound = errors.New("remote copy not found")
+
+// A remote is a location where a copy of a LEAF file is stored.
+type remote interface {
+	// Fetch returns the contents of the remote copy, or errRemoteNotFound.
+	Fetch() ([]byte, error)
+
+	// Store replaces the contents of the remote copy with data.
+	Store(data []byte) error
+}
+
+// parseRemote parses a remote location. The following forms are understood:
+//
+//	ssh://[user@]host[:port]/path   -- copied with scp
+//	s3://bucket/path                -- copied with the aws command-line tool
+//	webdav://host/path              -- WebDAV over HTTPS (webdav+http for HTTP)
+//	/local/path                     -- a local file
+func parseRemote(s string) (remote, error) {
+	if !strings.Contains(s, "://") {
+		return localRemote(s), nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "file":
+		return localRemote(u.Path), nil
+	case "ssh":
+		return sshRemote{u}, nil
+	case "s3":
+		return s3Remote(s), nil
+	case "webdav", "webdav+http", "webdav+https":
+		cp := *u
+		cp.Scheme = "https"
+		if u.Scheme == "webdav+http" {
+			cp.Scheme = "http"
+		}
+		return webdavRemote{&cp}, nil
+	default:
+		return nil, fmt.Errorf("unsupported remote scheme %q", u.Scheme)
+	}
+}
+
+type localRemote string
+
+func (r localRemote) Fetch() ([]byte, error) {
+	data, err := os.ReadFile(string(r))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errRemoteNotFound
+	}
+	return data, err
+}
+
+func (r localRemote) Store(data []byte) error { return os.WriteFile(string(r), data, 0600) }
+
+type sshRemote struct{ u *url.URL }
+
+// spec returns the scp source/target for r, and the scp arguments needed.
+func (r sshRemote) spec() (string, []string) {
+	host := r.u.Hostname()
+	if r.u.User != nil {
+		host = r.u.User.Username() + "@" + host
+	}
+	args := []string{"-q", "-B"}
+	if p := r.u.Port(); p != "" {
+		args = append(args, "-P", p)
+	}
+	return host + ":" + r.u.Path, args
+}
+
+func (r sshRemote) Fetch() ([]byte, error) {
+	return viaTempFile(func(tmp string) error {
+		src, args := r.spec()
+		_, err := runTool(nil, "scp", append(args, src, tmp)...)
+		if err != nil && strings.Contains(err.Error(), "No such file") {
+			return errRemoteNotFound
+		}
+		return err
+	})
+}
+
+func (r sshRemote) Store(data []byte) error {
+	_, err := viaTempFile(func(tmp string) error {
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return err
+		}
+		dst, args := r.spec()
+		_, err := runTool(nil, "scp", append(args, tmp, dst)...)
+		return err
+	})
+	return err
+}
+
+type s3Remote string
+
+func (r s3Remote) Fetch() ([]byte, error) {
+	return viaTempFile(func(tmp string) error {
+		_, err := runTool(nil, "aws", "s3", "cp", "--quiet", string(r), tmp)
+		if err != nil && (strings.Contains(err.Error(), "404") || strings.Contains(err.Error(), "Not Found")) {
+			return errRemoteNotFound
+		}
+		return err
+	})
+}
+
+func (r s3Remote) Store(data []byte) error {
+	_, err := viaTempFile(func(tmp string) error {
+		if err := os.WriteFile(tmp, data, 0600); err != nil {
+			return err
+		}
+		_, err := runTool(nil, "aws", "s3", "cp", "--quiet", tmp, string(r))
+		return err
+	})
+	return err
+}
+
+type webdavRemote struct{ u *url.URL }
+
+func (r webdavRemote) do(method string, body []byte) (*http.Response, error) {
+	cp := *r.u
+	cp.User = nil
+	req, err := http.NewRequest(method, cp.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if r.u.User != nil {
+		pw, _ := r.u.User.Password()
+		req.SetBasicAuth(r.u.User.Username(), pw)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func (r webdavRemote) Fetch() ([]byte, error) {
+	rsp, err := r.do(http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode == http.StatusNotFound {
+		return nil, errRemoteNotFound
+	} else if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch: %s", rsp.Status)
+	}
+	return io.ReadAll(rsp.Body)
+}
+
+func (r webdavRemote) Store(data []byte) error {
+	rsp, err := r.do(http.MethodPut, data)
+	if err != nil {
+		return err
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("store: %s", rsp.Status)
+	}
+	return nil
+}
+
+// viaTempFile calls f with the path of a temporary file, and returns the
+// contents of that file after f returns successfully.
+func viaTempFile(f func(tmp string) error) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "leaf-sync-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := dir + string(os.PathSeparator) + "copy.leaf"
+	if err := f(tmp); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(tmp)
+}
+
+func runSync(env *command.Env, location string) error {
+	if settings.FilePath == "" {
+		return env.Usagef("no file path is defined")
+	}
+	rem, err := parseRemote(location)
+	if err != nil {
+		return env.Usagef("invalid remote: %v", err)
+	} else if settings.ReadOnly && !dryRunFlags.DryRun {
+		return errReadOnly
+	}
+	accessKey, err := getAccessKey(settings.FilePath, false)
+	if err != nil {
+		return err
+	}
+	if err := lockFile(settings.FilePath); err != nil {
+		return err
+	}
+	local, err := openWithKey(settings.FilePath, accessKey)
+	if err != nil {
+		return err
+	}
+
+	data, err := rem.Fetch()
+	if errors.Is(err, errRemoteNotFound) {
+		if dryRunFlags.DryRun {
+			notify(env, object{"event": "dry-run", "remote": location}, "remote copy not found; would upload local copy")
+			return nil
+		}
+		notify(env, object{"event": "uploaded", "remote": location}, "remote copy not found; uploading local copy")
+		return storeFile(rem, local)
+	} else if err != nil {
+		return err
+	}
+	other, err := leaf.Open(accessKey, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("open remote copy: %w", openError(err))
+	}
+
+	// Merge the remote changes into the local copy, and vice versa.  The
+	// remote copy is only used to decide whether it needs to be updated.
+	oldLocal, oldRemote := fileDB(local).Snapshot(), fileDB(other).Snapshot()
+	nLocal := mergeFiles(local, other)
+	nRemote := mergeFiles(other, local)
+	if dryRunFlags.DryRun {
+		if err := printDryRun(settings.FilePath, oldLocal, fileDB(local).Snapshot()); err != nil {
+			return err
+		}
+		return printDryRun(location, oldRemote, fileDB(other).Snapshot())
+	}
+	notify(env, object{"event": "merged", "remote": location, "from_remote": nLocal, "to_remote": nRemote},
+		"merged %d entries from remote, %d entries to remote", nLocal, nRemote)
+	if local.IsModified() {
+		if err := saveFile(local); err != nil {
+			return err
+		}
+	}
+	if other.IsModified() {
+		return storeFile(rem, local)
+	}
+	return nil
+}
+
+// mergeFiles merges each database of src into the database of dst with the
+// same name, and returns the total number of log entries added to dst.
+func mergeFiles(dst, src *leaf.File) int {
+	var n int
+	for _, name := range append([]string{""}, src.DatabaseNames()...) {
+		sdb, _ := src.GetDatabase(name)
+		n += dst.NamedDatabase(name).Merge(sdb)
+	}
+	return n
+}
+
+// storeFile writes the encoded contents of f to rem.
+func storeFile(rem remote, f *leaf.File) error {
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		return err
+	}
+	return rem.Store(buf.Bytes())
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// defaultCredential is the name of the systemd credential holding the access
+// key, if --credential is not set.
+const defaultCredential = "leaf-access-key"
+
+// systemdCredential returns the path of the file holding the access key
+// credential passed by systemd, or "" if there is none. Services receive
+// credentials (from LoadCredential=, LoadCredentialEncrypted=, or
+// SetCredential=) as files in $CREDENTIALS_DIRECTORY, readable only by the
+// service. It is an error if --credential names a credential that is not
+// present.
+func systemdCredential() (string, error) {
+	name := settings.Credential
+	if name == "" {
+		name = defaultCredential
+	}
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	if settings.Credential != "" {
+		return "", fmt.Errorf("systemd credential %q not found", settings.Credential)
+	}
+	return "", nil
+}
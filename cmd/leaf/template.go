@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/creachadair/atomicfile"
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var templateFlags struct {
+	Output string `flag:"o,Write the rendered output to this file (default stdout)"`
+}
+
+func runTemplate(env *command.Env, tmplFile string) error {
+	src, err := os.ReadFile(tmplFile)
+	if err != nil {
+		return err
+	}
+	f := env.Config.(*leaf.File)
+	t, err := template.New(tmplFile).Option("missingkey=error").Funcs(templateFuncs(fileDB(f))).Parse(string(src))
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, nil); err != nil {
+		return err
+	}
+	if templateFlags.Output == "" {
+		return printResult(buf.String(), func() { os.Stdout.Write(buf.Bytes()) })
+	}
+	return atomicfile.WriteData(templateFlags.Output, buf.Bytes(), 0600)
+}
+
+// A formatItem is the data passed to a --format template.
+type formatItem struct {
+	Table string // the table name
+	Key   string // the key
+	Value any    // the decoded value of the key
+}
+
+// parseFormat parses a --format template, with the functions available to
+// the template command.
+func parseFormat(db *leaf.Database, format string) (*template.Template, error) {
+	t, err := template.New("format").Option("missingkey=error").Funcs(templateFuncs(db)).Parse(format)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --format: %w", err)
+	}
+	return t, nil
+}
+
+// formatValue renders t with the given key and value. A line break is added
+// to the output unless it already ends with one.
+func formatValue(t *template.Template, table, key string, val json.RawMessage) (string, error) {
+	item := formatItem{Table: table, Key: key}
+	if err := json.Unmarshal(val, &item.Value); err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, item); err != nil {
+		return "", fmt.Errorf("table %q key %q: %w", table, key, err)
+	}
+	if !strings.HasSuffix(sb.String(), "\n") {
+		sb.WriteByte('\n')
+	}
+	return sb.String(), nil
+}
+
+// templateFuncs returns the functions available to templates rendered with
+// values from db.
+func templateFuncs(db *leaf.Database) template.FuncMap {
+	lookup := func(table, key string) (json.RawMessage, error) {
+		tab, ok := db.GetTable(table)
+		if !ok {
+			return nil, notFoundf("table %q not found", table)
+		}
+		var val json.RawMessage
+		if !tab.Get(key, &val) {
+			return nil, notFoundf("key %q not found in table %q", key, table)
+		}
+		return val, nil
+	}
+	return template.FuncMap{
+		// get "table" "key" returns the value of key. Strings are returned
+		// without quotation; other values are returned as JSON text.
+		"get": func(table, key string) (string, error) {
+			val, err := lookup(table, key)
+			if err != nil {
+				return "", err
+			}
+			return rawString(val), nil
+		},
+
+		// field "table" "key" "path" returns the portion of the value of key
+		// selected by path, as with "get --path".
+		"field": func(table, key, path string) (string, error) {
+			val, err := lookup(table, key)
+			if err != nil {
+				return "", err
+			}
+			sub, err := extractPath(val, path)
+			if err != nil {
+				return "", fmt.Errorf("table %q key %q: %w", table, key, err)
+			}
+			return rawString(sub), nil
+		},
+
+		// value "table" "key" returns the decoded value of key, so that its
+		// contents can be used with other template actions.
+		"value": func(table, key string) (any, error) {
+			val, err := lookup(table, key)
+			if err != nil {
+				return nil, err
+			}
+			var v any
+			err = json.Unmarshal(val, &v)
+			return v, err
+		},
+
+		// json returns the JSON encoding of its argument.
+		"json": func(v any) (string, error) {
+			bits, err := json.Marshal(v)
+			return string(bits), err
+		},
+	}
+}
+
+// rawString returns the contents of val if it is a JSON string, otherwise it
+// returns the JSON text of val.
+func rawString(val json.RawMessage) string {
+	var s string
+	if json.Unmarshal(val, &s) == nil {
+		return s
+	}
+	return string(val)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// runTool runs the named program with the given arguments, with stdin as its
+// standard input if it is not nil, and returns its standard output. If the
+// program fails, the error includes its diagnostic output.
+func runTool(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, toolError(name, &stderr, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// toolError returns the error for a failure err of the named program, with
+// its diagnostic output if there is any.
+func toolError(name string, stderr *bytes.Buffer, err error) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%s: %s", filepath.Base(name), msg)
+	}
+	return fmt.Errorf("%s: %w", filepath.Base(name), err)
+}
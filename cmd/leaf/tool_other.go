@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+// pipeFile is not used on this platform.
+const pipeFile = ""
+
+// runToolPipe is not supported on this platform, which cannot pass a pipe to
+// a program as an extra file descriptor.
+func runToolPipe(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	return nil, errors.New("reading the output of " + name + " through a pipe is not supported on this platform")
+}
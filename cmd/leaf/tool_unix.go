@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// pipeFile is the path by which a program run by runToolPipe can write to
+// the pipe.
+const pipeFile = "/dev/fd/3"
+
+// runToolPipe runs the named program as runTool does, but also passes it a
+// pipe as file descriptor 3 (see pipeFile), and returns what the program
+// writes to the pipe rather than its standard output. This keeps the result
+// apart from any messages the program prints, without writing it to disk.
+func runToolPipe(stdin io.Reader, name string, args ...string) ([]byte, error) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer pr.Close()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { _, err := out.ReadFrom(pr); done <- err }()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdin, cmd.Stderr = stdin, &stderr
+	cmd.ExtraFiles = []*os.File{pw}
+	err = cmd.Run()
+	pw.Close() // so that the reader sees EOF once the program has exited
+	rerr := <-done
+	if err != nil {
+		clear(out.Bytes())
+		return nil, toolError(name, &stderr, err)
+	} else if rerr != nil {
+		clear(out.Bytes())
+		return nil, rerr
+	}
+	return out.Bytes(), nil
+}
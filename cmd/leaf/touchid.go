@@ -0,0 +1,18 @@
+package main
+
+// seParams are the key slot parameters for an access key wrapped with a
+// Secure Enclave key. The identity is an age-plugin-se identity, which holds
+// the Secure Enclave key in a form that only the device that created it can
+// use, so it is safe to store in the file.
+type seParams struct {
+	Identity string `json:"identity"` // age-plugin-se identity file contents
+	Key      []byte `json:"key"`      // access key encrypted to the identity
+}
+
+// seProvider is a keyProvider that unwraps the access keys of Touch ID key
+// slots with the Secure Enclave, on platforms that support it.
+type seProvider struct{}
+
+func (seProvider) device() string                      { return "Touch ID" }
+func (seProvider) has(p slotParams) bool               { return seSupported && p.SE != nil }
+func (seProvider) unlock(p slotParams) ([]byte, error) { return seUnwrap(p.SE) }
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/leaf"
+)
+
+// On macOS, access keys are wrapped with a Secure Enclave key using the age
+// command-line tool and age-plugin-se. The key is created with an access
+// control that requires Touch ID, so the plugin prompts for a fingerprint
+// whenever the access key is unwrapped.
+
+const seSupported = true
+
+// seNewKey generates a random access key, and wraps it with a new Secure
+// Enclave key that requires Touch ID to use.
+func seNewKey() ([]byte, *seParams, error) {
+	dir, err := os.MkdirTemp("", "leaf-se")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	idPath := filepath.Join(dir, "identity.txt")
+
+	cmd := exec.Command("age-plugin-se", "keygen", "--access-control=any-biometry", "-o", idPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return nil, nil, fmt.Errorf("age-plugin-se: %s", msg)
+		}
+		return nil, nil, fmt.Errorf("age-plugin-se: %w", err)
+	}
+	identity, err := os.ReadFile(idPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	recipient := identityRecipient(string(identity))
+	if recipient == "" {
+		return nil, nil, errors.New("age-plugin-se did not report a public key")
+	}
+
+	accessKey := make([]byte, leaf.AccessKeyLen)
+	if _, err := cryptorand.Read(accessKey); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := runTool(bytes.NewReader(accessKey), "age", "--encrypt", "--recipient", recipient)
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessKey, &seParams{Identity: string(identity), Key: wrapped}, nil
+}
+
+// seUnwrap unwraps the access key in p, which prompts the user for Touch ID.
+func seUnwrap(p *seParams) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "leaf-se")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	idPath := filepath.Join(dir, "identity.txt")
+	if err := os.WriteFile(idPath, []byte(p.Identity), 0600); err != nil {
+		return nil, err
+	}
+	return runTool(bytes.NewReader(p.Key), "age", "--decrypt", "--identity", idPath)
+}
+
+// identityRecipient returns the recipient recorded in the "# public key:"
+// comment of an age identity file, or "" if there is none.
+func identityRecipient(identity string) string {
+	sc := bufio.NewScanner(strings.NewReader(identity))
+	for sc.Scan() {
+		if rest, ok := strings.CutPrefix(sc.Text(), "# public key:"); ok {
+			return strings.TrimSpace(rest)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,13 @@
+//go:build !darwin
+
+package main
+
+import "errors"
+
+const seSupported = false
+
+var errNoSecureEnclave = errors.New("Touch ID is only supported on macOS")
+
+func seNewKey() ([]byte, *seParams, error) { return nil, nil, errNoSecureEnclave }
+
+func seUnwrap(p *seParams) ([]byte, error) { return nil, errNoSecureEnclave }
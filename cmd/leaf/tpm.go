@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/creachadair/leaf"
+)
+
+// tpmParams are the key slot parameters for an access key sealed to the TPM
+// of the local machine. The sealed object can only be loaded by the TPM that
+// created it, under the storage primary key derived from its owner seed.
+type tpmParams struct {
+	Public  []byte `json:"public"`         // TPM2B_PUBLIC of the sealed object
+	Private []byte `json:"private"`        // TPM2B_PRIVATE of the sealed object
+	PCRs    string `json:"pcrs,omitempty"` // PCR policy selection, e.g., "sha256:0,7"
+}
+
+// tpmProvider is a keyProvider that unseals the access keys of TPM key slots.
+type tpmProvider struct{}
+
+func (tpmProvider) device() string                      { return "the TPM" }
+func (tpmProvider) has(p slotParams) bool               { return p.TPM != nil }
+func (tpmProvider) unlock(p slotParams) ([]byte, error) { return tpmUnseal(p.TPM) }
+
+var pcrSpec = regexp.MustCompile(`^(?:(sha1|sha256|sha384|sha512):)?(\d+(?:,\d+)*)$`)
+
+// parsePCRs normalizes a PCR selection such as "7" or "sha256:0,7". The bank
+// defaults to sha256.
+func parsePCRs(s string) (string, error) {
+	m := pcrSpec.FindStringSubmatch(strings.ReplaceAll(s, " ", ""))
+	if m == nil {
+		return "", fmt.Errorf("invalid PCR selection %q", s)
+	}
+	bank := m[1]
+	if bank == "" {
+		bank = "sha256"
+	}
+	return bank + ":" + m[2], nil
+}
+
+// tpmNewKey generates a random access key and seals it to the TPM. If pcrs is
+// not empty, unsealing also requires the selected PCRs to have their current
+// values.
+func tpmNewKey(pcrs string) ([]byte, *tpmParams, error) {
+	accessKey := make([]byte, leaf.AccessKeyLen)
+	if _, err := cryptorand.Read(accessKey); err != nil {
+		return nil, nil, err
+	}
+	dir, err := os.MkdirTemp("", "leaf-tpm")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := func(name string) string { return filepath.Join(dir, name) }
+
+	if err := tpmPrimary(tmp("primary.ctx")); err != nil {
+		return nil, nil, err
+	}
+	args := []string{"-Q", "-C", tmp("primary.ctx"), "-g", "sha256",
+		"-u", tmp("seal.pub"), "-r", tmp("seal.priv"), "-i", "-"}
+	if pcrs != "" {
+		if _, err := runTool(nil, "tpm2_createpolicy", "-Q", "--policy-pcr",
+			"-l", pcrs, "-L", tmp("policy.dat")); err != nil {
+			return nil, nil, err
+		}
+		args = append(args, "-L", tmp("policy.dat"))
+	}
+	if _, err := runTool(bytes.NewReader(accessKey), "tpm2_create", args...); err != nil {
+		return nil, nil, err
+	}
+	p := &tpmParams{PCRs: pcrs}
+	if p.Public, err = os.ReadFile(tmp("seal.pub")); err != nil {
+		return nil, nil, err
+	}
+	if p.Private, err = os.ReadFile(tmp("seal.priv")); err != nil {
+		return nil, nil, err
+	}
+	return accessKey, p, nil
+}
+
+// tpmUnseal loads the sealed object described by p and unseals its contents.
+func tpmUnseal(p *tpmParams) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "leaf-tpm")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	tmp := func(name string) string { return filepath.Join(dir, name) }
+
+	if err := os.WriteFile(tmp("seal.pub"), p.Public, 0600); err != nil {
+		return nil, err
+	} else if err := os.WriteFile(tmp("seal.priv"), p.Private, 0600); err != nil {
+		return nil, err
+	} else if err := tpmPrimary(tmp("primary.ctx")); err != nil {
+		return nil, err
+	}
+	if _, err := runTool(nil, "tpm2_load", "-Q", "-C", tmp("primary.ctx"),
+		"-u", tmp("seal.pub"), "-r", tmp("seal.priv"), "-c", tmp("seal.ctx")); err != nil {
+		return nil, err
+	}
+	args := []string{"-c", tmp("seal.ctx")}
+	if p.PCRs != "" {
+		args = append(args, "-p", "pcr:"+p.PCRs)
+	}
+	key, err := runTool(nil, "tpm2_unseal", args...)
+	if err != nil {
+		return nil, err
+	} else if len(key) != leaf.AccessKeyLen {
+		return nil, fmt.Errorf("unsealed key has length %d, want %d", len(key), leaf.AccessKeyLen)
+	}
+	return key, nil
+}
+
+// tpmPrimary creates the storage primary key under the owner hierarchy, and
+// saves its context to path. The key is derived from the owner seed, so it is
+// the same each time it is created with the same template.
+func tpmPrimary(path string) error {
+	_, err := runTool(nil, "tpm2_createprimary", "-Q", "-C", "o", "-g", "sha256", "-G", "ecc", "-c", path)
+	return err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+var treeFlags struct {
+	Tables bool `flag:"tables,Show only tables, not their keys"`
+}
+
+// A treeNode is a node of the table hierarchy. Table names are split at "/"
+// into path components, so a node may be a table, a group of tables with a
+// common prefix, or both.
+type treeNode struct {
+	Name     string      `json:"name"`
+	Table    string      `json:"table,omitempty"` // full name, if this node is a table
+	Keys     []string    `json:"keys,omitempty"`
+	Count    int         `json:"count"` // keys in this node and its children
+	Children []*treeNode `json:"children,omitempty"`
+}
+
+func (n *treeNode) child(name string) *treeNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &treeNode{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func runTree(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	}
+	f := env.Config.(*leaf.File)
+	db := fileDB(f)
+	root := &treeNode{Name: filepath.Base(settings.FilePath)}
+	var ntab int
+	for _, name := range db.TableNames() { // sorted, so children are too
+		if len(args) == 1 && name != args[0] && !strings.HasPrefix(name, strings.TrimSuffix(args[0], "/")+"/") {
+			continue
+		}
+		tab, _ := db.GetTable(name)
+		ntab++
+		cur := root
+		cur.Count += tab.Len()
+		for _, part := range strings.Split(name, "/") {
+			cur = cur.child(part)
+			cur.Count += tab.Len()
+		}
+		cur.Table = name
+		if !treeFlags.Tables {
+			cur.Keys = tab.Keys()
+		}
+	}
+	if len(args) == 1 && ntab == 0 {
+		return fmt.Errorf("no tables match %q", args[0])
+	}
+	return printResult(root, func() {
+		p := newPainter(os.Stdout)
+		fmt.Printf("%s %s\n", p.paint(root.Name, bold), p.paint(fmt.Sprintf("(%d %s, %d %s)",
+			ntab, plural(ntab, "table", "tables"), root.Count, plural(root.Count, "key", "keys")), dim))
+		printTree(p, root, "")
+	})
+}
+
+// printTree prints the children and keys of n, each line preceded by indent.
+func printTree(p painter, n *treeNode, indent string) {
+	nitems := len(n.Children) + len(n.Keys)
+	item := 0
+	branch := func() (string, string) {
+		item++
+		if item == nitems {
+			return indent + "└── ", indent + "    "
+		}
+		return indent + "├── ", indent + "│   "
+	}
+	for _, c := range n.Children {
+		head, next := branch()
+		name := p.paint(c.Name, blue)
+		if c.Table == "" {
+			name = p.paint(c.Name+"/", blue)
+		}
+		fmt.Printf("%s%s %s\n", head, name, p.paint(fmt.Sprintf("(%d)", c.Count), dim))
+		printTree(p, c, next)
+	}
+	for _, key := range n.Keys {
+		head, _ := branch()
+		fmt.Printf("%s%s\n", head, key)
+	}
+}
@@ -0,0 +1,549 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+	"golang.org/x/term"
+)
+
+const (
+	tuiTables = iota // the table pane has focus
+	tuiKeys          // the key pane has focus
+)
+
+// tuiState is the state of the terminal browser.
+type tuiState struct {
+	f      *leaf.File
+	cooked *term.State // terminal state to restore while editing
+
+	// The access key, and the modification time and size of the file when it
+	// was last read, used to reload it when another process changes it.
+	// If accessKey == nil, the file is not reloaded.
+	accessKey []byte
+	modTime   time.Time
+	size      int64
+
+	focus  int
+	tables []string // tables matching tquery
+	keys   []string // keys of the current table matching kquery
+	tquery string
+	kquery string
+
+	tcur, ttop int // selected and first visible table
+	kcur, ktop int // selected and first visible key
+
+	search  bool   // the query of the focused pane is being edited
+	history bool   // show the history of the key instead of its value
+	reveal  bool   // show the value of the key
+	msg     string // status message, cleared by the next key
+}
+
+func runTUI(env *command.Env, args ...string) error {
+	if len(args) > 1 {
+		return env.Usagef("extra arguments: %q", args[1:])
+	}
+	f, accessKey, err := openFileKey()
+	if err != nil {
+		return err
+	}
+	s := &tuiState{f: f, accessKey: accessKey}
+	if accessKey != nil {
+		// Do not hold the lock while browsing, so that other commands can
+		// update the file. It is taken again to save an edit.
+		unlockFile(settings.FilePath)
+		s.stamp()
+	}
+
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("open terminal: %w", err)
+	}
+	defer tty.Close()
+	// Use the descriptor without calling Fd, which would put the terminal in
+	// blocking mode and disable the read deadlines used to poll the file.
+	fd := -1
+	if rc, err := tty.SyscallConn(); err == nil {
+		rc.Control(func(p uintptr) { fd = int(p) })
+	}
+	old, err := term.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("set terminal mode: %w", err)
+	}
+	io.WriteString(tty, "\x1b[?1049h\x1b[?25l") // alternate screen, hide cursor
+	defer func() {
+		io.WriteString(tty, "\x1b[?25h\x1b[?1049l")
+		term.Restore(fd, old)
+	}()
+
+	s.cooked = old
+	s.refresh()
+	if len(args) == 1 {
+		for i, name := range s.tables {
+			if name == args[0] {
+				s.tcur, s.focus = i, tuiKeys
+			}
+		}
+	}
+	// If the terminal supports read deadlines, wake up periodically to check
+	// whether the file has changed.
+	poll := s.accessKey != nil && tty.SetReadDeadline(time.Time{}) == nil
+
+	buf := make([]byte, 64)
+	for redraw := true; ; {
+		if redraw {
+			s.refresh()
+			w, h, err := term.GetSize(fd)
+			if err != nil || w <= 0 || h <= 0 {
+				w, h = 80, 24
+			}
+			io.WriteString(tty, s.draw(w, h))
+		}
+		if poll {
+			tty.SetReadDeadline(time.Now().Add(time.Second))
+		}
+		n, err := tty.Read(buf)
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			changed, err := s.reload()
+			if err != nil {
+				s.msg = "reload failed: " + err.Error()
+			} else if changed {
+				s.msg = "reloaded: the file was changed by another process"
+			}
+			redraw = err != nil || changed
+			continue
+		} else if err != nil {
+			return err
+		}
+		redraw = true
+		for in := buf[:n]; len(in) != 0; {
+			var key string
+			key, in = nextKey(in)
+			if s.handle(key, tty, fd) {
+				return nil
+			}
+		}
+	}
+}
+
+// stamp records the modification time and size of the file.
+func (s *tuiState) stamp() {
+	if fi, err := os.Stat(settings.FilePath); err == nil {
+		s.modTime, s.size = fi.ModTime(), fi.Size()
+	}
+}
+
+// reload reads the file again if it has changed since it was last read, and
+// reports whether it did so.
+func (s *tuiState) reload() (bool, error) {
+	if s.accessKey == nil {
+		return false, nil
+	}
+	fi, err := os.Stat(settings.FilePath)
+	if err != nil {
+		return false, err
+	} else if fi.ModTime().Equal(s.modTime) && fi.Size() == s.size {
+		return false, nil
+	}
+	f, err := openWithKey(settings.FilePath, s.accessKey)
+	if err != nil {
+		return false, err
+	}
+	s.f.Close()
+	s.f, s.modTime, s.size = f, fi.ModTime(), fi.Size()
+	return true, nil
+}
+
+// nextKey decodes the first key press from in, and returns a name for the key
+// with the remaining input. Printable characters are named by themselves.
+func nextKey(in []byte) (string, []byte) {
+	for seq, name := range map[string]string{
+		"\x1b[A": "up", "\x1bOA": "up", "\x1b[B": "down", "\x1bOB": "down",
+		"\x1b[C": "right", "\x1bOC": "right", "\x1b[D": "left", "\x1bOD": "left",
+		"\x1b[5~": "pgup", "\x1b[6~": "pgdn", "\x1b[Z": "backtab",
+	} {
+		if rest, ok := bytes.CutPrefix(in, []byte(seq)); ok {
+			return name, rest
+		}
+	}
+	switch in[0] {
+	case 0x1b:
+		return "esc", in[1:]
+	case '\r', '\n':
+		return "enter", in[1:]
+	case '\t':
+		return "tab", in[1:]
+	case 0x7f, 0x08:
+		return "backspace", in[1:]
+	case 0x03:
+		return "ctrl-c", in[1:]
+	case 0x10:
+		return "up", in[1:] // Ctrl-P
+	case 0x0e:
+		return "down", in[1:] // Ctrl-N
+	}
+	r, size := utf8.DecodeRune(in)
+	if r < 0x20 {
+		return "", in[size:] // ignore other control characters
+	}
+	return string(r), in[size:]
+}
+
+// refresh recomputes the visible tables and keys, and keeps the cursors in
+// range.
+func (s *tuiState) refresh() {
+	db := fileDB(s.f)
+	s.tables = filterNames(db.TableNames(), s.tquery)
+	s.tcur = min(s.tcur, max(len(s.tables)-1, 0))
+	s.keys = nil
+	if tab, ok := db.GetTable(s.table()); ok {
+		s.keys = filterNames(tab.Keys(), s.kquery)
+	}
+	s.kcur = min(s.kcur, max(len(s.keys)-1, 0))
+}
+
+// filterNames returns the names matching query, best first, as for pick.
+// If query is empty, names is returned unchanged.
+func filterNames(names []string, query string) []string {
+	if query == "" {
+		return names
+	}
+	items := make([]pickItem, len(names))
+	for i, name := range names {
+		items[i] = pickItem{label: name}
+	}
+	var out []string
+	for _, it := range fuzzyFilter(items, query) {
+		out = append(out, it.label)
+	}
+	return out
+}
+
+// table returns the name of the selected table, or "" if there is none.
+func (s *tuiState) table() string {
+	if s.tcur < len(s.tables) {
+		return s.tables[s.tcur]
+	}
+	return ""
+}
+
+// key returns the selected key, or "" if there is none.
+func (s *tuiState) key() string {
+	if s.kcur < len(s.keys) {
+		return s.keys[s.kcur]
+	}
+	return ""
+}
+
+// value returns the value of the selected key, or nil if there is none.
+func (s *tuiState) value() json.RawMessage {
+	tab, ok := fileDB(s.f).GetTable(s.table())
+	if !ok {
+		return nil
+	}
+	var val json.RawMessage
+	tab.Get(s.key(), &val)
+	return val
+}
+
+// move moves the cursor of the focused pane by delta. Selecting a different
+// table or key resets the key query and hides the value.
+func (s *tuiState) move(delta int) {
+	if s.focus == tuiTables {
+		next := min(max(s.tcur+delta, 0), max(len(s.tables)-1, 0))
+		if next != s.tcur {
+			s.tcur, s.kcur, s.ktop, s.kquery = next, 0, 0, ""
+			s.reveal = false
+		}
+		return
+	}
+	next := min(max(s.kcur+delta, 0), max(len(s.keys)-1, 0))
+	if next != s.kcur {
+		s.kcur, s.reveal = next, false
+	}
+}
+
+// query returns a pointer to the query of the focused pane.
+func (s *tuiState) query() *string {
+	if s.focus == tuiTables {
+		return &s.tquery
+	}
+	return &s.kquery
+}
+
+// handle updates the state for the given key press, and reports whether the
+// browser should exit.
+func (s *tuiState) handle(key string, tty *os.File, fd int) bool {
+	s.msg = ""
+	switch key {
+	case "up":
+		s.move(-1)
+		return false
+	case "down":
+		s.move(1)
+		return false
+	case "pgup":
+		s.move(-10)
+		return false
+	case "pgdn":
+		s.move(10)
+		return false
+	case "ctrl-c":
+		return true
+	}
+	if s.search {
+		q := s.query()
+		switch key {
+		case "enter":
+			s.search = false
+		case "esc":
+			*q, s.search = "", false
+		case "backspace":
+			if r := []rune(*q); len(r) != 0 {
+				*q = string(r[:len(r)-1])
+			}
+		case "tab", "backtab", "left", "right", "":
+		default:
+			*q += key
+		}
+		// Changing a query selects its best match.
+		if s.focus == tuiTables {
+			s.tcur, s.kcur, s.kquery = 0, 0, ""
+		} else {
+			s.kcur = 0
+		}
+		return false
+	}
+
+	switch key {
+	case "q":
+		return true
+	case "k":
+		s.move(-1)
+	case "j":
+		s.move(1)
+	case "tab", "backtab", "left", "right":
+		s.focus = 1 - s.focus
+	case "enter":
+		if s.focus == tuiTables {
+			s.focus = tuiKeys
+		} else {
+			s.reveal = !s.reveal
+		}
+	case "/":
+		s.search = true
+	case "esc":
+		s.tquery, s.kquery = "", ""
+	case "v":
+		s.reveal = !s.reveal
+	case "h":
+		s.history = !s.history
+	case "c":
+		if s.key() == "" {
+			break
+		}
+		if err := copyToClipboard(rawString(s.value())); err != nil {
+			s.msg = "copy failed: " + err.Error()
+		} else {
+			s.msg = fmt.Sprintf("copied the value of %q to the clipboard", s.key())
+		}
+	case "e":
+		if s.key() == "" {
+			break
+		}
+		s.msg = s.edit(tty, fd)
+	}
+	return false
+}
+
+// edit suspends the browser to edit the selected value with the user's
+// editor, and saves the file if the value changed. If another process changed
+// the value while it was being edited, the edit is not saved. It returns a
+// status message describing the outcome.
+func (s *tuiState) edit(tty *os.File, fd int) string {
+	if settings.ReadOnly {
+		return errReadOnly.Error()
+	}
+	io.WriteString(tty, "\x1b[?25h\x1b[?1049l")
+	term.Restore(fd, s.cooked)
+	table, key, val := s.table(), s.key(), s.value()
+	nval, err := editValue(val)
+
+	// Resume the browser regardless of the outcome.
+	term.MakeRaw(fd)
+	io.WriteString(tty, "\x1b[?1049h\x1b[?25l")
+
+	if err != nil {
+		return "edit failed: " + err.Error()
+	} else if equalJSON(val, nval) {
+		return "no changes"
+	}
+
+	// Hold the lock while checking for changes and saving, so that another
+	// process cannot write the file in between.
+	if s.accessKey != nil {
+		if err := lockFile(settings.FilePath); err != nil {
+			return "save failed: " + err.Error()
+		}
+		defer unlockFile(settings.FilePath)
+		if _, err := s.reload(); err != nil {
+			return "save failed: " + err.Error()
+		}
+	}
+	tab := fileDB(s.f).Table(table)
+	var cur json.RawMessage
+	if !tab.Get(key, &cur) || !equalJSON(cur, val) {
+		return fmt.Sprintf("not saved: %q was changed by another process", key)
+	}
+	tab.Set(key, nval)
+	if err := saveFile(s.f); err != nil {
+		return "save failed: " + err.Error()
+	}
+	s.stamp()
+	return fmt.Sprintf("updated %q in table %q", key, table)
+}
+
+// draw renders the complete screen for a terminal of the given size.
+func (s *tuiState) draw(width, height int) string {
+	rows := max(height-2, 1)
+	tw := min(max(maxWidth(s.tables)+2, 12), width/4)
+	kw := min(max(maxWidth(s.keys)+2, 12), width/3)
+	vw := max(width-tw-kw-2, 0)
+
+	left := paneLines(s.tables, s.tcur, &s.ttop, rows, tw, s.focus == tuiTables)
+	mid := paneLines(s.keys, s.kcur, &s.ktop, rows, kw, s.focus == tuiKeys)
+	right := s.valueLines(rows, vw)
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[H")
+	title := fmt.Sprintf(" leaf: %s", settings.FilePath)
+	if s.tquery != "" || s.kquery != "" {
+		title += fmt.Sprintf("  [tables: %q, keys: %q]", s.tquery, s.kquery)
+	}
+	fmt.Fprintf(&sb, "\x1b[7m%s\x1b[0m\r\n", fit(title, width))
+	for i := range rows {
+		fmt.Fprintf(&sb, "%s\x1b[2m│\x1b[0m%s\x1b[2m│\x1b[0m%s\x1b[K\r\n", left[i], mid[i], right[i])
+	}
+
+	var status string
+	switch {
+	case s.search:
+		status = "/" + *s.query()
+	case s.msg != "":
+		status = s.msg
+	default:
+		status = "↑↓ move  tab pane  / search  esc clear  v show  c copy  e edit  h history  q quit"
+	}
+	fmt.Fprintf(&sb, "\x1b[2m%s\x1b[0m\x1b[K", fit(status, width-1))
+	return sb.String()
+}
+
+// paneLines renders the items of a list pane as rows lines of the given
+// width, scrolling *top as needed to keep the cursor visible.
+func paneLines(items []string, cur int, top *int, rows, width int, active bool) []string {
+	if cur < *top {
+		*top = cur
+	} else if cur >= *top+rows {
+		*top = cur - rows + 1
+	}
+	out := make([]string, rows)
+	for i := range rows {
+		j := *top + i
+		if j >= len(items) {
+			out[i] = fit("", width)
+			continue
+		}
+		text := fit(" "+items[j], width)
+		switch {
+		case j == cur && active:
+			text = "\x1b[7m" + text + "\x1b[0m"
+		case j == cur:
+			text = "\x1b[1m" + text + "\x1b[0m"
+		}
+		out[i] = text
+	}
+	return out
+}
+
+// valueLines renders the value pane: the selected value, or its history.
+func (s *tuiState) valueLines(rows, width int) []string {
+	var lines []string
+	key := s.key()
+	switch {
+	case key == "":
+		lines = []string{" (no key selected)"}
+	case s.history:
+		lines = append(lines, " History of "+key+":", "")
+		log := fileDB(s.f).Log()
+		for i := len(log) - 1; i >= 0; i-- {
+			e := log[i]
+			if !keyOp(e.Op) || e.Table != s.table() || e.Key != key {
+				continue
+			}
+			line := fmt.Sprintf(" %s  %-6s", e.Time.Format(time.DateTime), e.Op)
+			if e.Value != nil {
+				if s.reveal {
+					line += "  " + preview(e.Value, max(width-30, 2))
+				} else {
+					line += fmt.Sprintf("  %s, %d bytes", jsonType(e.Value), len(e.Value))
+				}
+			}
+			lines = append(lines, line)
+		}
+	case !s.reveal:
+		val := s.value()
+		lines = []string{
+			fmt.Sprintf(" %s, %d bytes", jsonType(val), len(val)),
+			"",
+			" (hidden; press v to show)",
+		}
+	default:
+		var buf bytes.Buffer
+		if json.Indent(&buf, s.value(), "", "  ") != nil {
+			buf.Reset()
+			buf.Write(s.value())
+		}
+		for _, line := range strings.Split(buf.String(), "\n") {
+			lines = append(lines, " "+line)
+		}
+	}
+	out := make([]string, rows)
+	for i := range rows {
+		if i < len(lines) {
+			out[i] = fit(lines[i], width)
+		} else {
+			out[i] = fit("", width)
+		}
+	}
+	return out
+}
+
+// maxWidth returns the length in runes of the longest of ss.
+func maxWidth(ss []string) int {
+	w := 0
+	for _, s := range ss {
+		w = max(w, utf8.RuneCountInString(s))
+	}
+	return w
+}
+
+// fit pads or truncates s to exactly width runes.
+func fit(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	r := []rune(strings.ReplaceAll(s, "\t", " "))
+	if len(r) > width {
+		return string(r[:width-1]) + "…"
+	}
+	return string(r) + strings.Repeat(" ", width-len(r))
+}
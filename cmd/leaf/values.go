@@ -0,0 +1,180 @@
+package main
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/creachadair/command"
+	"github.com/creachadair/leaf"
+)
+
+func runAppend(env *command.Env, table, key string, values ...string) error {
+	if len(values) == 0 {
+		return env.Usagef("missing value to append")
+	}
+	f := env.Config.(*leaf.File)
+	tab := fileDB(f).Table(table)
+	var arr []json.RawMessage
+	var old json.RawMessage
+	if tab.Get(key, &old) {
+		if err := json.Unmarshal(old, &arr); err != nil || jsonType(old) != "array" {
+			return fmt.Errorf("key %q: value is %s, not an array", key, jsonType(old))
+		}
+	}
+	for _, v := range values {
+		if json.Valid([]byte(v)) {
+			arr = append(arr, json.RawMessage(v))
+		} else {
+			s, _ := json.Marshal(v)
+			arr = append(arr, s)
+		}
+	}
+	tab.Set(key, arr)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "appended", "table": table, "key": key, "length": len(arr)},
+		"appended %d %s to %q (length %d)", len(values), plural(len(values), "value", "values"), key, len(arr))
+	return nil
+}
+
+func runIncr(env *command.Env, table, key string, rest ...string) error {
+	if len(rest) > 1 {
+		return env.Usagef("extra arguments: %q", rest[1:])
+	}
+	delta := json.Number("1")
+	if len(rest) == 1 {
+		delta = json.Number(strings.TrimSpace(rest[0]))
+		if !isJSONNumber(delta) {
+			return env.Usagef("invalid increment %q", rest[0])
+		}
+	}
+	f := env.Config.(*leaf.File)
+	tab := fileDB(f).Table(table)
+	cur := json.Number("0")
+	var old json.RawMessage
+	if tab.Get(key, &old) {
+		if jsonType(old) != "number" {
+			return fmt.Errorf("key %q: value is %s, not a number", key, jsonType(old))
+		}
+		cur = json.Number(old)
+	}
+	next, err := addNumbers(cur, delta)
+	if err != nil {
+		return fmt.Errorf("key %q: %w", key, err)
+	}
+	tab.Set(key, next)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	return printResult(next, func() { fmt.Println(next) })
+}
+
+var touchFlags struct {
+	Object bool `flag:"object,Create the key with an empty object instead of null"`
+}
+
+func runTouch(env *command.Env, table, key string) error {
+	f := env.Config.(*leaf.File)
+	tab := fileDB(f).Table(table)
+	if tab.Get(key, nil) {
+		notify(env, object{"event": "exists", "table": table, "key": key, "created": false},
+			"key %q already exists in %q", key, table)
+		return nil
+	}
+	var val any // null
+	if touchFlags.Object {
+		val = struct{}{}
+	}
+	tab.Set(key, val)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	notify(env, object{"event": "created", "table": table, "key": key, "created": true},
+		"created key %q in %q", key, table)
+	return nil
+}
+
+// addNumbers returns the sum of a and b. If both are integers the sum is
+// computed exactly, and overflow is reported as an error; otherwise the sum
+// is computed in floating point.
+func addNumbers(a, b json.Number) (json.Number, error) {
+	ai, aerr := a.Int64()
+	bi, berr := b.Int64()
+	if aerr == nil && berr == nil {
+		sum := ai + bi
+		if (bi > 0 && sum < ai) || (bi < 0 && sum > ai) {
+			return "", fmt.Errorf("integer overflow adding %d to %d", bi, ai)
+		}
+		return json.Number(strconv.FormatInt(sum, 10)), nil
+	}
+	af, err := a.Float64()
+	if err != nil {
+		return "", err
+	}
+	bf, err := b.Float64()
+	if err != nil {
+		return "", err
+	}
+	sum := af + bf
+	if math.IsInf(sum, 0) || math.IsNaN(sum) {
+		return "", fmt.Errorf("floating-point overflow adding %s to %s", b, a)
+	}
+	return json.Number(strconv.FormatFloat(sum, 'g', -1, 64)), nil
+}
+
+// isJSONNumber reports whether n is a JSON number literal. Unlike the parsing
+// done by n.Float64, this excludes forms such as "Inf", "NaN", and "0x10".
+func isJSONNumber(n json.Number) bool {
+	return json.Valid([]byte(n)) && jsonType(json.RawMessage(n)) == "number"
+}
+
+var randomFlags struct {
+	Bytes    int    `flag:"bytes,default=32,Number of random bytes to generate"`
+	Encoding string `flag:"encoding,default=hex,Encoding of the stored value (hex, base64, base64url)"`
+	Print    bool   `flag:"print,Print the generated value"`
+}
+
+func runRandom(env *command.Env, table, key string) error {
+	if randomFlags.Bytes <= 0 || randomFlags.Bytes > 1<<16 {
+		return env.Usagef("invalid --bytes %d", randomFlags.Bytes)
+	}
+	var encode func([]byte) string
+	switch randomFlags.Encoding {
+	case "hex":
+		encode = hex.EncodeToString
+	case "base64":
+		encode = base64.StdEncoding.EncodeToString
+	case "base64url":
+		encode = base64.RawURLEncoding.EncodeToString
+	default:
+		return env.Usagef("unknown encoding %q (want hex, base64, or base64url)", randomFlags.Encoding)
+	}
+	f := env.Config.(*leaf.File)
+	tab := fileDB(f).Table(table)
+	if tab.Get(key, nil) {
+		if err := confirm(env, "Replace the value of %q in table %q?", key, table); err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, randomFlags.Bytes)
+	if _, err := cryptorand.Read(buf); err != nil {
+		return err
+	}
+	val := encode(buf)
+	clear(buf)
+	tab.Set(key, val)
+	if err := saveFile(f); err != nil {
+		return err
+	}
+	if randomFlags.Print {
+		return printResult(val, func() { fmt.Println(val) })
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package leaf
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+)
+
+// A DecodeMode selects how Open handles a file that is well-formed, but has
+// unknown fields in its wrapper or payload, log entries with unknown
+// operations, or log entries whose timestamps are out of order.
+type DecodeMode int
+
+const (
+	// DecodeDefault accepts the contents of the file as they are. This is the
+	// mode used by Open.
+	DecodeDefault DecodeMode = iota
+
+	// DecodeStrict fails with a *DecodeError at the first problem found.
+	DecodeStrict
+
+	// DecodeLenient discards log entries with unknown operations, log
+	// entries whose timestamps are earlier than the entry preceding them,
+	// and snapshots that cannot be decoded. A file from which entries were
+	// discarded is marked as modified.
+	DecodeLenient
+)
+
+// OpenOptions are optional settings for opening a File. A nil *OpenOptions
+// is ready for use, and provides default settings.
+type OpenOptions struct {
+	// Mode selects how problems in the file are handled.
+	Mode DecodeMode
+
+	// If not nil, Report is called with each problem found in the file,
+	// unless Mode is DecodeStrict. In DecodeLenient mode, this includes each
+	// log entry that was discarded.
+	Report func(*DecodeError)
+
+	// If true, the values of the file remain individually encrypted in
+	// memory, and each is decrypted only while it is read, for example by
+	// Table.Get, so that a long-running process does not hold the plaintext
+	// of the whole database. The plaintext returned to the caller, as by
+	// Table.All or Database.Snapshot, is not protected.
+	//
+	// Values are sealed with a random key generated when the file is opened,
+	// rather than the data key, so that they remain readable after the data
+	// key is rotated. The key is held in locked memory where the platform
+	// allows, and is wiped by File.Close, after which the values can no
+	// longer be read. Reads, writes, and maintenance such as Compact are
+	// slower, since they must decrypt the values.
+	SealValues bool
+}
+
+// A DecodeError describes a problem found while opening a file.
+type DecodeError struct {
+	Database string // the name of the database, or "" for the default
+	Entry    int    // the offset of the log entry, or -1 if not in a log
+	Message  string // a description of the problem
+}
+
+func (e *DecodeError) Error() string {
+	if e.Entry < 0 {
+		return e.Message
+	} else if e.Database == "" {
+		return fmt.Sprintf("log entry %d: %s", e.Entry, e.Message)
+	}
+	return fmt.Sprintf("database %q: log entry %d: %s", e.Database, e.Entry, e.Message)
+}
+
+// Open reads and decrypts a File from the contents of r using the given
+// accessKey, as the Open function does, handling problems in the file as
+// specified by o.
+func (o *OpenOptions) Open(accessKey []byte, r io.Reader) (*File, error) {
+	wf, dataKey, payload, err := readPayload(accessKey, r, o)
+	if err != nil {
+		return nil, err
+	}
+
+	// Phase 4: Decode the data logs.
+	fail := func(err error) (*File, error) {
+		clear(dataKey)
+		return nil, fmt.Errorf("decode data: %w", err)
+	}
+	payload = decompress(payload)
+	var seal *sealer
+	if o != nil && o.SealValues {
+		seal = newSealer()
+		defer clear(payload)
+	}
+	var p wirePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return fail(err)
+	} else if err := o.checkPayload(payload); err != nil {
+		return fail(err)
+	}
+	var shards []logShard
+	if p.ShardSize > 0 {
+		p.Log, shards, err = wf.readShardedLog(dataKey, &p)
+		if err != nil {
+			clear(dataKey)
+			return nil, err
+		}
+	}
+	log, fixed, err := o.checkLog("", p.Log)
+	if err != nil {
+		return fail(err)
+	} else if fixed {
+		shards = nil
+	}
+	sealLog(seal, log)
+	f := newFile(wf.keySlots(), dataKey, newDatabase(log, seal))
+	f.db.dirty = fixed
+	f.seal = seal
+	dbs := p.databases()
+	f.dbs = make(map[string]*Database, len(dbs))
+	for _, name := range slices.Sorted(maps.Keys(dbs)) {
+		log, fixed, err := o.checkLog(name, dbs[name])
+		if err != nil {
+			return fail(err)
+		}
+		sealLog(seal, log)
+		f.dbs[name] = newDatabase(log, seal)
+		f.dbs[name].dirty = fixed
+	}
+	f.cipher = wf.cipher()
+	f.shardSize, f.shards = p.ShardSize, shards
+	return f, nil
+}
+
+// active reports whether o requires the contents of a file to be checked.
+func (o *OpenOptions) active() bool {
+	return o != nil && (o.Mode != DecodeDefault || o.Report != nil)
+}
+
+// problem handles a problem found in a file. In strict mode it returns e;
+// otherwise it reports e, if o requests that, and returns nil.
+func (o *OpenOptions) problem(e *DecodeError) error {
+	if o.Mode == DecodeStrict {
+		return e
+	} else if o.Report != nil {
+		o.Report(e)
+	}
+	return nil
+}
+
+// checkFields checks for fields of the JSON object data that are not among
+// the known field names. The where argument describes the object.
+func (o *OpenOptions) checkFields(where string, data []byte, known ...string) error {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(obj)) {
+		if !slices.Contains(known, name) {
+			err := o.problem(&DecodeError{Entry: -1, Message: fmt.Sprintf("unknown field %q in %s", name, where)})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkWrapper checks the unencrypted wrapper of a file for unknown fields.
+func (o *OpenOptions) checkWrapper(data []byte) error {
+	if !o.active() {
+		return nil
+	}
+	return o.checkFields("wrapper", data, "leaf", "key", "slots", "cipher", "data", "shards")
+}
+
+// checkPayload checks the decrypted payload of a file, and the named
+// databases it contains, for unknown fields.
+func (o *OpenOptions) checkPayload(data []byte) error {
+	if !o.active() {
+		return nil
+	}
+	if err := o.checkFields("payload", data, "log", "dbs", "shard_size", "shard_sums"); err != nil {
+		return err
+	}
+	var p struct {
+		DBs map[string]json.RawMessage `json:"dbs"`
+	}
+	if err := json.Unmarshal(data, &p); err != nil {
+		return err
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.DBs)) {
+		if err := o.checkFields(fmt.Sprintf("database %q", name), p.DBs[name], "log"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// knownOps is the set of log operations understood by this package.
+var knownOps = map[string]bool{
+	opCreateTable: true, opDeleteTable: true, opRenameTable: true, opClearTable: true,
+	opUpdateKey: true, opDeleteKey: true, opSnapshot: true, opTag: true,
+}
+
+// checkLog checks the entries of the log of the named database for unknown
+// operations, out-of-order timestamps, and operations on tables that do not
+// exist. In lenient mode, it returns a copy of log without the entries that
+// cannot be replayed in order, and reports whether any were removed.
+// Otherwise it returns log unmodified. Operations on tables that do not exist
+// are reported, but not removed, since replay creates the table.
+func (o *OpenOptions) checkLog(db string, log []*logEntry) ([]*logEntry, bool, error) {
+	if !o.active() {
+		return log, false, nil
+	}
+	var out []*logEntry
+	tabs := make(map[string]bool)
+	fixed := false
+	for i, e := range log {
+		msg, drop := "", true
+		if !knownOps[e.Op] {
+			msg = fmt.Sprintf("unknown operation %q", e.Op)
+		} else if n := len(out); n != 0 && e.TS < out[n-1].TS {
+			msg = fmt.Sprintf("timestamp %d is earlier than %d", e.TS, out[n-1].TS)
+		} else {
+			msg, drop = checkTables(tabs, e)
+		}
+		if msg != "" {
+			if err := o.problem(&DecodeError{Database: db, Entry: i, Message: msg}); err != nil {
+				return nil, false, err
+			} else if drop && o.Mode == DecodeLenient {
+				fixed = true
+				continue
+			}
+		}
+		out = append(out, e)
+	}
+	if !fixed {
+		return log, false, nil
+	}
+	return out, true, nil
+}
+
+// checkTables updates the set of tables that exist to reflect e. If e
+// operates on a table that does not exist, or is a snapshot that cannot be
+// decoded, it returns a description of the problem, and reports whether e
+// must be discarded to replay the log.
+func checkTables(tabs map[string]bool, e *logEntry) (string, bool) {
+	switch e.Op {
+	case opCreateTable:
+		tabs[e.A] = true
+	case opDeleteTable:
+		delete(tabs, e.A)
+	case opSnapshot:
+		// Check the shape that replay requires, so that a malformed snapshot
+		// is reported rather than causing a panic.
+		var snap map[string]map[string]json.RawMessage
+		if err := json.Unmarshal(e.C, &snap); err != nil {
+			return fmt.Sprintf("invalid snapshot: %v", err), true
+		}
+		clear(tabs)
+		for name := range snap {
+			tabs[name] = true
+		}
+	case opRenameTable, opClearTable, opUpdateKey, opDeleteKey:
+		ok := tabs[e.A]
+		if e.Op == opRenameTable {
+			delete(tabs, e.A)
+			tabs[e.B] = true
+		} else if e.Op == opUpdateKey {
+			tabs[e.A] = true
+		}
+		if !ok {
+			return fmt.Sprintf("%s on missing table %q", e.Op, e.A), false
+		}
+	}
+	return "", false
+}
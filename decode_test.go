@@ -0,0 +1,47 @@
+package leaf
+
+import (
+	"testing"
+)
+
+// A snapshot must map table names to tables, each mapping keys to values.
+// Files are not written with malformed snapshots, so the log is checked here
+// directly rather than by opening a file.
+func TestCheckSnapshot(t *testing.T) {
+	tests := []struct {
+		snap string
+		ok   bool
+	}{
+		{`{}`, true},
+		{`{"t":{}}`, true},
+		{`{"t":{"a":1,"b":[2]}}`, true},
+		{`{"t":null}`, true},
+		{`{"t":5}`, false},
+		{`{"t":[1,2]}`, false},
+		{`{"t":{"a":1},"u":"x"}`, false},
+		{`[]`, false},
+	}
+	for _, tc := range tests {
+		log := []*logEntry{
+			{Op: opCreateTable, A: "t", TS: 100},
+			{Op: opSnapshot, C: []byte(tc.snap), TS: 200},
+		}
+		var reports int
+		o := &OpenOptions{Mode: DecodeLenient, Report: func(*DecodeError) { reports++ }}
+		out, fixed, err := o.checkLog("", log)
+		if err != nil {
+			t.Fatalf("checkLog %s: unexpected error: %v", tc.snap, err)
+		}
+		if fixed == tc.ok || (reports == 0) != tc.ok {
+			t.Errorf("checkLog %s: got fixed=%v, %d reports; want ok=%v", tc.snap, fixed, reports, tc.ok)
+		}
+
+		// The checked log can be replayed without a panic.
+		newDatabase(out, nil)
+
+		o = &OpenOptions{Mode: DecodeStrict}
+		if _, _, err := o.checkLog("", log); (err == nil) != tc.ok {
+			t.Errorf("checkLog strict %s: got %v, want ok=%v", tc.snap, err, tc.ok)
+		}
+	}
+}
@@ -1,10 +1,11 @@
 module github.com/creachadair/leaf
 
-go 1.22
+go 1.23
 
 toolchain go1.23.1
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/creachadair/atomicfile v0.3.3
 	github.com/creachadair/command v0.1.15
 	github.com/creachadair/flax v0.0.0-20240212192608-428acafa3bbe
@@ -12,10 +13,8 @@ require (
 	github.com/creachadair/mds v0.17.1
 	github.com/golang/snappy v0.0.4
 	github.com/google/go-cmp v0.6.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	golang.org/x/crypto v0.20.0
-)
-
-require (
-	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/term v0.17.0 // indirect
+	golang.org/x/sys v0.17.0
+	golang.org/x/term v0.17.0
 )
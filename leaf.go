@@ -10,12 +10,23 @@
 package leaf
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/cipher"
 	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"iter"
+	"maps"
+	"runtime"
+	"slices"
 	"sort"
 	"time"
 
@@ -26,8 +37,21 @@ import (
 // AccessKeyLen is the required length in bytes of an access key.
 const AccessKeyLen = chacha20poly1305.KeySize // 32 bytes
 
-// formatVersion is the file format version understood by this package.
-const formatVersion = 1
+// File format versions understood by this package. Each version adds a
+// feature to the one before it, and a file is written with the lowest version
+// that has the features it uses, so that versions of the package that do not
+// support those features will not open it. A file whose log is sharded (see
+// File.SetShardSize) is at least version 2, a file with named databases
+// (see File.NamedDatabase) is at least version 3, and a file whose payload
+// cipher is not the default (see File.SetCipher) is version 4.
+const (
+	formatVersion  = 1
+	shardedVersion = 2
+	dbsVersion     = 3
+	cipherVersion  = 4
+
+	maxVersion = cipherVersion // the latest version understood
+)
 
 // Constants for operations.
 const (
@@ -38,55 +62,556 @@ const (
 	opUpdateKey   = "update"
 	opDeleteKey   = "delete"
 	opSnapshot    = "snapshot"
+	opTag         = "tag"
+)
+
+// DefaultKeySlot is the name of the key slot created by New.
+const DefaultKeySlot = "default"
+
+// Ciphers supported for encrypting the payload of a File.
+const (
+	// CipherXChaCha20Poly1305 is XChaCha20-Poly1305 with a random nonce.
+	// This is the default.
+	CipherXChaCha20Poly1305 = "xchacha20-poly1305"
+
+	// CipherAESSIV is AES-SIV (RFC 5297) with a random nonce. It resists
+	// nonce misuse: if the random source fails, or a snapshot of a virtual
+	// machine is resumed more than once, a repeated nonce does not reveal
+	// the plaintext or the key. It is slower than the default.
+	CipherAESSIV = "aes-siv"
 )
 
 // A File is a LEAF archive file.
+//
+// The plaintext data key of a File is held in memory that is locked against
+// being written to swap, where the platform allows it. Call Close to wipe the
+// key when the File is no longer needed; otherwise it is wiped when the File
+// is garbage collected.
 type File struct {
-	dataKeyEncrypted []byte
-	dataKeyPlain     []byte
-	db               *Database
+	slots        []keySlot // encrypted copies of the data key
+	dataKeyPlain []byte    // allocated by allocSecret
+	cipher       string    // the payload cipher
+	db           *Database
+	dbs          map[string]*Database // named databases, by name
+	shardSize    int                  // log entries per shard, or 0
+	shards       []logShard           // the shards last read or written
+	logs         map[string]*logCache // encoded logs, by database name
+	scratch      []byte               // reused to encode the payload
+	seal         *sealer              // seals the values of databases, or nil
+}
+
+// errClosed is reported by operations that require the data key of a File
+// after it has been closed.
+var errClosed = errors.New("file is closed")
+
+// Errors reported by this package, which callers can check with errors.Is.
+// The errors reported usually have more specific messages.
+var (
+	// ErrWrongKey is reported when an access key does not match any key slot
+	// of a file.
+	ErrWrongKey = errors.New("access key does not match any key slot")
+
+	// ErrNotFound is reported when a key slot, tag, or key does not exist.
+	ErrNotFound = errors.New("not found")
+
+	// ErrExists is reported when a key slot or tag to be added already exists.
+	ErrExists = errors.New("already exists")
+)
+
+// A kindError is an error with a specific message that matches one of the
+// general errors reported by this package.
+type kindError struct {
+	kind error
+	msg  string
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
+
+// errorf returns an error with the formatted message that matches kind.
+func errorf(kind error, msg string, args ...any) error {
+	return &kindError{kind: kind, msg: fmt.Sprintf(msg, args...)}
+}
+
+// newFile constructs a File with the given slots, data key, and database.
+// The data key is copied into locked memory, and dataKey is wiped.
+func newFile(slots []keySlot, dataKey []byte, db *Database) *File {
+	f := &File{
+		slots:        slots,
+		dataKeyPlain: allocSecret(len(dataKey)),
+		cipher:       CipherXChaCha20Poly1305,
+		db:           db,
+	}
+	copy(f.dataKeyPlain, dataKey)
+	clear(dataKey)
+	runtime.SetFinalizer(f, (*File).freeDataKey)
+	return f
+}
+
+// Close wipes the data key of f from memory and releases it. After Close,
+// the database of f can still be read, but f cannot be written or have key
+// slots added. Calling Close more than once has no further effect.
+//
+// If f was opened with OpenOptions.SealValues, Close also wipes the key that
+// seals its values, after which the databases of f cannot be used: Reading
+// or changing a value panics.
+func (f *File) Close() error {
+	f.freeDataKey()
+	if f.seal != nil {
+		f.seal.close()
+	}
+	return nil
+}
+
+// freeDataKey wipes and releases the data key of f. Unlike Close, it does not
+// wipe the seal key, which the databases of f may still be using when f is
+// finalized.
+func (f *File) freeDataKey() {
+	if f.dataKeyPlain != nil {
+		freeSecret(f.dataKeyPlain)
+		f.dataKeyPlain = nil
+		runtime.SetFinalizer(f, nil)
+	}
+}
+
+// A keySlot is a copy of the data key encrypted with an access key.
+type keySlot struct {
+	KeySlot
+	key []byte
+}
+
+// A KeySlot describes a key slot of a File.
+type KeySlot struct {
+	Name string // the name of the slot
+
+	// Params are arbitrary public parameters recorded with the slot, for
+	// example to describe how its access key is derived. Params are stored in
+	// plaintext in the file, and must not contain secret values.
+	Params json.RawMessage
 }
 
 // WriteTo encodes, encrypts, and writes the current contents of f to w.
 // If an error occurs in encoding or encryption, no data are written to w.
 // Writing f clears its modification flag, if set.
+//
+// The encoded logs of f are retained between writes, so that writing f again
+// after entries are added encodes only the new entries. The payload is still
+// compressed and encrypted in full each time, unless the log is sharded (see
+// SetShardSize).
 func (f *File) WriteTo(w io.Writer) (int64, error) {
-	if len(f.dataKeyEncrypted) == 0 || len(f.dataKeyPlain) == 0 {
+	if len(f.slots) == 0 || len(f.dataKeyPlain) == 0 {
 		return 0, errors.New("invalid file: no encryption key present")
 	}
-	data, err := json.Marshal(f.db)
+	wf := wireFile{wireHeader: wireHeader{V: formatVersion}}
+	var shards []logShard
+	if f.shardSize > 0 {
+		var err error
+		shards, err = f.writeShards()
+		if err != nil {
+			return 0, err
+		}
+		wf.V = shardedVersion
+		for _, s := range shards {
+			wf.Shards = append(wf.Shards, s.data)
+		}
+	}
+	if len(f.DatabaseNames()) != 0 {
+		wf.V = dbsVersion
+	}
+	data, err := f.encodePayload(shards)
 	if err != nil {
 		return 0, fmt.Errorf("encode data: %w", err)
 	}
-	dataEncrypted, err := encryptWithKey(f.dataKeyPlain, compress(data))
+	wf.Data, err = encryptPayload(f.cipher, f.dataKeyPlain, data)
+	clear(data) // the buffer is reused, but should not retain plaintext
 	if err != nil {
 		return 0, fmt.Errorf("encrypt data: %w", err)
 	}
-	wf, err := json.Marshal(wireFile{
-		V:    formatVersion,
-		Key:  f.dataKeyEncrypted,
-		Data: dataEncrypted,
-	})
-	if err != nil {
-		return 0, fmt.Errorf("encode file: %w", err)
+	if f.cipher != CipherXChaCha20Poly1305 {
+		wf.Cipher = f.cipher // omitted for the default, as written by older versions
+		wf.V = cipherVersion
+	}
+	for _, s := range f.slots {
+		if s.Name == DefaultKeySlot && s.Params == nil {
+			wf.Key = s.key
+		} else {
+			wf.Slots = append(wf.Slots, wireSlot{Name: s.Name, Key: s.key, Params: s.Params})
+		}
 	}
-	nw, err := w.Write(wf)
+	nw, err := wf.writeTo(w)
 	if err == nil {
+		f.shards = shards
 		f.db.dirty = false
+		for _, db := range f.dbs {
+			db.dirty = false
+		}
+	}
+	return nw, err
+}
+
+// encodePayload returns the JSON encoding of the plaintext payload of f, in
+// the format of wirePayload. The logs of the shards, if any, are not included
+// in the payload. Named databases with empty logs are omitted.
+//
+// The result is encoded into a buffer that is reused by later calls, and is
+// only valid until the next call.
+func (f *File) encodePayload(shards []logShard) ([]byte, error) {
+	if f.logs == nil {
+		f.logs = make(map[string]*logCache)
+	}
+	appendLog := func(buf []byte, name string, db *Database) ([]byte, error) {
+		if db.seal != nil {
+			// Do not retain the plaintext encoding of a sealed log.
+			delete(f.logs, name)
+			var c logCache
+			log := db.plainLog(db.log)
+			defer db.releaseLog(log)
+			defer func() { clear(c.data) }()
+			return c.appendTo(buf, log)
+		}
+		c, ok := f.logs[name]
+		if !ok {
+			c = new(logCache)
+			f.logs[name] = c
+		}
+		return c.appendTo(buf, db.log)
+	}
+
+	buf := append(f.scratch[:0], `{"log":`...)
+	var err error
+	if f.shardSize == 0 {
+		buf, err = appendLog(buf, "", f.db)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// The log is encoded in the shards instead.
+		delete(f.logs, "")
+		buf = append(buf, "[]"...)
+	}
+	if names := f.DatabaseNames(); len(names) != 0 {
+		buf = append(buf, `,"dbs":{`...)
+		for i, name := range names {
+			if i != 0 {
+				buf = append(buf, ',')
+			}
+			key, _ := json.Marshal(name) // a string cannot fail
+			buf = append(buf, key...)
+			buf = append(buf, `:{"log":`...)
+			buf, err = appendLog(buf, name, f.dbs[name])
+			if err != nil {
+				return nil, err
+			}
+			buf = append(buf, '}')
+		}
+		buf = append(buf, '}')
+	}
+	for name := range f.logs {
+		if _, ok := f.GetDatabase(name); !ok {
+			delete(f.logs, name) // the database was deleted
+		}
+	}
+	if len(shards) != 0 {
+		buf = fmt.Appendf(buf, `,"shard_size":%d,"shard_sums":[`, f.shardSize)
+		for i, s := range shards {
+			if i != 0 {
+				buf = append(buf, ',')
+			}
+			buf = append(buf, '"')
+			buf = base64.StdEncoding.AppendEncode(buf, s.sum)
+			buf = append(buf, '"')
+		}
+		buf = append(buf, ']')
+	}
+	buf = append(buf, '}')
+	f.scratch = buf
+	return buf, nil
+}
+
+// A logCache caches the JSON encoding of a log, so that when entries are
+// added to the log, it can be encoded again by encoding only the new entries.
+type logCache struct {
+	entries []*logEntry // the entries encoded
+	data    []byte      // their encodings, separated by commas
+}
+
+// appendTo appends the JSON encoding of log to buf, reusing the cached
+// encoding of as much of it as is unchanged.
+func (c *logCache) appendTo(buf []byte, log []*logEntry) ([]byte, error) {
+	// Entries are never modified once added to a log, so an entry with the
+	// same address has the same encoding.
+	if len(c.entries) > len(log) || !slices.Equal(c.entries, log[:len(c.entries)]) {
+		c.entries, c.data = nil, nil // the log was rewritten
+	}
+	for _, e := range log[len(c.entries):] {
+		bits, err := json.Marshal(e)
+		if err != nil {
+			c.entries, c.data = nil, nil
+			return nil, err
+		}
+		if len(c.data) != 0 {
+			c.data = append(c.data, ',')
+		}
+		c.data = append(c.data, bits...)
+		c.entries = append(c.entries, e)
+	}
+	buf = append(buf, '[')
+	buf = append(buf, c.data...)
+	return append(buf, ']'), nil
+}
+
+// Cipher returns the name of the cipher used to encrypt the payload of f.
+func (f *File) Cipher() string { return f.cipher }
+
+// SetCipher sets the cipher used to encrypt the payload of f when it is next
+// written. It reports an error if name is not a supported cipher. The key
+// slots of f are not affected. If the cipher changes, f is marked as modified.
+//
+// A file with a cipher other than CipherXChaCha20Poly1305 is written in
+// format version 4, which versions of this package that do not support other
+// ciphers will not open.
+func (f *File) SetCipher(name string) error {
+	if _, err := newAEAD(name, make([]byte, AccessKeyLen)); err != nil {
+		return err
+	}
+	if name != f.cipher {
+		f.cipher = name
+		f.shards = nil // re-encrypt all shards with the new cipher
+		f.db.dirty = true
 	}
-	return int64(nw), err
+	return nil
 }
 
 // IsModified reports whether the contents of f have been modified.
-func (f *File) IsModified() bool { return f.db.IsModified() }
+func (f *File) IsModified() bool {
+	if f.db.IsModified() {
+		return true
+	}
+	for _, db := range f.dbs {
+		if db.IsModified() {
+			return true
+		}
+	}
+	return false
+}
 
-// Database returns the database stored in f.
+// Database returns the default database stored in f.
 func (f *File) Database() *Database { return f.db }
 
-// New constructs a new empty File using the specified access key.
+// DatabaseNames returns a slice of the names of the named databases of f in
+// sorted order. The default database is not included.
+//
+// In addition to its default database, a file may contain any number of
+// named databases. Each is independent of the others, with its own tables
+// and log, but all of them are encrypted with the same data key. A file with
+// named databases is written in format version 3, which versions of this
+// package that do not support them will not open.
+func (f *File) DatabaseNames() []string {
+	out := make([]string, 0, len(f.dbs))
+	for name, db := range f.dbs {
+		if len(db.log) != 0 {
+			out = append(out, name)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// GetDatabase reports whether f has a database by the given name, and if so
+// returns the database. The name "" refers to the default database, which
+// always exists.
+func (f *File) GetDatabase(name string) (*Database, bool) {
+	if name == "" {
+		return f.db, true
+	}
+	db, ok := f.dbs[name]
+	if !ok || len(db.log) == 0 {
+		return nil, false
+	}
+	return db, true
+}
+
+// NamedDatabase returns the database with the given name from f, creating it
+// empty if it does not exist. The name "" refers to the default database.
+// A new database is not stored in f until it is modified.
+func (f *File) NamedDatabase(name string) *Database {
+	if name == "" {
+		return f.db
+	} else if db, ok := f.dbs[name]; ok {
+		return db
+	}
+	if f.dbs == nil {
+		f.dbs = make(map[string]*Database)
+	}
+	db := newDatabase(nil, f.seal)
+	f.dbs[name] = db
+	return db
+}
+
+// DeleteDatabase deletes the named database from f and reports whether it
+// existed. The default database cannot be deleted.
+func (f *File) DeleteDatabase(name string) bool {
+	if _, ok := f.GetDatabase(name); !ok || name == "" {
+		return false
+	}
+	delete(f.dbs, name)
+	f.db.dirty = true
+	return true
+}
+
+// KeySlots returns descriptions of the key slots of f. Each key slot holds a
+// copy of the data key encrypted with a different access key, and any of them
+// can be used to open the file.
+func (f *File) KeySlots() []KeySlot {
+	out := make([]KeySlot, len(f.slots))
+	for i, s := range f.slots {
+		out[i] = s.KeySlot
+	}
+	return out
+}
+
+// AddKey adds a key slot with the given name, granting accessKey the ability
+// to open f. It reports an error if a slot with that name already exists.
 // The key must be AccessKeyLen bytes in length.
+// If the slot is added, f is marked as modified.
+func (f *File) AddKey(name string, accessKey []byte) error {
+	return f.AddKeySlot(KeySlot{Name: name}, accessKey)
+}
+
+// AddKeySlot adds a key slot described by ks, granting accessKey the ability
+// to open f. It behaves as AddKey, but also records the parameters of ks.
+func (f *File) AddKeySlot(ks KeySlot, accessKey []byte) error {
+	if len(f.dataKeyPlain) == 0 {
+		return errClosed
+	} else if ks.Name == "" {
+		return errors.New("empty key slot name")
+	} else if f.findSlot(ks.Name) >= 0 {
+		return errorf(ErrExists, "key slot %q already exists", ks.Name)
+	} else if ks.Params != nil && !json.Valid(ks.Params) {
+		return fmt.Errorf("invalid parameters for key slot %q", ks.Name)
+	}
+	enc, err := encryptWithKey(accessKey, f.dataKeyPlain)
+	if err != nil {
+		return fmt.Errorf("encrypt data key: %w", err)
+	}
+	f.slots = append(f.slots, keySlot{KeySlot: ks, key: enc})
+	f.db.dirty = true
+	return nil
+}
+
+// ReplaceKeySlot replaces the key slot with the same name as ks by one granting
+// accessKey the ability to open f, with the parameters of ks. The previous
+// access key of the slot can no longer be used to open f. It reports an error
+// if no slot with that name exists.
+// If the slot is replaced, f is marked as modified.
+func (f *File) ReplaceKeySlot(ks KeySlot, accessKey []byte) error {
+	i := f.findSlot(ks.Name)
+	if len(f.dataKeyPlain) == 0 {
+		return errClosed
+	} else if i < 0 {
+		return errorf(ErrNotFound, "key slot %q not found", ks.Name)
+	} else if ks.Params != nil && !json.Valid(ks.Params) {
+		return fmt.Errorf("invalid parameters for key slot %q", ks.Name)
+	}
+	enc, err := encryptWithKey(accessKey, f.dataKeyPlain)
+	if err != nil {
+		return fmt.Errorf("encrypt data key: %w", err)
+	}
+	f.slots[i] = keySlot{KeySlot: ks, key: enc}
+	f.db.dirty = true
+	return nil
+}
+
+// RemoveKey removes the key slot with the given name, so that its access key
+// can no longer be used to open f. It reports an error if no such slot
+// exists, or if it is the only remaining slot.
+// If the slot is removed, f is marked as modified.
+func (f *File) RemoveKey(name string) error {
+	i := f.findSlot(name)
+	if i < 0 {
+		return errorf(ErrNotFound, "key slot %q not found", name)
+	} else if len(f.slots) == 1 {
+		return fmt.Errorf("cannot remove the last key slot %q", name)
+	}
+	f.slots = append(f.slots[:i], f.slots[i+1:]...)
+	f.db.dirty = true
+	return nil
+}
+
+// RotateDataKey replaces the data key of f with a new random key, and stores
+// the new key in each key slot. Since a slot holds the data key encrypted
+// with its access key, accessKeys must map the name of every slot of f to
+// its access key. RotateDataKey reports an error without modifying f if any
+// slot is missing a key, or if any key does not open its slot.
+//
+// Rotate the data key after removing a key slot, since whoever held its
+// access key may have kept a copy of the data key, which would still decrypt
+// the file. The payload is encrypted with the new key when f is next written.
+// If the data key is rotated, f is marked as modified.
+func (f *File) RotateDataKey(accessKeys map[string][]byte) error {
+	if len(f.dataKeyPlain) == 0 {
+		return errClosed
+	}
+	for name := range accessKeys {
+		if f.findSlot(name) < 0 {
+			return errorf(ErrNotFound, "key slot %q not found", name)
+		}
+	}
+	dataKey := allocSecret(len(f.dataKeyPlain))
+	if _, err := cryptorand.Read(dataKey); err != nil {
+		freeSecret(dataKey)
+		return fmt.Errorf("generate data key: %w", err)
+	}
+	slots := make([]keySlot, len(f.slots))
+	for i, s := range f.slots {
+		enc, err := rewrapKey(s, accessKeys[s.Name], f.dataKeyPlain, dataKey)
+		if err != nil {
+			freeSecret(dataKey)
+			return err
+		}
+		slots[i] = keySlot{KeySlot: s.KeySlot, key: enc}
+	}
+	freeSecret(f.dataKeyPlain)
+	f.dataKeyPlain, f.slots = dataKey, slots
+	f.shards = nil // encrypted with the old key
+	f.db.dirty = true
+	return nil
+}
+
+// rewrapKey checks that accessKey opens slot s, which holds oldKey, and
+// returns newKey encrypted with accessKey.
+func rewrapKey(s keySlot, accessKey, oldKey, newKey []byte) ([]byte, error) {
+	if accessKey == nil {
+		return nil, fmt.Errorf("no access key for key slot %q", s.Name)
+	}
+	old, err := decryptWithKey(accessKey, s.key)
+	if err != nil || subtle.ConstantTimeCompare(old, oldKey) != 1 {
+		clear(old)
+		return nil, fmt.Errorf("access key does not open key slot %q", s.Name)
+	}
+	clear(old)
+	enc, err := encryptWithKey(accessKey, newKey)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt data key: %w", err)
+	}
+	return enc, nil
+}
+
+func (f *File) findSlot(name string) int {
+	for i, s := range f.slots {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// New constructs a new empty File using the specified access key.
+// The key must be AccessKeyLen bytes in length, and is stored in a key slot
+// named DefaultKeySlot.
 func New(accessKey []byte) (*File, error) {
 	dataKeyPlain := make([]byte, chacha20poly1305.KeySize)
+	defer clear(dataKeyPlain)
 	if _, err := cryptorand.Read(dataKeyPlain); err != nil {
 		return nil, fmt.Errorf("generate data key: %w", err)
 	}
@@ -94,68 +619,425 @@ func New(accessKey []byte) (*File, error) {
 	if err != nil {
 		return nil, fmt.Errorf("encrypt data key: %w", err)
 	}
-	return &File{
-		dataKeyEncrypted: dataKeyEncrypted, // for storage
-		dataKeyPlain:     dataKeyPlain,     // to encrypt data
-		db:               newDatabase(nil),
-	}, nil
+	slots := []keySlot{{KeySlot: KeySlot{Name: DefaultKeySlot}, key: dataKeyEncrypted}}
+	return newFile(slots, dataKeyPlain, newDatabase(nil, nil)), nil
 }
 
 // Open reads and decrypts a File from the contents of r using the given
-// accessKey. The key must be AccessKeyLen bytes in length.
+// accessKey. The key must be AccessKeyLen bytes in length, and must match at
+// least one of the key slots of the file.
 func Open(accessKey []byte, r io.Reader) (*File, error) {
+	return (*OpenOptions)(nil).Open(accessKey, r)
+}
+
+// readPayload reads a File from r and decrypts its payload using accessKey.
+// It returns the wrapper, data key, and (compressed) payload of the file.
+func readPayload(accessKey []byte, r io.Reader, o *OpenOptions) (*wireFile, []byte, []byte, error) {
 	// Phase 1: Decode the unencrypted wrapper to get the data key.
-	bits, err := io.ReadAll(r)
+	bits, err := readAll(r)
 	if err != nil {
-		return nil, fmt.Errorf("read file: %w", err)
+		return nil, nil, nil, fmt.Errorf("read file: %w", err)
 	}
 	var wf wireFile
 	if err := json.Unmarshal(bits, &wf); err != nil {
-		return nil, fmt.Errorf("decode file: %w", err)
-	} else if wf.V != formatVersion {
-		return nil, fmt.Errorf("version mismatch: got %v, want %v", wf.V, formatVersion)
+		return nil, nil, nil, fmt.Errorf("decode file: %w", err)
+	} else if err := wf.checkVersion(); err != nil {
+		return nil, nil, nil, err
+	} else if err := o.checkWrapper(bits); err != nil {
+		return nil, nil, nil, fmt.Errorf("decode file: %w", err)
+	}
+	slots := wf.keySlots()
+	if len(slots) == 0 {
+		return nil, nil, nil, errors.New("decode file: no key slots present")
 	}
 
-	// Phase 2: Decrypt the data key with the access key.
-	dataKey, err := decryptWithKey(accessKey, wf.Key)
+	// Phase 2: Decrypt the data key with the access key, trying each slot.
+	var dataKey []byte
+	for _, s := range slots {
+		dataKey, err = decryptWithKey(accessKey, s.key)
+		if err == nil {
+			break
+		}
+	}
 	if err != nil {
-		return nil, fmt.Errorf("decrypt data key: %w", err)
+		return nil, nil, nil, fmt.Errorf("decrypt data key: %w", ErrWrongKey)
 	}
 
-	// Phase 3: Decrypt the data payload with the data key.
-	payload, err := decryptWithKey(dataKey, wf.Data)
+	// Phase 3: Decrypt the data payload with the data key. The encrypted data
+	// are not needed again, so the payload replaces them.
+	payload, err := decryptInPlace(wf.cipher(), dataKey, wf.Data)
+	wf.Data = nil
 	if err != nil {
-		return nil, fmt.Errorf("decrypt data: %w", err)
+		clear(dataKey)
+		return nil, nil, nil, fmt.Errorf("decrypt data: %w", err)
 	}
+	return &wf, dataKey, payload, nil
+}
 
-	// Phase 4: Decode the data log.
-	var db Database
-	if err := json.Unmarshal(decompress(payload), &db); err != nil {
-		clear(dataKey)
+// readAll reads the complete contents of r. If r reports its size, as for
+// example a file or a bytes.Reader does, the buffer is allocated once.
+func readAll(r io.Reader) ([]byte, error) {
+	size := -1
+	switch t := r.(type) {
+	case interface{ Len() int }:
+		size = t.Len()
+	case interface{ Stat() (fs.FileInfo, error) }:
+		if fi, err := t.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = int(fi.Size())
+		}
+	}
+	if size < 0 {
+		return io.ReadAll(r)
+	}
+	var buf bytes.Buffer
+	buf.Grow(size + bytes.MinRead) // room to detect EOF without growing
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// Unwrap reads and decrypts a File from the contents of r using the given
+// accessKey, as Open does, and returns its plaintext payload: the JSON
+// encoding of its databases. Unlike Open, the payload is not decoded, so
+// Unwrap can recover the contents of a file whose payload is damaged.
+//
+// If the log of the file is sharded, its shards are decoded and the payload
+// is returned in unsharded form.
+func Unwrap(accessKey []byte, r io.Reader) ([]byte, error) {
+	wf, dataKey, payload, err := readPayload(accessKey, r, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer clear(dataKey)
+	dec, err := snappy.Decode(nil, payload)
+	if err != nil {
+		return nil, fmt.Errorf("decompress data: %w", err)
+	} else if wf.V < shardedVersion {
+		return dec, nil
+	}
+	var p wirePayload
+	if err := json.Unmarshal(dec, &p); err != nil {
+		return nil, fmt.Errorf("decode data: %w", err)
+	} else if p.ShardSize == 0 {
+		return dec, nil
+	}
+	p.Log, _, err = wf.readShardedLog(dataKey, &p)
+	if err != nil {
+		return nil, err
+	}
+	p.ShardSize, p.ShardSums = 0, nil
+	return json.Marshal(p)
+}
+
+// ReadLog reads and decrypts a File from the contents of r using the given
+// accessKey, as Open does, and returns an iterator over the entries of the
+// log of its default database, in order. Unlike Open, the entries are
+// decoded one at a time (or one shard at a time, if the log is sharded) as
+// the iterator proceeds and the database is not constructed, so a tool that
+// only needs to scan the history of a large file can do so in much less
+// memory.
+//
+// If an error occurs, the iterator yields it with a zero LogEntry and stops.
+// The file is not read until iteration begins.
+func ReadLog(accessKey []byte, r io.Reader) iter.Seq2[LogEntry, error] {
+	return func(yield func(LogEntry, error) bool) {
+		wf, dataKey, payload, err := readPayload(accessKey, r, nil)
+		if err != nil {
+			yield(LogEntry{}, err)
+			return
+		}
+		defer clear(dataKey)
+		dec, err := snappy.Decode(nil, payload)
+		if err != nil {
+			yield(LogEntry{}, fmt.Errorf("decompress data: %w", err))
+			return
+		}
+		// Only the index of the shards is decoded here, so that the log of a
+		// file that is not sharded is still read one entry at a time.
+		var idx struct {
+			ShardSize int      `json:"shard_size"`
+			ShardSums [][]byte `json:"shard_sums"`
+		}
+		if wf.V >= shardedVersion {
+			if err := json.Unmarshal(dec, &idx); err != nil {
+				yield(LogEntry{}, fmt.Errorf("decode data: %w", err))
+				return
+			}
+		}
+		if idx.ShardSize > 0 {
+			err := wf.readShards(dataKey, idx.ShardSums, func(_ int, log []*logEntry) bool {
+				for _, e := range log {
+					if !yield(e.export(), nil) {
+						return false
+					}
+				}
+				return true
+			})
+			if err != nil {
+				yield(LogEntry{}, err)
+			}
+			return
+		}
+		err = scanLog(json.NewDecoder(bytes.NewReader(dec)), func(e *logEntry) bool {
+			return yield(e.export(), nil)
+		})
+		if err != nil {
+			yield(LogEntry{}, fmt.Errorf("decode data: %w", err))
+		}
+	}
+}
+
+// scanLog decodes the entries of the log from the JSON encoding of a database
+// read by dec, and calls f for each in turn until f returns false.
+func scanLog(dec *json.Decoder, f func(*logEntry) bool) error {
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		} else if tok != "log" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			var e logEntry
+			if err := dec.Decode(&e); err != nil {
+				return err
+			} else if !f(&e) {
+				return nil
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, '}')
+}
+
+// expectDelim reads the next token from dec and reports an error if it is
+// not the delimiter want.
+func expectDelim(dec *json.Decoder, want json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	} else if tok != want {
+		return fmt.Errorf("got %v, want %q", tok, want)
+	}
+	return nil
+}
+
+// Wrap constructs a new File from a plaintext payload in the format returned
+// by Unwrap, using the specified access key as New does. It reports an error
+// if the payload is not a valid encoding of a database.
+// The resulting file is marked as modified.
+func Wrap(accessKey, payload []byte) (*File, error) {
+	var p wirePayload
+	if err := json.Unmarshal(payload, &p); err != nil {
 		return nil, fmt.Errorf("decode data: %w", err)
+	} else if p.ShardSize != 0 {
+		return nil, errors.New("decode data: payload refers to log shards")
+	}
+	f, err := New(accessKey)
+	if err != nil {
+		return nil, err
+	}
+	f.db = newDatabase(p.Log, nil)
+	f.db.dirty = true
+	f.dbs = make(map[string]*Database)
+	for name, log := range p.databases() {
+		f.dbs[name] = newDatabase(log, nil)
+	}
+	return f, nil
+}
+
+// ReadKeySlots reads the unencrypted wrapper of a File from r, and returns
+// descriptions of its key slots. No access key is required.
+func ReadKeySlots(r io.Reader) ([]KeySlot, error) {
+	var wf wireFile
+	if err := json.NewDecoder(r).Decode(&wf); err != nil {
+		return nil, fmt.Errorf("decode file: %w", err)
+	} else if err := wf.checkVersion(); err != nil {
+		return nil, err
+	}
+	slots := wf.keySlots()
+	out := make([]KeySlot, len(slots))
+	for i, s := range slots {
+		out[i] = s.KeySlot
 	}
-	db.tabs = tablesFromLog(db.log)
-	return &File{
-		dataKeyEncrypted: wf.Key,
-		dataKeyPlain:     dataKey,
-		db:               &db,
-	}, nil
+	return out, nil
+}
+
+// VerifyKey reads the unencrypted wrapper of a File from r, and reports which
+// of its key slots can be unlocked by accessKey. It reports an error if no slot
+// matches. The data of the file are not decrypted.
+func VerifyKey(accessKey []byte, r io.Reader) (KeySlot, error) {
+	var wf wireFile
+	if err := json.NewDecoder(r).Decode(&wf); err != nil {
+		return KeySlot{}, fmt.Errorf("decode file: %w", err)
+	} else if err := wf.checkVersion(); err != nil {
+		return KeySlot{}, err
+	}
+	for _, s := range wf.keySlots() {
+		if dataKey, err := decryptWithKey(accessKey, s.key); err == nil {
+			clear(dataKey)
+			return s.KeySlot, nil
+		}
+	}
+	return KeySlot{}, ErrWrongKey
+}
+
+// Info describes the unencrypted wrapper of a File.
+type Info struct {
+	Version     int       // file format version
+	Cipher      string    // payload cipher
+	Compression string    // payload compression
+	Codec       string    // payload encoding
+	KeySlots    []KeySlot // descriptions of the key slots
+	DataLen     int       // length in bytes of the encrypted payload
+	Shards      int       // number of encrypted log shards, or 0 if not sharded
+}
+
+// ReadInfo reads the unencrypted wrapper of a File from r, and returns a
+// description of it. No access key is required.
+func ReadInfo(r io.Reader) (Info, error) {
+	var wf wireFile
+	if err := json.NewDecoder(r).Decode(&wf); err != nil {
+		return Info{}, fmt.Errorf("decode file: %w", err)
+	} else if err := wf.checkVersion(); err != nil {
+		return Info{}, err
+	}
+	info := Info{
+		Version:     int(wf.V),
+		Cipher:      wf.cipher(),
+		Compression: "snappy",
+		Codec:       "json",
+		DataLen:     len(wf.Data),
+		Shards:      len(wf.Shards),
+	}
+	for _, s := range wf.Shards {
+		info.DataLen += len(s)
+	}
+	for _, s := range wf.keySlots() {
+		info.KeySlots = append(info.KeySlots, s.KeySlot)
+	}
+	return info, nil
 }
 
 type wireFile struct {
-	V    int64  `json:"leaf"`
-	Key  []byte `json:"key"`
-	Data []byte `json:"data"`
+	wireHeader
+	Data   []byte   `json:"data"`
+	Shards [][]byte `json:"shards,omitempty"` // encrypted log shards (version 2 and later)
+}
+
+// wireHeader is the part of a wireFile that precedes its encrypted data.
+type wireHeader struct {
+	V      int64      `json:"leaf"`
+	Key    []byte     `json:"key,omitempty"`    // the default key slot
+	Slots  []wireSlot `json:"slots,omitempty"`  // additional key slots
+	Cipher string     `json:"cipher,omitempty"` // the payload cipher, if not the default
+}
+
+// writeTo writes the JSON encoding of wf to w. The result is the same as
+// json.Marshal(wf), but the data and shards of wf, which are large, are
+// encoded directly to w rather than into a buffer.
+func (wf *wireFile) writeTo(w io.Writer) (int64, error) {
+	hdr, err := json.Marshal(wf.wireHeader)
+	if err != nil {
+		return 0, fmt.Errorf("encode file: %w", err)
+	}
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	writeBase64 := func(data []byte) {
+		bw.WriteByte('"')
+		enc := base64.NewEncoder(base64.StdEncoding, bw)
+		enc.Write(data)
+		enc.Close()
+		bw.WriteByte('"')
+	}
+	bw.Write(hdr[:len(hdr)-1]) // without the closing brace
+	bw.WriteString(`,"data":`)
+	writeBase64(wf.Data)
+	if len(wf.Shards) != 0 {
+		bw.WriteString(`,"shards":[`)
+		for i, s := range wf.Shards {
+			if i != 0 {
+				bw.WriteByte(',')
+			}
+			writeBase64(s)
+		}
+		bw.WriteByte(']')
+	}
+	bw.WriteByte('}')
+	err = bw.Flush() // reports the first error from w, if any
+	return cw.n, err
+}
+
+// countWriter is an io.Writer that counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(data []byte) (int, error) {
+	n, err := c.w.Write(data)
+	c.n += int64(n)
+	return n, err
+}
+
+// checkVersion reports an error if wf has a format version not understood by
+// this package.
+func (wf *wireFile) checkVersion() error {
+	if wf.V < formatVersion || wf.V > maxVersion {
+		return fmt.Errorf("version mismatch: got %v, want %v to %v", wf.V, formatVersion, maxVersion)
+	}
+	return nil
+}
+
+// cipher returns the name of the payload cipher of wf.
+func (wf *wireFile) cipher() string {
+	if wf.Cipher == "" {
+		return CipherXChaCha20Poly1305
+	}
+	return wf.Cipher
+}
+
+type wireSlot struct {
+	Name   string          `json:"name"`
+	Key    []byte          `json:"key"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// keySlots returns the key slots of wf, with the default slot first.
+func (wf *wireFile) keySlots() []keySlot {
+	var out []keySlot
+	if len(wf.Key) != 0 {
+		out = append(out, keySlot{KeySlot: KeySlot{Name: DefaultKeySlot}, key: wf.Key})
+	}
+	for _, s := range wf.Slots {
+		out = append(out, keySlot{KeySlot: KeySlot{Name: s.Name, Params: s.Params}, key: s.Key})
+	}
+	return out
 }
 
 // Database is a database of key-value tables stored in a File.
 type Database struct {
-	log   []*logEntry
-	dirty bool // whether the log was modified
+	log     []*logEntry
+	dirty   bool   // whether the log was modified
+	chained bool   // whether new entries are hash-chained
+	note    string // the note recorded with new entries
 
 	saved  []*logEntry // the original state of a rewound database
 	wasMod bool        // whether saved was also dirty
 
+	seal *sealer // if not nil, values are sealed by it
+
 	tabs map[string]map[string]*logEntry
 }
 
@@ -166,7 +1048,9 @@ func (d *Database) IsModified() bool { return d.dirty }
 func (d *Database) TableNames() []string {
 	out := make([]string, 0, len(d.tabs))
 	for tab := range d.tabs {
-		out = append(out, tab)
+		if !isChunkTable(tab) {
+			out = append(out, tab)
+		}
 	}
 	sort.Strings(out)
 	return out
@@ -175,12 +1059,50 @@ func (d *Database) TableNames() []string {
 // GetTable reports whether d has a table by the given name, and if so returns
 // the table.
 func (d *Database) GetTable(name string) (Table, bool) {
-	if _, ok := d.tabs[name]; ok {
+	if _, ok := d.tabs[name]; ok && !isChunkTable(name) {
 		return Table{name: name, db: d}, true
 	}
 	return Table{}, false
 }
 
+// All returns an iterator over the tables of d, in sorted order by name.
+// Tables added to d during iteration are not visited, and tables deleted
+// during iteration are skipped.
+func (d *Database) All() iter.Seq2[string, Table] {
+	return func(yield func(string, Table) bool) {
+		for _, name := range d.TableNames() {
+			if _, ok := d.tabs[name]; !ok {
+				continue // deleted during iteration
+			}
+			if !yield(name, Table{name: name, db: d}) {
+				return
+			}
+		}
+	}
+}
+
+// A Record is a single key and value from a table of a database.
+type Record struct {
+	Table string
+	Key   string
+	Value json.RawMessage
+}
+
+// Records returns an iterator over the records of all the tables of d, in
+// sorted order by table name and then by key. The values yielded are copies,
+// and modifications of them do not affect the database.
+func (d *Database) Records() iter.Seq[Record] {
+	return func(yield func(Record) bool) {
+		for name, tab := range d.All() {
+			for key, val := range tab.All() {
+				if !yield(Record{Table: name, Key: key, Value: val}) {
+					return
+				}
+			}
+		}
+	}
+}
+
 // Table returns the table with the given name from db, creating it empty if it
 // does not exist.
 func (d *Database) Table(name string) Table {
@@ -196,6 +1118,10 @@ func (d *Database) DeleteTable(name string) bool {
 	if _, ok := d.tabs[name]; ok {
 		delete(d.tabs, name)
 		d.addLog(&logEntry{Op: opDeleteTable, A: name, TS: timeNow()})
+		if ct := chunkTableName(name); d.tabs[ct] != nil {
+			delete(d.tabs, ct)
+			d.addLog(&logEntry{Op: opDeleteTable, A: ct, TS: timeNow()})
+		}
 		return true
 	}
 	return false
@@ -212,18 +1138,33 @@ func (d *Database) Rewind(when time.Time) bool {
 	d.Revert() // in case there was a previous rewind
 
 	ts := when.UnixMicro()
-	var newLog []*logEntry
-	for _, e := range d.log {
-		if e.TS > ts {
-			break
+	n := 0
+	for n < len(d.log) && d.log[n].TS <= ts {
+		n++
+	}
+	return d.rewindTo(n)
+}
+
+// RewindTag rewinds the state of d to the point at which the named tag was
+// recorded, as Rewind does for a timestamp. It reports whether this changed
+// the visible state, or an error if d has no tag with that name.
+func (d *Database) RewindTag(name string) (bool, error) {
+	d.Revert()
+	for i, e := range d.log {
+		if e.Op == opTag && e.A == name {
+			return d.rewindTo(i + 1), nil
 		}
-		newLog = append(newLog, e)
 	}
-	isChanged := len(newLog) < len(d.log)
-	if isChanged {
-		d.saved, d.wasMod, d.log = d.log, d.dirty, newLog
+	return false, errorf(ErrNotFound, "tag %q not found", name)
+}
+
+// rewindTo truncates the log of d to its first n entries, saving the original
+// state for Revert. It reports whether the log changed.
+func (d *Database) rewindTo(n int) bool {
+	if n < len(d.log) {
+		d.saved, d.wasMod, d.log = d.log, d.dirty, d.log[:n:n]
 		d.dirty = true
-		d.tabs = tablesFromLog(d.log)
+		d.tabs = tablesFromLog(d.log, d.seal)
 		return true
 	}
 	return false
@@ -234,7 +1175,7 @@ func (d *Database) Rewind(when time.Time) bool {
 func (d *Database) Revert() {
 	if d.saved != nil {
 		d.log, d.dirty, d.saved = d.saved, d.wasMod, nil
-		d.tabs = tablesFromLog(d.log)
+		d.tabs = tablesFromLog(d.log, d.seal)
 	}
 }
 
@@ -247,14 +1188,109 @@ func (d *Database) Time() time.Time {
 	return time.UnixMicro(d.log[len(d.log)-1].TS)
 }
 
+// A LogEntry is a record of a change to a database.
+type LogEntry struct {
+	Op    string          // the operation, for example "update" or "delete-table"
+	Table string          // the table affected, or the name of a tag
+	Key   string          // the key affected, or the new name of a renamed table
+	Value json.RawMessage // the value stored, if any
+	Time  time.Time       // when the change was made
+	Note  string          // the note recorded with the change, if any
+}
+
+// Log returns the entries of the log of d, in order. Modifications of the
+// result do not affect the database.
+func (d *Database) Log() []LogEntry {
+	log := d.plainLog(d.log)
+	defer d.releaseLog(log)
+	out := make([]LogEntry, len(log))
+	for i, e := range log {
+		out[i] = e.export()
+	}
+	return out
+}
+
+// SetNote sets a note to be recorded in the log with each subsequent change
+// to d, explaining why it was made. The note remains in effect until it is
+// replaced by another call to SetNote; an empty note stops recording notes.
+// Notes are reported in the Note field of the log entries they annotate.
+//
+// Like tags, notes are discarded when the log is compacted.
+func (d *Database) SetNote(note string) { d.note = note }
+
+// Note returns the note currently being recorded with changes to d, or ""
+// if there is none.
+func (d *Database) Note() string { return d.note }
+
+// A Tag is a named marker recorded in the log of a database.
+type Tag struct {
+	Name string
+	Time time.Time // when the tag was recorded
+}
+
+// AddTag records a tag with the given name marking the current state of d, and
+// marks d as modified. It reports an error if name is empty or if d already
+// has a tag with that name.
+//
+// Use RewindTag to restore the state at which the tag was recorded.
+func (d *Database) AddTag(name string) error {
+	if name == "" {
+		return errors.New("empty tag name")
+	} else if _, ok := d.FindTag(name); ok {
+		return errorf(ErrExists, "tag %q already exists", name)
+	}
+	// Ensure the tag follows all existing entries, even if their timestamps
+	// came from a clock that was ahead of ours.
+	ts := timeNow()
+	if n := len(d.log); n != 0 && d.log[n-1].TS > ts {
+		ts = d.log[n-1].TS
+	}
+	d.addLog(&logEntry{Op: opTag, A: name, TS: ts})
+	return nil
+}
+
+// Tags returns the tags recorded in the log of d, in the order recorded.
+func (d *Database) Tags() []Tag {
+	var out []Tag
+	for _, e := range d.log {
+		if e.Op == opTag {
+			out = append(out, Tag{Name: e.A, Time: time.UnixMicro(e.TS)})
+		}
+	}
+	return out
+}
+
+// FindTag reports whether d has a tag with the given name, and if so returns
+// the tag.
+func (d *Database) FindTag(name string) (Tag, bool) {
+	for _, e := range d.log {
+		if e.Op == opTag && e.A == name {
+			return Tag{Name: e.A, Time: time.UnixMicro(e.TS)}, true
+		}
+	}
+	return Tag{}, false
+}
+
 // Snapshot returns a map of the current state of the database.  The keys of
 // the outer map are the names of the tables, the inner maps are the keys and
 // values. Modifications of the snapshot do not affect the database.
 func (d *Database) Snapshot() map[string]map[string]json.RawMessage {
+	tabs := maps.Clone(d.tabs)
+	maps.DeleteFunc(tabs, func(name string, _ map[string]*logEntry) bool { return isChunkTable(name) })
+	return snapshotOf(tabs, d.seal)
+}
+
+// snapshotOf returns a snapshot of tabs, whose values are sealed by
+// seal if it is not nil.
+func snapshotOf(tabs map[string]map[string]*logEntry, seal *sealer) map[string]map[string]json.RawMessage {
 	snap := make(map[string]map[string]json.RawMessage)
-	for name, tab := range d.tabs {
+	for name, tab := range tabs {
 		m := make(map[string]json.RawMessage)
 		for key, val := range tab {
+			if seal != nil {
+				m[key] = openValue(seal, val.C) // a new buffer
+				continue
+			}
 			cp := string(val.C) // don't alias the log
 			m[key] = json.RawMessage(cp)
 		}
@@ -263,28 +1299,387 @@ func (d *Database) Snapshot() map[string]map[string]json.RawMessage {
 	return snap
 }
 
+// Canonical writes a canonical plaintext encoding of the current state of d
+// to w. The encoding is an indented JSON object mapping each table name to an
+// object of its keys and values, in the format of Snapshot. Tables, keys, and
+// the fields of object values are in sorted order, and values are indented
+// uniformly; numbers are written as they were recorded. Databases
+// with the same contents have the same canonical encoding, however their
+// logs differ, so the encoding changes only when the data do.
+//
+// The encoding contains all values in plaintext.
+func (d *Database) Canonical(w io.Writer) error { return WriteCanonical(w, d.Snapshot()) }
+
+// WriteCanonical writes the canonical encoding of snap, in the format of
+// Snapshot, to w. This is the encoding written by Database.Canonical, for a
+// snapshot that has been filtered or otherwise modified.
+func WriteCanonical(w io.Writer, snap map[string]map[string]json.RawMessage) error {
+	state := make(map[string]map[string]any, len(snap))
+	for name, tab := range snap {
+		m := make(map[string]any, len(tab))
+		for key, val := range tab {
+			dec := json.NewDecoder(bytes.NewReader(val))
+			dec.UseNumber() // preserve numbers exactly
+			var v any
+			if err := dec.Decode(&v); err != nil {
+				return fmt.Errorf("table %q key %q: %w", name, key, err)
+			}
+			m[key] = v
+		}
+		state[name] = m
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(state)
+}
+
+// LogLen reports the number of entries in the log of d.
+func (d *Database) LogLen() int { return len(d.log) }
+
 // Compact compacts the log of d to the current state of the database.
+// Compaction discards any tags recorded in the log.
 func (d *Database) Compact() {
+	if snap := d.compactEntry(); snap != nil {
+		d.log = []*logEntry{snap}
+		d.dirty = true
+	}
+}
+
+// compactEntry returns the snapshot entry with which Compact replaces the
+// log of d, or nil if compaction would not change the log.
+func (d *Database) compactEntry() *logEntry {
 	if len(d.log) == 0 {
-		return
+		return nil
+	}
+	cur := d.encodeTables(d.tabs)
+	defer d.release(cur)
+	if len(d.log) == 1 && d.log[0].Op == opSnapshot {
+		old := d.value(d.log[0])
+		same := bytes.Equal(cur, old)
+		d.release(old)
+		if same {
+			return nil // nothing to do
+		}
+	}
+	snap := &logEntry{Op: opSnapshot, C: sealValue(d.seal, cur), TS: timeNow()}
+	if d.chained {
+		// The snapshot is anchored to the last entry it replaces.
+		snap.P = d.hash(d.log[len(d.log)-1])
+	}
+	return snap
+}
+
+// Prune discards the history of d up to the specified time, replacing the
+// log entries recorded at or before that time with a snapshot of the state
+// they produce. Later entries are kept, so d can still be rewound to a time
+// after before. Prune reports the number of log entries discarded; if this is
+// not zero, the database is marked as modified. Pruning discards any tags
+// recorded at or before that time.  If d is hash-chained, the entries
+// following the snapshot are linked again, which changes its ChainHead.
+func (d *Database) Prune(before time.Time) int {
+	n := d.pruneLen(before)
+	snap := d.pruneEntry(n)
+	if snap == nil {
+		return 0
+	}
+	d.log = append([]*logEntry{snap}, d.log[n:]...)
+	d.dirty = true
+	if d.chained {
+		d.relink(1)
+	}
+	return n - 1
+}
+
+// pruneLen returns the number of log entries of d recorded at or before the
+// specified time.
+func (d *Database) pruneLen(before time.Time) int {
+	ts := before.UnixMicro()
+	n := 0
+	for n < len(d.log) && d.log[n].TS <= ts {
+		n++
+	}
+	return n
+}
+
+// pruneEntry returns the snapshot entry with which Prune replaces the first
+// n entries of the log of d, or nil if pruning would not shorten the log.
+func (d *Database) pruneEntry(n int) *logEntry {
+	if n < 2 {
+		return nil
 	}
-	cur, err := json.Marshal(d.Snapshot())
+	cur := d.encodeTables(tablesFromLog(d.log[:n], d.seal))
+	defer d.release(cur)
+	snap := &logEntry{Op: opSnapshot, C: sealValue(d.seal, cur), TS: d.log[n-1].TS}
+	if d.chained {
+		snap.P = d.hash(d.log[n-1])
+	}
+	return snap
+}
+
+// GarbageStats describes how much of the log of a database maintenance would
+// reclaim. Sizes are of the JSON encoding of the log, before compression and
+// encryption, and are approximate.
+type GarbageStats struct {
+	LogEntries int // the number of entries in the log
+	LogBytes   int // the encoded size of the log
+
+	CompactEntries int // the number of entries Compact would discard
+	CompactBytes   int // the number of bytes Compact would reclaim
+	PruneEntries   int // the number of entries Prune(before) would discard
+	PruneBytes     int // the number of bytes Prune(before) would reclaim
+}
+
+// GarbageStats reports how many log entries and encoded bytes would be
+// reclaimed by compacting d, or by pruning it at the specified time.  Pass
+// the zero time to report only on compaction. It does not modify d.
+func (d *Database) GarbageStats(before time.Time) GarbageStats {
+	sizes := make([]int, len(d.log)+1) // sizes[i] is the size of d.log[:i]
+	for i, e := range d.log {
+		sizes[i+1] = sizes[i] + d.entrySize(e)
+	}
+	gs := GarbageStats{LogEntries: len(d.log), LogBytes: sizes[len(d.log)]}
+	if snap := d.compactEntry(); snap != nil {
+		gs.CompactEntries = len(d.log) - 1
+		gs.CompactBytes = max(0, gs.LogBytes-d.entrySize(snap))
+	}
+	n := d.pruneLen(before)
+	if snap := d.pruneEntry(n); snap != nil {
+		gs.PruneEntries = n - 1
+		gs.PruneBytes = max(0, sizes[n]-d.entrySize(snap))
+	}
+	return gs
+}
+
+// entrySize returns the size of the encoding of e, an entry of d, in a log.
+func (d *Database) entrySize(e *logEntry) int {
+	if d.seal != nil {
+		p := d.plainLog([]*logEntry{e})
+		defer d.releaseLog(p)
+		e = p[0]
+	}
+	bits, err := json.Marshal(e)
 	if err != nil {
 		panic(err)
 	}
-	if len(d.log) == 1 && d.log[0].Op == opSnapshot && bytes.Equal(cur, d.log[0].C) {
-		return // nothing to do
+	defer d.release(bits)
+	return len(bits) + 1 // for the separator
+}
+
+// Merge merges the log of other into the log of d, and reports the number of
+// log entries added to d. If the log of d changed, d is marked as modified.
+//
+// The merged log contains the entries common to both logs, followed by the
+// remaining entries of each log interleaved in timestamp order. An entry that
+// appears in both logs is included only once. Merging does not modify other.
+// If d is hash-chained, the entries following the common prefix are linked
+// again in their merged order.
+//
+// A change to a table that follows the deletion or renaming of that table in
+// the merged log, from either side, is discarded. It was made without
+// knowledge of the deletion, and replaying it would restore the table.
+func (d *Database) Merge(other *Database) int {
+	// Find the longest common prefix of the logs.
+	n := 0
+	for n < len(d.log) && n < len(other.log) && d.sameEntry(d.log[n], other, other.log[n]) {
+		n++
+	}
+	if n == len(other.log) {
+		return 0 // nothing new in other
 	}
-	d.log = []*logEntry{{Op: opSnapshot, C: cur, TS: timeNow()}}
-	d.dirty = true
+
+	// Merge the remaining entries in timestamp order, preferring entries from
+	// d when timestamps are equal, and skipping duplicates.
+	mine, theirs := d.log[n:], other.log[n:]
+	merged := append([]*logEntry(nil), d.log[:n]...)
+	gone := make(tableSet)
+	var added, dropped int
+	for len(mine) != 0 || len(theirs) != 0 {
+		if len(theirs) == 0 || (len(mine) != 0 && mine[0].TS <= theirs[0].TS) {
+			if gone.keep(mine[0]) {
+				merged = append(merged, mine[0])
+			} else {
+				dropped++
+			}
+			mine = mine[1:]
+			continue
+		}
+		e := theirs[0]
+		theirs = theirs[1:]
+		if !d.containsEntry(d.log[n:], other, e) && gone.keep(e) {
+			merged = append(merged, d.adopt(other, e))
+			added++
+		}
+	}
+	if added != 0 || dropped != 0 {
+		d.log = merged
+		d.dirty = true
+		d.tabs = tablesFromLog(d.log, d.seal)
+		if d.chained {
+			d.relink(n)
+		}
+	}
+	return added
+}
+
+// A tableSet records the tables deleted or renamed away in a merged log.
+type tableSet map[string]bool
+
+// keep updates gone to reflect e, the next entry of a merged log, and reports
+// whether e should be kept. Changes to a table in gone are not kept.
+func (gone tableSet) keep(e *logEntry) bool {
+	switch e.Op {
+	case opCreateTable:
+		delete(gone, e.A)
+	case opDeleteTable:
+		gone[e.A] = true
+	case opRenameTable:
+		if gone[e.A] {
+			return false
+		}
+		gone[e.A] = true
+		delete(gone, e.B)
+	case opUpdateKey, opDeleteKey, opClearTable:
+		return !gone[e.A]
+	case opSnapshot:
+		clear(gone)
+	}
+	return true
+}
+
+// containsEntry reports whether log, a portion of the log of d, contains an
+// entry the same as e, an entry of other.
+func (d *Database) containsEntry(log []*logEntry, other *Database, e *logEntry) bool {
+	for _, le := range log {
+		if d.sameEntry(le, other, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// chainGenesis is the link recorded in the first entry of a hash chain.
+var chainGenesis [sha256.Size]byte
+
+// isChained reports whether log is hash-chained, meaning that its last entry
+// is linked to its predecessor.
+func isChained(log []*logEntry) bool { return len(log) != 0 && log[len(log)-1].P != nil }
+
+// relink recomputes the links of the entries of d from index i onward. The
+// entries are copied, since they may be shared with a saved log.
+func (d *Database) relink(i int) {
+	for ; i < len(d.log); i++ {
+		cp := *d.log[i]
+		cp.P = chainGenesis[:]
+		if i != 0 {
+			cp.P = d.hash(d.log[i-1])
+		}
+		d.log[i] = &cp
+	}
+	d.tabs = tablesFromLog(d.log, d.seal)
+}
+
+// EnableChain enables hash chaining for the log of d. Each entry of the log,
+// including the existing entries, records the hash of the entry before it,
+// so that the log cannot be altered, reordered, or truncated at the front
+// without VerifyChain detecting it. If chaining was not already enabled, d is
+// marked as modified. An empty database records the chain once an entry is
+// added to it.
+//
+// Compacting a chained log anchors the new snapshot to the last entry it
+// replaces, and merging links the merged entries again in their new order.
+func (d *Database) EnableChain() {
+	if d.chained {
+		return
+	}
+	d.chained = true
+	if len(d.log) != 0 {
+		d.relink(0)
+		d.dirty = true
+	}
+}
+
+// IsChained reports whether the log of d is hash-chained.
+func (d *Database) IsChained() bool { return d.chained }
+
+// ChainHead returns the hash of the last entry of the log of d, or nil if d
+// is not chained or is empty. A head recorded outside the file can later be
+// passed to VerifyChain, to check that the log still contains that state.
+func (d *Database) ChainHead() []byte {
+	if !d.chained || len(d.log) == 0 {
+		return nil
+	}
+	return d.hash(d.log[len(d.log)-1])
+}
+
+// VerifyChain checks the hash chain of the log of d. It reports an error if d
+// is not chained, or if any entry is not linked to the entry before it, as
+// happens if entries are inserted, removed, reordered, or modified by someone
+// who does not relink the rest of the log.
+//
+// Someone who has the access key can still rewrite the log and relink it, or
+// remove entries from its end. To detect this, record the ChainHead of the
+// log outside the file. If head != nil, VerifyChain also reports an error
+// unless head is the hash of an entry of the log, or of the last entry
+// replaced by a compaction at the start of the log.
+func (d *Database) VerifyChain(head []byte) error {
+	if !d.chained {
+		return errors.New("log is not hash-chained")
+	}
+	found := head == nil
+	for i, e := range d.log {
+		switch {
+		case e.P == nil:
+			return fmt.Errorf("log entry %d is not chained", i)
+		case i != 0 && !bytes.Equal(e.P, d.hash(d.log[i-1])):
+			return fmt.Errorf("log entry %d does not follow entry %d", i, i-1)
+		case i == 0 && !bytes.Equal(e.P, chainGenesis[:]):
+			// Only a snapshot left by compaction may begin a chain elsewhere.
+			if e.Op != opSnapshot {
+				return errors.New("log entry 0 does not begin the chain")
+			}
+			found = found || bytes.Equal(e.P, head)
+		}
+		found = found || bytes.Equal(d.hash(e), head)
+	}
+	if !found {
+		return errors.New("log does not contain the chain head")
+	}
+	return nil
 }
 
 type wireDB struct {
 	Log []*logEntry `json:"log"`
 }
 
+// A wirePayload is the encoding of the plaintext payload of a File: the log
+// of its default database, and its named databases, if any.
+type wirePayload struct {
+	Log []*logEntry        `json:"log"`
+	DBs map[string]*wireDB `json:"dbs,omitempty"`
+
+	// If the log of the default database is sharded, Log is empty, and the
+	// shards are stored in the file. This is the index of the shards.
+	ShardSize int      `json:"shard_size,omitempty"` // log entries per shard
+	ShardSums [][]byte `json:"shard_sums,omitempty"` // SHA-256 of each encrypted shard
+}
+
+// databases returns the logs of the named databases of p, omitting any that
+// are empty. The logs are not replayed, so that they can be checked first.
+func (p *wirePayload) databases() map[string][]*logEntry {
+	out := make(map[string][]*logEntry)
+	for name, db := range p.DBs {
+		if db != nil && len(db.Log) != 0 && name != "" {
+			out[name] = db.Log
+		}
+	}
+	return out
+}
+
 func (d Database) MarshalJSON() ([]byte, error) {
-	return json.Marshal(wireDB{Log: d.log})
+	log := d.plainLog(d.log)
+	defer d.releaseLog(log)
+	return json.Marshal(wireDB{Log: log})
 }
 
 func (d *Database) UnmarshalJSON(data []byte) error {
@@ -293,41 +1688,41 @@ func (d *Database) UnmarshalJSON(data []byte) error {
 		return err
 	}
 	d.log = wdb.Log
-	d.tabs = tablesFromLog(d.log)
+	d.tabs = tablesFromLog(d.log, d.seal)
+	d.chained = isChained(d.log)
 	return nil
 }
 
-func newDatabase(log []*logEntry) *Database { return &Database{log: log, tabs: tablesFromLog(log)} }
+// newDatabase constructs a database with the given log. If seal != nil,
+// the values of log must already be sealed by it.
+func newDatabase(log []*logEntry, seal *sealer) *Database {
+	return &Database{log: log, chained: isChained(log), seal: seal, tabs: tablesFromLog(log, seal)}
+}
 
-func tablesFromLog(log []*logEntry) map[string]map[string]*logEntry {
-	m := make(map[string]map[string]*logEntry)
-	for _, e := range log {
-		switch e.Op {
-		case opCreateTable:
-			if m[e.A] == nil {
-				m[e.A] = make(map[string]*logEntry)
-			}
-		case opDeleteTable:
-			delete(m, e.A)
-		case opRenameTable:
-			old := m[e.A]
-			delete(m, e.A)
-			m[e.B] = old
-		case opClearTable:
-			clear(m[e.A])
-		case opUpdateKey:
-			m[e.A][e.B] = e
-		case opDeleteKey:
-			delete(m[e.A], e.B)
-		case opSnapshot:
-			clear(m)
-			unmarshalOrPanic(e.C, &m)
-		}
+// addLog adds e to the log of d. If the values of d are sealed, the value of
+// e is sealed, and its plaintext is wiped.
+func (d *Database) addLog(e *logEntry) {
+	if d.seal != nil && e.C != nil {
+		plain := e.C
+		e.C = sealValue(d.seal, plain)
+		clear(plain)
 	}
-	return m
+	d.addSealedLog(e)
 }
 
-func (d *Database) addLog(e *logEntry) { d.log = append(d.log, e); d.dirty = true }
+// addSealedLog is as addLog, for an entry whose value is already sealed as
+// the values of d are.
+func (d *Database) addSealedLog(e *logEntry) {
+	e.N = d.note
+	if d.chained {
+		e.P = chainGenesis[:]
+		if n := len(d.log); n != 0 {
+			e.P = d.hash(d.log[n-1])
+		}
+	}
+	d.log = append(d.log, e)
+	d.dirty = true
+}
 
 type logEntry struct {
 	Op string          `json:"op"`
@@ -335,6 +1730,50 @@ type logEntry struct {
 	B  string          `json:"key,omitempty"`
 	C  json.RawMessage `json:"val,omitempty"`
 	TS int64           `json:"clk,string"`
+	P  []byte          `json:"prev,omitempty"` // hash of the previous entry, if chained
+	N  string          `json:"note,omitempty"` // a note explaining the change
+}
+
+// hash returns the SHA-256 hash of e, including its link to the previous
+// entry. Each field is hashed with its length, and the value is compacted,
+// so that the hash does not depend on how the entry was encoded.
+func (e *logEntry) hash() []byte {
+	h := sha256.New()
+	var buf []byte
+	field := func(b []byte) {
+		buf = binary.AppendUvarint(buf[:0], uint64(len(b)))
+		h.Write(buf)
+		h.Write(b)
+	}
+	field([]byte(e.Op))
+	field([]byte(e.A))
+	field([]byte(e.B))
+	var val bytes.Buffer
+	if json.Compact(&val, e.C) != nil {
+		val.Reset()
+		val.Write(e.C)
+	}
+	field(val.Bytes())
+	field(binary.BigEndian.AppendUint64(nil, uint64(e.TS)))
+	field(e.P)
+	if e.N != "" {
+		// Only entries with a note hash it, so that adding notes did not
+		// change the hashes of existing entries.
+		field([]byte(e.N))
+	}
+	return h.Sum(nil)
+}
+
+// export returns a LogEntry for e that does not alias it.
+func (e *logEntry) export() LogEntry {
+	return LogEntry{
+		Op:    e.Op,
+		Table: e.A,
+		Key:   e.B,
+		Value: bytes.Clone(e.C),
+		Time:  time.UnixMicro(e.TS),
+		Note:  e.N,
+	}
 }
 
 // A Table is a mapping of string keys to JSON-marshalable values.
@@ -349,7 +1788,9 @@ func (t Table) Get(key string, val any) bool {
 	e, ok := t.db.tabs[t.name][key]
 	if ok {
 		if val != nil {
-			unmarshalOrPanic(e.C, val)
+			v := t.db.value(e)
+			defer t.db.release(v)
+			unmarshalOrPanic(v, val)
 		}
 		return true
 	}
@@ -364,6 +1805,42 @@ func Get[T any](t Table, key string) (T, bool) {
 	return val, ok
 }
 
+// GetMulti returns a map of the values of the specified keys in t. Keys that
+// are not present in t are omitted from the result. The values are copies,
+// and modifications of them do not affect the table.
+func (t Table) GetMulti(keys []string) map[string]json.RawMessage {
+	tab := t.db.tabs[t.name]
+	out := make(map[string]json.RawMessage, len(keys))
+	for _, key := range keys {
+		if e, ok := tab[key]; ok {
+			out[key] = t.db.clone(e)
+		}
+	}
+	return out
+}
+
+// GetMulti returns a map of the values of the specified keys in t. Keys that
+// are not present in t are omitted from the result.
+func GetMulti[T any](t Table, keys []string) map[string]T {
+	tab := t.db.tabs[t.name]
+	out := make(map[string]T, len(keys))
+	for _, key := range keys {
+		if e, ok := tab[key]; ok {
+			out[key] = unmarshalValue[T](t.db, e)
+		}
+	}
+	return out
+}
+
+// unmarshalValue returns the value of e, an entry of d, unmarshaled into a T.
+func unmarshalValue[T any](d *Database, e *logEntry) T {
+	var val T
+	v := d.value(e)
+	defer d.release(v)
+	unmarshalOrPanic(v, &val)
+	return val
+}
+
 // Keys returns a slice of the keys of t in lexicographic (sorted) order.
 func (t Table) Keys() []string {
 	tab := t.db.tabs[t.name]
@@ -375,15 +1852,45 @@ func (t Table) Keys() []string {
 	return out
 }
 
+// All returns an iterator over the keys of t and their values, in
+// lexicographic (sorted) order by key. The values yielded are copies, and
+// modifications of them do not affect the table. Keys added to t during
+// iteration are not visited, and keys deleted during iteration are skipped.
+func (t Table) All() iter.Seq2[string, json.RawMessage] {
+	return func(yield func(string, json.RawMessage) bool) {
+		for _, key := range t.Keys() {
+			e, ok := t.db.tabs[t.name][key]
+			if !ok {
+				continue // deleted during iteration
+			}
+			if !yield(key, t.db.clone(e)) {
+				return
+			}
+		}
+	}
+}
+
 // AsMap returns a map of the values of t. The resulting map is independent of
 // the table, and modifications of it do not affect the table.
 func AsMap[T any](t Table) map[string]T {
 	tab := t.db.tabs[t.name]
 	m := make(map[string]T, len(tab))
 	for key, e := range tab {
-		var val T
-		unmarshalOrPanic(e.C, &val)
-		m[key] = val
+		m[key] = unmarshalValue[T](t.db, e)
+	}
+	return m
+}
+
+// Filter returns a map of the values of t for which keep reports true. Each
+// value is unmarshaled into a T before it is passed to keep. As with AsMap,
+// the resulting map is independent of the table.
+func Filter[T any](t Table, keep func(key string, val T) bool) map[string]T {
+	m := make(map[string]T)
+	for key, e := range t.db.tabs[t.name] {
+		val := unmarshalValue[T](t.db, e)
+		if keep(key, val) {
+			m[key] = val
+		}
 	}
 	return m
 }
@@ -398,9 +1905,35 @@ func (t Table) Set(key string, val any) bool {
 	_, isOld := tab[key]
 	tab[key] = &logEntry{Op: opUpdateKey, A: t.name, B: key, C: bits, TS: timeNow()}
 	t.db.addLog(tab[key])
+	t.dropChunks(key, "")
 	return !isOld
 }
 
+// Swap stores newVal as the value of key in t, and reports whether key
+// previously had a value. If so, and old != nil, the previous value is
+// unmarshaled into old. If the previous value cannot be unmarshaled into
+// old, Swap panics without modifying t.
+//
+// Swap is useful when the old value must be acted upon after it is replaced,
+// for example to revoke a credential after storing its replacement.
+func (t Table) Swap(key string, newVal, old any) bool {
+	bits, err := json.Marshal(newVal)
+	if err != nil {
+		panic(err)
+	}
+	tab := t.db.tabs[t.name]
+	prev, isOld := tab[key]
+	if isOld && old != nil {
+		v := t.db.value(prev)
+		defer t.db.release(v)
+		unmarshalOrPanic(v, old)
+	}
+	tab[key] = &logEntry{Op: opUpdateKey, A: t.name, B: key, C: bits, TS: timeNow()}
+	t.db.addLog(tab[key])
+	t.dropChunks(key, "")
+	return isOld
+}
+
 // SetMap adds or updates the values in t to the corresponding entries from m.
 func SetMap[T any](t Table, m map[string]T) {
 	for key, val := range m {
@@ -408,12 +1941,43 @@ func SetMap[T any](t Table, m map[string]T) {
 	}
 }
 
+// SetMany adds or updates the values in t to the corresponding entries from
+// m, in sorted order by key. Unlike SetMap, it does not panic if a value
+// cannot be marshaled: instead, it reports an error for each such key, and
+// leaves t unmodified. It returns nil if all the values were stored.
+func SetMany[T any](t Table, m map[string]T) map[string]error {
+	bits := make(map[string][]byte, len(m))
+	var errs map[string]error
+	for key, val := range m {
+		b, err := json.Marshal(val)
+		if err != nil {
+			if errs == nil {
+				errs = make(map[string]error)
+			}
+			errs[key] = err
+			continue
+		}
+		bits[key] = b
+	}
+	if errs != nil {
+		return errs
+	}
+	tab := t.db.tabs[t.name]
+	for _, key := range slices.Sorted(maps.Keys(bits)) {
+		tab[key] = &logEntry{Op: opUpdateKey, A: t.name, B: key, C: bits[key], TS: timeNow()}
+		t.db.addLog(tab[key])
+		t.dropChunks(key, "")
+	}
+	return nil
+}
+
 // Delete removes key from t and reports whether it was present.
 func (t Table) Delete(key string) bool {
 	tab := t.db.tabs[t.name]
 	if _, ok := tab[key]; ok {
 		delete(tab, key)
 		t.db.addLog(&logEntry{Op: opDeleteKey, A: t.name, B: key, TS: timeNow()})
+		t.dropChunks(key, "")
 		return true
 	}
 	return false
@@ -427,15 +1991,28 @@ func (t *Table) Rename(newName string) {
 	t.db.tabs[newName] = t.db.tabs[t.name]
 	delete(t.db.tabs, t.name)
 	t.db.addLog(&logEntry{Op: opRenameTable, A: t.name, B: newName, TS: timeNow()})
+
+	// The chunks of the values of t move with it, and chunks left by a table
+	// previously named newName are discarded.
+	oc, nc := chunkTableName(t.name), chunkTableName(newName)
+	if ct, ok := t.db.tabs[oc]; ok {
+		t.db.tabs[nc] = ct
+		delete(t.db.tabs, oc)
+		t.db.addLog(&logEntry{Op: opRenameTable, A: oc, B: nc, TS: timeNow()})
+	} else if _, ok := t.db.tabs[nc]; ok {
+		delete(t.db.tabs, nc)
+		t.db.addLog(&logEntry{Op: opDeleteTable, A: nc, TS: timeNow()})
+	}
 	t.name = newName
 }
 
 // Clear removes all the keys from t.
 func (t Table) Clear() {
-	tab := t.db.tabs[t.name]
-	if len(tab) != 0 {
-		clear(tab)
-		t.db.addLog(&logEntry{Op: opClearTable, A: t.name, TS: timeNow()})
+	for _, name := range []string{t.name, chunkTableName(t.name)} {
+		if tab := t.db.tabs[name]; len(tab) != 0 {
+			clear(tab)
+			t.db.addLog(&logEntry{Op: opClearTable, A: name, TS: timeNow()})
+		}
 	}
 }
 
@@ -444,8 +2021,28 @@ func (t Table) Len() int { return len(t.db.tabs[t.name]) }
 
 func timeNow() int64 { return time.Now().UnixMicro() }
 
+// newAEAD returns an AEAD for the named cipher with the given key.
+func newAEAD(name string, key []byte) (cipher.AEAD, error) {
+	switch name {
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	case CipherAESSIV:
+		return newSIV(key)
+	default:
+		return nil, fmt.Errorf("unknown cipher %q", name)
+	}
+}
+
 func decryptWithKey(key, data []byte) ([]byte, error) {
-	aead, err := chacha20poly1305.NewX(key)
+	return decryptWith(CipherXChaCha20Poly1305, key, data)
+}
+
+func encryptWithKey(key, data []byte) ([]byte, error) {
+	return encryptWith(CipherXChaCha20Poly1305, key, data)
+}
+
+func decryptWith(name string, key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(name, key)
 	if err != nil {
 		return nil, fmt.Errorf("initialize key: %w", err)
 	}
@@ -456,8 +2053,44 @@ func decryptWithKey(key, data []byte) ([]byte, error) {
 	return aead.Open(nil, nonce, ctext, nil)
 }
 
-func encryptWithKey(key, data []byte) ([]byte, error) {
-	aead, err := chacha20poly1305.NewX(key)
+// encryptPayload compresses data and encrypts the result with the named
+// cipher. The data are compressed into the space following the nonce, and
+// encrypted in place, so that only one buffer is allocated.
+func encryptPayload(name string, key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, fmt.Errorf("initialize key: %w", err)
+	}
+	zlen := snappy.MaxEncodedLen(len(data))
+	if zlen < 0 {
+		return nil, snappy.ErrTooLarge
+	}
+	ns := aead.NonceSize()
+	buf := make([]byte, ns+zlen+aead.Overhead())
+	nonce := buf[:ns]
+	if _, err := cryptorand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	z := snappy.Encode(buf[ns:ns+zlen], data)
+	return aead.Seal(nonce, nonce, z, nil), nil
+}
+
+// decryptInPlace decrypts data as decryptWith does, but the plaintext
+// replaces the contents of data, to avoid allocating a second buffer.
+func decryptInPlace(name string, key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(name, key)
+	if err != nil {
+		return nil, fmt.Errorf("initialize key: %w", err)
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("malformed input: short nonce")
+	}
+	nonce, ctext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(ctext[:0], nonce, ctext, nil)
+}
+
+func encryptWith(name string, key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(name, key)
 	if err != nil {
 		return nil, fmt.Errorf("initialize key: %w", err)
 	}
@@ -3,8 +3,17 @@ package leaf_test
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/rand/v2"
+	"slices"
 	"sort"
+	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/creachadair/leaf"
 	"github.com/creachadair/mds/slice"
@@ -61,6 +70,431 @@ func TestRekey(t *testing.T) {
 	})
 }
 
+func TestKeySlots(t *testing.T) {
+	const testKey1 = "00000000000000000000000000000000"
+	const testKey2 = "11111111111111111111111111111111"
+
+	f, err := leaf.New([]byte(testKey1))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	f.Database().Table("test").Set("x", 1)
+
+	other := leaf.KeySlot{Name: "other", Params: json.RawMessage(`{"hint":"x"}`)}
+	if err := f.AddKeySlot(other, []byte(testKey2)); err != nil {
+		t.Fatalf("AddKeySlot: %v", err)
+	}
+	if err := f.AddKey("other", []byte(testKey2)); err == nil {
+		t.Error("AddKey: duplicate slot should have failed")
+	}
+	wantSlots := []leaf.KeySlot{{Name: leaf.DefaultKeySlot}, other}
+	if diff := cmp.Diff(f.KeySlots(), wantSlots); diff != "" {
+		t.Errorf("KeySlots (-got, +want):\n%s", diff)
+	}
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data := buf.String()
+
+	// The slots should be readable without a key.
+	slots, err := leaf.ReadKeySlots(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadKeySlots: %v", err)
+	}
+	if diff := cmp.Diff(slots, wantSlots); diff != "" {
+		t.Errorf("ReadKeySlots (-got, +want):\n%s", diff)
+	}
+	info, err := leaf.ReadInfo(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	}
+	if diff := cmp.Diff(info.KeySlots, wantSlots); diff != "" {
+		t.Errorf("ReadInfo slots (-got, +want):\n%s", diff)
+	}
+	if info.Version != 1 || info.DataLen == 0 {
+		t.Errorf("ReadInfo: got %+v, want version 1 with data", info)
+	}
+
+	// Each key should match its own slot.
+	for i, key := range []string{testKey1, testKey2} {
+		ks, err := leaf.VerifyKey([]byte(key), strings.NewReader(data))
+		if err != nil {
+			t.Errorf("VerifyKey %q: %v", key, err)
+		} else if ks.Name != wantSlots[i].Name {
+			t.Errorf("VerifyKey %q: got slot %q, want %q", key, ks.Name, wantSlots[i].Name)
+		}
+	}
+	if ks, err := leaf.VerifyKey([]byte("22222222222222222222222222222222"), strings.NewReader(data)); err == nil {
+		t.Errorf("VerifyKey: got %+v, want error", ks)
+	}
+
+	// Either key should open the file.
+	for _, key := range []string{testKey1, testKey2} {
+		g, err := leaf.Open([]byte(key), strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("Open %q: %v", key, err)
+		}
+		diffData(t, g.Database(), f.Database())
+	}
+
+	// After removing the default slot, only the other key should work.
+	if err := f.RemoveKey(leaf.DefaultKeySlot); err != nil {
+		t.Fatalf("RemoveKey: %v", err)
+	}
+	if err := f.RemoveKey("other"); err == nil {
+		t.Error("RemoveKey: removing the last slot should have failed")
+	}
+	buf.Reset()
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data = buf.String()
+	if g, err := leaf.Open([]byte(testKey1), strings.NewReader(data)); err == nil {
+		t.Errorf("Open with removed key: got %+v, want error", g)
+	}
+	if _, err := leaf.Open([]byte(testKey2), strings.NewReader(data)); err != nil {
+		t.Errorf("Open with other key: %v", err)
+	}
+
+	// After replacing the other slot, only the replacement key should work.
+	const testKey3 = "22222222222222222222222222222222"
+	if err := f.ReplaceKeySlot(leaf.KeySlot{Name: "other"}, []byte(testKey3)); err != nil {
+		t.Fatalf("ReplaceKeySlot: %v", err)
+	}
+	if err := f.ReplaceKeySlot(leaf.KeySlot{Name: "nonesuch"}, []byte(testKey3)); err == nil {
+		t.Error("ReplaceKeySlot: replacing a missing slot should have failed")
+	}
+	buf.Reset()
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data = buf.String()
+	if g, err := leaf.Open([]byte(testKey2), strings.NewReader(data)); err == nil {
+		t.Errorf("Open with replaced key: got %+v, want error", g)
+	}
+	if _, err := leaf.Open([]byte(testKey3), strings.NewReader(data)); err != nil {
+		t.Errorf("Open with replacement key: %v", err)
+	}
+}
+
+func TestRotateDataKey(t *testing.T) {
+	const keyA = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	const keyB = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+	f, err := leaf.New([]byte(keyA))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := f.AddKey("b", []byte(keyB)); err != nil {
+		t.Fatalf("AddKey: %v", err)
+	}
+	f.SetShardSize(2)
+	leaf.SetMap(f.Database().Table("test"), map[string]int{"x": 1, "y": 2, "z": 3})
+	if _, err := f.WriteTo(io.Discard); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// Each slot must have a key that opens it.
+	for _, keys := range []map[string][]byte{
+		{leaf.DefaultKeySlot: []byte(keyA)},
+		{leaf.DefaultKeySlot: []byte(keyA), "b": []byte(keyA)},
+		{leaf.DefaultKeySlot: []byte(keyA), "b": []byte(keyB), "c": []byte(keyB)},
+	} {
+		if err := f.RotateDataKey(keys); err == nil {
+			t.Errorf("RotateDataKey %v: got nil, want error", slices.Sorted(maps.Keys(keys)))
+		} else if f.IsModified() {
+			t.Errorf("RotateDataKey %v: file is modified after error", slices.Sorted(maps.Keys(keys)))
+		}
+	}
+
+	if err := f.RotateDataKey(map[string][]byte{
+		leaf.DefaultKeySlot: []byte(keyA), "b": []byte(keyB),
+	}); err != nil {
+		t.Fatalf("RotateDataKey: %v", err)
+	} else if !f.IsModified() {
+		t.Error("RotateDataKey: file is not marked as modified")
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	for _, key := range []string{keyA, keyB} {
+		g, err := leaf.Open([]byte(key), bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		checkTab(t, g.Database().Table("test"), map[string]int{"x": 1, "y": 2, "z": 3})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	const testKey = "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+	const wrongKey = "wwwwwwwwwwwwwwwwwwwwwwwwwwwwwwww"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := f.AddKey(leaf.DefaultKeySlot, []byte(testKey)); !errors.Is(err, leaf.ErrExists) {
+		t.Errorf("AddKey existing: got %v, want %v", err, leaf.ErrExists)
+	}
+	if err := f.RemoveKey("nonesuch"); !errors.Is(err, leaf.ErrNotFound) {
+		t.Errorf("RemoveKey missing: got %v, want %v", err, leaf.ErrNotFound)
+	}
+	if _, err := f.Database().RewindTag("nonesuch"); !errors.Is(err, leaf.ErrNotFound) {
+		t.Errorf("RewindTag missing: got %v, want %v", err, leaf.ErrNotFound)
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if _, err := leaf.Open([]byte(wrongKey), bytes.NewReader(buf.Bytes())); !errors.Is(err, leaf.ErrWrongKey) {
+		t.Errorf("Open with wrong key: got %v, want %v", err, leaf.ErrWrongKey)
+	}
+	if _, err := leaf.VerifyKey([]byte(wrongKey), bytes.NewReader(buf.Bytes())); !errors.Is(err, leaf.ErrWrongKey) {
+		t.Errorf("VerifyKey with wrong key: got %v, want %v", err, leaf.ErrWrongKey)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	const testKey = "gggggggggggggggggggggggggggggggg"
+	const payload = `{"log":[
+  {"op":"create-table","tab":"t","clk":"100"},
+  {"op":"update","tab":"t","key":"plain","val":"hunter2","clk":"200"},
+  {"op":"update","tab":"t","key":"escapes","val":"a\"b\\c\/d\b\f\n\r\t","clk":"200"},
+  {"op":"update","tab":"t","key":"unicode","val":"é☃ 😀 é","clk":"200"},
+  {"op":"update","tab":"t","key":"surrogate","val":"x\ud83dy\ude00z\ud83d\ude00\u00e9","clk":"200"},
+  {"op":"update","tab":"t","key":"empty","val":"","clk":"200"},
+  {"op":"update","tab":"t","key":"null","val":null,"clk":"200"},
+  {"op":"update","tab":"t","key":"number","val":25,"clk":"200"}
+]}`
+	f, err := leaf.Wrap([]byte(testKey), []byte(payload))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	tab := f.Database().Table("t")
+
+	// Each string value decodes to the same contents as Get.
+	for _, key := range []string{"plain", "escapes", "unicode", "surrogate", "empty", "null"} {
+		var want string
+		tab.Get(key, &want)
+		buf := leaf.NewSecret(64)
+		n, err := tab.GetInto(key, buf)
+		if err != nil {
+			t.Errorf("GetInto %q: unexpected error: %v", key, err)
+		} else if got := string(buf[:n]); got != want {
+			t.Errorf("GetInto %q: got %q, want %q", key, got, want)
+		}
+		leaf.Wipe(buf)
+	}
+
+	// A buffer that is too short is wiped.
+	short := []byte("XXXX")
+	if _, err := tab.GetInto("plain", short); !errors.Is(err, io.ErrShortBuffer) {
+		t.Errorf("GetInto short: got %v, want %v", err, io.ErrShortBuffer)
+	} else if !bytes.Equal(short, make([]byte, 4)) {
+		t.Errorf("GetInto short: buffer not wiped: %q", short)
+	}
+	if _, err := tab.GetInto("number", make([]byte, 8)); err == nil {
+		t.Error("GetInto number: got nil, want error")
+	}
+	if _, err := tab.GetInto("nonesuch", make([]byte, 8)); !errors.Is(err, leaf.ErrNotFound) {
+		t.Errorf("GetInto missing: got %v, want %v", err, leaf.ErrNotFound)
+	}
+
+	// Byte values of each length decode exactly into a buffer of that length.
+	for n := range 10 {
+		want := bytes.Repeat([]byte{0xA5}, n)
+		tab.Set("bytes", want)
+		buf := make([]byte, n)
+		if got, err := tab.GetBytesInto("bytes", buf); err != nil {
+			t.Errorf("GetBytesInto %d: unexpected error: %v", n, err)
+		} else if !bytes.Equal(buf[:got], want) {
+			t.Errorf("GetBytesInto %d: got %x, want %x", n, buf[:got], want)
+		}
+		if n > 0 {
+			if _, err := tab.GetBytesInto("bytes", make([]byte, n-1)); !errors.Is(err, io.ErrShortBuffer) {
+				t.Errorf("GetBytesInto %d short: got %v, want %v", n, err, io.ErrShortBuffer)
+			}
+		}
+	}
+}
+
+func TestSetFrom(t *testing.T) {
+	const testKey = "cccccccccccccccccccccccccccccccc"
+	big := make([]byte, 3*leaf.ChunkSize+17)
+	for i := range big {
+		big[i] = byte(i * 7)
+	}
+
+	for _, o := range []*leaf.OpenOptions{nil, {SealValues: true}} {
+		w, err := leaf.New([]byte(testKey))
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		var buf bytes.Buffer
+		if _, err := w.WriteTo(&buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		f, err := o.Open([]byte(testKey), &buf)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		tab := f.Database().Table("t")
+		readAll := func(key string) ([]byte, error) {
+			t.Helper()
+			r, err := tab.Open(key)
+			if err != nil {
+				return nil, err
+			}
+			defer r.Close()
+			return io.ReadAll(r)
+		}
+
+		if isNew, err := tab.SetFrom("big", bytes.NewReader(big)); err != nil || !isNew {
+			t.Fatalf("SetFrom big: got %v, %v; want true, nil", isNew, err)
+		}
+		if got, err := readAll("big"); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("Open big: got %d bytes, %v; want %d bytes", len(got), err, len(big))
+		}
+
+		// Each chunk is a separate log entry, and the table holding them is
+		// not visible.
+		var nchunk int
+		for _, e := range f.Database().Log() {
+			if e.Op == "update" && e.Key != "big" {
+				nchunk++
+			}
+		}
+		if nchunk != 4 {
+			t.Errorf("Log: got %d chunk entries, want 4", nchunk)
+		}
+		if got := f.Database().TableNames(); !slices.Equal(got, []string{"t"}) {
+			t.Errorf("TableNames: got %q, want [t]", got)
+		}
+		if got := tab.Keys(); !slices.Equal(got, []string{"big"}) {
+			t.Errorf("Keys: got %q, want [big]", got)
+		}
+
+		// A reader is not affected by later changes, and the chunks move with
+		// their table.
+		old, err := tab.Open("big")
+		if err != nil {
+			t.Fatalf("Open big: %v", err)
+		}
+		tab.Rename("u")
+		if r, err := f.Database().Table("u").Open("big"); err != nil {
+			t.Errorf("Open big after Rename: %v", err)
+		} else {
+			r.Close()
+		}
+		tab.Rename("t")
+		tab.SetFrom("big", strings.NewReader("small"))
+		if got, err := io.ReadAll(old); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("Read old big: got %d bytes, %v; want %d bytes", len(got), err, len(big))
+		}
+		old.Close()
+		if got, err := readAll("big"); err != nil || string(got) != "small" {
+			t.Errorf("Open big: got %q, %v; want small", got, err)
+		}
+		if _, err := tab.SetFrom("big", bytes.NewReader(big)); err != nil {
+			t.Fatalf("SetFrom big: %v", err)
+		}
+
+		// Values stored from a []byte, empty values, and nulls can be opened.
+		tab.Set("bytes", []byte("hello"))
+		tab.Set("null", nil)
+		tab.SetFrom("empty", strings.NewReader(""))
+		for key, want := range map[string]string{"bytes": "hello", "null": "", "empty": ""} {
+			if got, err := readAll(key); err != nil || string(got) != want {
+				t.Errorf("Open %q: got %q, %v; want %q", key, got, err, want)
+			}
+		}
+
+		// Other values, and missing keys, report errors.
+		tab.Set("number", 25)
+		if _, err := tab.Open("number"); err == nil {
+			t.Error("Open number: got nil, want error")
+		}
+		if _, err := tab.Open("nonesuch"); !errors.Is(err, leaf.ErrNotFound) {
+			t.Errorf("Open missing: got %v, want %v", err, leaf.ErrNotFound)
+		}
+
+		// A read error does not modify the table.
+		n := f.Database().LogLen()
+		if _, err := tab.SetFrom("big", io.MultiReader(strings.NewReader("x"), iotest.ErrReader(io.ErrClosedPipe))); !errors.Is(err, io.ErrClosedPipe) {
+			t.Errorf("SetFrom error: got %v, want %v", err, io.ErrClosedPipe)
+		} else if f.Database().LogLen() != n {
+			t.Error("SetFrom error: table was modified")
+		}
+
+		// The value survives a round trip and compaction.
+		buf.Reset()
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		g, err := leaf.Open([]byte(testKey), &buf)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		g.Database().Compact()
+		r, err := g.Database().Table("t").Open("big")
+		if err != nil {
+			t.Fatalf("Open big: %v", err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("Read back big: got %d bytes, %v; want %d bytes", len(got), err, len(big))
+		}
+		r.Close()
+	}
+}
+
+// slowReader delays each read, so that the time to read a value is visible
+// in the timestamps of the log.
+type slowReader struct{ io.Reader }
+
+func (s slowReader) Read(p []byte) (int, error) {
+	time.Sleep(time.Millisecond)
+	return s.Reader.Read(p)
+}
+
+func TestSetFromDecode(t *testing.T) {
+	const testKey = "dddddddddddddddddddddddddddddddd"
+	big := bytes.Repeat([]byte("0123456789abcdef"), leaf.ChunkSize/8)
+
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if _, err := f.Database().Table("t").SetFrom("big", slowReader{bytes.NewReader(big)}); err != nil {
+		t.Fatalf("SetFrom: %v", err)
+	}
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	// The log of a file written by SetFrom is in order, so the value can be
+	// read back in both strict and lenient modes.
+	for _, mode := range []leaf.DecodeMode{leaf.DecodeStrict, leaf.DecodeLenient} {
+		opts := &leaf.OpenOptions{Mode: mode, Report: func(e *leaf.DecodeError) {
+			t.Errorf("Mode %v: unexpected problem: %v", mode, e)
+		}}
+		g, err := opts.Open([]byte(testKey), bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Mode %v: Open: %v", mode, err)
+		}
+		r, err := g.Database().Table("t").Open("big")
+		if err != nil {
+			t.Fatalf("Mode %v: Open big: %v", mode, err)
+		}
+		if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, big) {
+			t.Errorf("Mode %v: Read big: got %d bytes, %v; want %d bytes", mode, len(got), err, len(big))
+		}
+		r.Close()
+	}
+}
+
 func TestRoundTrip(t *testing.T) {
 	const testKey = "xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"
 
@@ -96,79 +530,484 @@ func TestRoundTrip(t *testing.T) {
 	}
 }
 
-func TestSemantics(t *testing.T) {
-	const testKey = "********************************"
-	f, err := leaf.New([]byte(testKey))
+func TestUnwrapWrap(t *testing.T) {
+	const oldKey = "oooooooooooooooooooooooooooooooo"
+	const newKey = "nnnnnnnnnnnnnnnnnnnnnnnnnnnnnnnn"
+
+	f, err := leaf.New([]byte(oldKey))
 	if err != nil {
 		t.Fatalf("New: %v", err)
 	}
-	db := f.Database()
-
-	// An empty file should have no tables.
-	if tab, ok := db.GetTable("test"); ok {
-		t.Errorf("Table test: got %v, want none", tab)
+	leaf.SetMap(f.Database().Table("test"), map[string]string{"a": "apple", "b": "banana"})
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
 	}
 
-	// After creating a table, it should exist.
-	nt := db.Table("test")
-	if _, ok := db.GetTable("test"); !ok {
-		t.Error("Table test: should exist but does not")
+	if _, err := leaf.Unwrap([]byte(newKey), bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Unwrap with the wrong key: got nil, want error")
+	}
+	payload, err := leaf.Unwrap([]byte(oldKey), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	} else if !json.Valid(payload) {
+		t.Fatalf("Unwrap: payload is not valid JSON: %q", payload)
 	}
 
-	// After renaming a table, the old name should not exist and the new one
-	// should.
-	nt.Rename("other")
-	if got, ok := db.GetTable("test"); ok {
-		t.Errorf("Table test: got %v, want none", got)
+	if _, err := leaf.Wrap([]byte(newKey), []byte(`{"log":"bogus"}`)); err == nil {
+		t.Error("Wrap of an invalid payload: got nil, want error")
 	}
-	if _, ok := db.GetTable("other"); !ok {
-		t.Error("Table other: should exist but does not")
+	g, err := leaf.Wrap([]byte(newKey), payload)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	} else if !g.IsModified() {
+		t.Error("Wrap: file is not marked as modified")
+	}
+	buf.Reset()
+	if _, err := g.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	h, err := leaf.Open([]byte(newKey), &buf)
+	if err != nil {
+		t.Fatalf("Open with new key: %v", err)
 	}
+	diffData(t, f.Database(), h.Database())
+}
 
-	// But renaming back should restore the status quo.
-	nt.Rename("test")
+func TestDecodeModes(t *testing.T) {
+	const testKey = "dddddddddddddddddddddddddddddddd"
 
-	// If we delete a table, it should not exist anymore.
-	if !db.DeleteTable("test") {
-		t.Error("Delete test (1): reported false")
-	}
-	if tab, ok := db.GetTable("test"); ok {
-		t.Errorf("Table test: got %v, want none", tab)
+	// A payload with an unknown operation, and an entry whose timestamp is
+	// earlier than its predecessor.
+	const payload = `{"log":[
+  {"op":"create-table","tab":"t","clk":"100"},
+  {"op":"update","tab":"t","key":"a","val":1,"clk":"200"},
+  {"op":"frobnicate","tab":"t","clk":"300"},
+  {"op":"update","tab":"t","key":"b","val":2,"clk":"150"},
+  {"op":"update","tab":"t","key":"c","val":3,"clk":"400"}
+]}`
+	f, err := leaf.Wrap([]byte(testKey), []byte(payload))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
 	}
-	if db.DeleteTable("test") {
-		t.Error("Delete test (2): reported true")
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
 	}
 
-	// A fresh table should have length zero.
-	tab := db.Table("test")
-	if n := tab.Len(); n != 0 {
-		t.Errorf("Table len: got %d, want 0", n)
+	// Add an unknown field to the wrapper.
+	var wrapper map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &wrapper); err != nil {
+		t.Fatalf("Decode wrapper: %v", err)
 	}
+	wrapper["extra"] = true
+	data := mustJSON(t, wrapper)
 
-	// Add some values and verify we have them.
-	vals := map[string]int{"x": 1, "y": 2, "z": 3}
-	leaf.SetMap(tab, vals)
-	checkTab(t, tab, vals)
+	t.Run("Default", func(t *testing.T) {
+		g, err := leaf.Open([]byte(testKey), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		if n := g.Database().LogLen(); n != 5 {
+			t.Errorf("LogLen: got %d, want 5", n)
+		}
+		checkTab(t, g.Database().Table("t"), map[string]int{"a": 1, "b": 2, "c": 3})
+	})
+	t.Run("Strict", func(t *testing.T) {
+		opts := &leaf.OpenOptions{Mode: leaf.DecodeStrict}
+		_, err := opts.Open([]byte(testKey), bytes.NewReader(data))
+		var derr *leaf.DecodeError
+		if !errors.As(err, &derr) {
+			t.Fatalf("Open: got %v, want *DecodeError", err)
+		} else if !strings.Contains(derr.Message, `"extra"`) {
+			t.Errorf("Open: got %v, want unknown field", err)
+		}
 
-	// Capture a timestamp so we can revert.
-	clk := db.Time()
-	logJSON(t, "State after insert", db.Snapshot())
+		_, err = opts.Open([]byte(testKey), bytes.NewReader(buf.Bytes()))
+		if !errors.As(err, &derr) {
+			t.Fatalf("Open: got %v, want *DecodeError", err)
+		} else if derr.Entry != 2 {
+			t.Errorf("Open: got %v, want error at entry 2", err)
+		}
+	})
+	t.Run("Lenient", func(t *testing.T) {
+		var got []string
+		opts := &leaf.OpenOptions{
+			Mode:   leaf.DecodeLenient,
+			Report: func(e *leaf.DecodeError) { got = append(got, e.Error()) },
+		}
+		g, err := opts.Open([]byte(testKey), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		want := []string{
+			`unknown field "extra" in wrapper`,
+			`log entry 2: unknown operation "frobnicate"`,
+			`log entry 3: timestamp 150 is earlier than 200`,
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("Reports (-got, +want):\n%s", diff)
+		}
+		if n := g.Database().LogLen(); n != 3 {
+			t.Errorf("LogLen: got %d, want 3", n)
+		}
+		if !g.IsModified() {
+			t.Error("File with discarded entries is not marked as modified")
+		}
+		checkTab(t, g.Database().Table("t"), map[string]int{"a": 1, "c": 3})
+	})
+}
 
-	// Delete x and verify it's gone.
-	if !tab.Delete("x") {
-		t.Error("Delete x (1): reported false")
+func TestMissingTable(t *testing.T) {
+	const testKey = "mmmmmmmmmmmmmmmmmmmmmmmmmmmmmmmm"
+
+	// A log that operates on tables that were never created.
+	const payload = `{"log":[
+  {"op":"update","tab":"t","key":"a","val":1,"clk":"100"},
+  {"op":"delete","tab":"u","key":"b","clk":"200"},
+  {"op":"rename-table","tab":"v","key":"w","clk":"300"},
+  {"op":"update","tab":"t","key":"c","val":2,"clk":"400"}
+]}`
+	f, err := leaf.Wrap([]byte(testKey), []byte(payload))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
 	}
-	if tab.Get("x", nil) {
-		t.Error("Get x: reported true")
+	if diff := cmp.Diff(f.Database().TableNames(), []string{"t", "w"}); diff != "" {
+		t.Errorf("TableNames (-got, +want):\n%s", diff)
 	}
-	if n := tab.Len(); n != 2 {
-		t.Errorf("Table len: got %d, want 2", n)
+	checkTab(t, f.Database().Table("t"), map[string]int{"a": 1, "c": 2})
+	f.Database().Table("w").Set("x", 3)
+	checkTab(t, f.Database().Table("w"), map[string]int{"x": 3})
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
 	}
-	if tab.Delete("x") {
-		t.Error("Delete x (2): reported true")
+	var got []string
+	opts := &leaf.OpenOptions{Report: func(e *leaf.DecodeError) { got = append(got, e.Error()) }}
+	if _, err := opts.Open([]byte(testKey), bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	want := []string{
+		`log entry 0: update on missing table "t"`,
+		`log entry 1: delete on missing table "u"`,
+		`log entry 2: rename-table on missing table "v"`,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("Reports (-got, +want):\n%s", diff)
 	}
 
-	// Verify we can get the values out as a map.
+	opts = &leaf.OpenOptions{Mode: leaf.DecodeStrict}
+	if _, err := opts.Open([]byte(testKey), bytes.NewReader(buf.Bytes())); err == nil {
+		t.Error("Open strict: got nil, want error")
+	}
+}
+
+func TestCipher(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if got := f.Cipher(); got != leaf.CipherXChaCha20Poly1305 {
+		t.Errorf("Cipher: got %q, want %q", got, leaf.CipherXChaCha20Poly1305)
+	}
+	if err := f.SetCipher("rot13"); err == nil {
+		t.Error("SetCipher(rot13): got nil, want error")
+	}
+	leaf.SetMap(f.Database().Table("test"), map[string]string{"a": "apple", "b": "banana"})
+	want := f.Database().Snapshot()
+
+	// Each cipher round-trips, and is recorded in the file. Only a cipher
+	// other than the default requires format version 4.
+	for _, tc := range []struct {
+		name    string
+		version int
+	}{
+		{leaf.CipherAESSIV, 4},
+		{leaf.CipherXChaCha20Poly1305, 1},
+	} {
+		name := tc.name
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := f.SetCipher(name); err != nil {
+			t.Fatalf("SetCipher(%q): %v", name, err)
+		} else if !f.IsModified() {
+			t.Errorf("SetCipher(%q): file is not marked as modified", name)
+		}
+		buf.Reset()
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		info, err := leaf.ReadInfo(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("ReadInfo: %v", err)
+		} else if info.Cipher != name {
+			t.Errorf("ReadInfo: got cipher %q, want %q", info.Cipher, name)
+		} else if info.Version != tc.version {
+			t.Errorf("ReadInfo: got version %d, want %d", info.Version, tc.version)
+		}
+		g, err := leaf.Open([]byte(testKey), bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		} else if got := g.Cipher(); got != name {
+			t.Errorf("Cipher: got %q, want %q", got, name)
+		}
+		if diff := cmp.Diff(want, g.Database().Snapshot()); diff != "" {
+			t.Errorf("Contents (-want, +got):\n%s", diff)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	db.Table("other") // so that the first entry can be removed
+	db.Table("test").Set("a", "apple")
+	if err := db.VerifyChain(nil); err == nil {
+		t.Error("VerifyChain before EnableChain: got nil, want error")
+	}
+	db.EnableChain()
+	if !db.IsChained() {
+		t.Error("IsChained: got false, want true")
+	}
+	db.Table("test").Set("b", "banana")
+	head := db.ChainHead()
+	db.Table("test").Set("c", "cherry")
+	last := db.ChainHead()
+	if err := db.VerifyChain(head); err != nil {
+		t.Errorf("VerifyChain: unexpected error: %v", err)
+	}
+
+	// The chain survives a round trip through the file.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := g.Database().VerifyChain(head); err != nil {
+		t.Errorf("VerifyChain after Open: unexpected error: %v", err)
+	}
+
+	// Edit the log directly, bypassing the chain.
+	payload, err := leaf.Unwrap([]byte(testKey), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	var wdb struct {
+		Log []json.RawMessage `json:"log"`
+	}
+	if err := json.Unmarshal(payload, &wdb); err != nil {
+		t.Fatalf("Decode payload: %v", err)
+	}
+	edit := func(log ...json.RawMessage) *leaf.Database {
+		t.Helper()
+		data, err := json.Marshal(map[string]any{"log": log})
+		if err != nil {
+			t.Fatalf("Encode payload: %v", err)
+		}
+		f, err := leaf.Wrap([]byte(testKey), data)
+		if err != nil {
+			t.Fatalf("Wrap: %v", err)
+		}
+		return f.Database()
+	}
+	n := len(wdb.Log)
+	tests := []struct {
+		name string
+		db   *leaf.Database
+		head []byte
+	}{
+		{"DropFirst", edit(wdb.Log[1:]...), nil},
+		{"DropMiddle", edit(append(slices.Clone(wdb.Log[:2]), wdb.Log[3:]...)...), nil},
+		{"Swap", edit(append(slices.Clone(wdb.Log[:n-2]), wdb.Log[n-1], wdb.Log[n-2])...), nil},
+		{"Modify", edit(append(slices.Clone(wdb.Log[:n-2]),
+			bytes.Replace(wdb.Log[n-2], []byte("banana"), []byte("durian"), 1), wdb.Log[n-1])...), nil},
+		{"ModifyLast", edit(append(slices.Clone(wdb.Log[:n-1]),
+			bytes.Replace(wdb.Log[n-1], []byte("cherry"), []byte("durian"), 1))...), last},
+		{"Truncate", edit(wdb.Log[:n-2]...), head},
+	}
+	for _, tc := range tests {
+		if err := tc.db.VerifyChain(tc.head); err == nil {
+			t.Errorf("VerifyChain %s: got nil, want error", tc.name)
+		}
+	}
+
+	// Truncating the end is not detected without the head.
+	if err := edit(wdb.Log[:n-2]...).VerifyChain(nil); err != nil {
+		t.Errorf("VerifyChain truncated without head: unexpected error: %v", err)
+	}
+
+	// A compacted log is anchored to the head it replaced.
+	head = db.ChainHead()
+	db.Compact()
+	db.Table("test").Set("d", "durian")
+	if err := db.VerifyChain(head); err != nil {
+		t.Errorf("VerifyChain after Compact: unexpected error: %v", err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	const testKey = "00000000000000000000000000000000"
+
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	f.Database().Table("test").Set("a", "apple")
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	for _, lf := range []*leaf.File{f, g} {
+		if err := lf.Close(); err != nil {
+			t.Errorf("Close: unexpected error: %v", err)
+		}
+		if err := lf.Close(); err != nil {
+			t.Errorf("Close again: unexpected error: %v", err)
+		}
+
+		// The database is still readable, but the file cannot be written.
+		if v, ok := leaf.Get[string](lf.Database().Table("test"), "a"); !ok || v != "apple" {
+			t.Errorf("Get after Close: got %q, %v; want apple, true", v, ok)
+		}
+		if _, err := lf.WriteTo(io.Discard); err == nil {
+			t.Error("Write after Close: got nil, want error")
+		}
+		if err := lf.AddKey("other", []byte(testKey)); err == nil {
+			t.Error("AddKey after Close: got nil, want error")
+		}
+	}
+}
+
+func TestSemantics(t *testing.T) {
+	const testKey = "********************************"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+
+	// An empty file should have no tables.
+	if tab, ok := db.GetTable("test"); ok {
+		t.Errorf("Table test: got %v, want none", tab)
+	}
+
+	// After creating a table, it should exist.
+	nt := db.Table("test")
+	if _, ok := db.GetTable("test"); !ok {
+		t.Error("Table test: should exist but does not")
+	}
+
+	// After renaming a table, the old name should not exist and the new one
+	// should.
+	nt.Rename("other")
+	if got, ok := db.GetTable("test"); ok {
+		t.Errorf("Table test: got %v, want none", got)
+	}
+	if _, ok := db.GetTable("other"); !ok {
+		t.Error("Table other: should exist but does not")
+	}
+
+	// But renaming back should restore the status quo.
+	nt.Rename("test")
+
+	// If we delete a table, it should not exist anymore.
+	if !db.DeleteTable("test") {
+		t.Error("Delete test (1): reported false")
+	}
+	if tab, ok := db.GetTable("test"); ok {
+		t.Errorf("Table test: got %v, want none", tab)
+	}
+	if db.DeleteTable("test") {
+		t.Error("Delete test (2): reported true")
+	}
+
+	// A fresh table should have length zero.
+	tab := db.Table("test")
+	if n := tab.Len(); n != 0 {
+		t.Errorf("Table len: got %d, want 0", n)
+	}
+
+	// Add some values and verify we have them.
+	vals := map[string]int{"x": 1, "y": 2, "z": 3}
+	leaf.SetMap(tab, vals)
+	checkTab(t, tab, vals)
+
+	// Swap should store the new value and return the old one.
+	var old int
+	if !tab.Swap("y", 5, &old) {
+		t.Error("Swap y: reported false")
+	} else if old != 2 {
+		t.Errorf("Swap y: old value is %d, want 2", old)
+	}
+	if tab.Swap("w", 4, &old) {
+		t.Error("Swap w: reported true")
+	}
+	checkTab(t, tab, map[string]int{"w": 4, "x": 1, "y": 5, "z": 3})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("Swap w: should have panicked for a mismatched type")
+			}
+		}()
+		var s string
+		tab.Swap("w", 6, &s)
+	}()
+	tab.Delete("w")
+	tab.Set("y", 2)
+	checkTab(t, tab, vals)
+
+	// GetMulti should return the keys that exist, and omit the others.
+	if diff := cmp.Diff(leaf.GetMulti[int](tab, []string{"x", "q", "z"}), map[string]int{"x": 1, "z": 3}); diff != "" {
+		t.Errorf("GetMulti (-got, +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(tab.GetMulti([]string{"y", "q"}), map[string]json.RawMessage{"y": json.RawMessage("2")}); diff != "" {
+		t.Errorf("Table.GetMulti (-got, +want):\n%s", diff)
+	}
+
+	// Filter should return only the entries selected.
+	odd := leaf.Filter(tab, func(key string, val int) bool { return val%2 == 1 })
+	if diff := cmp.Diff(odd, map[string]int{"x": 1, "z": 3}); diff != "" {
+		t.Errorf("Filter (-got, +want):\n%s", diff)
+	}
+
+	// Capture a timestamp so we can revert.
+	clk := db.Time()
+	logJSON(t, "State after insert", db.Snapshot())
+
+	// Delete x and verify it's gone.
+	if !tab.Delete("x") {
+		t.Error("Delete x (1): reported false")
+	}
+	if tab.Get("x", nil) {
+		t.Error("Get x: reported true")
+	}
+	if n := tab.Len(); n != 2 {
+		t.Errorf("Table len: got %d, want 2", n)
+	}
+	if tab.Delete("x") {
+		t.Error("Delete x (2): reported true")
+	}
+
+	// Verify we can get the values out as a map.
 	checkTab(t, tab, map[string]int{"y": 2, "z": 3})
 	logJSON(t, "State after delete", db.Snapshot())
 
@@ -192,6 +1031,951 @@ func TestSemantics(t *testing.T) {
 	logJSON(t, "Database", db)
 }
 
+func TestSetMany(t *testing.T) {
+	f, err := leaf.New([]byte("tttttttttttttttttttttttttttttttt"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tab := f.Database().Table("test")
+	tab.Set("x", 1)
+
+	// If any value cannot be marshaled, nothing should be stored.
+	errs := leaf.SetMany(tab, map[string]any{"x": 2, "y": make(chan int), "z": 3})
+	if len(errs) != 1 || errs["y"] == nil {
+		t.Errorf("SetMany: got errors %v, want one for y", errs)
+	}
+	checkTab(t, tab, map[string]int{"x": 1})
+
+	if errs := leaf.SetMany(tab, map[string]int{"x": 2, "y": 3}); errs != nil {
+		t.Errorf("SetMany: unexpected errors: %v", errs)
+	}
+	checkTab(t, tab, map[string]int{"x": 2, "y": 3})
+}
+
+func TestCompact(t *testing.T) {
+	const testKey = "cccccccccccccccccccccccccccccccc"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tab := f.Database().Table("test")
+	leaf.SetMap(tab, map[string]any{"x": "one", "y": 2, "z": []int{3}})
+	tab.Set("x", "four")
+	tab.Delete("y")
+	f.Database().Table("empty")
+	want := f.Database().Snapshot()
+
+	f.Database().Compact()
+	if n := f.Database().LogLen(); n != 1 {
+		t.Errorf("LogLen after Compact: got %d, want 1", n)
+	}
+	if diff := cmp.Diff(f.Database().Snapshot(), want); diff != "" {
+		t.Errorf("Snapshot after Compact (-got, +want):\n%s", diff)
+	}
+
+	// The compacted log should replay to the same state.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if diff := cmp.Diff(g.Database().Snapshot(), want); diff != "" {
+		t.Errorf("Snapshot after reopen (-got, +want):\n%s", diff)
+	}
+	checkTab(t, g.Database().Table("test"), map[string]any{"x": "four", "z": []any{3.0}})
+}
+
+func TestCanonical(t *testing.T) {
+	const testKey = "cccccccccccccccccccccccccccccccc"
+
+	// Two logs that arrive at the same state by different routes.
+	const payload1 = `{"log":[
+  {"op":"create-table","tab":"t","clk":"100"},
+  {"op":"update","tab":"t","key":"b","val":{"y": 2, "x": [1, 2.50]},"clk":"200"},
+  {"op":"update","tab":"t","key":"a","val":"<&>","clk":"300"},
+  {"op":"create-table","tab":"e","clk":"400"}
+]}`
+	const payload2 = `{"log":[
+  {"op":"create-table","tab":"e","clk":"100"},
+  {"op":"create-table","tab":"u","clk":"200"},
+  {"op":"update","tab":"u","key":"a","val":"<&>","clk":"300"},
+  {"op":"update","tab":"u","key":"b","val":{"x":[1,2.50],"y":2},"clk":"400"},
+  {"op":"update","tab":"u","key":"c","val":null,"clk":"500"},
+  {"op":"delete","tab":"u","key":"c","clk":"600"},
+  {"op":"rename-table","tab":"u","key":"t","clk":"700"}
+]}`
+	canon := func(payload string) string {
+		t.Helper()
+		f, err := leaf.Wrap([]byte(testKey), []byte(payload))
+		if err != nil {
+			t.Fatalf("Wrap: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := f.Database().Canonical(&buf); err != nil {
+			t.Fatalf("Canonical: %v", err)
+		}
+		return buf.String()
+	}
+	const want = `{
+  "e": {},
+  "t": {
+    "a": "<&>",
+    "b": {
+      "x": [
+        1,
+        2.50
+      ],
+      "y": 2
+    }
+  }
+}
+`
+	if diff := cmp.Diff(canon(payload1), want); diff != "" {
+		t.Errorf("Canonical 1 (-got, +want):\n%s", diff)
+	}
+	if diff := cmp.Diff(canon(payload2), want); diff != "" {
+		t.Errorf("Canonical 2 (-got, +want):\n%s", diff)
+	}
+
+	// A filtered snapshot has the same encoding as its database would.
+	snap := map[string]map[string]json.RawMessage{
+		"t": {"b": json.RawMessage(`{"y":2,"x":[1,2.50]}`)},
+	}
+	var buf bytes.Buffer
+	if err := leaf.WriteCanonical(&buf, snap); err != nil {
+		t.Fatalf("WriteCanonical: %v", err)
+	}
+	const wantB = `{
+  "t": {
+    "b": {
+      "x": [
+        1,
+        2.50
+      ],
+      "y": 2
+    }
+  }
+}
+`
+	if diff := cmp.Diff(buf.String(), wantB); diff != "" {
+		t.Errorf("WriteCanonical (-got, +want):\n%s", diff)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	const testKey = "pppppppppppppppppppppppppppppppp"
+	const payload = `{"log":[
+  {"op":"create-table","tab":"t","clk":"100"},
+  {"op":"update","tab":"t","key":"a","val":1,"clk":"200"},
+  {"op":"update","tab":"t","key":"a","val":2,"clk":"300"},
+  {"op":"update","tab":"t","key":"b","val":3,"clk":"400"},
+  {"op":"delete","tab":"t","key":"a","clk":"500"},
+  {"op":"update","tab":"t","key":"c","val":4,"clk":"600"}
+]}`
+	f, err := leaf.Wrap([]byte(testKey), []byte(payload))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	db := f.Database()
+	want := db.Snapshot()
+
+	gs := db.GarbageStats(time.UnixMicro(300))
+	if gs.LogEntries != 6 || gs.CompactEntries != 5 || gs.PruneEntries != 2 {
+		t.Errorf("GarbageStats: got %+v, want 6 entries, 5 compacted, 2 pruned", gs)
+	}
+	if gs.LogBytes <= gs.CompactBytes || gs.CompactBytes <= gs.PruneBytes || gs.PruneBytes <= 0 {
+		t.Errorf("GarbageStats: implausible sizes %+v", gs)
+	}
+	if gs := db.GarbageStats(time.Time{}); gs.PruneEntries != 0 || gs.PruneBytes != 0 {
+		t.Errorf("GarbageStats at zero: got %+v, want nothing pruned", gs)
+	}
+
+	if n := db.Prune(time.UnixMicro(300)); n != 2 {
+		t.Errorf("Prune: got %d, want 2", n)
+	}
+	if n := db.LogLen(); n != 4 {
+		t.Errorf("LogLen after Prune: got %d, want 4", n)
+	}
+	if diff := cmp.Diff(db.Snapshot(), want); diff != "" {
+		t.Errorf("Snapshot after Prune (-got, +want):\n%s", diff)
+	}
+	if n := db.Prune(time.UnixMicro(300)); n != 0 {
+		t.Errorf("Prune again: got %d, want 0", n)
+	}
+
+	// The retained history can still be rewound.
+	db.Rewind(time.UnixMicro(400))
+	checkTab(t, db.Table("t"), map[string]int{"a": 2, "b": 3})
+	db.Revert()
+
+	// Pruning a chained log relinks it.
+	db.EnableChain()
+	if n := db.Prune(time.UnixMicro(500)); n != 2 {
+		t.Errorf("Prune chained: got %d, want 2", n)
+	}
+	if err := db.VerifyChain(nil); err != nil {
+		t.Errorf("VerifyChain after Prune: %v", err)
+	}
+	checkTab(t, db.Table("t"), map[string]int{"b": 3, "c": 4})
+
+	db.Compact()
+	if gs := db.GarbageStats(time.UnixMicro(1000)); gs.CompactEntries != 0 || gs.PruneEntries != 0 {
+		t.Errorf("GarbageStats after Compact: got %+v, want nothing to reclaim", gs)
+	}
+}
+
+func TestTags(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	tab := db.Table("test")
+	tab.Set("x", 1)
+	if err := db.AddTag("v1"); err != nil {
+		t.Fatalf("AddTag v1: %v", err)
+	}
+	if err := db.AddTag("v1"); err == nil {
+		t.Error("AddTag v1: duplicate tag should have failed")
+	}
+	if err := db.AddTag(""); err == nil {
+		t.Error("AddTag: empty name should have failed")
+	}
+	tab.Set("x", 2)
+	tab.Set("y", 3)
+
+	// Tags should survive a round trip.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	gdb := g.Database()
+	if diff := cmp.Diff(gdb.Tags(), db.Tags()); diff != "" {
+		t.Errorf("Tags (-got, +want):\n%s", diff)
+	}
+	checkTab(t, gdb.Table("test"), map[string]int{"x": 2, "y": 3})
+
+	// Rewinding to the tag should restore the tagged state.
+	if _, ok := gdb.FindTag("v1"); !ok {
+		t.Fatal("FindTag v1: not found")
+	}
+	if ok, err := gdb.RewindTag("v1"); err != nil || !ok {
+		t.Errorf("RewindTag v1: got (%v, %v), want (true, nil)", ok, err)
+	}
+	checkTab(t, gdb.Table("test"), map[string]int{"x": 1})
+
+	var ops []string
+	for _, e := range gdb.Log() {
+		ops = append(ops, e.Op+" "+e.Table+" "+e.Key)
+	}
+	if diff := cmp.Diff(ops, []string{"create-table test ", "update test x", "tag v1 "}); diff != "" {
+		t.Errorf("Log after rewind (-got, +want):\n%s", diff)
+	}
+
+	if _, ok := gdb.FindTag("v2"); ok {
+		t.Error("FindTag v2: unexpectedly found")
+	}
+	if _, err := gdb.RewindTag("v2"); err == nil {
+		t.Error("RewindTag v2: should have failed")
+	}
+}
+
+func TestNotes(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	db.EnableChain()
+	tab := db.Table("test")
+	tab.Set("x", 1)
+	db.SetNote("rotated after incident #42")
+	if got, want := db.Note(), "rotated after incident #42"; got != want {
+		t.Errorf("Note: got %q, want %q", got, want)
+	}
+	tab.Set("x", 2)
+	tab.Delete("y")
+	tab.Set("y", 3)
+	db.SetNote("")
+	tab.Set("z", 4)
+
+	// Notes should survive a round trip, and be covered by the chain.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	gdb := g.Database()
+	if err := gdb.VerifyChain(db.ChainHead()); err != nil {
+		t.Errorf("VerifyChain: unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, e := range gdb.Log() {
+		got = append(got, e.Op+" "+e.Key+" "+e.Note)
+	}
+	if diff := cmp.Diff(got, []string{
+		"create-table  ",
+		"update x ",
+		"update x rotated after incident #42",
+		"update y rotated after incident #42",
+		"update z ",
+	}); diff != "" {
+		t.Errorf("Log (-got, +want):\n%s", diff)
+	}
+}
+
+func TestAll(t *testing.T) {
+	f, err := leaf.New([]byte("tttttttttttttttttttttttttttttttt"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	db.Table("b").Set("y", 2)
+	db.Table("b").Set("x", "one")
+	db.Table("a").Set("z", true)
+	db.Table("c")
+
+	var names []string
+	for name := range db.All() {
+		names = append(names, name)
+		if name == "a" {
+			db.DeleteTable("b") // not yet visited, so it should be skipped
+		}
+	}
+	if diff := cmp.Diff(names, []string{"a", "c"}); diff != "" {
+		t.Errorf("All (-got, +want):\n%s", diff)
+	}
+
+	db.Table("b").Set("y", 2)
+	db.Table("b").Set("x", "one")
+	var got []string
+	for r := range db.Records() {
+		got = append(got, r.Table+"/"+r.Key+"="+string(r.Value))
+		r.Value[0] = '!' // should not affect the database
+	}
+	if diff := cmp.Diff(got, []string{"a/z=true", "b/x=\"one\"", "b/y=2"}); diff != "" {
+		t.Errorf("Records (-got, +want):\n%s", diff)
+	}
+	checkTab(t, db.Table("a"), map[string]bool{"z": true})
+
+	var first []string
+	for key := range db.Table("b").All() {
+		first = append(first, key)
+		break // stopping early should not panic
+	}
+	if diff := cmp.Diff(first, []string{"x"}); diff != "" {
+		t.Errorf("Table.All (-got, +want):\n%s", diff)
+	}
+}
+
+func TestReadLog(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	tab := db.Table("test")
+	tab.Set("x", 1)
+	db.SetNote("why not")
+	tab.Set("y", []string{"a", "b"})
+	tab.Rename("other")
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	var got []leaf.LogEntry
+	for e, err := range leaf.ReadLog([]byte(testKey), bytes.NewReader(buf.Bytes())) {
+		if err != nil {
+			t.Fatalf("ReadLog: unexpected error: %v", err)
+		}
+		got = append(got, e)
+	}
+	if diff := cmp.Diff(got, db.Log()); diff != "" {
+		t.Errorf("ReadLog (-got, +want):\n%s", diff)
+	}
+
+	// Stopping early should not report an error.
+	for _, err := range leaf.ReadLog([]byte(testKey), bytes.NewReader(buf.Bytes())) {
+		if err != nil {
+			t.Fatalf("ReadLog: unexpected error: %v", err)
+		}
+		break
+	}
+
+	// A bad key should be reported.
+	var nerr int
+	for _, err := range leaf.ReadLog([]byte("00000000000000000000000000000000"), bytes.NewReader(buf.Bytes())) {
+		if err == nil {
+			t.Error("ReadLog: got an entry with the wrong key")
+		}
+		nerr++
+	}
+	if nerr != 1 {
+		t.Errorf("ReadLog: got %d errors, want 1", nerr)
+	}
+}
+
+func TestDatabases(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	f.Database().Table("test").Set("x", 1)
+	if db, ok := f.GetDatabase(""); !ok || db != f.Database() {
+		t.Errorf("GetDatabase: got (%p, %v), want the default database", db, ok)
+	}
+
+	// A new named database is not stored until it is modified.
+	work := f.NamedDatabase("work")
+	if _, ok := f.GetDatabase("work"); ok {
+		t.Error("GetDatabase work: found before modification")
+	}
+	work.Table("test").Set("x", 2)
+	f.NamedDatabase("personal").Table("test").Set("y", 3)
+	f.NamedDatabase("empty")
+	if diff := cmp.Diff(f.DatabaseNames(), []string{"personal", "work"}); diff != "" {
+		t.Errorf("DatabaseNames (-got, +want):\n%s", diff)
+	}
+
+	// Named databases should survive a round trip, in format version 3, which
+	// older versions do not open.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if info, err := leaf.ReadInfo(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	} else if info.Version != 3 {
+		t.Errorf("ReadInfo: got version %d, want 3", info.Version)
+	}
+	var log []leaf.LogEntry
+	for e, err := range leaf.ReadLog([]byte(testKey), bytes.NewReader(buf.Bytes())) {
+		if err != nil {
+			t.Fatalf("ReadLog: %v", err)
+		}
+		log = append(log, e)
+	}
+	if diff := cmp.Diff(log, f.Database().Log()); diff != "" {
+		t.Errorf("ReadLog (-got, +want):\n%s", diff)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if diff := cmp.Diff(g.DatabaseNames(), []string{"personal", "work"}); diff != "" {
+		t.Errorf("DatabaseNames (-got, +want):\n%s", diff)
+	}
+	checkTab(t, g.Database().Table("test"), map[string]int{"x": 1})
+	if db, ok := g.GetDatabase("work"); !ok {
+		t.Error("GetDatabase work: not found")
+	} else {
+		checkTab(t, db.Table("test"), map[string]int{"x": 2})
+	}
+	if g.IsModified() {
+		t.Error("File is modified after Open")
+	}
+
+	// Modifying a named database modifies the file.
+	g.NamedDatabase("personal").Table("test").Set("z", 4)
+	if !g.IsModified() {
+		t.Error("File is not modified after Set")
+	}
+
+	if g.DeleteDatabase("") {
+		t.Error("DeleteDatabase: deleted the default database")
+	}
+	if !g.DeleteDatabase("work") {
+		t.Error("DeleteDatabase work: reported false")
+	}
+	if g.DeleteDatabase("work") {
+		t.Error("DeleteDatabase work: reported true after deletion")
+	}
+	if diff := cmp.Diff(g.DatabaseNames(), []string{"personal"}); diff != "" {
+		t.Errorf("DatabaseNames (-got, +want):\n%s", diff)
+	}
+
+	// Without named databases, the file is version 1 again.
+	g.DeleteDatabase("personal")
+	buf.Reset()
+	if _, err := g.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if info, err := leaf.ReadInfo(&buf); err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	} else if info.Version != 1 {
+		t.Errorf("ReadInfo: got version %d, want 1", info.Version)
+	}
+}
+
+func TestShards(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	f.SetShardSize(3)
+	tab := f.Database().Table("test")
+	for i := range 10 {
+		tab.Set(fmt.Sprint(i), i)
+	}
+	f.NamedDatabase("other").Table("x").Set("y", true)
+
+	// write encodes f and returns its bytes and its encrypted shards.
+	write := func(f *leaf.File) ([]byte, [][]byte) {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		var wf struct {
+			Shards [][]byte `json:"shards"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &wf); err != nil {
+			t.Fatalf("Decode file: %v", err)
+		}
+		return buf.Bytes(), wf.Shards
+	}
+	bits, shards := write(f)
+	if info, err := leaf.ReadInfo(bytes.NewReader(bits)); err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	} else if info.Version != 3 || info.Shards != 4 {
+		// The file is version 3 because it has a named database.
+		t.Errorf("ReadInfo: got version %d, %d shards; want version 3, 4 shards", info.Version, info.Shards)
+	}
+
+	g, err := leaf.Open([]byte(testKey), bytes.NewReader(bits))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got := g.ShardSize(); got != 3 {
+		t.Errorf("ShardSize: got %d, want 3", got)
+	}
+	if diff := cmp.Diff(g.Database().Log(), f.Database().Log()); diff != "" {
+		t.Errorf("Log (-got, +want):\n%s", diff)
+	}
+	if db, ok := g.GetDatabase("other"); !ok {
+		t.Error("GetDatabase other: not found")
+	} else {
+		checkTab(t, db.Table("x"), map[string]bool{"y": true})
+	}
+	var log []leaf.LogEntry
+	for e, err := range leaf.ReadLog([]byte(testKey), bytes.NewReader(bits)) {
+		if err != nil {
+			t.Fatalf("ReadLog: %v", err)
+		}
+		log = append(log, e)
+	}
+	if diff := cmp.Diff(log, f.Database().Log()); diff != "" {
+		t.Errorf("ReadLog (-got, +want):\n%s", diff)
+	}
+
+	// Adding an entry should re-encrypt only the last shard.
+	g.Database().Table("test").Set("10", 10)
+	_, gshards := write(g)
+	if len(gshards) != 4 {
+		t.Fatalf("Got %d shards, want 4", len(gshards))
+	}
+	for i := range 3 {
+		if !bytes.Equal(gshards[i], shards[i]) {
+			t.Errorf("Shard %d was rewritten", i)
+		}
+	}
+	if bytes.Equal(gshards[3], shards[3]) {
+		t.Error("Shard 3 was not rewritten")
+	}
+
+	// Reordering the shards should be detected.
+	swapped := bytes.Replace(bits, mustJSON(t, shards[0]), []byte(`"X"`), 1)
+	swapped = bytes.Replace(swapped, mustJSON(t, shards[1]), mustJSON(t, shards[0]), 1)
+	swapped = bytes.Replace(swapped, []byte(`"X"`), mustJSON(t, shards[1]), 1)
+	if _, err := leaf.Open([]byte(testKey), bytes.NewReader(swapped)); err == nil {
+		t.Error("Open: reordered shards were not detected")
+	}
+
+	// An unwrapped payload should not refer to shards.
+	payload, err := leaf.Unwrap([]byte(testKey), bytes.NewReader(bits))
+	if err != nil {
+		t.Fatalf("Unwrap: %v", err)
+	}
+	w, err := leaf.Wrap([]byte(testKey), payload)
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	if diff := cmp.Diff(w.Database().Log(), f.Database().Log()); diff != "" {
+		t.Errorf("Wrap log (-got, +want):\n%s", diff)
+	}
+
+	// Without the named database, the file is version 2.
+	g.DeleteDatabase("other")
+	bits, _ = write(g)
+	if info, err := leaf.ReadInfo(bytes.NewReader(bits)); err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	} else if info.Version != 2 || info.Shards != 4 {
+		t.Errorf("ReadInfo: got version %d, %d shards; want version 2, 4 shards", info.Version, info.Shards)
+	}
+
+	// Turning off sharding should restore the original format.
+	g.SetShardSize(0)
+	bits, shards = write(g)
+	if info, err := leaf.ReadInfo(bytes.NewReader(bits)); err != nil {
+		t.Fatalf("ReadInfo: %v", err)
+	} else if info.Version != 1 || info.Shards != 0 || len(shards) != 0 {
+		t.Errorf("ReadInfo: got version %d, %d shards; want version 1, 0 shards", info.Version, info.Shards)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	const testKey = "tttttttttttttttttttttttttttttttt"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+	tab := db.Table("test")
+
+	// Each write should reflect the current state of the file, however its
+	// log has changed since the previous write.
+	check := func(label string) {
+		t.Helper()
+		var buf bytes.Buffer
+		if _, err := f.WriteTo(&buf); err != nil {
+			t.Fatalf("%s: Write: %v", label, err)
+		}
+		g, err := leaf.Open([]byte(testKey), &buf)
+		if err != nil {
+			t.Fatalf("%s: Open: %v", label, err)
+		}
+		if diff := cmp.Diff(g.Database().Log(), db.Log()); diff != "" {
+			t.Errorf("%s: log (-got, +want):\n%s", label, diff)
+		}
+		for _, name := range f.DatabaseNames() {
+			want, _ := f.GetDatabase(name)
+			got, _ := g.GetDatabase(name)
+			if diff := cmp.Diff(got.Log(), want.Log()); diff != "" {
+				t.Errorf("%s: log of %q (-got, +want):\n%s", label, name, diff)
+			}
+		}
+	}
+	check("empty")
+	tab.Set("x", 1)
+	tab.Set("y", 2)
+	check("set")
+	tab.Set("z", 3)
+	f.NamedDatabase("other").Table("a").Set("b", 4)
+	check("append")
+	clk := db.Time()
+	tab.Delete("x")
+	check("delete")
+	db.Rewind(clk)
+	check("rewind")
+	tab.Set("w", 5)
+	check("set after rewind")
+	db.EnableChain()
+	check("relink")
+	db.Compact()
+	check("compact")
+	f.DeleteDatabase("other")
+	f.NamedDatabase("other").Table("c").Set("d", 6)
+	check("replace database")
+}
+
+func TestReplay(t *testing.T) {
+	const testKey = "rrrrrrrrrrrrrrrrrrrrrrrrrrrrrrrr"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	db := f.Database()
+
+	// Generate a log long enough that the tables are rebuilt concurrently,
+	// mixing key updates with table operations. Replaying the log must give
+	// the same state as the live database.
+	rng := rand.New(rand.NewPCG(1, 2))
+	for i := range 20000 {
+		if i == 5000 {
+			db.Compact()
+		}
+		name := fmt.Sprintf("t%d", rng.IntN(16))
+		tab := db.Table(name)
+		switch r := rng.IntN(1000); {
+		case r < 5:
+			db.DeleteTable(name)
+		case r < 10:
+			tab.Rename(fmt.Sprintf("t%d", rng.IntN(16)))
+		case r < 15:
+			tab.Clear()
+		case r < 300:
+			tab.Delete(fmt.Sprintf("k%d", rng.IntN(64)))
+		default:
+			tab.Set(fmt.Sprintf("k%d", rng.IntN(64)), i)
+		}
+	}
+	want := db.Snapshot()
+
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if diff := cmp.Diff(g.Database().Snapshot(), want); diff != "" {
+		t.Errorf("Snapshot after reopen (-got, +want):\n%s", diff)
+	}
+
+	// Rewinding and reverting replays the log again.
+	db.Rewind(time.UnixMicro(1))
+	db.Revert()
+	if diff := cmp.Diff(db.Snapshot(), want); diff != "" {
+		t.Errorf("Snapshot after revert (-got, +want):\n%s", diff)
+	}
+}
+
+func mustJSON(t *testing.T, v any) []byte {
+	t.Helper()
+	bits, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return bits
+}
+
+func TestMerge(t *testing.T) {
+	const testKey = "mmmmmmmmmmmmmmmmmmmmmmmmmmmmmmmm"
+	f, err := leaf.New([]byte(testKey))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	tab := f.Database().Table("test")
+	leaf.SetMap(tab, map[string]int{"x": 1, "y": 2})
+
+	// Make a copy of the file, and apply different changes to each.
+	var buf bytes.Buffer
+	if _, err := f.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	g, err := leaf.Open([]byte(testKey), &buf)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	tab.Set("x", 3)
+	f.Database().Table("other").Set("p", 4)
+	g.Database().Table("test").Delete("y")
+	g.Database().Table("test").Set("z", 5)
+
+	if n := f.Database().Merge(g.Database()); n != 2 {
+		t.Errorf("Merge: got %d entries, want 2", n)
+	}
+	checkTab(t, tab, map[string]int{"x": 3, "z": 5})
+	checkTab(t, f.Database().Table("other"), map[string]int{"p": 4})
+
+	// Merging again should have no effect.
+	if n := f.Database().Merge(g.Database()); n != 0 {
+		t.Errorf("Merge again: got %d entries, want 0", n)
+	}
+
+	// Merging the other way should converge on the same state.
+	g.Database().Merge(f.Database())
+	if diff := cmp.Diff(g.Database().Snapshot(), f.Database().Snapshot()); diff != "" {
+		t.Errorf("Merged snapshots (-g, +f):\n%s", diff)
+	}
+}
+
+func TestMergeDeletedTable(t *testing.T) {
+	const testKey = "dddddddddddddddddddddddddddddddd"
+	const base = `{"op":"create-table","tab":"t","clk":"100"},
+  {"op":"update","tab":"t","key":"x","val":1,"clk":"200"}`
+	open := func(entries string) *leaf.Database {
+		t.Helper()
+		f, err := leaf.Wrap([]byte(testKey), []byte(`{"log":[`+base+`,`+entries+`]}`))
+		if err != nil {
+			t.Fatalf("Wrap: %v", err)
+		}
+		return f.Database()
+	}
+
+	// One side deletes the table, and the other later changes it without
+	// knowing that. The change must not restore the deleted table.
+	del := open(`{"op":"delete-table","tab":"t","clk":"300"}`)
+	upd := open(`{"op":"update","tab":"t","key":"y","val":2,"clk":"400"},
+  {"op":"rename-table","tab":"t","key":"u","clk":"500"}`)
+
+	if n := del.Merge(upd); n != 0 {
+		t.Errorf("Merge into deleted: got %d entries, want 0", n)
+	}
+	if got := del.TableNames(); len(got) != 0 {
+		t.Errorf("Merge into deleted: got tables %q, want none", got)
+	}
+	if n := upd.Merge(del); n != 1 {
+		t.Errorf("Merge into updated: got %d entries, want 1", n)
+	}
+	if !upd.IsModified() {
+		t.Error("Merge into updated: database not marked as modified")
+	}
+	if got := upd.TableNames(); len(got) != 0 {
+		t.Errorf("Merge into updated: got tables %q, want none", got)
+	}
+	if diff := cmp.Diff(upd.Log(), del.Log()); diff != "" {
+		t.Errorf("Merged logs (-upd, +del):\n%s", diff)
+	}
+
+	// A table created again after its deletion accepts later changes.
+	re := open(`{"op":"delete-table","tab":"t","clk":"300"},
+  {"op":"create-table","tab":"t","clk":"350"}`)
+	re.Merge(open(`{"op":"update","tab":"t","key":"y","val":2,"clk":"400"}`))
+	checkTab(t, re.Table("t"), map[string]int{"y": 2})
+}
+
+func TestSealValues(t *testing.T) {
+	const testKey = "ssssssssssssssssssssssssssssssss"
+	const payload = `{"log":[
+  {"op":"snapshot","val":{"t":{"a":"apple","b":[1,2]},"u":{"c":true}},"clk":"100"},
+  {"op":"update","tab":"t","key":"d","val":"durian","clk":"200"},
+  {"op":"delete","tab":"t","key":"b","clk":"300"},
+  {"op":"tag","tab":"v1","clk":"300"}
+],"dbs":{"extra":{"log":[
+  {"op":"update","tab":"x","key":"k","val":"secret","clk":"400"}
+]}}}`
+	w, err := leaf.Wrap([]byte(testKey), []byte(payload))
+	if err != nil {
+		t.Fatalf("Wrap: %v", err)
+	}
+	w.Database().EnableChain()
+	var buf bytes.Buffer
+	if _, err := w.WriteTo(&buf); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	data := buf.Bytes()
+
+	open := func(o *leaf.OpenOptions) *leaf.File {
+		t.Helper()
+		f, err := o.Open([]byte(testKey), bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		return f
+	}
+	plain, sealed := open(nil), open(&leaf.OpenOptions{SealValues: true})
+
+	// The sealed file has the same contents as the plain one.
+	check := func(msg string) {
+		t.Helper()
+		for _, name := range []string{"", "extra"} {
+			pd, sd := plain.NamedDatabase(name), sealed.NamedDatabase(name)
+			if diff := cmp.Diff(sd.Snapshot(), pd.Snapshot()); diff != "" {
+				t.Errorf("%s: db %q snapshot (-sealed, +plain):\n%s", msg, name, diff)
+			}
+			if diff := cmp.Diff(sd.Log(), pd.Log()); diff != "" {
+				t.Errorf("%s: db %q log (-sealed, +plain):\n%s", msg, name, diff)
+			}
+			if diff := cmp.Diff(sd.GarbageStats(time.Time{}), pd.GarbageStats(time.Time{})); diff != "" {
+				t.Errorf("%s: db %q garbage stats (-sealed, +plain):\n%s", msg, name, diff)
+			}
+		}
+		if got, want := sealed.Database().ChainHead(), plain.Database().ChainHead(); !bytes.Equal(got, want) {
+			t.Errorf("%s: chain head: got %x, want %x", msg, got, want)
+		}
+		if err := sealed.Database().VerifyChain(nil); err != nil {
+			t.Errorf("%s: VerifyChain: %v", msg, err)
+		}
+	}
+	check("open")
+
+	tab := sealed.Database().Table("t")
+	if got, ok := leaf.Get[string](tab, "a"); !ok || got != "apple" {
+		t.Errorf("Get a: got %q, %v; want apple, true", got, ok)
+	}
+	if got, ok := leaf.Get[string](sealed.NamedDatabase("extra").Table("x"), "k"); !ok || got != "secret" {
+		t.Errorf("Get extra k: got %q, %v; want secret, true", got, ok)
+	}
+	buf2 := make([]byte, 16)
+	if n, err := tab.GetInto("d", buf2); err != nil || string(buf2[:n]) != "durian" {
+		t.Errorf("GetInto d: got %q, %v; want durian", buf2[:n], err)
+	}
+
+	// Modifications of the sealed file are read back, and written out.
+	var old string
+	tab.Swap("a", "apricot", &old)
+	if old != "apple" {
+		t.Errorf("Swap a: old value is %q, want apple", old)
+	}
+	tab.Set("e", "elderberry")
+	sealed.NamedDatabase("new").Table("y").Set("z", 25)
+	checkTab(t, tab, map[string]string{"a": "apricot", "d": "durian", "e": "elderberry"})
+
+	sealed.SetShardSize(2)
+	var out bytes.Buffer
+	if _, err := sealed.WriteTo(&out); err != nil {
+		t.Fatalf("Write sealed: %v", err)
+	}
+	back, err := leaf.Open([]byte(testKey), &out)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for _, name := range []string{"", "extra", "new"} {
+		if diff := cmp.Diff(back.NamedDatabase(name).Snapshot(), sealed.NamedDatabase(name).Snapshot()); diff != "" {
+			t.Errorf("Read back db %q (-got, +want):\n%s", name, diff)
+		}
+	}
+
+	// Merging in either direction converges on the same state.
+	pt := plain.Database().Table("t")
+	pt.Set("f", "fig")
+	sealed.Database().Merge(plain.Database())
+	plain.Database().Merge(sealed.Database())
+	check("merge")
+
+	// Maintenance produces the same results.
+	if _, err := sealed.Database().RewindTag("v1"); err != nil {
+		t.Fatalf("RewindTag: %v", err)
+	}
+	if _, err := plain.Database().RewindTag("v1"); err != nil {
+		t.Fatalf("RewindTag: %v", err)
+	}
+	check("rewind")
+	sealed.Database().Revert()
+	plain.Database().Revert()
+	sealed.Database().Compact()
+	plain.Database().Compact()
+	if diff := cmp.Diff(sealed.Database().Snapshot(), plain.Database().Snapshot()); diff != "" {
+		t.Errorf("Compact (-sealed, +plain):\n%s", diff)
+	}
+	if err := sealed.Database().VerifyChain(nil); err != nil {
+		t.Errorf("VerifyChain after Compact: %v", err)
+	}
+
+	// Closing the file wipes the seal key, so the values cannot be read.
+	if err := sealed.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	func() {
+		defer func() {
+			if x := recover(); x == nil {
+				t.Error("Get after Close: got no panic")
+			}
+		}()
+		var v string
+		sealed.Database().Table("t").Get("a", &v)
+	}()
+}
+
 func diffData(t *testing.T, got, want *leaf.Database) {
 	t.Helper()
 	opt := cmp.AllowUnexported(leaf.Database{})
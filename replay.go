@@ -0,0 +1,158 @@
+package leaf
+
+import (
+	"encoding/json"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// minParallelReplay is the smallest log for which tablesFromLog rebuilds
+// tables concurrently. Below this, the cost of starting the workers exceeds
+// the cost of the replay.
+const minParallelReplay = 4096
+
+// A tableReplay records the operations that determine the contents of one
+// table, from its creation (or the most recent snapshot) onward.
+type tableReplay struct {
+	name string                     // the name of the table in the snapshot
+	base map[string]json.RawMessage // initial contents, from a snapshot
+	ts   int64                      // timestamp of the snapshot
+	ops  []*logEntry                // key and clear operations, in order
+}
+
+// rebuild replays the operations of r to construct the table contents. If
+// seal != nil, the initial contents are sealed by it.
+func (r *tableReplay) rebuild(seal *sealer) map[string]*logEntry {
+	m := make(map[string]*logEntry, len(r.base))
+	for key, val := range r.base {
+		m[key] = &logEntry{Op: opUpdateKey, A: r.name, B: key, C: sealValue(seal, val), TS: r.ts}
+	}
+	for _, e := range r.ops {
+		switch e.Op {
+		case opClearTable:
+			clear(m)
+		case opUpdateKey:
+			m[e.B] = e
+		case opDeleteKey:
+			delete(m, e.B)
+		}
+	}
+	return m
+}
+
+// tablesFromLog replays log to construct the contents of its tables.
+//
+// Operations on different tables are independent, so the replay is done in
+// two passes: The first follows the table-level operations in order to
+// assign each key operation to the table it affects, and the second rebuilds
+// the surviving tables from their operations. For a large log the tables are
+// rebuilt concurrently.
+//
+// Replay does not fail if the log refers to a table that does not exist, as
+// a log edited by hand might: Updating or renaming such a table creates it,
+// and clearing it or deleting its keys does nothing. OpenOptions can report
+// these entries.
+//
+// If seal != nil, the values of log are sealed by it (see sealValue),
+// and so are the values of the tables.
+func tablesFromLog(log []*logEntry, seal *sealer) map[string]map[string]*logEntry {
+	live := make(map[string]*tableReplay)
+	var snaps []map[string]map[string]json.RawMessage // to wipe, if sealed
+	for _, e := range log {
+		switch e.Op {
+		case opCreateTable:
+			if live[e.A] == nil {
+				live[e.A] = &tableReplay{name: e.A}
+			}
+		case opDeleteTable:
+			delete(live, e.A)
+		case opRenameTable:
+			// Renaming a table that does not exist leaves an empty table
+			// under the new name.
+			old := live[e.A]
+			if old == nil {
+				old = &tableReplay{name: e.B}
+			}
+			delete(live, e.A)
+			live[e.B] = old
+		case opClearTable, opDeleteKey:
+			if r := live[e.A]; r != nil {
+				r.ops = append(r.ops, e)
+			}
+		case opUpdateKey:
+			// An update to a table that does not exist creates it, as if the
+			// log had created the table first.
+			r := live[e.A]
+			if r == nil {
+				r = &tableReplay{name: e.A}
+				live[e.A] = r
+			}
+			r.ops = append(r.ops, e)
+		case opSnapshot:
+			var snap map[string]map[string]json.RawMessage
+			bits := openValue(seal, e.C)
+			unmarshalOrPanic(bits, &snap)
+			wipeValue(seal, bits)
+			snaps = append(snaps, snap)
+			clear(live)
+			for name, tab := range snap {
+				live[name] = &tableReplay{name: name, base: tab, ts: e.TS}
+			}
+		}
+	}
+
+	m := make(map[string]map[string]*logEntry, len(live))
+	var names []string
+	var todo []*tableReplay
+	for name, r := range live {
+		names = append(names, name)
+		todo = append(todo, r)
+	}
+
+	nw := min(runtime.GOMAXPROCS(0), len(todo))
+	if len(log) < minParallelReplay || nw < 2 {
+		for i, r := range todo {
+			m[names[i]] = r.rebuild(seal)
+		}
+		wipeSnapshots(seal, snaps)
+		return m
+	}
+	out := make([]map[string]*logEntry, len(todo))
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range nw {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(todo) {
+					return
+				}
+				out[i] = todo[i].rebuild(seal)
+			}
+		}()
+	}
+	wg.Wait()
+	for i, name := range names {
+		m[name] = out[i]
+	}
+	wipeSnapshots(seal, snaps)
+	return m
+}
+
+// wipeSnapshots wipes the values of snaps, decoded from sealed snapshots, if
+// seal != nil. The tables rebuilt from them hold sealed copies.
+func wipeSnapshots(seal *sealer, snaps []map[string]map[string]json.RawMessage) {
+	if seal == nil {
+		return
+	}
+	for _, snap := range snaps {
+		for _, tab := range snap {
+			for _, v := range tab {
+				clear(v)
+			}
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package leaf
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"errors"
+	"runtime"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The values of a database opened with OpenOptions.SealValues are sealed in
+// memory: The C field of each entry of its log, and of its tables, holds the
+// value encrypted with the seal key of the database, rather than its JSON
+// encoding. The functions in this file convert between the two forms. For a
+// database whose values are not sealed, they return values unchanged.
+
+// A sealer holds the key that seals the values of a file and its databases.
+// The key is allocated with allocSecret, and wiped when the file is closed,
+// or when the sealer is no longer in use.
+type sealer struct {
+	key []byte // nil after close
+}
+
+// newSealer returns a sealer with a new random key.
+func newSealer() *sealer {
+	s := &sealer{key: allocSecret(chacha20poly1305.KeySize)}
+	if _, err := cryptorand.Read(s.key); err != nil {
+		panic(err)
+	}
+	runtime.SetFinalizer(s, (*sealer).close)
+	return s
+}
+
+// close wipes and releases the key of s. After close, values sealed by s can
+// no longer be opened, and new values cannot be sealed.
+func (s *sealer) close() {
+	if s.key != nil {
+		freeSecret(s.key)
+		s.key = nil
+		runtime.SetFinalizer(s, nil)
+	}
+}
+
+// errSealerClosed is the value of the panic when a sealed value is used after
+// its file has been closed.
+var errSealerClosed = errors.New("leaf: sealed value used after File.Close")
+
+// sealValue returns the sealed form of the plaintext value v under s. If
+// s == nil, it returns v unchanged.
+func sealValue(s *sealer, v json.RawMessage) json.RawMessage {
+	if s == nil || v == nil {
+		return v
+	} else if s.key == nil {
+		panic(errSealerClosed)
+	}
+	c, err := encryptWithKey(s.key, v)
+	if err != nil {
+		panic(err) // fails only if the random source fails
+	}
+	return c
+}
+
+// openValue returns the plaintext of the value c sealed by s, in a new
+// buffer. If s == nil, it returns c unchanged.
+func openValue(s *sealer, c json.RawMessage) json.RawMessage {
+	if s == nil || c == nil {
+		return c
+	} else if s.key == nil {
+		panic(errSealerClosed)
+	}
+	v, err := decryptWithKey(s.key, c)
+	if err != nil {
+		panic(err) // sealed values are never modified
+	}
+	return v
+}
+
+// wipeValue wipes v, a plaintext returned by openValue, if s != nil.
+func wipeValue(s *sealer, v json.RawMessage) {
+	if s != nil {
+		clear(v)
+	}
+}
+
+// sealLog replaces the values of the entries of log with their sealed forms
+// under s, and wipes the plaintext. Since it modifies the entries, it must
+// only be used on entries that are not yet in the log of a database.
+func sealLog(s *sealer, log []*logEntry) {
+	if s == nil {
+		return
+	}
+	for _, e := range log {
+		plain := e.C
+		e.C = sealValue(s, plain)
+		clear(plain)
+	}
+}
+
+// value returns the plaintext value of e, an entry of d. The result must not
+// be modified or retained; pass it to release when it is no longer needed.
+func (d *Database) value(e *logEntry) json.RawMessage { return openValue(d.seal, e.C) }
+
+// release wipes v, a value returned by d.value, if the values of d are sealed.
+func (d *Database) release(v json.RawMessage) { wipeValue(d.seal, v) }
+
+// clone returns a copy of the plaintext value of e, an entry of d, which the
+// caller may retain.
+func (d *Database) clone(e *logEntry) json.RawMessage {
+	if d.seal == nil {
+		return bytes.Clone(e.C)
+	}
+	return d.value(e)
+}
+
+// plainLog returns the entries of log, which belong to d, with their values
+// in plaintext. If the values of d are sealed, the entries are copies, which
+// should be passed to releaseLog when they are no longer needed.
+func (d *Database) plainLog(log []*logEntry) []*logEntry {
+	if d.seal == nil {
+		return log
+	}
+	out := make([]*logEntry, len(log))
+	for i, e := range log {
+		cp := *e
+		cp.C = d.value(e)
+		out[i] = &cp
+	}
+	return out
+}
+
+// releaseLog wipes the values of log, as returned by plainLog.
+func (d *Database) releaseLog(log []*logEntry) {
+	if d.seal != nil {
+		for _, e := range log {
+			clear(e.C)
+		}
+	}
+}
+
+// hash returns the hash of e, an entry of d, as e.hash does for its
+// plaintext value.
+func (d *Database) hash(e *logEntry) []byte {
+	if d.seal == nil {
+		return e.hash()
+	}
+	p := d.plainLog([]*logEntry{e})
+	defer d.releaseLog(p)
+	return p[0].hash()
+}
+
+// sameEntry reports whether e, an entry of d, is the same as o, an entry of
+// other, comparing their plaintext values.
+func (d *Database) sameEntry(e *logEntry, other *Database, o *logEntry) bool {
+	if e == o {
+		return true
+	} else if e.Op != o.Op || e.A != o.A || e.B != o.B || e.TS != o.TS || e.N != o.N {
+		return false
+	}
+	a, b := d.value(e), other.value(o)
+	defer d.release(a)
+	defer other.release(b)
+	return bytes.Equal(a, b)
+}
+
+// adopt returns a copy of e, an entry of other, to be added to the log of d.
+// The copy has no chain link, and its value is sealed as the values of d are.
+func (d *Database) adopt(other *Database, e *logEntry) *logEntry {
+	cp := *e
+	cp.P = nil
+	if d.seal != nil || other.seal != nil {
+		v := other.value(e)
+		cp.C = sealValue(d.seal, v)
+		if d.seal != nil {
+			other.release(v)
+		}
+	}
+	return &cp
+}
+
+// encodeTables returns the JSON encoding of tabs, which belong to d, as a
+// snapshot. The caller should release it when it is no longer needed.
+func (d *Database) encodeTables(tabs map[string]map[string]*logEntry) json.RawMessage {
+	snap := snapshotOf(tabs, d.seal)
+	bits, err := json.Marshal(snap)
+	if err != nil {
+		panic(err)
+	}
+	if d.seal != nil {
+		for _, tab := range snap {
+			for _, v := range tab {
+				clear(v)
+			}
+		}
+	}
+	return bits
+}
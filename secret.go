@@ -0,0 +1,194 @@
+package leaf
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// NewSecret returns a zeroed buffer of n bytes to hold a sensitive value,
+// such as one read with Table.GetInto. Where possible the buffer is allocated
+// outside the Go heap, so that the collector does not copy it, and locked so
+// that it is not written to swap, as the data key of a File is. The buffer
+// must be released with Wipe.
+func NewSecret(n int) []byte { return allocSecret(n) }
+
+// Wipe overwrites the contents of b with zeroes. If b was returned by
+// NewSecret, Wipe also releases it, and b must not be used afterward.
+func Wipe(b []byte) { freeSecret(b) }
+
+// GetInto reports whether t contains a record for key, and if so copies the
+// contents of its value, which must be a JSON string, into dst, and returns
+// the number of bytes copied. A null value has no contents.
+//
+// Unlike Get, GetInto decodes the value directly into dst, so that no other
+// copy of it is made. This is useful for values such as passwords or private
+// keys, which the caller can wipe when they are no longer needed (see
+// NewSecret and Wipe). If the value is not a string, or is too long for dst,
+// GetInto reports an error and wipes dst. If key is not in t, the error
+// matches ErrNotFound. If the values of t are sealed (see
+// OpenOptions.SealValues), the value is decrypted into a temporary buffer,
+// which is wiped before GetInto returns.
+func (t Table) GetInto(key string, dst []byte) (int, error) {
+	e, ok := t.db.tabs[t.name][key]
+	if !ok {
+		return 0, errorf(ErrNotFound, "key %q not found", key)
+	}
+	v := t.db.value(e)
+	defer t.db.release(v)
+	n, err := unquoteInto(dst, v)
+	if err != nil {
+		clear(dst)
+		return 0, fmt.Errorf("value of %q: %w", key, err)
+	}
+	return n, nil
+}
+
+// GetBytesInto is as GetInto, but for a value stored from a []byte, which is
+// encoded as a base64 string. The decoded bytes are copied into dst.
+func (t Table) GetBytesInto(key string, dst []byte) (int, error) {
+	e, ok := t.db.tabs[t.name][key]
+	if !ok {
+		return 0, errorf(ErrNotFound, "key %q not found", key)
+	}
+	v := t.db.value(e)
+	defer t.db.release(v)
+	n, err := decodeBytesInto(dst, v)
+	if err != nil {
+		clear(dst)
+		return 0, fmt.Errorf("value of %q: %w", key, err)
+	}
+	return n, nil
+}
+
+var errNotString = errors.New("value is not a string")
+
+// unquoteInto decodes the JSON string src into dst, and returns the number of
+// bytes written. Invalid UTF-8 is replaced by U+FFFD, as json.Unmarshal does.
+func unquoteInto(dst, src []byte) (int, error) {
+	if string(src) == "null" {
+		return 0, nil
+	} else if len(src) < 2 || src[0] != '"' || src[len(src)-1] != '"' {
+		return 0, errNotString
+	}
+	src = src[1 : len(src)-1]
+	n := 0
+	put := func(r rune) error {
+		if utf8.RuneLen(r) > len(dst)-n {
+			return io.ErrShortBuffer
+		}
+		n += utf8.EncodeRune(dst[n:], r)
+		return nil
+	}
+	for len(src) != 0 {
+		c := src[0]
+		switch {
+		case c == '\\':
+			r, size := unescape(src)
+			if size == 0 {
+				return 0, errors.New("invalid escape in string")
+			} else if err := put(r); err != nil {
+				return 0, err
+			}
+			src = src[size:]
+		case c < utf8.RuneSelf:
+			if n == len(dst) {
+				return 0, io.ErrShortBuffer
+			}
+			dst[n] = c
+			n++
+			src = src[1:]
+		default:
+			r, size := utf8.DecodeRune(src)
+			if err := put(r); err != nil {
+				return 0, err
+			}
+			src = src[size:]
+		}
+	}
+	return n, nil
+}
+
+// unescape decodes the escape sequence at the start of src, and returns the
+// rune it denotes and its length in bytes. It returns a size of 0 if src does
+// not begin with a valid escape sequence. A surrogate pair is decoded as a
+// single rune; an unpaired surrogate is decoded as U+FFFD.
+func unescape(src []byte) (rune, int) {
+	if len(src) < 2 {
+		return 0, 0
+	}
+	switch src[1] {
+	case '"', '\\', '/':
+		return rune(src[1]), 2
+	case 'b':
+		return '\b', 2
+	case 'f':
+		return '\f', 2
+	case 'n':
+		return '\n', 2
+	case 'r':
+		return '\r', 2
+	case 't':
+		return '\t', 2
+	case 'u':
+		r := hex4(src[2:])
+		if r < 0 {
+			return 0, 0
+		} else if r < 0xD800 || r > 0xDFFF {
+			return r, 6
+		} else if r < 0xDC00 && len(src) >= 12 && src[6] == '\\' && src[7] == 'u' {
+			if lo := hex4(src[8:]); lo >= 0xDC00 && lo <= 0xDFFF {
+				return 0x10000 + (r-0xD800)<<10 + (lo - 0xDC00), 12
+			}
+		}
+		return utf8.RuneError, 6
+	}
+	return 0, 0
+}
+
+// hex4 decodes four hexadecimal digits at the start of src, and returns -1 if
+// they are missing or invalid.
+func hex4(src []byte) rune {
+	if len(src) < 4 {
+		return -1
+	}
+	var r rune
+	for _, c := range src[:4] {
+		switch {
+		case '0' <= c && c <= '9':
+			c -= '0'
+		case 'a' <= c && c <= 'f':
+			c -= 'a' - 10
+		case 'A' <= c && c <= 'F':
+			c -= 'A' - 10
+		default:
+			return -1
+		}
+		r = r<<4 | rune(c)
+	}
+	return r
+}
+
+// decodeBytesInto decodes src, the JSON encoding of a []byte, into dst, and
+// returns the number of bytes written.
+func decodeBytesInto(dst, src []byte) (int, error) {
+	if string(src) == "null" {
+		return 0, nil
+	} else if len(src) < 2 || src[0] != '"' || src[len(src)-1] != '"' {
+		return 0, errNotString
+	}
+	src = src[1 : len(src)-1]
+	if len(src)%4 != 0 {
+		return 0, errors.New("invalid base64 length")
+	}
+	want := len(src) / 4 * 3
+	for i := len(src) - 1; i >= 0 && i >= len(src)-2 && src[i] == '='; i-- {
+		want--
+	}
+	if want > len(dst) {
+		return 0, io.ErrShortBuffer
+	}
+	return base64.StdEncoding.Decode(dst[:want:want], src)
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package leaf
+
+// allocSecret returns a zeroed buffer of n bytes to hold key material. On
+// this platform memory cannot be locked, so it is an ordinary allocation.
+func allocSecret(n int) []byte { return make([]byte, n) }
+
+// freeSecret wipes a buffer returned by allocSecret.
+func freeSecret(b []byte) { clear(b) }
@@ -0,0 +1,26 @@
+//go:build unix
+
+package leaf
+
+import "golang.org/x/sys/unix"
+
+// allocSecret returns a zeroed buffer of n bytes to hold key material. Where
+// possible the buffer is mapped outside the Go heap, so that the collector
+// does not copy it, and locked so that it is not written to swap. Locking may
+// fail, for example if RLIMIT_MEMLOCK is exhausted, in which case the buffer
+// is used unlocked. The buffer must be released with freeSecret.
+func allocSecret(n int) []byte {
+	b, err := unix.Mmap(-1, 0, n, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return make([]byte, n)
+	}
+	unix.Mlock(b)
+	return b
+}
+
+// freeSecret wipes and releases a buffer returned by allocSecret.
+func freeSecret(b []byte) {
+	clear(b)
+	unix.Munlock(b)
+	unix.Munmap(b) // fails harmlessly if b was not mapped
+}
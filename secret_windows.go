@@ -0,0 +1,23 @@
+package leaf
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocSecret returns a zeroed buffer of n bytes to hold key material. The
+// buffer is locked so that it is not written to the page file, if possible.
+// The collector does not move heap objects, so the buffer is locked in place.
+// The buffer must be released with freeSecret.
+func allocSecret(n int) []byte {
+	b := make([]byte, n)
+	if n > 0 {
+		windows.VirtualLock(uintptr(unsafe.Pointer(&b[0])), uintptr(n))
+	}
+	return b
+}
+
+// freeSecret wipes a buffer returned by allocSecret. The buffer is not
+// unlocked, since its pages may be shared with other locked buffers.
+func freeSecret(b []byte) { clear(b) }
@@ -0,0 +1,144 @@
+package leaf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/golang/snappy"
+)
+
+// DefaultShardSize is a reasonable number of log entries per shard for a
+// large file. See File.SetShardSize.
+const DefaultShardSize = 4096
+
+// A logShard is the encrypted form of a contiguous range of log entries,
+// retained so that it can be written again without re-encoding if the
+// entries it holds have not changed.
+type logShard struct {
+	entries []*logEntry // the entries encoded in data
+	data    []byte      // the encrypted shard
+	sum     []byte      // the SHA-256 of data
+}
+
+// ShardSize reports the number of log entries stored in each shard of f, or
+// 0 if the log of f is not sharded.
+func (f *File) ShardSize() int { return f.shardSize }
+
+// SetShardSize sets the number of log entries stored in each shard of f when
+// it is next written. If n == 0, the log is not sharded; this is the default.
+// SetShardSize panics if n < 0. If the shard size changes, f is marked as
+// modified.
+//
+// Normally the whole contents of a file are encoded and encrypted as a single
+// payload each time it is written. When the log of the default database is
+// sharded, each range of n entries is encoded and encrypted separately, and
+// a shard whose entries have not changed since f was last read or written is
+// not encoded or encrypted again. Since the log changes mainly by adding new
+// entries, this makes each write of a large file much cheaper, at the cost
+// of a slightly larger file. Compacting or rewinding the log, or merging
+// changes into it, rewrites the shards affected.
+//
+// A file with a sharded log is written in format version 2 or later, which
+// versions of this package that do not support sharding will not open.
+func (f *File) SetShardSize(n int) {
+	if n < 0 {
+		panic("leaf: negative shard size")
+	}
+	if n != f.shardSize {
+		f.shardSize = n
+		f.shards = nil
+		f.db.dirty = true
+	}
+}
+
+// writeShards encodes and encrypts the log of the default database of f in
+// shards of f.shardSize entries, reusing the previously-written shards whose
+// entries have not changed. It returns the new shards, but does not update
+// f, in case the write fails.
+func (f *File) writeShards() ([]logShard, error) {
+	log := f.db.log
+	var out []logShard
+	for i := 0; i < len(log); i += f.shardSize {
+		part := log[i:min(i+f.shardSize, len(log))]
+		if k := len(out); k < len(f.shards) && slices.Equal(f.shards[k].entries, part) {
+			out = append(out, f.shards[k])
+			continue
+		}
+		plain := f.db.plainLog(part)
+		bits, err := json.Marshal(plain)
+		f.db.releaseLog(plain)
+		if err != nil {
+			return nil, fmt.Errorf("encode shard %d: %w", len(out), err)
+		}
+		packed := compress(bits)
+		data, err := encryptWith(f.cipher, f.dataKeyPlain, packed)
+		if f.seal != nil {
+			clear(bits)
+			clear(packed)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("encrypt shard %d: %w", len(out), err)
+		}
+		sum := sha256.Sum256(data)
+
+		// Copy the entries, since the log may be modified in place.
+		out = append(out, logShard{entries: slices.Clone(part), data: data, sum: sum[:]})
+	}
+	return out, nil
+}
+
+// readShards decrypts and decodes the log shards of wf, and calls f with the
+// entries of each in order, until f returns false. Each shard is checked
+// against the corresponding sum from the index, so that shards cannot be
+// removed or reordered without detection.
+func (wf *wireFile) readShards(dataKey []byte, sums [][]byte, f func(i int, log []*logEntry) bool) error {
+	if len(sums) != len(wf.Shards) {
+		return fmt.Errorf("file has %d shards, index has %d", len(wf.Shards), len(sums))
+	}
+	for i, data := range wf.Shards {
+		if sum := sha256.Sum256(data); !bytes.Equal(sum[:], sums[i]) {
+			return fmt.Errorf("shard %d does not match the index", i)
+		}
+		plain, err := decryptWith(wf.cipher(), dataKey, data)
+		if err != nil {
+			return fmt.Errorf("decrypt shard %d: %w", i, err)
+		}
+		dec, err := snappy.Decode(nil, plain)
+		if err != nil {
+			return fmt.Errorf("decompress shard %d: %w", i, err)
+		}
+		var log []*logEntry
+		if err := json.Unmarshal(dec, &log); err != nil {
+			return fmt.Errorf("decode shard %d: %w", i, err)
+		} else if len(log) == 0 {
+			return fmt.Errorf("shard %d is empty", i)
+		}
+		if !f(i, log) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// readShardedLog decodes the complete log of the default database of a
+// sharded file, and the shards it was read from.
+func (wf *wireFile) readShardedLog(dataKey []byte, p *wirePayload) ([]*logEntry, []logShard, error) {
+	if wf.V < shardedVersion || p.ShardSize <= 0 {
+		return nil, nil, errors.New("file is not sharded")
+	}
+	var log []*logEntry
+	var shards []logShard
+	err := wf.readShards(dataKey, p.ShardSums, func(i int, part []*logEntry) bool {
+		log = append(log, part...)
+		shards = append(shards, logShard{entries: part, data: wf.Shards[i], sum: p.ShardSums[i]})
+		return true
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return log, shards, nil
+}
@@ -0,0 +1,166 @@
+package leaf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"slices"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// sivAEAD implements AES-SIV as defined by RFC 5297, with AES-256 for both
+// the CMAC and CTR steps (AEAD_AES_SIV_CMAC_512).
+//
+// The synthetic IV is computed from the additional data, the nonce, and the
+// plaintext, in that order, and is also the initial counter for encryption.
+// If a nonce is ever repeated, the only consequence is that encryptions of
+// the same plaintext are identical; unlike a stream cipher with a repeated
+// nonce, nothing about the plaintexts or the key is revealed.
+type sivAEAD struct {
+	mac, ctr cipher.Block
+}
+
+// The info string used to derive the AES-SIV keys from a 32-byte key.
+const sivKeyInfo = "leaf aes-siv-cmac-512"
+
+// newSIV returns an AES-SIV AEAD for the given 32-byte key. The 64 bytes of
+// key material required by the construction are derived from key with HKDF.
+func newSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != AccessKeyLen {
+		return nil, errors.New("aes-siv: bad key length")
+	}
+	var keys [64]byte
+	defer clear(keys[:])
+	if _, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(sivKeyInfo)), keys[:]); err != nil {
+		return nil, err
+	}
+	mac, err := aes.NewCipher(keys[:32])
+	if err != nil {
+		return nil, err
+	}
+	ctr, err := aes.NewCipher(keys[32:])
+	if err != nil {
+		return nil, err
+	}
+	return sivAEAD{mac: mac, ctr: ctr}, nil
+}
+
+func (sivAEAD) NonceSize() int { return aes.BlockSize }
+
+func (sivAEAD) Overhead() int { return aes.BlockSize }
+
+func (s sivAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != aes.BlockSize {
+		panic("aes-siv: incorrect nonce length")
+	}
+	v := s2v(s.mac, additionalData, nonce, plaintext)
+
+	// The plaintext is copied before the IV is written, so that plaintext may
+	// be the storage following dst, to encrypt in place.
+	n := len(dst)
+	out := slices.Grow(dst, aes.BlockSize+len(plaintext))[:n+aes.BlockSize+len(plaintext)]
+	copy(out[n+aes.BlockSize:], plaintext)
+	copy(out[n:], v[:])
+	s.xorCTR(out[n+aes.BlockSize:], v)
+	return out
+}
+
+func (s sivAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != aes.BlockSize {
+		panic("aes-siv: incorrect nonce length")
+	} else if len(ciphertext) < aes.BlockSize {
+		return nil, errors.New("aes-siv: message authentication failed")
+	}
+	var v [aes.BlockSize]byte
+	copy(v[:], ciphertext)
+	n := len(dst)
+	out := append(dst, ciphertext[aes.BlockSize:]...)
+	s.xorCTR(out[n:], v)
+	if t := s2v(s.mac, additionalData, nonce, out[n:]); subtle.ConstantTimeCompare(t[:], v[:]) != 1 {
+		clear(out[n:])
+		return nil, errors.New("aes-siv: message authentication failed")
+	}
+	return out, nil
+}
+
+// xorCTR encrypts or decrypts buf in place with AES-CTR, using the counter
+// derived from the synthetic IV v as RFC 5297 specifies.
+func (s sivAEAD) xorCTR(buf []byte, v [aes.BlockSize]byte) {
+	v[8] &= 0x7f
+	v[12] &= 0x7f
+	cipher.NewCTR(s.ctr, v[:]).XORKeyStream(buf, buf)
+}
+
+// s2v computes the S2V function of RFC 5297 over the given strings.
+func s2v(mac cipher.Block, strs ...[]byte) [aes.BlockSize]byte {
+	var zero [aes.BlockSize]byte
+	d := cmac(mac, zero[:])
+	last := len(strs) - 1
+	for _, s := range strs[:last] {
+		d = dbl(d)
+		t := cmac(mac, s)
+		subtle.XORBytes(d[:], d[:], t[:])
+	}
+	sn := strs[last]
+	if len(sn) >= aes.BlockSize {
+		t := make([]byte, len(sn))
+		copy(t, sn)
+		tail := t[len(t)-aes.BlockSize:]
+		subtle.XORBytes(tail, tail, d[:])
+		return cmac(mac, t)
+	}
+	d = dbl(d)
+	var t [aes.BlockSize]byte
+	copy(t[:], sn)
+	t[len(sn)] = 0x80
+	subtle.XORBytes(t[:], t[:], d[:])
+	return cmac(mac, t[:])
+}
+
+// cmac computes the AES-CMAC of msg as defined by RFC 4493.
+func cmac(b cipher.Block, msg []byte) [aes.BlockSize]byte {
+	var k1, x [aes.BlockSize]byte
+	b.Encrypt(k1[:], k1[:])
+	k1 = dbl(k1)
+
+	// Process all but the last block, which is complete if msg is non-empty
+	// and a multiple of the block size, and otherwise padded.
+	n := (len(msg) + aes.BlockSize - 1) / aes.BlockSize
+	if n == 0 {
+		n = 1
+	}
+	for i := 0; i < n-1; i++ {
+		subtle.XORBytes(x[:], x[:], msg[i*aes.BlockSize:])
+		b.Encrypt(x[:], x[:])
+	}
+	var last [aes.BlockSize]byte
+	rest := msg[(n-1)*aes.BlockSize:]
+	copy(last[:], rest)
+	if len(rest) == aes.BlockSize {
+		subtle.XORBytes(last[:], last[:], k1[:])
+	} else {
+		last[len(rest)] = 0x80
+		k2 := dbl(k1)
+		subtle.XORBytes(last[:], last[:], k2[:])
+	}
+	subtle.XORBytes(x[:], x[:], last[:])
+	b.Encrypt(x[:], x[:])
+	return x
+}
+
+// dbl multiplies b by x in GF(2^128), as defined by RFC 5297.
+func dbl(b [aes.BlockSize]byte) [aes.BlockSize]byte {
+	var out [aes.BlockSize]byte
+	for i := 0; i < aes.BlockSize-1; i++ {
+		out[i] = b[i]<<1 | b[i+1]>>7
+	}
+	out[aes.BlockSize-1] = b[aes.BlockSize-1] << 1
+	if b[0]&0x80 != 0 {
+		out[aes.BlockSize-1] ^= 0x87
+	}
+	return out
+}
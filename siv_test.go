@@ -0,0 +1,77 @@
+package leaf
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("Invalid hex %q: %v", s, err)
+	}
+	return b
+}
+
+// Test vectors from RFC 4493 section 4, for AES-128.
+func TestCMAC(t *testing.T) {
+	b, err := aes.NewCipher(mustHex(t, "2b7e1516 28aed2a6 abf71588 09cf4f3c"))
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	tests := []struct {
+		msg, want string
+	}{
+		{"", "bb1d6929 e9593728 7fa37d12 9b756746"},
+		{"6bc1bee2 2e409f96 e93d7e11 7393172a", "070a16b4 6b4d4144 f79bdd9d d04a287c"},
+		{"6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 30c81c46 a35ce411",
+			"dfa66747 de9ae630 30ca3261 1497c827"},
+		{"6bc1bee2 2e409f96 e93d7e11 7393172a ae2d8a57 1e03ac9c 9eb76fac 45af8e51 " +
+			"30c81c46 a35ce411 e5fbc119 1a0a52ef f69f2445 df4f9b17 ad2b417b e66c3710",
+			"51f0bebf 7e3b9d92 fc497417 79363cfe"},
+	}
+	for _, tc := range tests {
+		got := cmac(b, mustHex(t, tc.msg))
+		if want := mustHex(t, tc.want); string(got[:]) != string(want) {
+			t.Errorf("cmac(%q): got %x, want %x", tc.msg, got, want)
+		}
+	}
+}
+
+// Test the S2V and CTR steps with the deterministic example of RFC 5297
+// appendix A.1, which uses AES-128 for both steps.
+func TestSIVKnownAnswer(t *testing.T) {
+	key := mustHex(t, "fffefdfc fbfaf9f8 f7f6f5f4 f3f2f1f0 f0f1f2f3 f4f5f6f7 f8f9fafb fcfdfeff")
+	ad := mustHex(t, "10111213 14151617 18191a1b 1c1d1e1f 20212223 24252627")
+	plain := mustHex(t, "11223344 55667788 99aabbcc ddee")
+	wantIV := mustHex(t, "85632d07 c6e8f37f 950acd32 0a2ecc93")
+	wantCT := mustHex(t, "40c02b96 90c4dc04 daef7f6a fe5c")
+
+	mac, err := aes.NewCipher(key[:16])
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	ctr, err := aes.NewCipher(key[16:])
+	if err != nil {
+		t.Fatalf("NewCipher: %v", err)
+	}
+	s := sivAEAD{mac: mac, ctr: ctr}
+
+	v := s2v(mac, ad, plain)
+	if string(v[:]) != string(wantIV) {
+		t.Errorf("s2v: got %x, want %x", v, wantIV)
+	}
+
+	buf := append([]byte(nil), plain...)
+	s.xorCTR(buf, v)
+	if string(buf) != string(wantCT) {
+		t.Errorf("Encrypt: got %x, want %x", buf, wantCT)
+	}
+	s.xorCTR(buf, v)
+	if string(buf) != string(plain) {
+		t.Errorf("Decrypt: got %x, want %x", buf, plain)
+	}
+}
@@ -0,0 +1,239 @@
+package leaf
+
+import (
+	"bytes"
+	cryptorand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ChunkSize is the largest number of bytes stored in each chunk of a value
+// written by Table.SetFrom.
+const ChunkSize = 64 << 10
+
+// The chunks of the values of a table stored by SetFrom are kept as ordinary
+// entries of a companion table, which is not reported as a table of the
+// database. The value itself is a chunkManifest. Each chunk is a JSON string
+// holding the base64 encoding of at most ChunkSize bytes, stored under a key
+// formed from the key of the value, the ID of the manifest, and the index of
+// the chunk (see chunkKey).
+//
+// The companion table is deleted, renamed, and cleared with its table. The
+// chunks of a value are deleted when the value is replaced or deleted.
+
+// chunkTablePrefix is the prefix of the name of each companion table.
+const chunkTablePrefix = "\x00chunks\x00"
+
+// chunkTableName returns the name of the companion table of the named table.
+func chunkTableName(name string) string { return chunkTablePrefix + name }
+
+// isChunkTable reports whether name is the name of a companion table.
+func isChunkTable(name string) bool { return strings.HasPrefix(name, chunkTablePrefix) }
+
+// chunkKey returns the key of chunk i of the value of key with the given ID.
+func chunkKey(key, id string, i int) string {
+	return key + "\x00" + id + "\x00" + strconv.Itoa(i)
+}
+
+// A chunkManifest is the value of a key stored by SetFrom.
+type chunkManifest struct {
+	ID     string `json:"chunked"` // distinguishes the chunks of this value
+	Chunks int    `json:"chunks"`  // the number of chunks
+	Size   int64  `json:"size"`    // the total size of the contents in bytes
+}
+
+// SetFrom adds or updates the value of key in t to the contents of r, read
+// until EOF, and reports whether it was new. If reading r fails, SetFrom
+// reports the error and does not modify t.
+//
+// The contents are stored in chunks of at most ChunkSize bytes, each in its
+// own log entry, and can be read back one chunk at a time with Open. Unlike
+// Set, SetFrom does not require the contents to be held in memory apart from
+// the database. The value of key, as reported by Get, describes the chunks
+// rather than holding the contents.
+func (t Table) SetFrom(key string, r io.Reader) (bool, error) {
+	var idBytes [8]byte
+	if _, err := cryptorand.Read(idBytes[:]); err != nil {
+		return false, err
+	}
+	id := hex.EncodeToString(idBytes[:])
+	ctab := chunkTableName(t.name)
+
+	// Read all the chunks before adding any of them, so that a read error
+	// leaves t unmodified. The chunks are sealed as they are read, but are
+	// timestamped as they are added, so that the log remains in order.
+	chunk := make([]byte, ChunkSize)
+	defer t.db.release(chunk)
+	var chunks []*logEntry
+	var size int64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n != 0 {
+			bits := make([]byte, 0, base64.StdEncoding.EncodedLen(n)+2)
+			bits = append(bits, '"')
+			bits = base64.StdEncoding.AppendEncode(bits, chunk[:n])
+			bits = append(bits, '"')
+			chunks = append(chunks, &logEntry{
+				Op: opUpdateKey, A: ctab, B: chunkKey(key, id, len(chunks)),
+				C: sealValue(t.db.seal, bits),
+			})
+			t.db.release(bits)
+			size += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		} else if err != nil {
+			return false, fmt.Errorf("read value of %q: %w", key, err)
+		}
+	}
+
+	if len(chunks) != 0 {
+		t.db.Table(ctab)
+		for _, e := range chunks {
+			e.TS = timeNow()
+			t.db.tabs[ctab][e.B] = e
+			t.db.addSealedLog(e)
+		}
+	}
+	bits, err := json.Marshal(chunkManifest{ID: id, Chunks: len(chunks), Size: size})
+	if err != nil {
+		panic(err)
+	}
+	tab := t.db.tabs[t.name]
+	_, isOld := tab[key]
+	tab[key] = &logEntry{Op: opUpdateKey, A: t.name, B: key, C: bits, TS: timeNow()}
+	t.db.addLog(tab[key])
+	t.dropChunks(key, id)
+	return !isOld, nil
+}
+
+// dropChunks deletes the chunks of key from the companion table of t, other
+// than those with the given ID. If keep == "", all the chunks are deleted.
+func (t Table) dropChunks(key, keep string) {
+	ct, ok := t.db.tabs[chunkTableName(t.name)]
+	if !ok {
+		return
+	}
+	prefix := key + "\x00"
+	var drop []string
+	for ck := range ct {
+		if rest, ok := strings.CutPrefix(ck, prefix); ok && (keep == "" || !strings.HasPrefix(rest, keep+"\x00")) {
+			drop = append(drop, ck)
+		}
+	}
+	slices.Sort(drop)
+	for _, ck := range drop {
+		delete(ct, ck)
+		t.db.addLog(&logEntry{Op: opDeleteKey, A: chunkTableName(t.name), B: ck, TS: timeNow()})
+	}
+}
+
+var errNotChunked = errors.New("value is not chunked or a string")
+
+// Open returns a reader for the contents of the value of key in t, which must
+// have been stored by SetFrom, or by Set from a []byte. The contents are read
+// one chunk at a time, and each chunk is decoded only when it is reached. If
+// key is not in t, the error matches ErrNotFound. A null value has no
+// contents.
+//
+// The reader is not affected by later changes to t. The caller should close
+// the reader when it is no longer needed. If the values of t are sealed (see
+// OpenOptions.SealValues), each chunk is decrypted when it is read, and the
+// decrypted copy is wiped when the next chunk is read or the reader is
+// closed.
+func (t Table) Open(key string) (io.ReadCloser, error) {
+	e, ok := t.db.tabs[t.name][key]
+	if !ok {
+		return nil, errorf(ErrNotFound, "key %q not found", key)
+	}
+	r := &chunkReader{db: t.db}
+	val := t.db.value(e)
+	defer t.db.release(val)
+	switch v := bytes.TrimSpace(val); {
+	case string(v) == "null":
+		// no contents
+	case len(v) >= 2 && v[0] == '"':
+		r.chunks = []*logEntry{e}
+	case len(v) >= 2 && v[0] == '{':
+		var m chunkManifest
+		if err := json.Unmarshal(v, &m); err != nil || m.ID == "" {
+			return nil, fmt.Errorf("value of %q: %w", key, errNotChunked)
+		}
+		ct := t.db.tabs[chunkTableName(t.name)]
+		for i := range m.Chunks {
+			c, ok := ct[chunkKey(key, m.ID, i)]
+			if !ok {
+				return nil, fmt.Errorf("value of %q: chunk %d is missing", key, i)
+			}
+			r.chunks = append(r.chunks, c)
+		}
+	default:
+		return nil, fmt.Errorf("value of %q: %w", key, errNotChunked)
+	}
+	return r, nil
+}
+
+// A chunkReader reads the contents of a chunked value, decoding one chunk at
+// a time. Since log entries are never modified once added, the reader holds
+// the entries for the chunks, and reads their values as it reaches them.
+type chunkReader struct {
+	db     *Database
+	chunks []*logEntry // the entries for the chunks not yet decoded
+	chunk  []byte      // the current decoded chunk
+	pos    int         // the offset of the unread portion of chunk
+	closed bool
+}
+
+var errReaderClosed = errors.New("reader is closed")
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, errReaderClosed
+	}
+	for r.pos == len(r.chunk) {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.chunk[r.pos:])
+	r.pos += n
+	return n, nil
+}
+
+// next decodes the next chunk of r, and returns io.EOF if there are no more.
+func (r *chunkReader) next() error {
+	if len(r.chunks) == 0 {
+		return io.EOF
+	}
+	src := r.db.value(r.chunks[0])
+	defer r.db.release(src)
+	src = bytes.TrimSpace(src)
+
+	need := base64.StdEncoding.DecodedLen(max(len(src)-2, 0))
+	if cap(r.chunk) < need {
+		r.db.release(r.chunk[:cap(r.chunk)])
+		r.chunk = make([]byte, need)
+	}
+	n, err := decodeBytesInto(r.chunk[:need], src)
+	if err != nil {
+		return fmt.Errorf("decode chunk: %w", err)
+	}
+	r.chunk, r.pos, r.chunks = r.chunk[:n], 0, r.chunks[1:]
+	return nil
+}
+
+// Close releases the chunk read by r. It always returns nil.
+func (r *chunkReader) Close() error {
+	if !r.closed {
+		r.db.release(r.chunk[:cap(r.chunk)])
+		r.chunks, r.chunk, r.closed = nil, nil, true
+	}
+	return nil
+}